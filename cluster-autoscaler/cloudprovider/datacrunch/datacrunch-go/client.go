@@ -2,6 +2,7 @@ package datacrunchclient
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,7 +14,7 @@ import (
 // Client is the main struct for interacting with the DataCrunch API.
 type Client struct {
 	clientID     string
-	clientSecret string
+	clientSecret func() (string, error)
 	baseURL      string
 	httpClient   *http.Client
 	token        *tokenResponse
@@ -32,6 +33,15 @@ type tokenResponse struct {
 
 // NewClient creates a new DataCrunch API client.
 func NewClient(clientID, clientSecret string) *Client {
+	return NewClientWithSecretFunc(clientID, func() (string, error) {
+		return clientSecret, nil
+	})
+}
+
+// NewClientWithSecretFunc creates a new DataCrunch API client which calls
+// clientSecret for the client secret on every login, e.g. to read a rotated
+// secret.
+func NewClientWithSecretFunc(clientID string, clientSecret func() (string, error)) *Client {
 	return &Client{
 		clientID:     clientID,
 		clientSecret: clientSecret,
@@ -41,23 +51,27 @@ func NewClient(clientID, clientSecret string) *Client {
 }
 
 // Login authenticates with the API and retrieves an access token.
-func (c *Client) Login() error {
+func (c *Client) Login(ctx context.Context) error {
 	c.tokenMu.Lock()
 	defer c.tokenMu.Unlock()
 
+	secret, err := c.clientSecret()
+	if err != nil {
+		return fmt.Errorf("failed to get client secret: %w", err)
+	}
 	body := map[string]string{
 		"grant_type":    "client_credentials",
 		"client_id":     c.clientID,
-		"client_secret": c.clientSecret,
+		"client_secret": secret,
 	}
 	b, _ := json.Marshal(body)
-	resp, err := c.httpClient.Post(c.baseURL+"/oauth2/token", "application/json", bytes.NewReader(b))
+	resp, err := c.postToken(ctx, b)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 && resp.StatusCode != 201 {
-		return fmt.Errorf("login failed: %s", resp.Status)
+		return fmt.Errorf("login failed: %w", c.parseAPIError(resp))
 	}
 	var tr tokenResponse
 	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
@@ -69,7 +83,7 @@ func (c *Client) Login() error {
 }
 
 // RefreshToken refreshes the access token using the refresh token.
-func (c *Client) RefreshToken() error {
+func (c *Client) RefreshToken(ctx context.Context) error {
 	c.tokenMu.Lock()
 	defer c.tokenMu.Unlock()
 	if c.token == nil || c.token.RefreshToken == "" {
@@ -80,13 +94,13 @@ func (c *Client) RefreshToken() error {
 		"refresh_token": c.token.RefreshToken,
 	}
 	b, _ := json.Marshal(body)
-	resp, err := c.httpClient.Post(c.baseURL+"/oauth2/token", "application/json", bytes.NewReader(b))
+	resp, err := c.postToken(ctx, b)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 && resp.StatusCode != 201 {
-		return fmt.Errorf("refresh token failed: %s", resp.Status)
+		return fmt.Errorf("refresh token failed: %w", c.parseAPIError(resp))
 	}
 	var tr tokenResponse
 	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
@@ -97,20 +111,31 @@ func (c *Client) RefreshToken() error {
 	return nil
 }
 
+// postToken sends a request to the OAuth2 token endpoint.
+func (c *Client) postToken(ctx context.Context, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/oauth2/token", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.httpClient.Do(req)
+}
+
 // ensureToken ensures a valid access token is present, refreshing if needed.
-func (c *Client) ensureToken() error {
+func (c *Client) ensureToken(ctx context.Context) error {
 	c.tokenMu.Lock()
 	if c.token == nil || time.Since(c.token.ObtainedAt) > time.Duration(c.token.ExpiresIn-60)*time.Second {
 		c.tokenMu.Unlock()
-		return c.Login()
+		return c.Login(ctx)
 	}
 	c.tokenMu.Unlock()
 	return nil
 }
 
 // doRequest performs an HTTP request with authentication, handling token refresh on 401.
+// Authentication requests share the context of req.
 func (c *Client) doRequest(req *http.Request) (*http.Response, error) {
-	if err := c.ensureToken(); err != nil {
+	if err := c.ensureToken(req.Context()); err != nil {
 		return nil, err
 	}
 	c.tokenMu.Lock()
@@ -124,7 +149,7 @@ func (c *Client) doRequest(req *http.Request) (*http.Response, error) {
 	if resp.StatusCode == 401 {
 		// Try refresh
 		_ = resp.Body.Close()
-		if err := c.RefreshToken(); err != nil {
+		if err := c.RefreshToken(req.Context()); err != nil {
 			return nil, err
 		}
 		c.tokenMu.Lock()
@@ -144,9 +169,9 @@ type BalanceResponse struct {
 }
 
 // GetBalance returns the project balance.
-func (c *Client) GetBalance() (*BalanceResponse, error) {
+func (c *Client) GetBalance(ctx context.Context) (*BalanceResponse, error) {
 	endpoint := c.baseURL + "/balance"
-	req, err := http.NewRequest("GET", endpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -156,7 +181,7 @@ func (c *Client) GetBalance() (*BalanceResponse, error) {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return nil, c.parseAPIError(resp.Body)
+		return nil, c.parseAPIError(resp)
 	}
 	var balance BalanceResponse
 	if err := json.NewDecoder(resp.Body).Decode(&balance); err != nil {
@@ -178,9 +203,9 @@ type ImageInfoResponseDto struct {
 }
 
 // ListImages returns the list of available image types.
-func (c *Client) ListImages() ([]ImageInfoResponseDto, error) {
+func (c *Client) ListImages(ctx context.Context) ([]ImageInfoResponseDto, error) {
 	endpoint := c.baseURL + "/images"
-	req, err := http.NewRequest("GET", endpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -191,7 +216,7 @@ func (c *Client) ListImages() ([]ImageInfoResponseDto, error) {
 
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return nil, c.parseAPIError(resp.Body)
+		return nil, c.parseAPIError(resp)
 	}
 	var images []ImageInfoResponseDto
 	if err := json.NewDecoder(resp.Body).Decode(&images); err != nil {
@@ -209,3 +234,8 @@ func (c *Client) Token() tokenResponse {
 	}
 	return *c.token
 }
+
+// CloseIdleConnections closes the idle connections to the API.
+func (c *Client) CloseIdleConnections() {
+	c.httpClient.CloseIdleConnections()
+}
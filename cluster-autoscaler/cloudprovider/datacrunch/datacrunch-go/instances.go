@@ -2,6 +2,7 @@ package datacrunchclient
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,12 +11,12 @@ import (
 )
 
 // ListInstances returns all instances, optionally filtered by status.
-func (c *Client) ListInstances(status string) (InstanceList, error) {
+func (c *Client) ListInstances(ctx context.Context, status string) (InstanceList, error) {
 	endpoint := c.baseURL + "/instances"
 	if status != "" {
 		endpoint += "?status=" + url.QueryEscape(status)
 	}
-	req, err := http.NewRequest("GET", endpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -25,7 +26,7 @@ func (c *Client) ListInstances(status string) (InstanceList, error) {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return nil, c.parseAPIError(resp.Body)
+		return nil, c.parseAPIError(resp)
 	}
 	var list InstanceList
 	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
@@ -35,9 +36,9 @@ func (c *Client) ListInstances(status string) (InstanceList, error) {
 }
 
 // GetInstance returns a single instance by ID.
-func (c *Client) GetInstance(id string) (*Instance, error) {
+func (c *Client) GetInstance(ctx context.Context, id string) (*Instance, error) {
 	endpoint := fmt.Sprintf("%s/instances/%s", c.baseURL, id)
-	req, err := http.NewRequest("GET", endpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -47,7 +48,7 @@ func (c *Client) GetInstance(id string) (*Instance, error) {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return nil, c.parseAPIError(resp.Body)
+		return nil, c.parseAPIError(resp)
 	}
 	var inst Instance
 	if err := json.NewDecoder(resp.Body).Decode(&inst); err != nil {
@@ -57,14 +58,14 @@ func (c *Client) GetInstance(id string) (*Instance, error) {
 }
 
 // DeployInstance deploys a new instance and returns its ID.
-func (c *Client) DeployInstance(reqBody DeployInstanceRequest) (string, error) {
+func (c *Client) DeployInstance(ctx context.Context, reqBody DeployInstanceRequest) (string, error) {
 	endpoint := c.baseURL + "/instances"
 	b, err := json.Marshal(reqBody)
 	if err != nil {
 		return "", err
 	}
 
-	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(b))
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(b))
 	if err != nil {
 		return "", err
 	}
@@ -75,7 +76,7 @@ func (c *Client) DeployInstance(reqBody DeployInstanceRequest) (string, error) {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 202 {
-		return "", c.parseAPIError(resp.Body)
+		return "", c.parseAPIError(resp)
 	}
 	id, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -85,13 +86,13 @@ func (c *Client) DeployInstance(reqBody DeployInstanceRequest) (string, error) {
 }
 
 // PerformInstanceAction performs an action (boot, start, shutdown, delete, etc.) on an instance.
-func (c *Client) PerformInstanceAction(reqBody InstanceActionRequest) error {
+func (c *Client) PerformInstanceAction(ctx context.Context, reqBody InstanceActionRequest) error {
 	endpoint := c.baseURL + "/instances"
 	b, err := json.Marshal(reqBody)
 	if err != nil {
 		return err
 	}
-	req, err := http.NewRequest("PUT", endpoint, bytes.NewReader(b))
+	req, err := http.NewRequestWithContext(ctx, "PUT", endpoint, bytes.NewReader(b))
 	if err != nil {
 		return err
 	}
@@ -102,15 +103,15 @@ func (c *Client) PerformInstanceAction(reqBody InstanceActionRequest) error {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 202 {
-		return c.parseAPIError(resp.Body)
+		return c.parseAPIError(resp)
 	}
 	return nil
 }
 
 // ListInstanceTypes returns all available instance types.
-func (c *Client) ListInstanceTypes() (InstanceTypeList, error) {
+func (c *Client) ListInstanceTypes(ctx context.Context) (InstanceTypeList, error) {
 	endpoint := c.baseURL + "/instance-types"
-	req, err := http.NewRequest("GET", endpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -120,7 +121,7 @@ func (c *Client) ListInstanceTypes() (InstanceTypeList, error) {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return nil, c.parseAPIError(resp.Body)
+		return nil, c.parseAPIError(resp)
 	}
 	var list InstanceTypeList
 	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
@@ -130,7 +131,7 @@ func (c *Client) ListInstanceTypes() (InstanceTypeList, error) {
 }
 
 // GetInstanceTypePriceHistory returns price history for all instance types.
-func (c *Client) GetInstanceTypePriceHistory(currency string, numOfMonths int) (PriceHistory, error) {
+func (c *Client) GetInstanceTypePriceHistory(ctx context.Context, currency string, numOfMonths int) (PriceHistory, error) {
 	endpoint := c.baseURL + "/instance-types/price-history"
 	params := url.Values{}
 	if currency != "" {
@@ -142,7 +143,7 @@ func (c *Client) GetInstanceTypePriceHistory(currency string, numOfMonths int) (
 	if len(params) > 0 {
 		endpoint += "?" + params.Encode()
 	}
-	req, err := http.NewRequest("GET", endpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -152,7 +153,7 @@ func (c *Client) GetInstanceTypePriceHistory(currency string, numOfMonths int) (
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return nil, c.parseAPIError(resp.Body)
+		return nil, c.parseAPIError(resp)
 	}
 	var ph PriceHistory
 	if err := json.NewDecoder(resp.Body).Decode(&ph); err != nil {
@@ -162,7 +163,7 @@ func (c *Client) GetInstanceTypePriceHistory(currency string, numOfMonths int) (
 }
 
 // ListInstanceAvailability returns all instance type availabilities for all locations.
-func (c *Client) ListInstanceAvailability(isSpot bool, locationCode string) (InstanceAvailabilityList, error) {
+func (c *Client) ListInstanceAvailability(ctx context.Context, isSpot bool, locationCode string) (InstanceAvailabilityList, error) {
 	endpoint := c.baseURL + "/instance-availability"
 	params := url.Values{}
 	if isSpot {
@@ -174,7 +175,7 @@ func (c *Client) ListInstanceAvailability(isSpot bool, locationCode string) (Ins
 	if len(params) > 0 {
 		endpoint += "?" + params.Encode()
 	}
-	req, err := http.NewRequest("GET", endpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -184,7 +185,7 @@ func (c *Client) ListInstanceAvailability(isSpot bool, locationCode string) (Ins
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return nil, c.parseAPIError(resp.Body)
+		return nil, c.parseAPIError(resp)
 	}
 	var list InstanceAvailabilityList
 	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
@@ -194,7 +195,7 @@ func (c *Client) ListInstanceAvailability(isSpot bool, locationCode string) (Ins
 }
 
 // GetInstanceTypeAvailability returns availability for a specific instance type.
-func (c *Client) GetInstanceTypeAvailability(instanceType string, isSpot bool, locationCode string) (bool, error) {
+func (c *Client) GetInstanceTypeAvailability(ctx context.Context, instanceType string, isSpot bool, locationCode string) (bool, error) {
 
 	endpoint := fmt.Sprintf("%s/instance-availability/%s", c.baseURL, url.PathEscape(instanceType))
 	params := url.Values{}
@@ -207,7 +208,7 @@ func (c *Client) GetInstanceTypeAvailability(instanceType string, isSpot bool, l
 	if len(params) > 0 {
 		endpoint += "?" + params.Encode()
 	}
-	req, err := http.NewRequest("GET", endpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return false, err
 	}
@@ -217,7 +218,7 @@ func (c *Client) GetInstanceTypeAvailability(instanceType string, isSpot bool, l
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return false, c.parseAPIError(resp.Body)
+		return false, c.parseAPIError(resp)
 	}
 	var available bool
 
@@ -228,11 +229,12 @@ func (c *Client) GetInstanceTypeAvailability(instanceType string, isSpot bool, l
 	return available, nil
 }
 
-// parseAPIError parses an API error response.
-func (c *Client) parseAPIError(r io.Reader) error {
-	var apiErr APIError
-	if err := json.NewDecoder(r).Decode(&apiErr); err != nil {
-		return fmt.Errorf("API error: failed to decode error response: %w", err)
-	}
-	return fmt.Errorf("API error: %s - %s", apiErr.Code, apiErr.Message)
+// parseAPIError parses an API error response into an *APIError.
+func (c *Client) parseAPIError(resp *http.Response) error {
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	if err := json.NewDecoder(resp.Body).Decode(apiErr); err != nil {
+		apiErr.Code = ""
+		apiErr.Message = fmt.Sprintf("failed to decode error response: %v", err)
+	}
+	return apiErr
 }
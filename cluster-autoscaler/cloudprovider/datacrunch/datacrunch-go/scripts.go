@@ -2,13 +2,14 @@ package datacrunchclient
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
 )
 
 // UploadStartupScript uploads a startup script to DataCrunch and returns its script ID.
-func (c *Client) UploadStartupScript(name string, script string) (string, error) {
+func (c *Client) UploadStartupScript(ctx context.Context, name string, script string) (string, error) {
 	type addScriptRequest struct {
 		Name   string `json:"name"`
 		Script string `json:"script"`
@@ -22,7 +23,7 @@ func (c *Client) UploadStartupScript(name string, script string) (string, error)
 		return "", err
 	}
 	endpoint := c.baseURL + "/scripts"
-	httpReq, err := http.NewRequest("POST", endpoint, bytes.NewReader(b))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(b))
 	if err != nil {
 		return "", err
 	}
@@ -41,7 +42,7 @@ func (c *Client) UploadStartupScript(name string, script string) (string, error)
 }
 
 // DeleteStartupScript deletes a startup script by its ID.
-func (c *Client) DeleteStartupScript(scriptID string) error {
+func (c *Client) DeleteStartupScript(ctx context.Context, scriptID string) error {
 	type deleteScriptsRequest struct {
 		Scripts []string `json:"scripts"`
 	}
@@ -50,7 +51,7 @@ func (c *Client) DeleteStartupScript(scriptID string) error {
 		return err
 	}
 	endpoint := c.baseURL + "/scripts"
-	req, err := http.NewRequest("DELETE", endpoint, bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, "DELETE", endpoint, bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
@@ -61,15 +62,15 @@ func (c *Client) DeleteStartupScript(scriptID string) error {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return c.parseAPIError(resp.Body)
+		return c.parseAPIError(resp)
 	}
 	return nil
 }
 
 // ListStartupScripts returns all startup scripts for the project.
-func (c *Client) ListStartupScripts() ([]StartupScript, error) {
+func (c *Client) ListStartupScripts(ctx context.Context) ([]StartupScript, error) {
 	endpoint := c.baseURL + "/scripts"
-	req, err := http.NewRequest("GET", endpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -79,7 +80,7 @@ func (c *Client) ListStartupScripts() ([]StartupScript, error) {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return nil, c.parseAPIError(resp.Body)
+		return nil, c.parseAPIError(resp)
 	}
 	var scripts []StartupScript
 	if err := json.NewDecoder(resp.Body).Decode(&scripts); err != nil {
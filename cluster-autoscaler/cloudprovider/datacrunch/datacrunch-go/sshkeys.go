@@ -4,6 +4,7 @@ package datacrunchclient
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -17,9 +18,9 @@ type SSHKey struct {
 }
 
 // ListSSHKeys returns all SSH keys for the project.
-func (c *Client) ListSSHKeys() ([]SSHKey, error) {
+func (c *Client) ListSSHKeys(ctx context.Context) ([]SSHKey, error) {
 	endpoint := c.baseURL + "/sshkeys"
-	req, err := http.NewRequest("GET", endpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -29,7 +30,7 @@ func (c *Client) ListSSHKeys() ([]SSHKey, error) {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return nil, c.parseAPIError(resp.Body)
+		return nil, c.parseAPIError(resp)
 	}
 	var keys []SSHKey
 	if err := json.NewDecoder(resp.Body).Decode(&keys); err != nil {
@@ -39,14 +40,14 @@ func (c *Client) ListSSHKeys() ([]SSHKey, error) {
 }
 
 // AddSSHKey adds a new SSH key and returns its ID.
-func (c *Client) AddSSHKey(name, key string) (string, error) {
+func (c *Client) AddSSHKey(ctx context.Context, name, key string) (string, error) {
 	endpoint := c.baseURL + "/sshkeys"
 	body := map[string]string{
 		"name": name,
 		"key":  key,
 	}
 	b, _ := json.Marshal(body)
-	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(b))
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(b))
 	if err != nil {
 		return "", err
 	}
@@ -57,7 +58,7 @@ func (c *Client) AddSSHKey(name, key string) (string, error) {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 201 {
-		return "", c.parseAPIError(resp.Body)
+		return "", c.parseAPIError(resp)
 	}
 	var id string
 	if err := json.NewDecoder(resp.Body).Decode(&id); err != nil {
@@ -67,13 +68,13 @@ func (c *Client) AddSSHKey(name, key string) (string, error) {
 }
 
 // DeleteSSHKeys deletes multiple SSH keys by their IDs.
-func (c *Client) DeleteSSHKeys(ids []string) error {
+func (c *Client) DeleteSSHKeys(ctx context.Context, ids []string) error {
 	endpoint := c.baseURL + "/sshkeys"
 	body := map[string][]string{
 		"keys": ids,
 	}
 	b, _ := json.Marshal(body)
-	req, err := http.NewRequest("DELETE", endpoint, bytes.NewReader(b))
+	req, err := http.NewRequestWithContext(ctx, "DELETE", endpoint, bytes.NewReader(b))
 	if err != nil {
 		return err
 	}
@@ -84,15 +85,15 @@ func (c *Client) DeleteSSHKeys(ids []string) error {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return c.parseAPIError(resp.Body)
+		return c.parseAPIError(resp)
 	}
 	return nil
 }
 
 // GetSSHKey returns a single SSH key by ID.
-func (c *Client) GetSSHKey(id string) (*SSHKey, error) {
+func (c *Client) GetSSHKey(ctx context.Context, id string) (*SSHKey, error) {
 	endpoint := fmt.Sprintf("%s/sshkeys/%s", c.baseURL, id)
-	req, err := http.NewRequest("GET", endpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -102,7 +103,7 @@ func (c *Client) GetSSHKey(id string) (*SSHKey, error) {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return nil, c.parseAPIError(resp.Body)
+		return nil, c.parseAPIError(resp)
 	}
 	var key SSHKey
 	if err := json.NewDecoder(resp.Body).Decode(&key); err != nil {
@@ -112,9 +113,9 @@ func (c *Client) GetSSHKey(id string) (*SSHKey, error) {
 }
 
 // DeleteSSHKey deletes a single SSH key by ID.
-func (c *Client) DeleteSSHKey(id string) error {
+func (c *Client) DeleteSSHKey(ctx context.Context, id string) error {
 	endpoint := fmt.Sprintf("%s/sshkeys/%s", c.baseURL, id)
-	req, err := http.NewRequest("DELETE", endpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", endpoint, nil)
 	if err != nil {
 		return err
 	}
@@ -125,7 +126,7 @@ func (c *Client) DeleteSSHKey(id string) error {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return c.parseAPIError(resp.Body)
+		return c.parseAPIError(resp)
 	}
 	return nil
 }
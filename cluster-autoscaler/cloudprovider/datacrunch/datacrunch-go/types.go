@@ -1,9 +1,20 @@
 package datacrunchclient
 
+import "fmt"
+
 // APIError represents an error response from the DataCrunch API.
 type APIError struct {
-	Code    string `json:"code"`
-	Message string `json:"message"`
+	StatusCode int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+// Error implements the error interface.
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("API error: status %d - %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("API error: %s - %s", e.Code, e.Message)
 }
 
 // Instance represents a DataCrunch instance.
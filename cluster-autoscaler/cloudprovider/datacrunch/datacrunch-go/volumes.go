@@ -2,6 +2,7 @@ package datacrunchclient
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -122,12 +123,12 @@ type VolumeTypePrice struct {
 }
 
 // ListVolumes returns all volumes, optionally filtered by status
-func (c *Client) ListVolumes(status string) ([]Volume, error) {
+func (c *Client) ListVolumes(ctx context.Context, status string) ([]Volume, error) {
 	endpoint := c.baseURL + "/volumes"
 	if status != "" {
 		endpoint += "?status=" + url.QueryEscape(status)
 	}
-	req, err := http.NewRequest("GET", endpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -137,7 +138,7 @@ func (c *Client) ListVolumes(status string) ([]Volume, error) {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return nil, c.parseAPIError(resp.Body)
+		return nil, c.parseAPIError(resp)
 	}
 	var volumes []Volume
 	if err := json.NewDecoder(resp.Body).Decode(&volumes); err != nil {
@@ -147,13 +148,13 @@ func (c *Client) ListVolumes(status string) ([]Volume, error) {
 }
 
 // CreateVolume creates a new volume and returns the volume ID
-func (c *Client) CreateVolume(request CreateVolumeRequest) (string, error) {
+func (c *Client) CreateVolume(ctx context.Context, request CreateVolumeRequest) (string, error) {
 	endpoint := c.baseURL + "/volumes"
 	b, err := json.Marshal(request)
 	if err != nil {
 		return "", err
 	}
-	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(b))
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(b))
 	if err != nil {
 		return "", err
 	}
@@ -164,7 +165,7 @@ func (c *Client) CreateVolume(request CreateVolumeRequest) (string, error) {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 202 {
-		return "", c.parseAPIError(resp.Body)
+		return "", c.parseAPIError(resp)
 	}
 	var volumeID string
 	if err := json.NewDecoder(resp.Body).Decode(&volumeID); err != nil {
@@ -174,13 +175,13 @@ func (c *Client) CreateVolume(request CreateVolumeRequest) (string, error) {
 }
 
 // PerformVolumeAction performs an action on one or multiple volumes
-func (c *Client) PerformVolumeAction(request VolumeActionRequest) error {
+func (c *Client) PerformVolumeAction(ctx context.Context, request VolumeActionRequest) error {
 	endpoint := c.baseURL + "/volumes"
 	b, err := json.Marshal(request)
 	if err != nil {
 		return err
 	}
-	req, err := http.NewRequest("PUT", endpoint, bytes.NewReader(b))
+	req, err := http.NewRequestWithContext(ctx, "PUT", endpoint, bytes.NewReader(b))
 	if err != nil {
 		return err
 	}
@@ -191,15 +192,15 @@ func (c *Client) PerformVolumeAction(request VolumeActionRequest) error {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 202 {
-		return c.parseAPIError(resp.Body)
+		return c.parseAPIError(resp)
 	}
 	return nil
 }
 
 // ListVolumesInTrash returns all volumes that are in trash
-func (c *Client) ListVolumesInTrash() ([]VolumeInTrash, error) {
+func (c *Client) ListVolumesInTrash(ctx context.Context) ([]VolumeInTrash, error) {
 	endpoint := c.baseURL + "/volumes/trash"
-	req, err := http.NewRequest("GET", endpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -209,7 +210,7 @@ func (c *Client) ListVolumesInTrash() ([]VolumeInTrash, error) {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return nil, c.parseAPIError(resp.Body)
+		return nil, c.parseAPIError(resp)
 	}
 	var volumes []VolumeInTrash
 	if err := json.NewDecoder(resp.Body).Decode(&volumes); err != nil {
@@ -219,9 +220,9 @@ func (c *Client) ListVolumesInTrash() ([]VolumeInTrash, error) {
 }
 
 // GetVolume returns a single volume by ID
-func (c *Client) GetVolume(volumeID string) (*Volume, error) {
+func (c *Client) GetVolume(ctx context.Context, volumeID string) (*Volume, error) {
 	endpoint := fmt.Sprintf("%s/volumes/%s", c.baseURL, volumeID)
-	req, err := http.NewRequest("GET", endpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -231,7 +232,7 @@ func (c *Client) GetVolume(volumeID string) (*Volume, error) {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return nil, c.parseAPIError(resp.Body)
+		return nil, c.parseAPIError(resp)
 	}
 	var volume Volume
 	if err := json.NewDecoder(resp.Body).Decode(&volume); err != nil {
@@ -241,14 +242,14 @@ func (c *Client) GetVolume(volumeID string) (*Volume, error) {
 }
 
 // DeleteVolume deletes a volume by ID
-func (c *Client) DeleteVolume(volumeID string, isPermanent bool) error {
+func (c *Client) DeleteVolume(ctx context.Context, volumeID string, isPermanent bool) error {
 	endpoint := fmt.Sprintf("%s/volumes/%s", c.baseURL, volumeID)
 	request := DeleteVolumeRequest{IsPermanent: isPermanent}
 	b, err := json.Marshal(request)
 	if err != nil {
 		return err
 	}
-	req, err := http.NewRequest("DELETE", endpoint, bytes.NewReader(b))
+	req, err := http.NewRequestWithContext(ctx, "DELETE", endpoint, bytes.NewReader(b))
 	if err != nil {
 		return err
 	}
@@ -259,15 +260,15 @@ func (c *Client) DeleteVolume(volumeID string, isPermanent bool) error {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 202 {
-		return c.parseAPIError(resp.Body)
+		return c.parseAPIError(resp)
 	}
 	return nil
 }
 
 // ListVolumeTypes returns all available volume types
-func (c *Client) ListVolumeTypes() ([]VolumeType, error) {
+func (c *Client) ListVolumeTypes(ctx context.Context) ([]VolumeType, error) {
 	endpoint := c.baseURL + "/volume-types"
-	req, err := http.NewRequest("GET", endpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -277,7 +278,7 @@ func (c *Client) ListVolumeTypes() ([]VolumeType, error) {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return nil, c.parseAPIError(resp.Body)
+		return nil, c.parseAPIError(resp)
 	}
 	var volumeTypes []VolumeType
 	if err := json.NewDecoder(resp.Body).Decode(&volumeTypes); err != nil {
@@ -0,0 +1,171 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
+)
+
+const (
+	autoDiscovererType   = "datacrunch"
+	autoDiscovererTagKey = "tag"
+
+	// The tag keys of discovered servers are prefixed with the tag of the
+	// auto discovery spec, e.g. `k8s.io/cluster-autoscaler/node-pool`.
+	enabledTagSuffix  = "/enabled"
+	nodePoolTagSuffix = "/node-pool"
+	minSizeTagSuffix  = "/min"
+	maxSizeTagSuffix  = "/max"
+)
+
+// autoDiscoveryConfig is the parsed form of a node group auto discovery spec.
+type autoDiscoveryConfig struct {
+	tagPrefix string
+}
+
+// parseAutoDiscoverySpec parses a node group auto discovery spec of the form
+// `datacrunch:tag=<prefix>`, e.g. `datacrunch:tag=k8s.io/cluster-autoscaler`.
+func parseAutoDiscoverySpec(spec string) (autoDiscoveryConfig, error) {
+	discoverer, option, found := strings.Cut(spec, ":")
+	if !found || discoverer != autoDiscovererType {
+		return autoDiscoveryConfig{}, fmt.Errorf("unsupported discoverer in spec %q, expected `%s:%s=<prefix>`", spec, autoDiscovererType, autoDiscovererTagKey)
+	}
+
+	key, value, found := strings.Cut(option, "=")
+	if !found || key != autoDiscovererTagKey {
+		return autoDiscoveryConfig{}, fmt.Errorf("unsupported option in spec %q, expected `%s=<prefix>`", spec, autoDiscovererTagKey)
+	}
+
+	prefix := strings.TrimSuffix(value, "/")
+	if prefix == "" {
+		return autoDiscoveryConfig{}, fmt.Errorf("empty tag prefix in spec %q", spec)
+	}
+	if strings.ContainsAny(prefix, "= \t\n") {
+		return autoDiscoveryConfig{}, fmt.Errorf("tag prefix in spec %q must not contain whitespace or '='", spec)
+	}
+
+	return autoDiscoveryConfig{tagPrefix: prefix}, nil
+}
+
+// parseServerTags returns the tags of a server. DataCrunch servers have no
+// tags, they are stored as whitespace separated `<key>=<value>` pairs in the
+// description. Words which are not pairs are ignored.
+func parseServerTags(description string) map[string]string {
+	tags := make(map[string]string)
+	for _, field := range strings.Fields(description) {
+		key, value, found := strings.Cut(field, "=")
+		if found && key != "" {
+			tags[key] = value
+		}
+	}
+	return tags
+}
+
+// formatServerTags returns the description of a server with the tags.
+func formatServerTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+tags[key])
+	}
+	return strings.Join(pairs, " ")
+}
+
+// discoverNodeGroupSpecs groups the servers tagged for auto discovery into
+// node group specs, sorted by name. The instance type and sizes of a node
+// group are taken from its first server, servers which disagree are skipped.
+func discoverNodeGroupSpecs(cfg autoDiscoveryConfig, servers []*datacrunchclient.Instance) []*datacrunchNodeGroupSpec {
+	specs := make(map[string]*datacrunchNodeGroupSpec)
+	for _, server := range servers {
+		tags := parseServerTags(server.Description)
+		if tags[cfg.tagPrefix+enabledTagSuffix] != "true" {
+			continue
+		}
+
+		spec, err := nodeGroupSpecFromTags(cfg, tags)
+		if err != nil {
+			klog.Warningf("Ignoring server %s for node group auto discovery: %v", server.ID, err)
+			continue
+		}
+		spec.instanceType = server.InstanceType
+
+		existing, found := specs[spec.name]
+		if !found {
+			spec.regions = []string{server.Location}
+			specs[spec.name] = spec
+			continue
+		}
+		if existing.instanceType != spec.instanceType || existing.minSize != spec.minSize || existing.maxSize != spec.maxSize {
+			klog.Warningf("Ignoring server %s for node group auto discovery: instance type or sizes differ from other servers of node group %s", server.ID, spec.name)
+			continue
+		}
+		if !slices.Contains(existing.regions, server.Location) {
+			existing.regions = append(existing.regions, server.Location)
+		}
+	}
+
+	result := make([]*datacrunchNodeGroupSpec, 0, len(specs))
+	for _, spec := range specs {
+		result = append(result, spec)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].name < result[j].name })
+	return result
+}
+
+func nodeGroupSpecFromTags(cfg autoDiscoveryConfig, tags map[string]string) (*datacrunchNodeGroupSpec, error) {
+	name := tags[cfg.tagPrefix+nodePoolTagSuffix]
+	if name == "" {
+		return nil, fmt.Errorf("missing tag %s", cfg.tagPrefix+nodePoolTagSuffix)
+	}
+
+	minSize, err := strconv.Atoi(tags[cfg.tagPrefix+minSizeTagSuffix])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse tag %s: %v", cfg.tagPrefix+minSizeTagSuffix, err)
+	}
+	maxSize, err := strconv.Atoi(tags[cfg.tagPrefix+maxSizeTagSuffix])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse tag %s: %v", cfg.tagPrefix+maxSizeTagSuffix, err)
+	}
+	if minSize < 0 || maxSize <= 0 || minSize > maxSize {
+		return nil, fmt.Errorf("invalid sizes min %d max %d", minSize, maxSize)
+	}
+
+	return &datacrunchNodeGroupSpec{
+		name:    name,
+		minSize: minSize,
+		maxSize: maxSize,
+		tags: map[string]string{
+			cfg.tagPrefix + enabledTagSuffix:  "true",
+			cfg.tagPrefix + nodePoolTagSuffix: name,
+			cfg.tagPrefix + minSizeTagSuffix:  strconv.Itoa(minSize),
+			cfg.tagPrefix + maxSizeTagSuffix:  strconv.Itoa(maxSize),
+		},
+	}, nil
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
+)
+
+func TestParseAutoDiscoverySpec(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      string
+		tagPrefix string
+		wantErr   bool
+	}{
+		{name: "valid", spec: "datacrunch:tag=k8s.io/cluster-autoscaler", tagPrefix: "k8s.io/cluster-autoscaler"},
+		{name: "trailing slash", spec: "datacrunch:tag=k8s.io/cluster-autoscaler/", tagPrefix: "k8s.io/cluster-autoscaler"},
+		{name: "other discoverer", spec: "asg:tag=k8s.io/cluster-autoscaler", wantErr: true},
+		{name: "missing option", spec: "datacrunch", wantErr: true},
+		{name: "unknown option", spec: "datacrunch:label=pool", wantErr: true},
+		{name: "empty prefix", spec: "datacrunch:tag=", wantErr: true},
+		{name: "prefix with whitespace", spec: "datacrunch:tag=my cluster", wantErr: true},
+		{name: "prefix with value", spec: "datacrunch:tag=enabled=true", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := parseAutoDiscoverySpec(tc.spec)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.tagPrefix, cfg.tagPrefix)
+		})
+	}
+}
+
+func TestServerTags(t *testing.T) {
+	tags := map[string]string{"ca/node-pool": "pool1", "ca/enabled": "true"}
+	description := formatServerTags(tags)
+	assert.Equal(t, "ca/enabled=true ca/node-pool=pool1", description)
+	assert.Equal(t, tags, parseServerTags(description))
+
+	assert.Empty(t, parseServerTags("pool1"))
+	assert.Equal(t, map[string]string{"a": "b"}, parseServerTags("created by hand a=b =c"))
+}
+
+func TestDiscoverNodeGroupSpecs(t *testing.T) {
+	cfg := autoDiscoveryConfig{tagPrefix: "ca"}
+	tagged := func(id, location, instanceType, description string) *datacrunchclient.Instance {
+		return &datacrunchclient.Instance{ID: id, Location: location, InstanceType: instanceType, Description: description}
+	}
+	servers := []*datacrunchclient.Instance{
+		tagged("id1", "FIN-01", "1A100.22V", "ca/enabled=true ca/node-pool=gpu ca/min=0 ca/max=4"),
+		tagged("id2", "ICE-01", "1A100.22V", "ca/enabled=true ca/node-pool=gpu ca/min=0 ca/max=4"),
+		tagged("id3", "FIN-01", "1A100.22V", "ca/enabled=true ca/node-pool=gpu ca/min=0 ca/max=4"),
+		tagged("id4", "FIN-01", "CPU.4V.16G", "ca/enabled=true ca/node-pool=cpu ca/min=1 ca/max=2"),
+		// conflicting instance type
+		tagged("id5", "FIN-01", "CPU.4V.16G", "ca/enabled=true ca/node-pool=gpu ca/min=0 ca/max=4"),
+		// not enabled, invalid sizes, missing pool and untagged servers
+		tagged("id6", "FIN-01", "1A100.22V", "ca/node-pool=disabled ca/min=0 ca/max=4"),
+		tagged("id7", "FIN-01", "1A100.22V", "ca/enabled=true ca/node-pool=invalid ca/min=3 ca/max=1"),
+		tagged("id8", "FIN-01", "1A100.22V", "ca/enabled=true ca/min=0 ca/max=1"),
+		tagged("id9", "FIN-01", "1A100.22V", "pool1"),
+	}
+
+	specs := discoverNodeGroupSpecs(cfg, servers)
+	require.Len(t, specs, 2)
+
+	assert.Equal(t, "cpu", specs[0].name)
+	assert.Equal(t, 1, specs[0].minSize)
+	assert.Equal(t, 2, specs[0].maxSize)
+	assert.Equal(t, "CPU.4V.16G", specs[0].instanceType)
+	assert.Equal(t, []string{"FIN-01"}, specs[0].regions)
+
+	assert.Equal(t, "gpu", specs[1].name)
+	assert.Equal(t, 0, specs[1].minSize)
+	assert.Equal(t, 4, specs[1].maxSize)
+	assert.Equal(t, "1A100.22V", specs[1].instanceType)
+	assert.Equal(t, []string{"FIN-01", "ICE-01"}, specs[1].regions)
+	assert.Equal(t, "ca/enabled=true ca/max=4 ca/min=0 ca/node-pool=gpu", formatServerTags(specs[1].tags))
+}
+
+func TestDiscoverNodeGroups(t *testing.T) {
+	servers := []*datacrunchclient.Instance{
+		{ID: "id1", Hostname: "gpu-1a", Location: "FIN-01", InstanceType: "1A100.22V", Description: "ca/enabled=true ca/node-pool=gpu ca/min=0 ca/max=4"},
+		{ID: "id2", Hostname: "cpu-2b", Location: "FIN-01", InstanceType: "CPU.4V.16G", Description: "ca/enabled=true ca/node-pool=cpu ca/min=0 ca/max=4"},
+		{ID: "id3", Hostname: "unconfigured-3c", Location: "FIN-01", InstanceType: "CPU.4V.16G", Description: "ca/enabled=true ca/node-pool=unconfigured ca/min=0 ca/max=4"},
+	}
+	manager := newTestManager(t, nil, servers)
+	explicit := newTestNodeGroup(manager, "cpu", 1, 2)
+	manager.clusterConfig.NodeConfigs["gpu"] = &NodeConfig{ImageType: "ubuntu-24.04"}
+
+	require.NoError(t, discoverNodeGroups(manager, "datacrunch:tag=ca"))
+	require.Len(t, manager.nodeGroups, 2)
+
+	// explicit specs take precedence
+	assert.Same(t, explicit, manager.nodeGroups["cpu"])
+
+	gpu := manager.nodeGroups["gpu"]
+	require.NotNil(t, gpu)
+	assert.Equal(t, 4, gpu.MaxSize())
+	assert.Equal(t, "1A100.22V", gpu.instanceType)
+	size, err := gpu.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 1, size)
+	// servers created by the node group are discovered again after a restart
+	tags := parseServerTags(servers[0].Description)
+	tags[nodeGroupTagKey] = "gpu"
+	assert.Equal(t, tags, parseServerTags(gpu.serverDescription()))
+	assert.Equal(t, "cluster-autoscaler/node-group=cpu", explicit.serverDescription())
+
+	assert.Error(t, discoverNodeGroups(manager, "datacrunch:name=gpu"))
+}
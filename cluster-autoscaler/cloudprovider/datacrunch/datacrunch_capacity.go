@@ -0,0 +1,141 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+// capacityPenaltyMaxFactor caps the penalty of placements which run out of
+// capacity again and again.
+const capacityPenaltyMaxFactor = 8
+
+// capacityKey identifies the capacity of an instance type in a region, spot,
+// on-demand and reserved capacity run out independently.
+type capacityKey struct {
+	serverPlacement
+	pool string
+}
+
+// capacityPenalty is the penalty of a placement which ran out of capacity.
+type capacityPenalty struct {
+	// failures counts the capacity failures in a row.
+	failures int
+	window   time.Duration
+	until    time.Time
+}
+
+// capacityPenalties tracks the placements which recently ran out of capacity.
+// Servers are not created in a penalized placement until its penalty expired,
+// so scale ups fail fast and the autoscaler moves on to other node groups.
+// The penalty doubles with every failure in a row up to
+// capacityPenaltyMaxFactor times the base, and decays once a penalty expired
+// for its window without further failures.
+type capacityPenalties struct {
+	sync.Mutex
+	clock clock.Clock
+	// base is the penalty of the first failure, zero disables penalties.
+	base      time.Duration
+	penalties map[capacityKey]*capacityPenalty
+}
+
+func newCapacityPenalties(c clock.Clock, base time.Duration) *capacityPenalties {
+	return &capacityPenalties{
+		clock:     c,
+		base:      base,
+		penalties: make(map[capacityKey]*capacityPenalty),
+	}
+}
+
+// recordFailure penalizes the placement after a server ran out of capacity.
+func (p *capacityPenalties) recordFailure(key capacityKey) {
+	if p.base == 0 {
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	now := p.clock.Now()
+	penalty, found := p.penalties[key]
+	if !found || now.Sub(penalty.until) > penalty.window {
+		penalty = &capacityPenalty{}
+		p.penalties[key] = penalty
+	}
+	penalty.failures++
+	penalty.window = min(p.base<<(penalty.failures-1), p.base*capacityPenaltyMaxFactor)
+	penalty.until = now.Add(penalty.window)
+	klog.V(2).Infof("%v Instance type %s is out of %s capacity, not creating servers of it for %s", logFields{region: key.region}, key.instanceType, key.pool, penalty.window)
+}
+
+// recordSuccess clears the penalty of the placement after a server was
+// created in it.
+func (p *capacityPenalties) recordSuccess(key capacityKey) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.penalties, key)
+}
+
+// penalized returns the time left of the penalty of the placement, zero if it
+// is not penalized.
+func (p *capacityPenalties) penalized(key capacityKey) time.Duration {
+	p.Lock()
+	defer p.Unlock()
+	penalty, found := p.penalties[key]
+	if !found {
+		return 0
+	}
+	return max(penalty.until.Sub(p.clock.Now()), 0)
+}
+
+// capacityPool returns the capacity the servers of the node group are
+// created from. Servers of groups preferring spot fall back to on-demand, they
+// only run out of capacity if the on-demand capacity is exhausted.
+func (n *datacrunchNodeGroup) capacityPool() string {
+	switch {
+	case n.reserved:
+		return "reserved"
+	case n.instanceOption() == InstanceOptionSpotOnly:
+		return "spot"
+	}
+	return "on-demand"
+}
+
+// capacityKey returns the capacity of the node group in the placement.
+func (n *datacrunchNodeGroup) capacityKey(placement serverPlacement) capacityKey {
+	return capacityKey{serverPlacement: placement, pool: n.capacityPool()}
+}
+
+// checkCapacityPenalties returns an out of capacity error if all placements
+// of the node group are penalized.
+func (n *datacrunchNodeGroup) checkCapacityPenalties() error {
+	var shortest time.Duration
+	for _, placement := range n.allPlacements() {
+		remaining := n.manager.capacityPenalties.penalized(n.capacityKey(placement))
+		if remaining == 0 {
+			return nil
+		}
+		if shortest == 0 || remaining < shortest {
+			shortest = remaining
+		}
+	}
+	return fmt.Errorf("%w: all instance types and regions of node group %s recently ran out of capacity, retrying in %s", errOutOfCapacity, n.id, shortest.Round(time.Second))
+}
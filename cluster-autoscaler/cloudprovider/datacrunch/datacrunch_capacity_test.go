@@ -0,0 +1,113 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
+	autoscalerErrors "k8s.io/autoscaler/cluster-autoscaler/utils/errors"
+	testclock "k8s.io/utils/clock/testing"
+)
+
+func TestCapacityPenalties(t *testing.T) {
+	fakeClock := testclock.NewFakeClock(time.Now())
+	penalties := newCapacityPenalties(fakeClock, time.Minute)
+	key := capacityKey{serverPlacement: serverPlacement{instanceType: "1A100.22V", region: "FIN-01"}, pool: "on-demand"}
+	other := capacityKey{serverPlacement: serverPlacement{instanceType: "1A100.22V", region: "FIN-01"}, pool: "spot"}
+
+	penalties.recordFailure(key)
+	assert.Equal(t, time.Minute, penalties.penalized(key))
+	assert.Zero(t, penalties.penalized(other))
+
+	// failures in a row double the penalty up to its cap
+	for _, window := range []time.Duration{2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 8 * time.Minute} {
+		fakeClock.Step(penalties.penalized(key))
+		penalties.recordFailure(key)
+		assert.Equal(t, window, penalties.penalized(key))
+	}
+
+	// the penalty decays once it expired for its window
+	fakeClock.Step(16*time.Minute + time.Second)
+	assert.Zero(t, penalties.penalized(key))
+	penalties.recordFailure(key)
+	assert.Equal(t, time.Minute, penalties.penalized(key))
+
+	// a created server clears the penalty
+	penalties.recordSuccess(key)
+	assert.Zero(t, penalties.penalized(key))
+
+	// a zero base disables penalties
+	disabled := newCapacityPenalties(fakeClock, 0)
+	disabled.recordFailure(key)
+	assert.Zero(t, disabled.penalized(key))
+}
+
+func TestIncreaseSizeCapacityPenalty(t *testing.T) {
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+	fakeClock := testclock.NewFakeClock(time.Now())
+	manager.capacityPenalties = newCapacityPenalties(fakeClock, time.Minute)
+	manager.scaleUpBackoff = 0
+	group := newTestNodeGroup(manager, "pool", 0, 5)
+	// another node group of the instance type shares its capacity
+	otherGroup := newTestNodeGroup(manager, "other", 0, 5)
+	client := fakeClientOf(manager)
+	outOfCapacity := true
+	client.deployErr = func(req datacrunchclient.DeployInstanceRequest) error {
+		if outOfCapacity {
+			return &datacrunchclient.APIError{StatusCode: 400, Code: "invalid_request", Message: "Not enough resources to deploy instance"}
+		}
+		return nil
+	}
+
+	require.Error(t, group.IncreaseSize(1))
+	require.Len(t, client.deployed, 1)
+
+	// the node groups fail fast during the penalty, without calling the API
+	calls := client.calls.Load()
+	for _, n := range []*datacrunchNodeGroup{group, otherGroup} {
+		err := n.IncreaseSize(1)
+		require.Error(t, err)
+		assert.ErrorIs(t, err, errOutOfCapacity)
+		assert.Equal(t, autoscalerErrors.TransientError, err.(autoscalerErrors.AutoscalerError).Type())
+	}
+	assert.Len(t, client.deployed, 1)
+	assert.Equal(t, calls, client.calls.Load())
+
+	// the penalty clears once its window passed
+	outOfCapacity = false
+	fakeClock.Step(time.Minute)
+	require.NoError(t, group.IncreaseSize(1))
+	assert.Len(t, client.deployed, 2)
+	assert.Zero(t, manager.capacityPenalties.penalized(group.capacityKey(group.allPlacements()[0])))
+}
+
+func TestAvailablePlacementsSkipPenalized(t *testing.T) {
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+	group := newTestNodeGroup(manager, "pool", 0, 5)
+	group.regions = []string{"FIN-01", "ICE-01"}
+
+	manager.capacityPenalties.recordFailure(group.capacityKey(serverPlacement{instanceType: "1A100.22V", region: "FIN-01"}))
+
+	require.NoError(t, group.checkCapacityPenalties())
+	placements, err := group.availablePlacements(group.instanceOption())
+	require.NoError(t, err)
+	assert.Equal(t, []serverPlacement{{instanceType: "1A100.22V", region: "ICE-01"}}, placements)
+}
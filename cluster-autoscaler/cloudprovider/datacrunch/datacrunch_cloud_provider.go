@@ -17,11 +17,15 @@ limitations under the License.
 package datacrunch
 
 import (
+	"errors"
 	"fmt"
+	"maps"
+	"math/rand"
 	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	apiv1 "k8s.io/api/core/v1"
@@ -31,20 +35,57 @@ import (
 	autoscalerErrors "k8s.io/autoscaler/cluster-autoscaler/utils/errors"
 	"k8s.io/autoscaler/cluster-autoscaler/utils/gpu"
 	"k8s.io/klog/v2"
+
+	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
 )
 
 var _ cloudprovider.CloudProvider = (*DatacrunchCloudProvider)(nil)
 
 const (
 	// GPULabel is the label added to nodes with GPU resource.
-	GPULabel                   = "datacrunch.io/gpu-node"
-	providerIDPrefix           = "datacrunch://"
-	nodeGroupLabel             = "datacrunch.io/node-group"
-	datacrunchLabelNamespace   = "datacrunch.io"
-	serverCreateTimeoutDefault = 5 * time.Minute
-	serverRegisterTimeout      = 10 * time.Minute
-	defaultPodAmountsLimit     = 110
-	maxPlacementGroupSize      = 10
+	GPULabel                     = "datacrunch.io/gpu-node"
+	gpuModelLabel                = "datacrunch.io/gpu-model"
+	providerIDPrefix             = "datacrunch://"
+	nodeGroupLabel               = "datacrunch.io/node-group"
+	tagPrefixDefault             = "cluster-autoscaler/"
+	datacrunchLabelNamespace     = "datacrunch.io"
+	serverCreateTimeoutDefault   = 5 * time.Minute
+	createMaxAttemptsDefault     = 3
+	createRetryBackoffDefault    = 2 * time.Second
+	nodeLookupMaxAttemptsDefault = 3
+	nodeLookupBackoffDefault     = 100 * time.Millisecond
+	wrongRegionMaxAttempts       = 3
+	createMaxInFlightDefault     = 5
+	createStaggerDefault         = 500 * time.Millisecond
+	createStaggerAboveDefault    = 5
+	createStaggerJitter          = 0.5
+	deleteMaxInFlightDefault     = 5
+	serverRegisterTimeoutDefault = 10 * time.Minute
+	registerGracePeriodDefault   = 2 * time.Minute
+	cleanupTimeout               = 30 * time.Second
+	apiCallTimeoutDefault        = 30 * time.Second
+	apiRateLimitDefault          = 10.0
+	scaleUpBackoffDefault        = 5 * time.Minute
+	capacityPenaltyDefault       = 2 * time.Minute
+	// reservedContract is the contract of servers created from reserved
+	// capacity, which is pre-allocated and comes online faster.
+	reservedContract             = "LONG_TERM"
+	reservedMaxNodeProvisionTime = 5 * time.Minute
+	// scaleDownDisabledTime is the unneeded time of node groups whose scale
+	// down is disabled.
+	scaleDownDisabledTime  = 100 * 365 * 24 * time.Hour
+	defaultPodAmountsLimit = 110
+
+	autoprovisionedNodeGroupPrefix  = "autoprovisioned"
+	autoprovisionedNodeGroupMaxSize = 10
+	// maxNodePoolNameLength leaves room for the random suffix of server
+	// hostnames, which must not exceed 63 characters.
+	maxNodePoolNameLength = 46
+)
+
+var (
+	validNodePoolName        = regexp.MustCompile(`^[a-z0-9A-Z]+[a-z0-9A-Z\-\.\_]*[a-z0-9A-Z]+$|^[a-z0-9A-Z]{1}$`)
+	invalidNodePoolNameChars = regexp.MustCompile(`[^a-z0-9]+`)
 )
 
 // DatacrunchCloudProvider implements CloudProvider interface.
@@ -85,8 +126,7 @@ func (d *DatacrunchCloudProvider) NodeGroupForNode(node *apiv1.Node) (cloudprovi
 		}
 		groupId = nodeGroupId
 	} else {
-		// DataCrunch does not have labels, so you may need to adapt this logic if grouping is different
-		groupId = instance.Description // Example: use Description as group
+		groupId = d.manager.nodeGroupIDForServer(instance)
 		if groupId == "" {
 			return nil, nil
 		}
@@ -113,7 +153,7 @@ func (d *DatacrunchCloudProvider) HasInstance(node *apiv1.Node) (bool, error) {
 // Pricing returns pricing model for this cloud provider or error if not
 // available. Implementation optional.
 func (d *DatacrunchCloudProvider) Pricing() (cloudprovider.PricingModel, autoscalerErrors.AutoscalerError) {
-	return nil, cloudprovider.ErrNotImplemented
+	return &datacrunchPriceModel{manager: d.manager}, nil
 }
 
 // GetAvailableMachineTypes get all machine types that can be requested from
@@ -123,13 +163,28 @@ func (d *DatacrunchCloudProvider) GetAvailableMachineTypes() ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
+	return machineTypeNames(serverTypes), nil
+}
 
-	types := make([]string, 0, len(serverTypes))
-	for _, server := range serverTypes {
-		types = append(types, server.Name)
+// GetAvailableMachineTypesInRegion returns the machine types available in
+// the region. All machine types are returned if the availability of the
+// region is unknown.
+func (d *DatacrunchCloudProvider) GetAvailableMachineTypesInRegion(region string) ([]string, error) {
+	serverTypes, err := d.manager.cachedServerType.getServerTypesInRegion(region)
+	if err != nil {
+		return nil, err
 	}
+	return machineTypeNames(serverTypes), nil
+}
 
-	return types, nil
+// machineTypeNames returns the instance types, which are used as machine
+// types by node groups.
+func machineTypeNames(serverTypes []*datacrunchclient.InstanceType) []string {
+	types := make([]string, 0, len(serverTypes))
+	for _, serverType := range serverTypes {
+		types = append(types, serverType.InstanceType)
+	}
+	return types
 }
 
 // NewNodeGroup builds a theoretical node group based on the node definition
@@ -143,7 +198,76 @@ func (d *DatacrunchCloudProvider) NewNodeGroup(
 	taints []apiv1.Taint,
 	extraResources map[string]resource.Quantity,
 ) (cloudprovider.NodeGroup, error) {
-	return nil, cloudprovider.ErrNotImplemented
+	if d.manager.clusterConfig.AutoprovisioningNodeConfig == nil {
+		return nil, fmt.Errorf("cannot autoprovision node group for machine type %s: no autoprovisioning_node_config in cluster config", machineType)
+	}
+
+	if _, err := d.manager.cachedServerType.getServerType(machineType); err != nil {
+		return nil, fmt.Errorf("cannot autoprovision node group for machine type %s: %v", machineType, err)
+	}
+
+	region, err := d.autoprovisioningRegion(labels, systemLabels)
+	if err != nil {
+		return nil, fmt.Errorf("cannot autoprovision node group for machine type %s: %v", machineType, err)
+	}
+	if err := d.manager.cachedServerType.validateRegion(region); err != nil {
+		return nil, fmt.Errorf("cannot autoprovision node group for machine type %s: %v", machineType, err)
+	}
+	machineTypes, err := d.GetAvailableMachineTypesInRegion(region)
+	if err != nil {
+		return nil, fmt.Errorf("cannot autoprovision node group for machine type %s: %v", machineType, err)
+	}
+	if !slices.Contains(machineTypes, machineType) {
+		return nil, fmt.Errorf("cannot autoprovision node group for machine type %s: not available in region %s", machineType, region)
+	}
+
+	id := fmt.Sprintf("%s-%s-%x", autoprovisionedNodeGroupPrefix, invalidNodePoolNameChars.ReplaceAllString(strings.ToLower(machineType), "-"), rand.Int31())
+
+	// The node config is kept by the theoretical node group, which is used to
+	// build template nodes, and only registered once it is created. Most
+	// theoretical node groups are never created.
+	nodeConfig := *d.manager.clusterConfig.AutoprovisioningNodeConfig
+	nodeConfig.Labels = cloudprovider.JoinStringMaps(nodeConfig.Labels, systemLabels, labels)
+	nodeConfig.Taints = append(append([]apiv1.Taint{}, nodeConfig.Taints...), taints...)
+
+	klog.V(2).Infof("Built theoretical node group %s with machine type %s in region %s", id, machineType, region)
+
+	return &datacrunchNodeGroup{
+		manager:                    d.manager,
+		id:                         id,
+		minSize:                    0,
+		maxSize:                    autoprovisionedNodeGroupMaxSize,
+		instanceType:               machineType,
+		region:                     region,
+		createTimeout:              d.manager.serverCreateTimeout,
+		registerTimeout:            d.manager.serverRegisterTimeout,
+		targetSize:                 0,
+		clusterUpdateMutex:         d.manager.clusterUpdateMutex,
+		autoprovisioned:            true,
+		autoprovisioningNodeConfig: &nodeConfig,
+	}, nil
+}
+
+// autoprovisioningRegion returns the region requested via labels or, if no
+// region is requested, the region of the existing node groups.
+func (d *DatacrunchCloudProvider) autoprovisioningRegion(labels map[string]string, systemLabels map[string]string) (string, error) {
+	if region, found := labels[apiv1.LabelTopologyRegion]; found {
+		return region, nil
+	}
+	if region, found := systemLabels[apiv1.LabelTopologyRegion]; found {
+		return region, nil
+	}
+
+	ids := make([]string, 0, len(d.manager.nodeGroups))
+	for id := range d.manager.nodeGroups {
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return "", errors.New("no region requested and no existing node group to take the region from")
+	}
+	sort.Strings(ids)
+
+	return d.manager.nodeGroups[ids[0]].region, nil
 }
 
 // GetResourceLimiter returns struct containing limits (max, min) for
@@ -157,30 +281,65 @@ func (d *DatacrunchCloudProvider) GPULabel() string {
 	return GPULabel
 }
 
-// GetAvailableGPUTypes return all available GPU types cloud provider supports.
+// GetAvailableGPUTypes return all available GPU types cloud provider supports,
+// there are none the autoscaler has to know about.
 func (d *DatacrunchCloudProvider) GetAvailableGPUTypes() map[string]struct{} {
 	return nil
 }
 
 // GetNodeGpuConfig returns the label, type and resource name for the GPU added to node. If node doesn't have
-// any GPUs, it returns nil.
+// any GPUs or GPUs are disabled by DATACRUNCH_DISABLE_GPU, it returns nil.
 func (d *DatacrunchCloudProvider) GetNodeGpuConfig(node *apiv1.Node) *cloudprovider.GpuConfig {
-	return gpu.GetNodeGPUFromCloudProvider(d, node)
+	if d.manager.gpuDisabled {
+		return nil
+	}
+	gpuConfig := gpu.GetNodeGPUFromCloudProvider(d, node)
+	if gpuConfig == nil {
+		return nil
+	}
+
+	// the resource name may be configured per node group
+	if group, err := d.NodeGroupForNode(node); err == nil && group != nil {
+		gpuConfig.ResourceName = group.(*datacrunchNodeGroup).gpuResourceName()
+	} else if d.manager.clusterConfig.GPUResourceName != "" {
+		gpuConfig.ResourceName = d.manager.clusterConfig.GPUResourceName
+	}
+	return gpuConfig
 }
 
 // Cleanup cleans up open resources before the cloud provider is destroyed,
 // i.e. go routines etc.
 func (d *DatacrunchCloudProvider) Cleanup() error {
-	return nil
+	return d.manager.Cleanup()
 }
 
 // Refresh is called before every main loop and can be used to dynamically
 // update cloud provider state. In particular the list of node groups returned
 // by NodeGroups() can change as a result of CloudProvider.Refresh().
 func (d *DatacrunchCloudProvider) Refresh() error {
-	for _, group := range d.manager.nodeGroups {
-		group.resetTargetSize(0)
+	// The servers being created are counted before listing the servers, so
+	// that a server created in between is not missed.
+	inFlightCreates := make(map[string]int, len(d.manager.nodeGroups))
+	generations := make(map[string]int, len(d.manager.nodeGroups))
+	for id, group := range d.manager.nodeGroups {
+		inFlightCreates[id], generations[id] = group.sizeSnapshot()
+		group.checkInstanceType()
+	}
+
+	servers, err := d.manager.cachedServers.servers()
+	if err != nil {
+		klog.Warningf("failed to list servers, keeping node group target sizes: %v", err)
+		return nil
 	}
+
+	d.manager.deletingServers.prune(servers)
+	for id, group := range d.manager.nodeGroups {
+		group.reconcileTargetSize(servers, inFlightCreates[id], generations[id])
+		group.checkServerTypes(servers)
+	}
+	servers = d.manager.cleanupFailedServers(servers)
+	d.manager.cleanupOrphans(servers)
+	d.manager.refreshWarmPools(servers)
 	return nil
 }
 
@@ -200,62 +359,376 @@ func BuildDatacrunch(_ config.AutoscalingOptions, do cloudprovider.NodeGroupDisc
 		klog.Fatalf("No cluster config present provider: %v", err)
 	}
 
-	validNodePoolName := regexp.MustCompile(`^[a-z0-9A-Z]+[a-z0-9A-Z\-\.\_]*[a-z0-9A-Z]+$|^[a-z0-9A-Z]{1}$`)
-	clusterUpdateLock := sync.Mutex{}
+	specs := make([]*datacrunchNodeGroupSpec, 0, len(do.NodeGroupSpecs)+len(manager.clusterConfig.NodeGroups))
 	for _, nodegroupSpec := range do.NodeGroupSpecs {
-		spec, err := createNodePoolSpec(nodegroupSpec)
+		spec, err := createNodePoolSpec(nodegroupSpec, manager.defaultRegion)
 		if err != nil {
 			klog.Fatalf("Failed to parse pool spec `%s` provider: %v", nodegroupSpec, err)
 		}
+		specs = append(specs, spec)
+	}
+	for _, nodeGroupConfig := range manager.clusterConfig.NodeGroups {
+		spec, err := nodePoolSpecFromConfig(nodeGroupConfig, manager.defaultRegion)
+		if err != nil {
+			klog.Fatalf("Failed to parse node group %q of the cluster config: %v", nodeGroupConfig.Name, err)
+		}
+		specs = append(specs, spec)
+	}
+
+	for _, spec := range specs {
+		if err := validateNodePoolName(spec.name); err != nil {
+			klog.Fatalf("Invalid name of node pool %q: %v", spec.name, err)
+		}
+		if _, found := manager.nodeGroups[spec.name]; found {
+			klog.Fatalf("Node pool %s is defined more than once", spec.name)
+		}
 
-		validNodePoolName.MatchString(spec.name)
-		instances, err := manager.allServers(spec.name)
+		nodeGroup, err := newNodeGroupFromSpec(manager, spec)
 		if err != nil {
-			klog.Fatalf("Failed to get instances for node pool %s error: %v", nodegroupSpec, err)
+			klog.Fatalf("Failed to create node pool %s error: %v", spec.name, err)
 		}
+		manager.nodeGroups[spec.name] = nodeGroup
+	}
 
-		manager.nodeGroups[spec.name] = &datacrunchNodeGroup{
-			manager:            manager,
-			id:                 spec.name,
-			minSize:            spec.minSize,
-			maxSize:            spec.maxSize,
-			instanceType:       spec.instanceType,
-			region:             spec.region,
-			targetSize:         len(instances),
-			clusterUpdateMutex: &clusterUpdateLock,
+	for _, discoverySpec := range do.NodeGroupAutoDiscoverySpecs {
+		if err := discoverNodeGroups(manager, discoverySpec); err != nil {
+			klog.Fatalf("Failed to discover node groups with spec `%s`: %v", discoverySpec, err)
 		}
 	}
 
+	if priorities := manager.priorityExpanderConfig(); priorities != "" {
+		klog.Infof("Priorities of the node groups, set them in the ConfigMap of the priority expander if it is used:\n%s", priorities)
+	}
+
 	return provider
 }
 
-func createNodePoolSpec(groupSpec string) (*datacrunchNodeGroupSpec, error) {
-	tokens := strings.SplitN(groupSpec, ":", 5)
-	if len(tokens) != 5 {
-		return nil, fmt.Errorf("expected format `<min-servers>:<max-servers>:<machine-type>:<region>:<name>` got %s", groupSpec)
+// discoverNodeGroups registers the node groups of the servers tagged for auto
+// discovery. Node groups given by explicit specs take precedence, discovered
+// node groups need a node config in the cluster config.
+func discoverNodeGroups(manager *datacrunchManager, discoverySpec string) error {
+	cfg, err := parseAutoDiscoverySpec(discoverySpec)
+	if err != nil {
+		return err
 	}
 
-	definition := datacrunchNodeGroupSpec{
-		instanceType: tokens[2],
-		region:       tokens[3],
-		name:         tokens[4],
+	servers, err := manager.cachedServers.getAllServers()
+	if err != nil {
+		return fmt.Errorf("failed to list servers: %v", err)
 	}
-	if size, err := strconv.Atoi(tokens[0]); err == nil {
-		definition.minSize = size
-	} else {
-		return nil, fmt.Errorf("failed to set min size: %s, expected integer", tokens[0])
+
+	for _, spec := range discoverNodeGroupSpecs(cfg, servers) {
+		if _, exists := manager.nodeGroups[spec.name]; exists {
+			klog.V(2).Infof("Discovered node group %s is already configured by a spec", spec.name)
+			continue
+		}
+		if err := validateNodePoolName(spec.name); err != nil {
+			klog.Warningf("Ignoring discovered node group %q: %v", spec.name, err)
+			continue
+		}
+		if _, found := manager.clusterConfig.NodeConfigs[spec.name]; !found {
+			klog.Warningf("Ignoring discovered node group %s: no node config in cluster config", spec.name)
+			continue
+		}
+
+		nodeGroup, err := newNodeGroupFromSpec(manager, spec)
+		if err != nil {
+			return fmt.Errorf("failed to create discovered node group %s: %v", spec.name, err)
+		}
+		manager.nodeGroups[spec.name] = nodeGroup
+		klog.Infof("Discovered node group %s with instance type %s in regions %v", spec.name, spec.instanceType, spec.regions)
 	}
+	return nil
+}
 
-	if size, err := strconv.Atoi(tokens[1]); err == nil {
-		definition.maxSize = size
-	} else {
+// validateNodePoolName checks that the name can be used as prefix of server
+// hostnames and as value of the node group label.
+func validateNodePoolName(name string) error {
+	if len(name) > maxNodePoolNameLength {
+		return fmt.Errorf("name must be at most %d characters long, got %d", maxNodePoolNameLength, len(name))
+	}
+	if !validNodePoolName.MatchString(name) {
+		return fmt.Errorf("name must consist of alphanumeric characters, '-', '_' or '.', and must start and end with an alphanumeric character")
+	}
+	return nil
+}
+
+// newNodeGroupFromSpec builds the node group described by the spec. Timeouts
+// not set in the spec fall back to the defaults of the manager.
+func newNodeGroupFromSpec(manager *datacrunchManager, spec *datacrunchNodeGroupSpec) (*datacrunchNodeGroup, error) {
+	if len(spec.regions) == 0 {
+		return nil, fmt.Errorf("node group %s has no region", spec.name)
+	}
+	for _, region := range spec.regions {
+		if err := manager.cachedServerType.validateRegion(region); err != nil {
+			return nil, err
+		}
+	}
+
+	createTimeout := manager.serverCreateTimeout
+	if spec.createTimeout != 0 {
+		createTimeout = spec.createTimeout
+	}
+	registerTimeout := manager.serverRegisterTimeout
+	if spec.registerTimeout != 0 {
+		registerTimeout = spec.registerTimeout
+	}
+	if registerTimeout <= createTimeout {
+		return nil, fmt.Errorf("register timeout %s must be greater than create timeout %s", registerTimeout, createTimeout)
+	}
+
+	instances, err := manager.allServers(spec.name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get instances: %v", err)
+	}
+
+	group := &datacrunchNodeGroup{
+		manager:               manager,
+		id:                    spec.name,
+		minSize:               spec.minSize,
+		maxSize:               spec.maxSize,
+		instanceType:          spec.instanceType,
+		fallbackInstanceTypes: spec.fallbackInstanceTypes,
+		region:                spec.regions[0],
+		regions:               spec.regions,
+		spot:                  spec.spot,
+		reserved:              spec.reserved,
+		createTimeout:         createTimeout,
+		registerTimeout:       registerTimeout,
+		maxPods:               spec.maxPods,
+		tags:                  spec.tags,
+		options:               spec.options,
+		createLimiter:         newCreateRateLimiter(spec.createRate),
+		clusterUpdateMutex:    manager.clusterUpdateMutex,
+	}
+	group.resumeTargetSize(instances)
+	return group, nil
+}
+
+func createNodePoolSpec(groupSpec string, defaultRegion string) (*datacrunchNodeGroupSpec, error) {
+	tokens := strings.Split(groupSpec, ":")
+	if len(tokens) != 5 && len(tokens) != 6 {
+		return nil, fmt.Errorf("expected format `<min-servers>:<max-servers>:<machine-type>[,<machine-type>...]:<region>[,<region>...]:<name>[:<options>]` got %s", groupSpec)
+	}
+
+	// an empty region token selects the default region
+	regions := tokens[3]
+	if regions == "" {
+		if defaultRegion == "" {
+			return nil, fmt.Errorf("spec %s has no region and DATACRUNCH_DEFAULT_REGION is not set", groupSpec)
+		}
+		regions = defaultRegion
+	}
+
+	minSize, err := strconv.Atoi(tokens[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to set min size: %s, expected integer", tokens[0])
+	}
+	maxSize, err := strconv.Atoi(tokens[1])
+	if err != nil {
 		return nil, fmt.Errorf("failed to set max size: %s, expected integer", tokens[1])
 	}
 
-	return &definition, nil
+	definition, err := newNodePoolSpec(tokens[4], minSize, maxSize, strings.Split(tokens[2], ","), strings.Split(regions, ","))
+	if err != nil {
+		return nil, fmt.Errorf("invalid spec %s: %v", groupSpec, err)
+	}
+
+	if len(tokens) == 6 {
+		if err := parseNodePoolOptions(tokens[5], definition); err != nil {
+			return nil, fmt.Errorf("failed to parse options of node pool spec %s: %v", groupSpec, err)
+		}
+	}
+
+	return definition, nil
+}
+
+// nodePoolSpecFromConfig returns the spec of a node group defined in the
+// cluster config.
+func nodePoolSpecFromConfig(config NodeGroupConfig, defaultRegion string) (*datacrunchNodeGroupSpec, error) {
+	regions := config.Regions
+	if len(regions) == 0 {
+		if defaultRegion == "" {
+			return nil, fmt.Errorf("node group %s has no regions and DATACRUNCH_DEFAULT_REGION is not set", config.Name)
+		}
+		regions = []string{defaultRegion}
+	}
+
+	definition, err := newNodePoolSpec(config.Name, config.MinSize, config.MaxSize, config.InstanceTypes, regions)
+	if err != nil {
+		return nil, fmt.Errorf("invalid node group %s: %v", config.Name, err)
+	}
+
+	keys := slices.Sorted(maps.Keys(config.Options))
+	for _, key := range keys {
+		if err := setNodePoolOption(key, string(config.Options[key]), definition); err != nil {
+			return nil, fmt.Errorf("failed to parse options of node group %s: %v", config.Name, err)
+		}
+	}
+	if err := validateNodePoolOptions(definition); err != nil {
+		return nil, fmt.Errorf("failed to parse options of node group %s: %v", config.Name, err)
+	}
+
+	return definition, nil
+}
+
+// newNodePoolSpec returns the spec of a node pool without options. The first
+// instance type is preferred, the others are fallbacks.
+func newNodePoolSpec(name string, minSize, maxSize int, instanceTypes, regions []string) (*datacrunchNodeGroupSpec, error) {
+	if len(instanceTypes) == 0 || slices.Contains(instanceTypes, "") {
+		return nil, fmt.Errorf("instance types %q contain an empty instance type", instanceTypes)
+	}
+	if len(regions) == 0 || slices.Contains(regions, "") {
+		return nil, fmt.Errorf("regions %q contain an empty region", regions)
+	}
+	if minSize < 0 {
+		return nil, fmt.Errorf("min size %d must not be negative", minSize)
+	}
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("max size %d must be positive", maxSize)
+	}
+	if minSize > maxSize {
+		return nil, fmt.Errorf("min size %d is greater than max size %d", minSize, maxSize)
+	}
+
+	definition := &datacrunchNodeGroupSpec{
+		name:         name,
+		minSize:      minSize,
+		maxSize:      maxSize,
+		instanceType: instanceTypes[0],
+		regions:      slices.Clone(regions),
+	}
+	if len(instanceTypes) > 1 {
+		definition.fallbackInstanceTypes = slices.Clone(instanceTypes[1:])
+	}
+	return definition, nil
+}
+
+// parseNodePoolOptions parses the optional comma separated list of
+// `<key>=<value>` options of a node pool spec, e.g. `spot=true,create_timeout=10m`.
+func parseNodePoolOptions(options string, definition *datacrunchNodeGroupSpec) error {
+	for _, option := range strings.Split(options, ",") {
+		key, value, found := strings.Cut(option, "=")
+		if !found {
+			return fmt.Errorf("expected option format `<key>=<value>` got %s", option)
+		}
+		if err := setNodePoolOption(key, value, definition); err != nil {
+			return err
+		}
+	}
+	return validateNodePoolOptions(definition)
+}
+
+// setNodePoolOption sets a single option of a node pool spec.
+func setNodePoolOption(key, value string, definition *datacrunchNodeGroupSpec) error {
+	switch key {
+	case "spot":
+		spot, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("failed to set spot: %s, expected boolean", value)
+		}
+		definition.spot = spot
+	case "reserved":
+		reserved, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("failed to set reserved: %s, expected boolean", value)
+		}
+		definition.reserved = reserved
+	case "create_timeout":
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("failed to set create timeout: %s, expected positive duration", value)
+		}
+		definition.createTimeout = timeout
+	case "register_timeout":
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("failed to set register timeout: %s, expected positive duration", value)
+		}
+		definition.registerTimeout = timeout
+	case "create_rate":
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate <= 0 {
+			return fmt.Errorf("failed to set create rate: %s, expected positive number of servers per minute", value)
+		}
+		definition.createRate = rate
+	case "max_pods":
+		maxPods, err := strconv.Atoi(value)
+		if err != nil || maxPods <= 0 {
+			return fmt.Errorf("failed to set max pods: %s, expected positive integer", value)
+		}
+		definition.maxPods = maxPods
+	case "scale_down_utilization_threshold":
+		threshold, err := parseThresholdOption(key, value)
+		if err != nil {
+			return err
+		}
+		definition.options.scaleDownUtilizationThreshold = &threshold
+	case "scale_down_gpu_utilization_threshold":
+		threshold, err := parseThresholdOption(key, value)
+		if err != nil {
+			return err
+		}
+		definition.options.scaleDownGpuUtilizationThreshold = &threshold
+	case "scale_down_unneeded_time":
+		duration, err := parseDurationOption(key, value)
+		if err != nil {
+			return err
+		}
+		definition.options.scaleDownUnneededTime = duration
+	case "scale_down_unready_time":
+		duration, err := parseDurationOption(key, value)
+		if err != nil {
+			return err
+		}
+		definition.options.scaleDownUnreadyTime = duration
+	case "max_node_provision_time":
+		duration, err := parseDurationOption(key, value)
+		if err != nil {
+			return err
+		}
+		definition.options.maxNodeProvisionTime = duration
+	case "scale_down_disabled":
+		disabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("failed to set scale down disabled: %s, expected boolean", value)
+		}
+		definition.options.scaleDownDisabled = disabled
+	default:
+		return fmt.Errorf("unknown option %s", key)
+	}
+	return nil
+}
+
+// validateNodePoolOptions returns an error if the options of a node pool spec
+// contradict each other.
+func validateNodePoolOptions(definition *datacrunchNodeGroupSpec) error {
+	if definition.spot && definition.reserved {
+		return errors.New("spot and reserved are mutually exclusive")
+	}
+	return nil
+}
+
+func parseThresholdOption(key, value string) (float64, error) {
+	threshold, err := strconv.ParseFloat(value, 64)
+	if err != nil || threshold < 0 || threshold > 1 {
+		return 0, fmt.Errorf("failed to set %s: %s, expected number between 0 and 1", key, value)
+	}
+	return threshold, nil
+}
+
+func parseDurationOption(key, value string) (time.Duration, error) {
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("failed to set %s: %s, expected positive duration", key, value)
+	}
+	return duration, nil
 }
 
 func newDatacrunchCloudProvider(manager *datacrunchManager, rl *cloudprovider.ResourceLimiter) (*DatacrunchCloudProvider, error) {
+	// node groups check the limits before creating servers
+	manager.resourceLimiter = rl
+
 	return &DatacrunchCloudProvider{
 		manager:         manager,
 		resourceLimiter: rl,
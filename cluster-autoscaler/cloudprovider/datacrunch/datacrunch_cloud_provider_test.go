@@ -0,0 +1,798 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
+	"k8s.io/utils/ptr"
+)
+
+func TestNodeGroupForNode(t *testing.T) {
+	servers := []*datacrunchclient.Instance{
+		{ID: "id1", Hostname: "pool1-1a2b3c", Description: "pool1"},
+		{ID: "id2", Hostname: "pool1-4d5e6f", Description: "edited by someone"},
+		{ID: "id3", Hostname: "manually-created", Description: "pool1"},
+	}
+	manager := newTestManager(t, nil, servers)
+	manager.nodeGroups["pool1"] = &datacrunchNodeGroup{id: "pool1", manager: manager}
+	provider, err := newDatacrunchCloudProvider(manager, nil)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		node          *apiv1.Node
+		expectedGroup string
+	}{
+		{
+			name:          "by provider id",
+			node:          &apiv1.Node{Spec: apiv1.NodeSpec{ProviderID: "datacrunch://id1"}},
+			expectedGroup: "pool1",
+		},
+		{
+			name:          "description changed manually",
+			node:          &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "pool1-4d5e6f"}},
+			expectedGroup: "pool1",
+		},
+		{
+			name: "server not created by the autoscaler",
+			node: &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "manually-created"}},
+		},
+		{
+			name: "instance lookup fails, fall back to node label",
+			node: &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "unknown", Labels: map[string]string{
+				nodeGroupLabel: "pool1",
+			}}},
+			expectedGroup: "pool1",
+		},
+		{
+			name: "other provider",
+			node: &apiv1.Node{Spec: apiv1.NodeSpec{ProviderID: "aws://id1"}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			group, err := provider.NodeGroupForNode(tc.node)
+			require.NoError(t, err)
+			if tc.expectedGroup == "" {
+				assert.Nil(t, group)
+				return
+			}
+			require.NotNil(t, group)
+			assert.Equal(t, tc.expectedGroup, group.Id())
+		})
+	}
+}
+
+func TestNodeGroupForNodeCachesServers(t *testing.T) {
+	servers := []*datacrunchclient.Instance{
+		{ID: "id1", Hostname: "pool1-1a", Location: "FIN-01", Status: "running"},
+		{ID: "id2", Hostname: "pool1-2b", Location: "ICE-01", Status: "running"},
+	}
+	manager := newTestManager(t, nil, servers)
+	client := fakeClientOf(manager)
+	manager.cachedServers = newServersCache(manager.apiCallContext, client, manager.apiCallTimeout)
+	manager.nodeGroups["pool1"] = &datacrunchNodeGroup{id: "pool1", manager: manager}
+	provider, err := newDatacrunchCloudProvider(manager, nil)
+	require.NoError(t, err)
+
+	nodeIn := func(id, region string) *apiv1.Node {
+		return &apiv1.Node{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{apiv1.LabelTopologyRegion: region}},
+			Spec:       apiv1.NodeSpec{ProviderID: toProviderID(id)},
+		}
+	}
+	lookup := func(node *apiv1.Node) {
+		t.Helper()
+		group, err := provider.NodeGroupForNode(node)
+		require.NoError(t, err)
+		require.NotNil(t, group)
+		assert.Equal(t, "pool1", group.Id())
+	}
+
+	lookup(nodeIn("id1", "FIN-01"))
+	lookup(nodeIn("id1", "FIN-01"))
+	lookup(nodeIn("id2", "ICE-01"))
+	assert.Equal(t, int32(1), client.calls.Load())
+
+	// a delete only invalidates the servers of its region
+	require.NoError(t, manager.deleteServer(servers[0]))
+	calls := client.calls.Load()
+	lookup(nodeIn("id2", "ICE-01"))
+	assert.Equal(t, calls, client.calls.Load())
+	_, err = provider.NodeGroupForNode(nodeIn("id1", "FIN-01"))
+	require.NoError(t, err)
+	assert.Equal(t, calls+1, client.calls.Load())
+}
+
+func TestNewNodeGroup(t *testing.T) {
+	serverTypes := []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}
+	manager := newTestManager(t, serverTypes, nil)
+	manager.nodeGroups["pool1"] = &datacrunchNodeGroup{id: "pool1", manager: manager, region: "FIN-01"}
+	provider, err := newDatacrunchCloudProvider(manager, nil)
+	require.NoError(t, err)
+
+	_, err = provider.NewNodeGroup("1A100.22V", nil, nil, nil, nil)
+	require.Error(t, err, "autoprovisioning must be disabled without autoprovisioning node config")
+
+	manager.clusterConfig.AutoprovisioningNodeConfig = &NodeConfig{ImageType: "ubuntu-24.04", DiskSizeGB: 100}
+
+	_, err = provider.NewNodeGroup("unknown", nil, nil, nil, nil)
+	require.Error(t, err)
+
+	// the machine type must be available in the region
+	manager.cachedServerType.regionAvailability = parseRegionAvailability(datacrunchclient.InstanceAvailabilityList{
+		{LocationCode: "FIN-01", Availabilities: []string{"1A100.22V"}},
+		{LocationCode: "ICE-01"},
+	})
+	_, err = provider.NewNodeGroup("1A100.22V", map[string]string{apiv1.LabelTopologyRegion: "ICE-01"}, nil, nil, nil)
+	require.Error(t, err)
+	machineTypes, err := provider.GetAvailableMachineTypesInRegion("ICE-01")
+	require.NoError(t, err)
+	assert.Empty(t, machineTypes)
+
+	taints := []apiv1.Taint{{Key: "gpu", Effect: apiv1.TaintEffectNoSchedule}}
+	group, err := provider.NewNodeGroup("1A100.22V", map[string]string{"workload": "training"}, nil, taints, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, group.MinSize())
+	assert.Equal(t, autoprovisionedNodeGroupMaxSize, group.MaxSize())
+	assert.False(t, group.Exist())
+	assert.Len(t, provider.NodeGroups(), 1)
+
+	nodeGroup := group.(*datacrunchNodeGroup)
+	assert.Equal(t, "FIN-01", nodeGroup.region)
+	nodeConfig := nodeGroup.nodeConfig()
+	require.NotNil(t, nodeConfig)
+	assert.Equal(t, "ubuntu-24.04", nodeConfig.ImageType)
+	assert.Equal(t, "training", nodeConfig.Labels["workload"])
+	assert.Equal(t, taints, nodeConfig.Taints)
+	// theoretical node groups which are never created leave nothing behind
+	assert.NotContains(t, manager.clusterConfig.NodeConfigs, group.Id())
+
+	created, err := group.Create()
+	require.NoError(t, err)
+	assert.True(t, created.Exist())
+	assert.Same(t, nodeConfig, manager.clusterConfig.NodeConfigs[group.Id()])
+	assert.True(t, created.Autoprovisioned())
+	assert.Len(t, provider.NodeGroups(), 2)
+	assert.False(t, manager.nodeGroups["pool1"].Autoprovisioned())
+}
+
+func TestAutoprovisionedNodeGroupLifecycle(t *testing.T) {
+	serverTypes := []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}
+	manager := newTestManager(t, serverTypes, nil)
+	manager.clusterConfig.AutoprovisioningNodeConfig = &NodeConfig{ImageType: "ubuntu-24.04", DiskSizeGB: 100, InstanceOption: InstanceOptionOnDemandOnly}
+	static := newTestNodeGroup(manager, "pool1", 0, 3)
+	provider, err := newDatacrunchCloudProvider(manager, nil)
+	require.NoError(t, err)
+
+	group, err := provider.NewNodeGroup("1A100.22V", map[string]string{apiv1.LabelTopologyRegion: "FIN-01"}, nil, nil, nil)
+	require.NoError(t, err)
+	assert.False(t, group.Exist())
+
+	created, err := group.Create()
+	require.NoError(t, err)
+	require.True(t, created.Exist())
+	_, err = group.Create()
+	assert.Error(t, err, "node groups must only be created once")
+
+	require.NoError(t, created.IncreaseSize(2))
+	client := fakeClientOf(manager)
+	require.Len(t, client.servers, 2)
+
+	require.NoError(t, created.Delete())
+	assert.False(t, created.Exist())
+	assert.Len(t, client.deleted, 2)
+	assert.NotContains(t, manager.clusterConfig.NodeConfigs, created.Id())
+	assert.Len(t, provider.NodeGroups(), 1)
+
+	require.Error(t, static.Delete(), "node groups of the --nodes flag must not be deleted")
+	assert.True(t, static.Exist())
+}
+
+func TestCreateNodePoolSpec(t *testing.T) {
+	tests := []struct {
+		name          string
+		spec          string
+		defaultRegion string
+		expected      *datacrunchNodeGroupSpec
+	}{
+		{
+			name: "without options",
+			spec: "0:3:1A100.22V:FIN-01:gpu-nodes",
+			expected: &datacrunchNodeGroupSpec{
+				name: "gpu-nodes", minSize: 0, maxSize: 3, instanceType: "1A100.22V", regions: []string{"FIN-01"},
+			},
+		},
+		{
+			name: "multiple regions",
+			spec: "0:3:1A100.22V:FIN-01,ICE-01:gpu-nodes",
+			expected: &datacrunchNodeGroupSpec{
+				name: "gpu-nodes", minSize: 0, maxSize: 3, instanceType: "1A100.22V", regions: []string{"FIN-01", "ICE-01"},
+			},
+		},
+		{
+			name: "fallback instance types",
+			spec: "0:3:1A100.22V,1H100.80S,1A100.40S:FIN-01:gpu-nodes",
+			expected: &datacrunchNodeGroupSpec{
+				name: "gpu-nodes", minSize: 0, maxSize: 3, instanceType: "1A100.22V", fallbackInstanceTypes: []string{"1H100.80S", "1A100.40S"}, regions: []string{"FIN-01"},
+			},
+		},
+		{name: "empty instance type", spec: "0:3:1A100.22V,:FIN-01:gpu-nodes"},
+		{
+			name: "spot",
+			spec: "1:5:1A100.22V:FIN-01:gpu-nodes:spot=true",
+			expected: &datacrunchNodeGroupSpec{
+				name: "gpu-nodes", minSize: 1, maxSize: 5, instanceType: "1A100.22V", regions: []string{"FIN-01"}, spot: true,
+			},
+		},
+		{
+			name: "reserved",
+			spec: "1:5:1A100.22V:FIN-01:gpu-nodes:reserved=true",
+			expected: &datacrunchNodeGroupSpec{
+				name: "gpu-nodes", minSize: 1, maxSize: 5, instanceType: "1A100.22V", regions: []string{"FIN-01"}, reserved: true,
+			},
+		},
+		{name: "spot and reserved", spec: "1:5:1A100.22V:FIN-01:gpu-nodes:spot=true,reserved=true"},
+		{
+			name: "explicitly not spot",
+			spec: "1:5:1A100.22V:FIN-01:gpu-nodes:spot=false",
+			expected: &datacrunchNodeGroupSpec{
+				name: "gpu-nodes", minSize: 1, maxSize: 5, instanceType: "1A100.22V", regions: []string{"FIN-01"},
+			},
+		},
+		{
+			name: "timeouts",
+			spec: "0:3:1A100.22V:FIN-01:gpu-nodes:spot=true,create_timeout=15m,register_timeout=30m",
+			expected: &datacrunchNodeGroupSpec{
+				name: "gpu-nodes", minSize: 0, maxSize: 3, instanceType: "1A100.22V", regions: []string{"FIN-01"}, spot: true,
+				createTimeout: 15 * time.Minute, registerTimeout: 30 * time.Minute,
+			},
+		},
+		{
+			name: "max pods",
+			spec: "0:3:1A100.22V:FIN-01:gpu-nodes:max_pods=30",
+			expected: &datacrunchNodeGroupSpec{
+				name: "gpu-nodes", minSize: 0, maxSize: 3, instanceType: "1A100.22V", regions: []string{"FIN-01"}, maxPods: 30,
+			},
+		},
+		{name: "invalid max pods", spec: "0:3:1A100.22V:FIN-01:gpu-nodes:max_pods=0"},
+		{
+			name: "create rate",
+			spec: "0:3:1A100.22V:FIN-01:gpu-nodes:create_rate=0.5",
+			expected: &datacrunchNodeGroupSpec{
+				name: "gpu-nodes", minSize: 0, maxSize: 3, instanceType: "1A100.22V", regions: []string{"FIN-01"}, createRate: 0.5,
+			},
+		},
+		{name: "invalid create rate", spec: "0:3:1A100.22V:FIN-01:gpu-nodes:create_rate=0"},
+		{
+			name: "autoscaling options",
+			spec: "0:3:1A100.22V:FIN-01:gpu-nodes:max_node_provision_time=30m,scale_down_unneeded_time=1h,scale_down_unready_time=2h,scale_down_utilization_threshold=0,scale_down_gpu_utilization_threshold=0.8",
+			expected: &datacrunchNodeGroupSpec{
+				name: "gpu-nodes", minSize: 0, maxSize: 3, instanceType: "1A100.22V", regions: []string{"FIN-01"},
+				options: nodeGroupOptions{
+					scaleDownUtilizationThreshold:    ptr.To(0.0),
+					scaleDownGpuUtilizationThreshold: ptr.To(0.8),
+					scaleDownUnneededTime:            time.Hour,
+					scaleDownUnreadyTime:             2 * time.Hour,
+					maxNodeProvisionTime:             30 * time.Minute,
+				},
+			},
+		},
+		{
+			name: "scale down disabled",
+			spec: "0:3:1A100.22V:FIN-01:gpu-nodes:scale_down_disabled=true",
+			expected: &datacrunchNodeGroupSpec{
+				name: "gpu-nodes", minSize: 0, maxSize: 3, instanceType: "1A100.22V", regions: []string{"FIN-01"},
+				options: nodeGroupOptions{scaleDownDisabled: true},
+			},
+		},
+		{name: "invalid scale down disabled", spec: "0:3:1A100.22V:FIN-01:gpu-nodes:scale_down_disabled=never"},
+		{name: "threshold out of range", spec: "0:3:1A100.22V:FIN-01:gpu-nodes:scale_down_utilization_threshold=1.5"},
+		{name: "invalid provision time", spec: "0:3:1A100.22V:FIN-01:gpu-nodes:max_node_provision_time=0s"},
+		{name: "invalid timeout", spec: "0:3:1A100.22V:FIN-01:gpu-nodes:create_timeout=soon"},
+		{name: "negative timeout", spec: "0:3:1A100.22V:FIN-01:gpu-nodes:register_timeout=-5m"},
+		{
+			name: "min equals max",
+			spec: "2:2:1A100.22V:FIN-01:gpu-nodes",
+			expected: &datacrunchNodeGroupSpec{
+				name: "gpu-nodes", minSize: 2, maxSize: 2, instanceType: "1A100.22V", regions: []string{"FIN-01"},
+			},
+		},
+		{name: "non numeric min", spec: "a:2:1A100.22V:FIN-01:gpu-nodes"},
+		{name: "empty region", spec: "0:3:1A100.22V:FIN-01,:gpu-nodes"},
+		{
+			name:          "default region",
+			spec:          "0:3:1A100.22V::gpu-nodes",
+			defaultRegion: "ICE-01",
+			expected: &datacrunchNodeGroupSpec{
+				name: "gpu-nodes", minSize: 0, maxSize: 3, instanceType: "1A100.22V", regions: []string{"ICE-01"},
+			},
+		},
+		{name: "no region without default region", spec: "0:3:1A100.22V::gpu-nodes"},
+		{
+			name:          "explicit region takes precedence over default region",
+			spec:          "0:3:1A100.22V:FIN-01:gpu-nodes",
+			defaultRegion: "ICE-01",
+			expected: &datacrunchNodeGroupSpec{
+				name: "gpu-nodes", minSize: 0, maxSize: 3, instanceType: "1A100.22V", regions: []string{"FIN-01"},
+			},
+		},
+		{name: "too few tokens", spec: "0:3:1A100.22V:gpu-nodes"},
+		{name: "too many tokens", spec: "0:3:1A100.22V:FIN-01:gpu-nodes:spot=true:extra"},
+		{name: "invalid spot value", spec: "0:3:1A100.22V:FIN-01:gpu-nodes:spot=maybe"},
+		{name: "unknown option", spec: "0:3:1A100.22V:FIN-01:gpu-nodes:foo=bar"},
+		{name: "malformed option", spec: "0:3:1A100.22V:FIN-01:gpu-nodes:spot"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			spec, err := createNodePoolSpec(tc.spec, tc.defaultRegion)
+			if tc.expected == nil {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, spec)
+		})
+	}
+}
+
+func TestNodePoolSpecFromConfig(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "cluster-config.yaml")
+	require.NoError(t, os.WriteFile(configFile, []byte(`
+node_configs:
+  gpu-nodes:
+    image_type: ubuntu-24.04-cuda-12.8-open-docker
+    labels:
+      nodepool: gpu
+  cpu-nodes:
+    image_type: ubuntu-24.04
+node_groups:
+  - name: gpu-nodes
+    min_size: 0
+    max_size: 3
+    instance_types: [1H100.80S.30V, 1A100.22V]
+    regions: [FIN-01, ICE-01]
+    options:
+      spot: true
+      create_timeout: 10m
+      scale_down_gpu_utilization_threshold: 0.7
+  - name: cpu-nodes
+    min_size: 1
+    max_size: 10
+    instance_types: [CPU.4V.16G]
+`), 0600))
+	t.Setenv("DATACRUNCH_CLIENT_ID", "client-id")
+	t.Setenv("DATACRUNCH_CLIENT_SECRET", "client-secret")
+	t.Setenv("DATACRUNCH_CLUSTER_CONFIG_FILE", configFile)
+	client := newFakeClient(nil, nil)
+	client.images = append(client.images, datacrunchclient.ImageInfoResponseDto{ImageType: "ubuntu-24.04-cuda-12.8-open-docker"})
+	setAPIClient(t, client)
+
+	manager, err := newManager()
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, manager.Cleanup()) })
+	require.Len(t, manager.clusterConfig.NodeGroups, 2)
+	assert.Equal(t, "gpu", manager.clusterConfig.NodeConfigs["gpu-nodes"].Labels["nodepool"])
+
+	gpuSpec, err := nodePoolSpecFromConfig(manager.clusterConfig.NodeGroups[0], "")
+	require.NoError(t, err)
+	assert.Equal(t, &datacrunchNodeGroupSpec{
+		name: "gpu-nodes", minSize: 0, maxSize: 3, instanceType: "1H100.80S.30V", fallbackInstanceTypes: []string{"1A100.22V"}, regions: []string{"FIN-01", "ICE-01"},
+		spot: true, createTimeout: 10 * time.Minute, options: nodeGroupOptions{scaleDownGpuUtilizationThreshold: ptr.To(0.7)},
+	}, gpuSpec)
+
+	// the default region is used for node groups without regions
+	cpuSpec, err := nodePoolSpecFromConfig(manager.clusterConfig.NodeGroups[1], "FIN-01")
+	require.NoError(t, err)
+	assert.Equal(t, &datacrunchNodeGroupSpec{name: "cpu-nodes", minSize: 1, maxSize: 10, instanceType: "CPU.4V.16G", regions: []string{"FIN-01"}}, cpuSpec)
+	_, err = nodePoolSpecFromConfig(manager.clusterConfig.NodeGroups[1], "")
+	require.Error(t, err)
+
+	invalid := []NodeGroupConfig{
+		{Name: "no-types", MaxSize: 3, Regions: []string{"FIN-01"}},
+		{Name: "min-above-max", MinSize: 4, MaxSize: 3, InstanceTypes: []string{"1A100.22V"}, Regions: []string{"FIN-01"}},
+		{Name: "unknown-option", MaxSize: 3, InstanceTypes: []string{"1A100.22V"}, Regions: []string{"FIN-01"}, Options: map[string]NodeGroupOptionValue{"zone": "a"}},
+		{Name: "spot-and-reserved", MaxSize: 3, InstanceTypes: []string{"1A100.22V"}, Regions: []string{"FIN-01"}, Options: map[string]NodeGroupOptionValue{"spot": "true", "reserved": "true"}},
+	}
+	for _, config := range invalid {
+		_, err := nodePoolSpecFromConfig(config, "")
+		require.Error(t, err, config.Name)
+		assert.Contains(t, err.Error(), config.Name)
+	}
+}
+
+func TestNodeGroupOptionValueUnmarshal(t *testing.T) {
+	var options map[string]NodeGroupOptionValue
+	require.NoError(t, json.Unmarshal([]byte(`{"spot": true, "max_pods": 64, "create_timeout": "10m"}`), &options))
+	assert.Equal(t, map[string]NodeGroupOptionValue{"spot": "true", "max_pods": "64", "create_timeout": "10m"}, options)
+	require.Error(t, json.Unmarshal([]byte(`{"spot": [true]}`), &options))
+}
+
+func TestCreateNodePoolSpecInvalidSizes(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   string
+		values []string
+	}{
+		{name: "min greater than max", spec: "5:2:1A100.22V:FIN-01:gpu-nodes", values: []string{"5", "2"}},
+		{name: "negative min", spec: "-1:2:1A100.22V:FIN-01:gpu-nodes", values: []string{"-1"}},
+		{name: "zero max", spec: "0:0:1A100.22V:FIN-01:gpu-nodes", values: []string{"0"}},
+		{name: "negative max", spec: "0:-2:1A100.22V:FIN-01:gpu-nodes", values: []string{"-2"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := createNodePoolSpec(tc.spec, "")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.spec)
+			for _, value := range tc.values {
+				assert.Contains(t, err.Error(), value)
+			}
+		})
+	}
+}
+
+func TestNewNodeGroupFromSpecTimeouts(t *testing.T) {
+	manager := newTestManager(t, nil, nil)
+
+	tests := []struct {
+		name            string
+		spec            *datacrunchNodeGroupSpec
+		createTimeout   time.Duration
+		registerTimeout time.Duration
+		expectError     bool
+	}{
+		{
+			name:            "defaults",
+			spec:            &datacrunchNodeGroupSpec{name: "pool", regions: []string{"FIN-01"}},
+			createTimeout:   serverCreateTimeoutDefault,
+			registerTimeout: serverRegisterTimeoutDefault,
+		},
+		{
+			name:            "overrides",
+			spec:            &datacrunchNodeGroupSpec{name: "pool", regions: []string{"FIN-01"}, createTimeout: 20 * time.Minute, registerTimeout: 40 * time.Minute},
+			createTimeout:   20 * time.Minute,
+			registerTimeout: 40 * time.Minute,
+		},
+		{
+			name:        "create timeout exceeds default register timeout",
+			spec:        &datacrunchNodeGroupSpec{name: "pool", regions: []string{"FIN-01"}, createTimeout: 15 * time.Minute},
+			expectError: true,
+		},
+		{
+			name:        "register timeout equals create timeout",
+			spec:        &datacrunchNodeGroupSpec{name: "pool", regions: []string{"FIN-01"}, createTimeout: 10 * time.Minute, registerTimeout: 10 * time.Minute},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			group, err := newNodeGroupFromSpec(manager, tc.spec)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.createTimeout, group.createTimeout)
+			assert.Equal(t, tc.registerTimeout, group.registerTimeout)
+		})
+	}
+}
+
+func TestNewNodeGroupFromSpecRegions(t *testing.T) {
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+
+	spec, err := createNodePoolSpec("0:3:1A100.22V:FIN-01,eu-wst:pool", "")
+	require.NoError(t, err, "regions are validated against the API, not by the parser")
+	_, err = newNodeGroupFromSpec(manager, spec)
+	require.Error(t, err)
+	assert.EqualError(t, err, `unknown region "eu-wst", the DataCrunch regions are FIN-01, FIN-02, FIN-03, ICE-01`)
+
+	// regions are case-insensitive
+	spec, err = createNodePoolSpec("0:3:1A100.22V:fin-01:pool", "")
+	require.NoError(t, err)
+	_, err = newNodeGroupFromSpec(manager, spec)
+	require.NoError(t, err)
+
+	// the region of autoprovisioned node groups is validated as well
+	manager.clusterConfig.AutoprovisioningNodeConfig = &NodeConfig{ImageType: "ubuntu-24.04"}
+	provider, err := newDatacrunchCloudProvider(manager, nil)
+	require.NoError(t, err)
+	_, err = provider.NewNodeGroup("1A100.22V", map[string]string{apiv1.LabelTopologyRegion: "eu-wst"}, nil, nil, nil)
+	require.ErrorContains(t, err, `unknown region "eu-wst"`)
+
+	// regions listed by the availability API are known
+	manager.cachedServerType.regionAvailability = parseRegionAvailability(datacrunchclient.InstanceAvailabilityList{
+		{LocationCode: "FIN-01", Availabilities: []string{"1A100.22V"}},
+		{LocationCode: "NEW-01"},
+	})
+	spec, err = createNodePoolSpec("0:3:1A100.22V:FIN-01,NEW-01:pool", "")
+	require.NoError(t, err)
+	_, err = newNodeGroupFromSpec(manager, spec)
+	require.NoError(t, err)
+}
+
+func TestNewNodeGroupFromSpecResumesTargetSize(t *testing.T) {
+	servers := []*datacrunchclient.Instance{
+		{ID: "id1", Hostname: "pool-1a", Status: "running"},
+		// created by the previous run of the autoscaler
+		{ID: "id2", Hostname: "pool-2b", Status: "provisioning"},
+		{ID: "id3", Hostname: "pool-3c", Status: "deleting"},
+	}
+	manager := newTestManager(t, nil, servers)
+	provider, err := newDatacrunchCloudProvider(manager, nil)
+	require.NoError(t, err)
+
+	group, err := newNodeGroupFromSpec(manager, &datacrunchNodeGroupSpec{name: "pool", minSize: 0, maxSize: 5, instanceType: "1A100.22V", regions: []string{"FIN-01"}})
+	require.NoError(t, err)
+	manager.nodeGroups[group.id] = group
+	size, err := group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 2, size, "the server being provisioned must be counted")
+
+	instances, err := group.Nodes()
+	require.NoError(t, err)
+	states := make(map[string]cloudprovider.InstanceState, len(instances))
+	for _, instance := range instances {
+		states[instance.Id] = instance.Status.State
+	}
+	assert.Equal(t, cloudprovider.InstanceCreating, states[toProviderID("id2")])
+
+	require.NoError(t, provider.Refresh())
+	size, err = group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 2, size)
+	assert.Empty(t, fakeClientOf(manager).deployed, "no server is created again")
+}
+
+func TestValidateNodePoolName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{name: "gpu-nodes", valid: true},
+		{name: "a", valid: true},
+		{name: "GPU_nodes.v2", valid: true},
+		{name: strings.Repeat("a", maxNodePoolNameLength), valid: true},
+		{name: ""},
+		{name: "-gpu-nodes"},
+		{name: "gpu-nodes-"},
+		{name: "gpu nodes"},
+		{name: "gpu-nödes"},
+		{name: "gpu-nodes-🚀"},
+		{name: strings.Repeat("a", maxNodePoolNameLength+1)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateNodePoolName(tc.name)
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestRefreshCountsInFlightCreates(t *testing.T) {
+	serverTypes := []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}
+	servers := []*datacrunchclient.Instance{
+		{ID: "id1", Hostname: "pool-1a", Status: "running"},
+		{ID: "id2", Hostname: "pool-2b", Status: "deleting"},
+		{ID: "id3", Hostname: "other-3c", Status: "running"},
+	}
+	manager := newTestManager(t, serverTypes, servers)
+	group := newTestNodeGroup(manager, "pool", 0, 3)
+	provider, err := newDatacrunchCloudProvider(manager, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, provider.Refresh())
+	size, err := group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 1, size, "servers being deleted must not be counted")
+
+	deploying := make(chan struct{})
+	release := make(chan struct{})
+	client := fakeClientOf(manager)
+	client.onDeploy = func(req datacrunchclient.DeployInstanceRequest) {
+		close(deploying)
+		<-release
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- group.IncreaseSize(1)
+	}()
+	<-deploying
+
+	require.NoError(t, provider.Refresh())
+	size, err = group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 2, size, "the server being created must be counted")
+
+	close(release)
+	require.NoError(t, <-done)
+
+	require.NoError(t, provider.Refresh())
+	size, err = group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 2, size)
+	assert.Len(t, client.deployed, 1)
+}
+
+func TestRefreshIgnoresDeletingServers(t *testing.T) {
+	servers := []*datacrunchclient.Instance{
+		{ID: "id1", Hostname: "pool-1a", Status: "running"},
+		{ID: "id2", Hostname: "pool-2b", Status: "running"},
+		{ID: "id3", Hostname: "pool-3c", Status: "deleting"},
+		{ID: "id4", Hostname: "pool-4d", Status: "offline"},
+	}
+	manager := newTestManager(t, nil, servers)
+	group := newTestNodeGroup(manager, "pool", 0, 5)
+	provider, err := newDatacrunchCloudProvider(manager, nil)
+	require.NoError(t, err)
+	client := fakeClientOf(manager)
+	client.keepDeleted = true
+
+	states := func() map[string]cloudprovider.InstanceState {
+		t.Helper()
+		instances, err := group.Nodes()
+		require.NoError(t, err)
+		states := make(map[string]cloudprovider.InstanceState, len(instances))
+		for _, instance := range instances {
+			states[instance.Id] = instance.Status.State
+		}
+		return states
+	}
+
+	require.NoError(t, provider.Refresh())
+	size, err := group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 2, size)
+	assert.Equal(t, map[string]cloudprovider.InstanceState{
+		toProviderID("id1"): cloudprovider.InstanceRunning,
+		toProviderID("id2"): cloudprovider.InstanceRunning,
+		toProviderID("id3"): cloudprovider.InstanceDeleting,
+		toProviderID("id4"): cloudprovider.InstanceDeleting,
+	}, states())
+
+	// the API still lists a deleted server as running for a while
+	require.NoError(t, manager.deleteServer(servers[1]))
+	require.NoError(t, provider.Refresh())
+	size, err = group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 1, size)
+	assert.Equal(t, cloudprovider.InstanceDeleting, states()[toProviderID("id2")])
+
+	// deleted servers are forgotten once they are no longer listed
+	client.mu.Lock()
+	client.servers = client.servers[:1]
+	client.mu.Unlock()
+	require.NoError(t, provider.Refresh())
+	assert.False(t, manager.deletingServers.contains("id2"))
+}
+
+func TestRefreshMissingInstanceType(t *testing.T) {
+	serverTypes := []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}
+	servers := []*datacrunchclient.Instance{{ID: "id1", Hostname: "legacy-1a", Status: "running"}}
+	manager := newTestManager(t, serverTypes, servers)
+	valid := newTestNodeGroup(manager, "pool", 0, 3)
+	legacy := newTestNodeGroup(manager, "legacy", 0, 3)
+	legacy.instanceType = "1V100.6V"
+	provider, err := newDatacrunchCloudProvider(manager, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, provider.Refresh())
+	assert.Len(t, provider.NodeGroups(), 2)
+
+	err = legacy.IncreaseSize(1)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "instance type 1V100.6V is no longer available")
+	assert.Empty(t, fakeClientOf(manager).deployed)
+
+	// existing nodes can still be removed
+	node := &apiv1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "legacy-1a"},
+		Spec:       apiv1.NodeSpec{ProviderID: "datacrunch://id1"},
+	}
+	require.NoError(t, legacy.DeleteNodes([]*apiv1.Node{node}))
+	assert.Equal(t, []string{"id1"}, fakeClientOf(manager).deleted)
+
+	require.NoError(t, valid.IncreaseSize(1))
+
+	// the node group is scalable again once the type is back
+	fakeClientOf(manager).serverTypes = append(fakeClientOf(manager).serverTypes, datacrunchclient.InstanceType{InstanceType: "1V100.6V"})
+	_, err = manager.cachedServerType.serverTypes()
+	require.NoError(t, err)
+	require.NoError(t, provider.Refresh())
+	require.NoError(t, legacy.IncreaseSize(1))
+}
+
+func TestRefreshMismatchedInstanceType(t *testing.T) {
+	servers := []*datacrunchclient.Instance{
+		{ID: "id1", Hostname: "pool-1a", Status: "running", InstanceType: "1A100.22V"},
+		// resized in the DataCrunch dashboard
+		{ID: "id2", Hostname: "pool-2b", Status: "running", InstanceType: "8H100.80S.176V"},
+		{ID: "id3", Hostname: "pool-3c", Status: "running", InstanceType: "1H100.80S.30V"},
+	}
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, servers)
+	group := newTestNodeGroup(manager, "pool", 0, 5)
+	group.fallbackInstanceTypes = []string{"1H100.80S.30V"}
+	provider, err := newDatacrunchCloudProvider(manager, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, provider.Refresh())
+	assert.Equal(t, map[string]string{"id2": "8H100.80S.176V"}, group.mismatchedServers)
+	size, err := group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 3, size, "the mismatched server is still counted")
+
+	// the mismatch is cleared once the server has an instance type of the
+	// node group again
+	fakeClientOf(manager).servers[1].InstanceType = "1A100.22V"
+	require.NoError(t, provider.Refresh())
+	assert.Empty(t, group.mismatchedServers)
+}
+
+func TestRefreshExternalServersAboveMaxSize(t *testing.T) {
+	servers := []*datacrunchclient.Instance{
+		{ID: "id1", Hostname: "pool-1a", Status: "running"},
+		{ID: "id2", Hostname: "pool-2b", Status: "running"},
+		// created in the DataCrunch dashboard
+		{ID: "id3", Hostname: "pool-3c", Status: "running"},
+		{ID: "id4", Hostname: "pool-4d", Status: "running"},
+	}
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, servers)
+	group := newTestNodeGroup(manager, "pool", 1, 2)
+	provider, err := newDatacrunchCloudProvider(manager, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, provider.Refresh())
+	size, err := group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 2, size, "the target size is capped at the max size")
+	assert.Equal(t, 2, group.excessServers)
+	assert.Equal(t, 1, group.MinSize())
+	assert.Equal(t, 2, group.MaxSize())
+
+	nodes, err := group.Nodes()
+	require.NoError(t, err)
+	assert.Len(t, nodes, 4)
+	assert.Empty(t, fakeClientOf(manager).deleted, "excess servers must not be deleted")
+	assert.Error(t, group.IncreaseSize(1))
+
+	// the excess is cleared once the servers are gone
+	fakeClientOf(manager).servers = fakeClientOf(manager).servers[:2]
+	require.NoError(t, provider.Refresh())
+	assert.Equal(t, 0, group.excessServers)
+}
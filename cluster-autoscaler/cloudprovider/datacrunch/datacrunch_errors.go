@@ -0,0 +1,109 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	autoscalerErrors "k8s.io/autoscaler/cluster-autoscaler/utils/errors"
+
+	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
+)
+
+var (
+	// errOutOfCapacity is returned if a region has no capacity left for the
+	// instance type, servers can be created in other regions.
+	errOutOfCapacity = errors.New("out of capacity")
+	// errQuotaExceeded is returned if the quota or the balance of the project
+	// is exhausted, no servers can be created until it is raised.
+	errQuotaExceeded = errors.New("quota exceeded")
+	// errAuth is returned if the credentials are invalid or lack permissions.
+	errAuth = errors.New("authentication failed")
+)
+
+// classifyAPIError wraps the error of a DataCrunch API call with errAuth,
+// errQuotaExceeded or errOutOfCapacity if it is caused by one of them, in
+// that order, other errors are returned unchanged.
+func classifyAPIError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, errOutOfCapacity), errors.Is(err, errQuotaExceeded), errors.Is(err, errAuth):
+		return err
+	case isAuthError(err):
+		return fmt.Errorf("%w: %w", errAuth, err)
+	case isQuotaExceededError(err):
+		return fmt.Errorf("%w: %w", errQuotaExceeded, err)
+	case isOutOfCapacityError(err):
+		return fmt.Errorf("%w: %w", errOutOfCapacity, err)
+	}
+	return err
+}
+
+// isQuotaExceededError returns whether the API rejected a request because
+// the quota or the balance of the project is exhausted.
+func isQuotaExceededError(err error) bool {
+	var apiErr *datacrunchclient.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.StatusCode == http.StatusPaymentRequired {
+		return true
+	}
+	message := strings.ToLower(apiErr.Code + " " + apiErr.Message)
+	return strings.Contains(message, "quota") || strings.Contains(message, "insufficient funds") || strings.Contains(message, "insufficient balance")
+}
+
+// isNotFoundError returns whether the API rejected a request because the
+// server does not exist.
+func isNotFoundError(err error) bool {
+	var apiErr *datacrunchclient.APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// toAutoscalerError returns the error as autoscaler error, its type tells the
+// autoscaler why a scale up failed. Authentication errors take precedence
+// over quota errors, which take precedence over capacity errors.
+func toAutoscalerError(err error) autoscalerErrors.AutoscalerError {
+	switch {
+	case errors.Is(err, errAuth):
+		return autoscalerErrors.ToAutoscalerError(autoscalerErrors.ConfigurationError, err)
+	case errors.Is(err, errQuotaExceeded):
+		return autoscalerErrors.ToAutoscalerError(autoscalerErrors.CloudProviderError, err)
+	case errors.Is(err, errOutOfCapacity), errors.Is(err, errServerTypesUnavailable):
+		return autoscalerErrors.ToAutoscalerError(autoscalerErrors.TransientError, err)
+	}
+	return autoscalerErrors.ToAutoscalerError(autoscalerErrors.CloudProviderError, err)
+}
+
+// instanceErrorInfo returns the error info of a server which failed with the
+// error.
+func instanceErrorInfo(err error) *cloudprovider.InstanceErrorInfo {
+	switch {
+	case errors.Is(err, errOutOfCapacity):
+		return &cloudprovider.InstanceErrorInfo{ErrorClass: cloudprovider.OutOfResourcesErrorClass, ErrorCode: "no-capacity", ErrorMessage: err.Error()}
+	case errors.Is(err, errQuotaExceeded):
+		return &cloudprovider.InstanceErrorInfo{ErrorClass: cloudprovider.OutOfResourcesErrorClass, ErrorCode: "quota-exceeded", ErrorMessage: err.Error()}
+	case errors.Is(err, errAuth):
+		return &cloudprovider.InstanceErrorInfo{ErrorClass: cloudprovider.OtherErrorClass, ErrorCode: "auth-failed", ErrorMessage: err.Error()}
+	}
+	return &cloudprovider.InstanceErrorInfo{ErrorClass: cloudprovider.OtherErrorClass, ErrorCode: "create-failed", ErrorMessage: err.Error()}
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	autoscalerErrors "k8s.io/autoscaler/cluster-autoscaler/utils/errors"
+
+	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
+)
+
+func TestClassifyAPIError(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		sentinel   error
+		errorType  autoscalerErrors.AutoscalerErrorType
+		errorClass cloudprovider.InstanceErrorClass
+	}{
+		{
+			name:       "out of capacity",
+			statusCode: http.StatusBadRequest,
+			body:       `{"code": "invalid_request", "message": "Not enough resources to deploy instance"}`,
+			sentinel:   errOutOfCapacity,
+			errorType:  autoscalerErrors.TransientError,
+			errorClass: cloudprovider.OutOfResourcesErrorClass,
+		},
+		{
+			name:       "quota exceeded",
+			statusCode: http.StatusBadRequest,
+			body:       `{"code": "quota_exceeded", "message": "GPU quota exceeded for project"}`,
+			sentinel:   errQuotaExceeded,
+			errorType:  autoscalerErrors.CloudProviderError,
+			errorClass: cloudprovider.OutOfResourcesErrorClass,
+		},
+		{
+			name:       "quota exceeded reading like out of capacity",
+			statusCode: http.StatusBadRequest,
+			body:       `{"code": "quota_exceeded", "message": "Not enough resources to deploy instance, the GPU quota of the project is used up"}`,
+			sentinel:   errQuotaExceeded,
+			errorType:  autoscalerErrors.CloudProviderError,
+			errorClass: cloudprovider.OutOfResourcesErrorClass,
+		},
+		{
+			name:       "insufficient balance",
+			statusCode: http.StatusPaymentRequired,
+			body:       `{"code": "payment_required", "message": "Insufficient balance"}`,
+			sentinel:   errQuotaExceeded,
+			errorType:  autoscalerErrors.CloudProviderError,
+			errorClass: cloudprovider.OutOfResourcesErrorClass,
+		},
+		{
+			name:       "unauthorized",
+			statusCode: http.StatusUnauthorized,
+			body:       `{"code": "unauthorized_request", "message": "Access token is invalid"}`,
+			sentinel:   errAuth,
+			errorType:  autoscalerErrors.ConfigurationError,
+			errorClass: cloudprovider.OtherErrorClass,
+		},
+		{
+			name:       "forbidden",
+			statusCode: http.StatusForbidden,
+			body:       `{"code": "forbidden_action", "message": "Missing permission"}`,
+			sentinel:   errAuth,
+			errorType:  autoscalerErrors.ConfigurationError,
+			errorClass: cloudprovider.OtherErrorClass,
+		},
+		{
+			name:       "invalid request",
+			statusCode: http.StatusBadRequest,
+			body:       `{"code": "invalid_request", "message": "Invalid image"}`,
+			errorType:  autoscalerErrors.CloudProviderError,
+			errorClass: cloudprovider.OtherErrorClass,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			apiErr := &datacrunchclient.APIError{StatusCode: tc.statusCode}
+			require.NoError(t, json.Unmarshal([]byte(tc.body), apiErr))
+
+			err := classifyAPIError(fmt.Errorf("could not create instance type 1A100.22V in region FIN-01: %w", apiErr))
+			for _, sentinel := range []error{errOutOfCapacity, errQuotaExceeded, errAuth} {
+				assert.Equal(t, sentinel == tc.sentinel, errors.Is(err, sentinel), sentinel.Error())
+			}
+			assert.ErrorIs(t, err, apiErr)
+			assert.Equal(t, err, classifyAPIError(err), "classified errors are not wrapped again")
+
+			assert.Equal(t, tc.errorType, toAutoscalerError(err).Type())
+			assert.Equal(t, tc.errorClass, instanceErrorInfo(err).ErrorClass)
+		})
+	}
+}
+
+func TestIncreaseSizeErrorType(t *testing.T) {
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+	group := newTestNodeGroup(manager, "pool", 0, 5)
+	client := fakeClientOf(manager)
+	client.deployErr = func(req datacrunchclient.DeployInstanceRequest) error {
+		if len(client.deployed) == 1 {
+			return &datacrunchclient.APIError{StatusCode: http.StatusBadRequest, Code: "invalid_request", Message: "Not enough resources to deploy instance"}
+		}
+		return &datacrunchclient.APIError{StatusCode: http.StatusUnauthorized, Code: "unauthorized_request", Message: "Access token is invalid"}
+	}
+
+	err := group.IncreaseSize(2)
+	require.Error(t, err)
+	var autoscalerErr autoscalerErrors.AutoscalerError
+	require.ErrorAs(t, err, &autoscalerErr)
+	assert.Equal(t, autoscalerErrors.ConfigurationError, autoscalerErr.Type(), "authentication errors take precedence")
+	assert.ErrorIs(t, err, errOutOfCapacity)
+	assert.ErrorIs(t, err, errAuth)
+}
+
+func TestIncreaseSizeQuotaExceeded(t *testing.T) {
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+	group := newTestNodeGroup(manager, "pool", 0, 5)
+	group.regions = []string{"FIN-01", "ICE-01"}
+	client := fakeClientOf(manager)
+	apiErr := &datacrunchclient.APIError{StatusCode: http.StatusBadRequest}
+	require.NoError(t, json.Unmarshal([]byte(`{"code": "quota_exceeded", "message": "Not enough resources to deploy instance, the GPU quota of the project is used up"}`), apiErr))
+	client.deployErr = func(req datacrunchclient.DeployInstanceRequest) error {
+		return apiErr
+	}
+
+	err := group.IncreaseSize(1)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, errQuotaExceeded)
+	assert.NotErrorIs(t, err, errOutOfCapacity)
+	assert.Equal(t, autoscalerErrors.CloudProviderError, err.(autoscalerErrors.AutoscalerError).Type())
+	errorInfo := instanceErrorInfo(err)
+	assert.Equal(t, cloudprovider.OutOfResourcesErrorClass, errorInfo.ErrorClass)
+	assert.Equal(t, "quota-exceeded", errorInfo.ErrorCode)
+
+	// the quota applies to all regions, they are not tried and not penalized
+	assert.Len(t, client.deployed, 1)
+	assert.NoError(t, group.checkCapacityPenalties())
+	assert.Zero(t, manager.capacityPenalties.penalized(group.capacityKey(group.allPlacements()[0])))
+}
+
+func TestIsNotFoundError(t *testing.T) {
+	assert.True(t, isNotFoundError(fmt.Errorf("failed to delete server: %w", &datacrunchclient.APIError{StatusCode: http.StatusNotFound, Code: "not_found"})))
+	assert.False(t, isNotFoundError(&datacrunchclient.APIError{StatusCode: http.StatusInternalServerError}))
+	assert.False(t, isNotFoundError(errors.New("connection reset")))
+}
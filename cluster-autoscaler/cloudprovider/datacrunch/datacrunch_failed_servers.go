@@ -0,0 +1,127 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+
+	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
+)
+
+const (
+	// failedServerGracePeriod is the time a failed server is kept after its
+	// creation, e.g. for inspecting it.
+	failedServerGracePeriod     = 5 * time.Minute
+	failedServerCleanupInterval = time.Minute
+	// failedServerMaxDeletes is the number of failed servers deleted per
+	// cleanup, so a burst of failures doesn't trigger a burst of deletes.
+	failedServerMaxDeletes = 3
+)
+
+// failedServerCleanup throttles the deletion of failed servers.
+type failedServerCleanup struct {
+	sync.Mutex
+	clock   clock.Clock
+	lastRun time.Time
+}
+
+func newFailedServerCleanup(c clock.Clock) *failedServerCleanup {
+	return &failedServerCleanup{clock: c}
+}
+
+// due returns whether the cleanup should run and records the run.
+func (f *failedServerCleanup) due() bool {
+	f.Lock()
+	defer f.Unlock()
+	now := f.clock.Now()
+	if now.Sub(f.lastRun) < failedServerCleanupInterval {
+		return false
+	}
+	f.lastRun = now
+	return true
+}
+
+// isFailedServer returns whether the server failed to be created, e.g. it is
+// in status error or installation_failed or failed its validation command.
+func (m *datacrunchManager) isFailedServer(server *datacrunchclient.Instance) bool {
+	status := m.serverStatus(server)
+	return status != nil && status.State == cloudprovider.InstanceCreating && status.ErrorInfo != nil
+}
+
+// cleanupFailedServers deletes the failed servers of the node groups whose
+// nodes never registered and decrements the target sizes of their node
+// groups, so the autoscaler creates replacements if they are still needed.
+// It returns the servers which were not deleted.
+func (m *datacrunchManager) cleanupFailedServers(servers []*datacrunchclient.Instance) []*datacrunchclient.Instance {
+	if !m.failedServers.due() {
+		return servers
+	}
+
+	now := m.failedServers.clock.Now()
+	left := make([]*datacrunchclient.Instance, 0, len(servers))
+	deleted := 0
+	for _, server := range servers {
+		group, found := m.nodeGroups[m.nodeGroupIDForServer(server)]
+		if !found || !m.isFailedServer(server) || m.orphans.isRegistered(server.ID) {
+			left = append(left, server)
+			continue
+		}
+		createdAt, err := time.Parse(time.RFC3339, server.CreatedAt)
+		if err != nil {
+			klog.Warningf("Skipping cleanup of failed server %s, failed to parse creation time %q: %v", server.ID, server.CreatedAt, err)
+			left = append(left, server)
+			continue
+		}
+		if now.Sub(createdAt) < failedServerGracePeriod {
+			left = append(left, server)
+			continue
+		}
+		if deleted >= failedServerMaxDeletes {
+			klog.V(2).Infof("Deleted %d failed servers, deleting server %s of node group %s in the next cleanup", deleted, server.ID, group.id)
+			left = append(left, server)
+			continue
+		}
+
+		if m.dryRun {
+			klog.Infof("Dry run: would delete server %s of node group %s in status %s", server.ID, group.id, server.Status)
+			left = append(left, server)
+			continue
+		}
+		klog.Warningf("Deleting server %s of node group %s in status %s, its node never registered", server.ID, group.id, server.Status)
+		// warm servers are not counted in the target size
+		warm := m.warmPool.isHeld(server.ID)
+		if err := m.deleteServer(server); err != nil {
+			klog.Errorf("failed to delete failed server %s: %v", server.ID, err)
+			left = append(left, server)
+			continue
+		}
+		deleted++
+		if warm {
+			continue
+		}
+
+		group.sizeMutex.Lock()
+		group.targetSize = max(group.targetSize-1, 0)
+		group.sizeMutex.Unlock()
+	}
+	return left
+}
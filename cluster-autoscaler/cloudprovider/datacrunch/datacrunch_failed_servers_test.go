@@ -0,0 +1,121 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	testclock "k8s.io/utils/clock/testing"
+
+	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
+)
+
+func TestCleanupFailedServers(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-time.Hour).Format(time.RFC3339)
+	servers := []*datacrunchclient.Instance{
+		{ID: "1a", Hostname: "pool-1a", Status: "running", CreatedAt: old},
+		{ID: "2b", Hostname: "pool-2b", Status: "error", CreatedAt: old},
+		// within the grace period
+		{ID: "3c", Hostname: "pool-3c", Status: "installation_failed", CreatedAt: now.Add(-time.Minute).Format(time.RFC3339)},
+		// not part of a node group
+		{ID: "4d", Hostname: "manual-4d", Status: "error", CreatedAt: old},
+	}
+	manager := newTestManager(t, nil, servers)
+	fakeClock := testclock.NewFakeClock(now)
+	manager.failedServers = newFailedServerCleanup(fakeClock)
+	group := newTestNodeGroup(manager, "pool", 0, 5)
+	provider, err := newDatacrunchCloudProvider(manager, nil)
+	require.NoError(t, err)
+	client := fakeClientOf(manager)
+
+	require.NoError(t, provider.Refresh())
+	assert.Equal(t, []string{"2b"}, client.deleted)
+	size, err := group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 2, size)
+
+	// the cleanup is throttled
+	client.mu.Lock()
+	client.servers = append(client.servers, datacrunchclient.Instance{ID: "5e", Hostname: "pool-5e", Status: "error", CreatedAt: old})
+	client.mu.Unlock()
+	require.NoError(t, provider.Refresh())
+	assert.Equal(t, []string{"2b"}, client.deleted)
+
+	fakeClock.Step(failedServerCleanupInterval)
+	require.NoError(t, provider.Refresh())
+	assert.Equal(t, []string{"2b", "5e"}, client.deleted)
+
+	// the grace period of the third server expired
+	fakeClock.Step(failedServerGracePeriod)
+	require.NoError(t, provider.Refresh())
+	assert.Equal(t, []string{"2b", "5e", "3c"}, client.deleted)
+}
+
+func TestFailedValidationServerIsReplaced(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-time.Hour).Format(time.RFC3339)
+	servers := []*datacrunchclient.Instance{
+		// the validation command failed, the server shut down
+		{ID: "1a", Hostname: "pool-1a", Status: "offline", CreatedAt: old},
+		{ID: "2b", Hostname: "pool-2b", Status: "running", CreatedAt: old},
+		// shut down servers of node groups without validation command are
+		// not failed
+		{ID: "3c", Hostname: "other-3c", Status: "offline", CreatedAt: old},
+	}
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, servers)
+	manager.failedServers = newFailedServerCleanup(testclock.NewFakeClock(now))
+	group := newTestNodeGroup(manager, "pool", 0, 5)
+	group.targetSize = 2
+	other := newTestNodeGroup(manager, "other", 0, 5)
+	other.targetSize = 1
+	manager.clusterConfig.NodeConfigs["pool"].ValidationCommand = "nvidia-smi"
+	provider, err := newDatacrunchCloudProvider(manager, nil)
+	require.NoError(t, err)
+	client := fakeClientOf(manager)
+
+	instances, err := group.Nodes()
+	require.NoError(t, err)
+	require.Len(t, instances, 2)
+	require.NotNil(t, instances[0].Status.ErrorInfo)
+	assert.Equal(t, cloudprovider.InstanceCreating, instances[0].Status.State)
+	assert.Equal(t, "validation-failed", instances[0].Status.ErrorInfo.ErrorCode)
+	assert.Nil(t, instances[1].Status.ErrorInfo)
+
+	instances, err = other.Nodes()
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	assert.Equal(t, cloudprovider.InstanceDeleting, instances[0].Status.State)
+
+	// the failed server is deleted and replaced by the next scale up
+	require.NoError(t, provider.Refresh())
+	assert.Equal(t, []string{"1a"}, client.deleted)
+	size, err := group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 1, size)
+
+	require.NoError(t, group.IncreaseSize(1))
+	require.Len(t, client.deployed, 1)
+	assert.Equal(t, "pool", nodeGroupIDForHostname(client.deployed[0].Hostname))
+	size, err = group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 2, size)
+}
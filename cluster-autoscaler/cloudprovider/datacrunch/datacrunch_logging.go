@@ -0,0 +1,57 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"fmt"
+	"strings"
+)
+
+// logFields is the context of a log message about an operation of a node
+// group. It prefixes the message, so the messages of several node groups can
+// be told apart and grepped for.
+//
+// Log messages use consistent verbosity levels:
+//   - info: scale ups, scale downs and changes of the target size
+//   - 2: every server created or deleted and retried operations
+//   - 4: API requests and other details
+type logFields struct {
+	nodeGroup string
+	region    string
+	server    string
+}
+
+// String returns the fields which are set, e.g.
+// "[node_group=gpu-nodes region=FIN-01 server=abc]".
+func (f logFields) String() string {
+	fields := make([]string, 0, 3)
+	for _, field := range []struct{ key, value string }{
+		{"node_group", f.nodeGroup},
+		{"region", f.region},
+		{"server", f.server},
+	} {
+		if field.value != "" {
+			fields = append(fields, fmt.Sprintf("%s=%s", field.key, field.value))
+		}
+	}
+	return "[" + strings.Join(fields, " ") + "]"
+}
+
+// logFields returns the log context of the node group.
+func (n *datacrunchNodeGroup) logFields() logFields {
+	return logFields{nodeGroup: n.id}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
+	"k8s.io/klog/v2"
+)
+
+// logBuffer collects log output, servers created in the background log
+// concurrently to the test reading the output.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	klog.Flush()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLogs redirects the log output at the verbosity to the returned buffer
+// until the end of the test.
+func captureLogs(t *testing.T, verbosity string) *logBuffer {
+	fs := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(fs)
+	require.NoError(t, fs.Set("logtostderr", "false"))
+	require.NoError(t, fs.Set("v", verbosity))
+
+	buf := &logBuffer{}
+	klog.SetOutput(buf)
+	t.Cleanup(func() {
+		klog.Flush()
+		klog.SetOutput(os.Stderr)
+		require.NoError(t, fs.Set("v", "0"))
+		require.NoError(t, fs.Set("logtostderr", "true"))
+	})
+	return buf
+}
+
+func TestLogFields(t *testing.T) {
+	assert.Equal(t, "[node_group=pool]", logFields{nodeGroup: "pool"}.String())
+	assert.Equal(t, "[node_group=pool region=FIN-01 server=id1]", logFields{nodeGroup: "pool", region: "FIN-01", server: "id1"}.String())
+	assert.Equal(t, "[region=FIN-01]", logFields{region: "FIN-01"}.String())
+}
+
+func TestIncreaseSizeLogsNodeGroupContext(t *testing.T) {
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+	group := newTestNodeGroup(manager, "pool", 0, 3)
+	client := fakeClientOf(manager)
+	logs := captureLogs(t, "2")
+
+	require.NoError(t, group.IncreaseSize(2))
+
+	output := logs.String()
+	assert.Contains(t, output, "[node_group=pool] Increasing size by 2 from 0 to 2")
+	require.Len(t, client.servers, 2)
+	for _, server := range client.servers {
+		assert.Contains(t, output, "[node_group=pool region=FIN-01 server="+server.ID+"] Created server of instance type 1A100.22V")
+	}
+	assert.Contains(t, output, "[node_group=pool] Set size from 2 to 2, expected delta 2")
+}
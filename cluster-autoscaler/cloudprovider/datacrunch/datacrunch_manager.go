@@ -22,30 +22,256 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net"
+	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/yaml"
 
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
 	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
 )
 
+// datacrunchAPIClient is the subset of the DataCrunch API used by the
+// provider. It is implemented by *datacrunchclient.Client.
+type datacrunchAPIClient interface {
+	ListInstances(ctx context.Context, status string) (datacrunchclient.InstanceList, error)
+	GetInstance(ctx context.Context, id string) (*datacrunchclient.Instance, error)
+	DeployInstance(ctx context.Context, reqBody datacrunchclient.DeployInstanceRequest) (string, error)
+	PerformInstanceAction(ctx context.Context, reqBody datacrunchclient.InstanceActionRequest) error
+	ListInstanceTypes(ctx context.Context) (datacrunchclient.InstanceTypeList, error)
+	GetInstanceTypeAvailability(ctx context.Context, instanceType string, isSpot bool, locationCode string) (bool, error)
+	ListInstanceAvailability(ctx context.Context, isSpot bool, locationCode string) (datacrunchclient.InstanceAvailabilityList, error)
+	UploadStartupScript(ctx context.Context, name string, script string) (string, error)
+	ListVolumes(ctx context.Context, status string) ([]datacrunchclient.Volume, error)
+	ListVolumesInTrash(ctx context.Context) ([]datacrunchclient.VolumeInTrash, error)
+	DeleteVolume(ctx context.Context, volumeID string, isPermanent bool) error
+	ListSSHKeys(ctx context.Context) ([]datacrunchclient.SSHKey, error)
+	ListImages(ctx context.Context) ([]datacrunchclient.ImageInfoResponseDto, error)
+	ListStartupScripts(ctx context.Context) ([]datacrunchclient.StartupScript, error)
+	DeleteStartupScript(ctx context.Context, scriptID string) error
+	CloseIdleConnections()
+}
+
+var _ datacrunchAPIClient = (*datacrunchclient.Client)(nil)
+
+// newAPIClient creates the client of the manager, it is replaced by tests.
+var newAPIClient = func(clientID string, clientSecret func() (string, error)) datacrunchAPIClient {
+	return datacrunchclient.NewClientWithSecretFunc(clientID, clientSecret)
+}
+
+var (
+	// errServerNotFound is returned if no server exists for a node.
+	errServerNotFound = errors.New("server not found")
+	// errNotInNodeGroup is returned by DeleteNodes for nodes of other node
+	// groups.
+	errNotInNodeGroup = errors.New("does not belong to the node group")
+	// errWrongRegion is returned if a server was created in another region
+	// than requested.
+	errWrongRegion = errors.New("server created in wrong region")
+)
+
 // datacrunchManager handles Datacrunch communication and data caching of
 // node groups
 type datacrunchManager struct {
-	client           *datacrunchclient.Client
+	client           datacrunchAPIClient
 	nodeGroups       map[string]*datacrunchNodeGroup
-	apiCallContext   context.Context
 	clusterConfig    *ClusterConfig
 	cachedServerType *serverTypeCache
+	cachedPrices     *priceCache
 	cachedServers    *serversCache
+
+	// apiCallContext is the parent of the contexts of all DataCrunch API
+	// calls, each call is bounded by apiCallTimeout.
+	apiCallContext context.Context
+	apiCallTimeout time.Duration
+
+	// serverCreateTimeout and serverRegisterTimeout are the defaults for node
+	// groups that do not set their own timeouts.
+	serverCreateTimeout   time.Duration
+	serverRegisterTimeout time.Duration
+	// registerGracePeriod is the time after the register timeout before a
+	// server whose node did not register is deleted as an orphan, so a slow
+	// join is not raced by the deletion.
+	registerGracePeriod time.Duration
+
+	// createMaxAttempts is the number of attempts to create a server when
+	// the DataCrunch API returns transient errors.
+	createMaxAttempts int
+	// createRetryBackoff is the initial backoff between attempts to create
+	// a server, it is doubled after every attempt.
+	createRetryBackoff time.Duration
+	// nodeLookupMaxAttempts is the number of attempts to look up the server
+	// of a node when listing the servers fails with a transient error.
+	nodeLookupMaxAttempts int
+	// nodeLookupBackoff is the initial backoff between attempts to look up
+	// the server of a node, it is doubled after every attempt.
+	nodeLookupBackoff time.Duration
+	// createSemaphores limits the number of servers created concurrently
+	// per region.
+	createSemaphores *regionSemaphores
+	// createStagger is the delay between the creates of a scale up by more
+	// than createStaggerAbove servers, it smooths the load on the API and
+	// the registration of the nodes.
+	createStagger      time.Duration
+	createStaggerAbove int
+	// waitForRunning makes scale ups wait for the created servers to run
+	// within the create timeout, servers which do not are deleted.
+	waitForRunning bool
+	// deleteMaxInFlight is the number of servers deleted concurrently by a
+	// scale down, the servers are deleted in batches of that size with
+	// deleteBatchDelay between the batches.
+	deleteMaxInFlight int
+	deleteBatchDelay  time.Duration
+	// scaleUpBackoff is the time node groups are not scaled up after a scale
+	// up failed to create any server.
+	scaleUpBackoff time.Duration
+	// capacityPenalties holds the instance types and regions which recently
+	// ran out of capacity.
+	capacityPenalties *capacityPenalties
+	// maxNodesTotal is the maximum number of servers of all node groups, no
+	// node group is scaled up beyond it. 0 disables the limit.
+	maxNodesTotal int
+	// registrationPollInterval is the interval at which created servers are
+	// polled until their node registered.
+	registrationPollInterval time.Duration
+
+	// clusterUpdateMutex serializes the operations which create or delete
+	// servers and change target sizes, of all node groups: IncreaseSize,
+	// DeleteNodes, DecreaseTargetSize and creating and deleting node groups.
+	// Scale ups and downs hold it until their servers are created or deleted
+	// and the target size is reset. Refresh does not take it, it reconciles
+	// the target sizes while servers are created by counting the servers in
+	// flight and leaving out the servers being deleted.
+	clusterUpdateMutex *sync.Mutex
+	// nodeGroupsMutex guards nodeGroups and the node configs of the cluster
+	// config against readers outside of the autoscaler loop, e.g. Status.
+	// The maps are only changed by the loop, which reads nodeGroups without
+	// the lock.
+	nodeGroupsMutex sync.RWMutex
+
+	// resourceLimiter holds the cluster wide resource limits, it is nil if
+	// no limits are configured.
+	resourceLimiter *cloudprovider.ResourceLimiter
+
+	// pendingRegistrations holds the servers created by the autoscaler
+	// whose nodes have not been seen yet.
+	pendingRegistrations *pendingRegistrations
+
+	// clusterName is tagged on the created servers, servers of the cluster
+	// whose nodes don't register are deleted by cleanupOrphans.
+	clusterName string
+	orphans     *orphanCleanup
+	// tagKeys are the keys of the cluster and node group tags of the created
+	// servers, prefixed with DATACRUNCH_TAG_PREFIX.
+	tagKeys serverTagKeys
+	// deletingServers holds the servers deleted by the autoscaler, which
+	// are not counted towards the target sizes while the API still lists
+	// them.
+	deletingServers *deletingServers
+	// failedServers throttles the deletion of failed servers whose nodes
+	// never registered.
+	failedServers *failedServerCleanup
+	// warmPool holds the warm servers of the node groups.
+	warmPool *warmPool
+
+	// defaultRegion is used by node group specs without a region.
+	defaultRegion string
+
+	// nodeNamePattern maps the names of nodes without provider ID to server
+	// hostnames with nodeNameReplacement, if it matches.
+	nodeNamePattern     *regexp.Regexp
+	nodeNameReplacement string
+
+	// dryRun makes IncreaseSize and DeleteNodes log the servers they would
+	// create and delete instead of calling the DataCrunch API.
+	dryRun bool
+
+	// gpuDisabled makes the node groups ignore the GPUs of their instance
+	// types, for clusters without GPU workloads.
+	gpuDisabled bool
+
+	// writeAccessCheck makes newManager probe the write access of the
+	// credentials, see checkWriteAccess.
+	writeAccessCheck bool
+
+	// backgroundCtx is cancelled by Cleanup to stop the goroutines started
+	// by goBackground, backgroundWG tracks them until they returned.
+	backgroundCtx    context.Context
+	cancelBackground context.CancelFunc
+	backgroundWG     sync.WaitGroup
+	// backgroundMutex guards backgroundClosed, which is set by Cleanup, so no
+	// goroutine is added to backgroundWG once Cleanup waits for them.
+	backgroundMutex  sync.Mutex
+	backgroundClosed bool
 }
 
 // ClusterConfig holds the configuration for all the nodepools
 type ClusterConfig struct {
 	NodeConfigs map[string]*NodeConfig `json:"node_configs"`
+	// AutoprovisioningNodeConfig is used as base configuration for node
+	// groups created by node autoprovisioning. Autoprovisioning is disabled
+	// if not set.
+	AutoprovisioningNodeConfig *NodeConfig `json:"autoprovisioning_node_config,omitempty"`
+	// GPUResourceName is the resource name the device plugin advertises the
+	// GPUs of all node groups with, default nvidia.com/gpu.
+	GPUResourceName apiv1.ResourceName `json:"gpu_resource_name,omitempty"`
+	// NodeGroups are defined in addition to the node groups of the --nodes
+	// flag, with the same fields and options.
+	NodeGroups []NodeGroupConfig `json:"node_groups,omitempty"`
+}
+
+// NodeGroupConfig defines a node group in the cluster config, as alternative
+// to the `<min>:<max>:<instance-type>:<region>:<name>` spec of the --nodes
+// flag. Its node config is the node config of the same name.
+type NodeGroupConfig struct {
+	Name    string `json:"name"`
+	MinSize int    `json:"min_size"`
+	MaxSize int    `json:"max_size"`
+	// InstanceTypes are tried in order, the first one is used for template
+	// nodes.
+	InstanceTypes []string `json:"instance_types"`
+	// Regions are tried in order, DATACRUNCH_DEFAULT_REGION is used if empty.
+	Regions []string `json:"regions,omitempty"`
+	// Options are the options of the node group spec, e.g. `spot: true`.
+	Options map[string]NodeGroupOptionValue `json:"options,omitempty"`
+}
+
+// NodeGroupOptionValue is the value of a node group option. It can be given
+// as string, boolean or number.
+type NodeGroupOptionValue string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (v *NodeGroupOptionValue) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch value := value.(type) {
+	case string:
+		*v = NodeGroupOptionValue(value)
+	case bool, float64:
+		*v = NodeGroupOptionValue(data)
+	default:
+		return fmt.Errorf("option value %s must be a string, boolean or number", data)
+	}
+	return nil
 }
 
 // InstanceOption is the option for the instance type
@@ -81,20 +307,81 @@ type NodeConfig struct {
 	// useful in MiG scenarios when MiG is configured as part of a startup script
 	OverrideNumGPUs *int `json:"override_num_gpus"`
 	// base64 encoded startup script. Takes precedence over StartupScriptFetchUrl.
-	StartupScriptBase64 string         `json:"startup_script_base64"`
-	InstanceOption      InstanceOption `json:"instance_option"`
-	PricingOption       *PricingOption `json:"pricing_option,omitempty"`
-	SSHKeyIDs           []string       `json:"ssh_key_ids"`
+	StartupScriptBase64 string `json:"startup_script_base64"`
+	// base64 encoded startup scripts keyed by region, e.g. for regions with
+	// their own join endpoint or registry mirror. Takes precedence over
+	// StartupScriptBase64 in the regions it is set for.
+	RegionStartupScriptsBase64 map[string]string `json:"region_startup_scripts_base64,omitempty"`
+	// ID of a startup script already uploaded to DataCrunch. Only used if no
+	// startup script is configured, it is not combined with the pre-script.
+	StartupScriptID string         `json:"startup_script_id"`
+	InstanceOption  InstanceOption `json:"instance_option"`
+	PricingOption   *PricingOption `json:"pricing_option,omitempty"`
+	SSHKeyIDs       []string       `json:"ssh_key_ids"`
+	// GPUResourceName overrides the GPU resource name of the cluster config
+	// for the node group, e.g. for AMD or custom accelerators.
+	GPUResourceName apiv1.ResourceName `json:"gpu_resource_name,omitempty"`
+	// DataVolumes are created and attached with every server, they are
+	// deleted along with the server.
+	DataVolumes []DataVolumeConfig `json:"data_volumes,omitempty"`
+	// ReservedMemory is the memory reserved by the kubelet for system and
+	// kube daemons, either a quantity like "2Gi" or a percentage of the
+	// memory of the server type like "10%". It is not allocatable by pods.
+	ReservedMemory string `json:"reserved_memory,omitempty"`
+	// ScaleDownUtilizationThreshold and ScaleDownGpuUtilizationThreshold
+	// override the scale down utilization thresholds of the autoscaler for
+	// the node group. The options of the node group spec take precedence.
+	ScaleDownUtilizationThreshold    *float64 `json:"scale_down_utilization_threshold,omitempty"`
+	ScaleDownGpuUtilizationThreshold *float64 `json:"scale_down_gpu_utilization_threshold,omitempty"`
+	// WarmPoolSize is the number of booted servers kept for the node group
+	// whose nodes are held back from registration until a scale up releases
+	// them. Warm servers are paid for while they wait.
+	WarmPoolSize int `json:"warm_pool_size,omitempty"`
+	// KubeletExtraArgs are appended to KUBELET_EXTRA_ARGS of the pre-script,
+	// e.g. "--max-pods=200". The resources reserved by --kube-reserved and
+	// --system-reserved are not allocatable on the template node.
+	KubeletExtraArgs []string `json:"kubelet_extra_args,omitempty"`
+	// ValidationCommand is run by the pre-script before the node joins the
+	// cluster, e.g. "nvidia-smi" to check the GPU drivers. A server whose
+	// command fails shuts down and is replaced.
+	ValidationCommand string `json:"validation_command,omitempty"`
+	// Priority is the priority of the node group for the priority expander.
+	// The expander reads the priorities from its own ConfigMap, they are
+	// reported by Status and logged at startup to keep it consistent.
+	Priority int `json:"priority,omitempty"`
+}
+
+// DataVolumeConfig is a data volume of the servers of a node group. The
+// volume of a server is named `<hostname>-data-<name>`.
+type DataVolumeConfig struct {
+	Name   string `json:"name"`
+	SizeGB int    `json:"size_gb"`
+	// Type is the volume type, e.g. NVMe or HDD.
+	Type string `json:"type"`
+}
+
+// dataVolumeName returns the name of the data volume of a server.
+func dataVolumeName(hostname, name string) string {
+	return hostname + "-data-" + name
+}
+
+// isServerVolume returns whether the volume is the OS volume or a data volume
+// created by the autoscaler for the server.
+func isServerVolume(instance *datacrunchclient.Instance, volumeName string) bool {
+	return volumeName == instance.Hostname || strings.HasPrefix(volumeName, dataVolumeName(instance.Hostname, ""))
 }
 
 func newManager() (*datacrunchManager, error) {
 	token := os.Getenv("DATACRUNCH_CLIENT_ID")
-	secret := os.Getenv("DATACRUNCH_CLIENT_SECRET")
-	if token == "" || secret == "" {
-		return nil, errors.New("`DATACRUNCH_CLIENT_ID` and `DATACRUNCH_CLIENT_SECRET` must be specified")
+	if token == "" {
+		return nil, errors.New("`DATACRUNCH_CLIENT_ID` must be specified")
+	}
+	secret, err := clientSecretFromEnv()
+	if err != nil {
+		return nil, err
 	}
 
-	client := datacrunchclient.NewClient(token, secret)
+	client := newAPIClient(token, secret)
 
 	ctx := context.Background()
 
@@ -123,75 +410,1063 @@ func newManager() (*datacrunchManager, error) {
 		clusterConfigJsonData = []byte(clusterConfigBaseJSON)
 	}
 
-	unmarshalErr := json.Unmarshal(clusterConfigJsonData, &clusterConfig)
+	// YAML files are converted to JSON, the fields are named alike
+	unmarshal := json.Unmarshal
+	if ext := filepath.Ext(clusterConfigFile); clusterConfigBase64 == "" && (ext == ".yaml" || ext == ".yml") {
+		unmarshal = func(data []byte, v interface{}) error { return yaml.Unmarshal(data, v) }
+	}
+	unmarshalErr := unmarshal(clusterConfigJsonData, &clusterConfig)
 	if unmarshalErr != nil {
 		return nil, fmt.Errorf("failed to unmarshal cluster config JSON: %s", unmarshalErr)
 	}
 
+	serverTypeCacheTTL := serverTypeCacheTTLDefault
+	if v := os.Getenv("DATACRUNCH_SERVER_TYPE_CACHE_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("failed to parse DATACRUNCH_SERVER_TYPE_CACHE_TTL: %q is not a positive duration", v)
+		}
+		serverTypeCacheTTL = ttl
+	}
+
+	priceRefreshInterval := priceCacheRefreshIntervalDef
+	if v := os.Getenv("DATACRUNCH_PRICE_REFRESH_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("failed to parse DATACRUNCH_PRICE_REFRESH_INTERVAL: %q is not a positive duration", v)
+		}
+		priceRefreshInterval = interval
+	}
+
+	createMaxAttempts := createMaxAttemptsDefault
+	if v := os.Getenv("DATACRUNCH_CREATE_MAX_ATTEMPTS"); v != "" {
+		attempts, err := strconv.Atoi(v)
+		if err != nil || attempts <= 0 {
+			return nil, fmt.Errorf("failed to parse DATACRUNCH_CREATE_MAX_ATTEMPTS: %q is not a positive integer", v)
+		}
+		createMaxAttempts = attempts
+	}
+
+	createRetryBackoff := createRetryBackoffDefault
+	if v := os.Getenv("DATACRUNCH_CREATE_RETRY_BACKOFF"); v != "" {
+		backoff, err := time.ParseDuration(v)
+		if err != nil || backoff <= 0 {
+			return nil, fmt.Errorf("failed to parse DATACRUNCH_CREATE_RETRY_BACKOFF: %q is not a positive duration", v)
+		}
+		createRetryBackoff = backoff
+	}
+
+	nodeLookupMaxAttempts := nodeLookupMaxAttemptsDefault
+	if v := os.Getenv("DATACRUNCH_NODE_LOOKUP_MAX_ATTEMPTS"); v != "" {
+		attempts, err := strconv.Atoi(v)
+		if err != nil || attempts <= 0 {
+			return nil, fmt.Errorf("failed to parse DATACRUNCH_NODE_LOOKUP_MAX_ATTEMPTS: %q is not a positive integer", v)
+		}
+		nodeLookupMaxAttempts = attempts
+	}
+
+	nodeLookupBackoff := nodeLookupBackoffDefault
+	if v := os.Getenv("DATACRUNCH_NODE_LOOKUP_RETRY_BACKOFF"); v != "" {
+		backoff, err := time.ParseDuration(v)
+		if err != nil || backoff <= 0 {
+			return nil, fmt.Errorf("failed to parse DATACRUNCH_NODE_LOOKUP_RETRY_BACKOFF: %q is not a positive duration", v)
+		}
+		nodeLookupBackoff = backoff
+	}
+
+	createMaxInFlight := createMaxInFlightDefault
+	if v := os.Getenv("DATACRUNCH_CREATE_MAX_IN_FLIGHT"); v != "" {
+		inFlight, err := strconv.Atoi(v)
+		if err != nil || inFlight <= 0 {
+			return nil, fmt.Errorf("failed to parse DATACRUNCH_CREATE_MAX_IN_FLIGHT: %q is not a positive integer", v)
+		}
+		createMaxInFlight = inFlight
+	}
+
+	createStagger := createStaggerDefault
+	if v := os.Getenv("DATACRUNCH_CREATE_STAGGER"); v != "" {
+		stagger, err := time.ParseDuration(v)
+		if err != nil || stagger < 0 {
+			return nil, fmt.Errorf("failed to parse DATACRUNCH_CREATE_STAGGER: %q is not a duration", v)
+		}
+		createStagger = stagger
+	}
+
+	createStaggerAbove := createStaggerAboveDefault
+	if v := os.Getenv("DATACRUNCH_CREATE_STAGGER_ABOVE"); v != "" {
+		above, err := strconv.Atoi(v)
+		if err != nil || above < 0 {
+			return nil, fmt.Errorf("failed to parse DATACRUNCH_CREATE_STAGGER_ABOVE: %q is not a non-negative integer", v)
+		}
+		createStaggerAbove = above
+	}
+
+	waitForRunning := false
+	if v := os.Getenv("DATACRUNCH_SCALE_UP_WAIT_FOR_RUNNING"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse DATACRUNCH_SCALE_UP_WAIT_FOR_RUNNING: %q is not a boolean", v)
+		}
+		waitForRunning = enabled
+	}
+
+	deleteMaxInFlight := deleteMaxInFlightDefault
+	if v := os.Getenv("DATACRUNCH_DELETE_MAX_IN_FLIGHT"); v != "" {
+		inFlight, err := strconv.Atoi(v)
+		if err != nil || inFlight <= 0 {
+			return nil, fmt.Errorf("failed to parse DATACRUNCH_DELETE_MAX_IN_FLIGHT: %q is not a positive integer", v)
+		}
+		deleteMaxInFlight = inFlight
+	}
+
+	var deleteBatchDelay time.Duration
+	if v := os.Getenv("DATACRUNCH_DELETE_BATCH_DELAY"); v != "" {
+		delay, err := time.ParseDuration(v)
+		if err != nil || delay < 0 {
+			return nil, fmt.Errorf("failed to parse DATACRUNCH_DELETE_BATCH_DELAY: %q is not a duration", v)
+		}
+		deleteBatchDelay = delay
+	}
+
+	serverCreateTimeout := serverCreateTimeoutDefault
+	if v := os.Getenv("DATACRUNCH_SERVER_CREATE_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("failed to parse DATACRUNCH_SERVER_CREATE_TIMEOUT: %q is not a positive duration", v)
+		}
+		serverCreateTimeout = timeout
+	}
+
+	serverRegisterTimeout := serverRegisterTimeoutDefault
+	if v := os.Getenv("DATACRUNCH_SERVER_REGISTER_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("failed to parse DATACRUNCH_SERVER_REGISTER_TIMEOUT: %q is not a positive duration", v)
+		}
+		serverRegisterTimeout = timeout
+	}
+
+	registerGracePeriod := registerGracePeriodDefault
+	if v := os.Getenv("DATACRUNCH_REGISTER_GRACE_PERIOD"); v != "" {
+		period, err := time.ParseDuration(v)
+		if err != nil || period < 0 {
+			return nil, fmt.Errorf("failed to parse DATACRUNCH_REGISTER_GRACE_PERIOD: %q is not a duration", v)
+		}
+		registerGracePeriod = period
+	}
+
+	apiCallTimeout := apiCallTimeoutDefault
+	if v := os.Getenv("DATACRUNCH_API_CALL_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("failed to parse DATACRUNCH_API_CALL_TIMEOUT: %q is not a positive duration", v)
+		}
+		apiCallTimeout = timeout
+	}
+
+	apiRateLimit := apiRateLimitDefault
+	if v := os.Getenv("DATACRUNCH_API_RATE_LIMIT"); v != "" {
+		limit, err := strconv.ParseFloat(v, 64)
+		if err != nil || limit < 0 || math.IsInf(limit, 0) {
+			return nil, fmt.Errorf("failed to parse DATACRUNCH_API_RATE_LIMIT: %q is not a number of requests per second", v)
+		}
+		apiRateLimit = limit
+	}
+	if apiRateLimit > 0 {
+		client = newRateLimitedClient(client, apiRateLimit)
+	}
+
+	scaleUpBackoff := scaleUpBackoffDefault
+	if v := os.Getenv("DATACRUNCH_SCALE_UP_BACKOFF"); v != "" {
+		backoff, err := time.ParseDuration(v)
+		if err != nil || backoff < 0 {
+			return nil, fmt.Errorf("failed to parse DATACRUNCH_SCALE_UP_BACKOFF: %q is not a duration", v)
+		}
+		scaleUpBackoff = backoff
+	}
+
+	capacityPenalty := capacityPenaltyDefault
+	if v := os.Getenv("DATACRUNCH_CAPACITY_PENALTY"); v != "" {
+		penalty, err := time.ParseDuration(v)
+		if err != nil || penalty < 0 {
+			return nil, fmt.Errorf("failed to parse DATACRUNCH_CAPACITY_PENALTY: %q is not a duration", v)
+		}
+		capacityPenalty = penalty
+	}
+
+	maxNodesTotal := 0
+	if v := os.Getenv("DATACRUNCH_MAX_NODES_TOTAL"); v != "" {
+		total, err := strconv.Atoi(v)
+		if err != nil || total < 0 {
+			return nil, fmt.Errorf("failed to parse DATACRUNCH_MAX_NODES_TOTAL: %q is not a non-negative integer", v)
+		}
+		maxNodesTotal = total
+	}
+
+	dryRun := false
+	if v := os.Getenv("DATACRUNCH_DRY_RUN"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse DATACRUNCH_DRY_RUN: %q is not a boolean", v)
+		}
+		dryRun = enabled
+	}
+	if dryRun {
+		klog.Warning("DATACRUNCH_DRY_RUN is enabled, servers are neither created nor deleted")
+	}
+
+	writeAccessCheck := false
+	if v := os.Getenv("DATACRUNCH_CHECK_WRITE_ACCESS"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse DATACRUNCH_CHECK_WRITE_ACCESS: %q is not a boolean", v)
+		}
+		writeAccessCheck = enabled
+	}
+
+	gpuDisabled := false
+	if v := os.Getenv("DATACRUNCH_DISABLE_GPU"); v != "" {
+		disabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse DATACRUNCH_DISABLE_GPU: %q is not a boolean", v)
+		}
+		gpuDisabled = disabled
+	}
+
+	defaultRegion := os.Getenv("DATACRUNCH_DEFAULT_REGION")
+	if strings.ContainsAny(defaultRegion, ",: \t\n") {
+		return nil, fmt.Errorf("DATACRUNCH_DEFAULT_REGION %q must be a single region", defaultRegion)
+	}
+
+	var nodeNamePattern *regexp.Regexp
+	if v := os.Getenv("DATACRUNCH_NODE_NAME_PATTERN"); v != "" {
+		pattern, err := regexp.Compile(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse DATACRUNCH_NODE_NAME_PATTERN: %v", err)
+		}
+		nodeNamePattern = pattern
+	}
+	nodeNameReplacement := "$1"
+	if v, found := os.LookupEnv("DATACRUNCH_NODE_NAME_REPLACEMENT"); found {
+		nodeNameReplacement = v
+	}
+
+	clusterName := os.Getenv("DATACRUNCH_CLUSTER_NAME")
+	if strings.ContainsAny(clusterName, "= \t\n") {
+		return nil, fmt.Errorf("DATACRUNCH_CLUSTER_NAME %q must not contain whitespace or '='", clusterName)
+	}
+
+	tagPrefix := tagPrefixDefault
+	if v := os.Getenv("DATACRUNCH_TAG_PREFIX"); v != "" {
+		if strings.ContainsAny(v, "= \t\n") || strings.TrimSuffix(v, "/") == "" {
+			return nil, fmt.Errorf("DATACRUNCH_TAG_PREFIX %q must not be empty or contain whitespace or '='", v)
+		}
+		tagPrefix = strings.TrimSuffix(v, "/") + "/"
+	}
+
+	if serverRegisterTimeout <= serverCreateTimeout {
+		return nil, fmt.Errorf("DATACRUNCH_SERVER_REGISTER_TIMEOUT %s must be greater than DATACRUNCH_SERVER_CREATE_TIMEOUT %s", serverRegisterTimeout, serverCreateTimeout)
+	}
+
+	registerMetrics()
+
+	// API calls are cancelled by Cleanup along with the background goroutines.
+	backgroundCtx, cancelBackground := context.WithCancel(ctx)
+	cachedServerType := newServerTypeCache(backgroundCtx, client, serverTypeCacheTTL, apiCallTimeout)
+
 	m := &datacrunchManager{
 		client:           client,
 		nodeGroups:       make(map[string]*datacrunchNodeGroup),
-		apiCallContext:   ctx,
 		clusterConfig:    clusterConfig,
-		cachedServerType: newServerTypeCache(ctx, client),
-		cachedServers:    newServersCache(ctx, client),
+		cachedServerType: cachedServerType,
+		cachedPrices:     newPriceCache(cachedServerType, priceRefreshInterval),
+		cachedServers:    newServersCache(backgroundCtx, client, apiCallTimeout),
+		apiCallContext:   backgroundCtx,
+		apiCallTimeout:   apiCallTimeout,
+
+		serverCreateTimeout:      serverCreateTimeout,
+		serverRegisterTimeout:    serverRegisterTimeout,
+		registerGracePeriod:      registerGracePeriod,
+		createMaxAttempts:        createMaxAttempts,
+		createRetryBackoff:       createRetryBackoff,
+		nodeLookupMaxAttempts:    nodeLookupMaxAttempts,
+		nodeLookupBackoff:        nodeLookupBackoff,
+		createSemaphores:         newRegionSemaphores(createMaxInFlight),
+		createStagger:            createStagger,
+		createStaggerAbove:       createStaggerAbove,
+		waitForRunning:           waitForRunning,
+		deleteMaxInFlight:        deleteMaxInFlight,
+		deleteBatchDelay:         deleteBatchDelay,
+		scaleUpBackoff:           scaleUpBackoff,
+		capacityPenalties:        newCapacityPenalties(clock.RealClock{}, capacityPenalty),
+		maxNodesTotal:            maxNodesTotal,
+		registrationPollInterval: serverRegistrationPollInterval,
+		clusterUpdateMutex:       &sync.Mutex{},
+		pendingRegistrations:     newPendingRegistrations(),
+		clusterName:              clusterName,
+		tagKeys:                  newServerTagKeys(tagPrefix),
+		defaultRegion:            defaultRegion,
+		nodeNamePattern:          nodeNamePattern,
+		nodeNameReplacement:      nodeNameReplacement,
+		orphans:                  newOrphanCleanup(clock.RealClock{}),
+		failedServers:            newFailedServerCleanup(clock.RealClock{}),
+		deletingServers:          newDeletingServers(),
+		warmPool:                 newWarmPool(clock.RealClock{}),
+		dryRun:                   dryRun,
+		writeAccessCheck:         writeAccessCheck,
+		gpuDisabled:              gpuDisabled,
+		backgroundCtx:            backgroundCtx,
+		cancelBackground:         cancelBackground,
+	}
+
+	if err := m.checkCredentials(); err != nil {
+		m.cancelBackground()
+		return nil, err
+	}
+
+	if err := m.checkWriteAccess(); err != nil {
+		m.cancelBackground()
+		return nil, err
 	}
 
+	if err := m.validateNodeConfigReferences(); err != nil {
+		m.cancelBackground()
+		return nil, err
+	}
+
+	m.goBackground(m.cachedServerType.run)
+
 	return m, nil
 }
 
+// clientSecretFromEnv returns the function returning the client secret. If
+// DATACRUNCH_CLIENT_SECRET_FILE is set the file is read on every call, so a
+// rotated secret is used without a restart. DATACRUNCH_CLIENT_SECRET is used
+// otherwise.
+func clientSecretFromEnv() (func() (string, error), error) {
+	secretFile := os.Getenv("DATACRUNCH_CLIENT_SECRET_FILE")
+	if secretFile == "" {
+		secret := os.Getenv("DATACRUNCH_CLIENT_SECRET")
+		if secret == "" {
+			return nil, errors.New("one of `DATACRUNCH_CLIENT_SECRET` or `DATACRUNCH_CLIENT_SECRET_FILE` must be specified")
+		}
+		return func() (string, error) { return secret, nil }, nil
+	}
+
+	readSecret := func() (string, error) {
+		data, err := os.ReadFile(secretFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read client secret file: %v", err)
+		}
+		secret := strings.TrimSpace(string(data))
+		if secret == "" {
+			return "", fmt.Errorf("client secret file %s is empty", secretFile)
+		}
+		return secret, nil
+	}
+	// fail at startup if the file can't be used
+	if _, err := readSecret(); err != nil {
+		return nil, err
+	}
+	return readSecret, nil
+}
+
+// checkCredentials fetches the server type catalog, so invalid credentials
+// fail the startup instead of the first scale up. Other errors, e.g. network
+// errors, are only logged since they are likely transient. The catalog is
+// then fetched in the background until the API is available.
+func (m *datacrunchManager) checkCredentials() error {
+	_, err := m.cachedServerType.serverTypes()
+	if err == nil {
+		return nil
+	}
+	if isAuthError(err) {
+		return fmt.Errorf("%w, check DATACRUNCH_CLIENT_ID and DATACRUNCH_CLIENT_SECRET: %w", errAuth, err)
+	}
+	klog.Warningf("Failed to verify DataCrunch credentials: %v", err)
+	return nil
+}
+
+// writeAccessCheckScriptName is the name of the startup script created and
+// deleted by checkWriteAccess.
+const writeAccessCheckScriptName = "autoscaler-write-access-check"
+
+// checkWriteAccess lists the servers and creates and deletes a startup script
+// if DATACRUNCH_CHECK_WRITE_ACCESS is set, so credentials which may only read
+// fail the startup instead of the first scale up. The DataCrunch API does not
+// report the scopes of credentials, so they are probed with a startup script,
+// creating a server would be billed. Like in checkCredentials, errors other
+// than rejected requests are only logged.
+func (m *datacrunchManager) checkWriteAccess() error {
+	if !m.writeAccessCheck {
+		return nil
+	}
+	if m.dryRun {
+		klog.Warning("DATACRUNCH_CHECK_WRITE_ACCESS is ignored since DATACRUNCH_DRY_RUN is enabled")
+		return nil
+	}
+
+	var missing []string
+	var errs []error
+	check := func(operation string, err error) {
+		if err == nil {
+			return
+		}
+		if !isAuthError(err) {
+			klog.Warningf("Failed to verify the access of the DataCrunch credentials to %s: %v", operation, err)
+			return
+		}
+		missing = append(missing, operation)
+		errs = append(errs, err)
+	}
+
+	ctx, cancel := m.apiContext()
+	_, err := m.client.ListInstances(ctx, "")
+	cancel()
+	check("list servers", err)
+
+	ctx, cancel = m.apiContext()
+	scriptID, err := m.client.UploadStartupScript(ctx, writeAccessCheckScriptName, "#!/bin/bash\n")
+	cancel()
+	check("create startup scripts", err)
+	if err == nil {
+		ctx, cancel = m.apiContext()
+		err = m.client.DeleteStartupScript(ctx, scriptID)
+		cancel()
+		check("delete startup scripts", err)
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%w, the DataCrunch credentials may not %s: %w", errAuth, strings.Join(missing, ", "), errors.Join(errs...))
+	}
+	return nil
+}
+
+// gpuResourceNames returns the GPU resource names of all node groups.
+func (m *datacrunchManager) gpuResourceNames() []apiv1.ResourceName {
+	names := []apiv1.ResourceName{ResourceGPU}
+	add := func(name apiv1.ResourceName) {
+		if name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	add(m.clusterConfig.GPUResourceName)
+	if m.clusterConfig.AutoprovisioningNodeConfig != nil {
+		add(m.clusterConfig.AutoprovisioningNodeConfig.GPUResourceName)
+	}
+	m.nodeGroupsMutex.RLock()
+	defer m.nodeGroupsMutex.RUnlock()
+	for _, nodeConfig := range m.clusterConfig.NodeConfigs {
+		if nodeConfig != nil {
+			add(nodeConfig.GPUResourceName)
+		}
+	}
+	return names
+}
+
+// nodeConfig returns the node config of the node group, or nil if there is
+// none. Node configs of autoprovisioned node groups are added and removed by
+// the autoscaler loop while they are read by other goroutines.
+func (m *datacrunchManager) nodeConfig(nodeGroup string) *NodeConfig {
+	m.nodeGroupsMutex.RLock()
+	defer m.nodeGroupsMutex.RUnlock()
+	return m.clusterConfig.NodeConfigs[nodeGroup]
+}
+
+// validateNodeConfigReferences checks that the SSH keys and startup scripts
+// referenced by the node configs exist, servers could not join the cluster
+// otherwise. The DataCrunch API is only called if anything is referenced.
+func (m *datacrunchManager) validateNodeConfigReferences() error {
+	nodeConfigs := make(map[string]*NodeConfig, len(m.clusterConfig.NodeConfigs)+1)
+	maps.Copy(nodeConfigs, m.clusterConfig.NodeConfigs)
+	if m.clusterConfig.AutoprovisioningNodeConfig != nil {
+		nodeConfigs["autoprovisioning_node_config"] = m.clusterConfig.AutoprovisioningNodeConfig
+	}
+
+	sshKeysReferenced, scriptsReferenced, imagesReferenced := false, false, false
+	for name, nodeConfig := range nodeConfigs {
+		sshKeysReferenced = sshKeysReferenced || len(nodeConfig.SSHKeyIDs) > 0
+		imagesReferenced = imagesReferenced || nodeConfig.ImageType != ""
+		scriptsReferenced = scriptsReferenced || nodeConfig.StartupScriptID != ""
+
+		volumeNames := make(map[string]bool, len(nodeConfig.DataVolumes))
+		for _, volume := range nodeConfig.DataVolumes {
+			if volume.Name == "" || volume.SizeGB <= 0 || volume.Type == "" {
+				return fmt.Errorf("data volume %q of node config %s needs a name, a positive size_gb and a type", volume.Name, name)
+			}
+			if volumeNames[volume.Name] {
+				return fmt.Errorf("data volume %s of node config %s is configured twice", volume.Name, name)
+			}
+			volumeNames[volume.Name] = true
+		}
+
+		if _, err := memoryReservation(nodeConfig.ReservedMemory, resource.Quantity{}); err != nil {
+			return fmt.Errorf("invalid reserved_memory of node config %s: %v", name, err)
+		}
+
+		if err := validateKubeletExtraArgs(nodeConfig.KubeletExtraArgs); err != nil {
+			return fmt.Errorf("invalid kubelet_extra_args of node config %s: %v", name, err)
+		}
+
+		for key, threshold := range map[string]*float64{
+			"scale_down_utilization_threshold":     nodeConfig.ScaleDownUtilizationThreshold,
+			"scale_down_gpu_utilization_threshold": nodeConfig.ScaleDownGpuUtilizationThreshold,
+		} {
+			if threshold != nil && (*threshold < 0 || *threshold > 1) {
+				return fmt.Errorf("invalid %s of node config %s: %v, expected number between 0 and 1", key, name, *threshold)
+			}
+		}
+
+		if nodeConfig.WarmPoolSize < 0 {
+			return fmt.Errorf("invalid warm_pool_size of node config %s: %d, expected positive number", name, nodeConfig.WarmPoolSize)
+		}
+		// the pre-script holding back warm servers is only run along with a
+		// startup script, a startup_script_id is run as is
+		if nodeConfig.WarmPoolSize > 0 && !hasStartupScript(nodeConfig) {
+			return fmt.Errorf("invalid warm_pool_size of node config %s: %w", name, errWarmPoolWithoutStartupScript)
+		}
+	}
+
+	if sshKeysReferenced {
+		ctx, cancel := m.apiContext()
+		defer cancel()
+		keys, err := m.client.ListSSHKeys(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list SSH keys: %v", err)
+		}
+		keyIDs := make(map[string]bool, len(keys))
+		for _, key := range keys {
+			keyIDs[key.ID] = true
+		}
+		for name, nodeConfig := range nodeConfigs {
+			for _, id := range nodeConfig.SSHKeyIDs {
+				if !keyIDs[id] {
+					return fmt.Errorf("SSH key %s of node config %s does not exist", id, name)
+				}
+			}
+		}
+	}
+
+	if imagesReferenced {
+		ctx, cancel := m.apiContext()
+		defer cancel()
+		images, err := m.client.ListImages(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list images: %v", err)
+		}
+		imageTypes := make(map[string]bool, len(images))
+		for _, image := range images {
+			imageTypes[image.ImageType] = true
+		}
+		for name, nodeConfig := range nodeConfigs {
+			if nodeConfig.ImageType != "" && !imageTypes[nodeConfig.ImageType] {
+				return fmt.Errorf("image %s of node config %s does not exist", nodeConfig.ImageType, name)
+			}
+		}
+	}
+
+	if scriptsReferenced {
+		ctx, cancel := m.apiContext()
+		defer cancel()
+		scripts, err := m.client.ListStartupScripts(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list startup scripts: %v", err)
+		}
+		scriptIDs := make(map[string]bool, len(scripts))
+		for _, script := range scripts {
+			scriptIDs[script.ID] = true
+		}
+		for name, nodeConfig := range nodeConfigs {
+			if nodeConfig.StartupScriptID != "" && !scriptIDs[nodeConfig.StartupScriptID] {
+				return fmt.Errorf("startup script %s of node config %s does not exist", nodeConfig.StartupScriptID, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// apiContext returns the context for a single DataCrunch API call.
+func (m *datacrunchManager) apiContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(m.apiCallContext, m.apiCallTimeout)
+}
+
+// startBackground adds a goroutine to backgroundWG, which calls
+// backgroundWG.Done once it returned. It returns false once Cleanup started.
+func (m *datacrunchManager) startBackground() bool {
+	m.backgroundMutex.Lock()
+	defer m.backgroundMutex.Unlock()
+	if m.backgroundClosed {
+		return false
+	}
+	m.backgroundWG.Add(1)
+	return true
+}
+
+// goBackground runs f in a goroutine which is stopped by Cleanup. f must
+// return once the context is cancelled.
+func (m *datacrunchManager) goBackground(f func(ctx context.Context)) {
+	if !m.startBackground() {
+		klog.Warning("not starting background goroutine, manager is already cleaned up")
+		return
+	}
+
+	go func() {
+		defer m.backgroundWG.Done()
+		f(m.backgroundCtx)
+	}()
+}
+
+// Cleanup stops the background goroutines, waits for them to return for at
+// most cleanupTimeout and closes the idle connections to the DataCrunch API.
+func (m *datacrunchManager) Cleanup() error {
+	m.backgroundMutex.Lock()
+	m.backgroundClosed = true
+	m.cancelBackground()
+	m.backgroundMutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.backgroundWG.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-time.After(cleanupTimeout):
+		err = fmt.Errorf("background goroutines did not stop within %s", cleanupTimeout)
+	}
+
+	m.client.CloseIdleConnections()
+	return err
+}
+
 // Refresh refreshes the cache holding the nodegroups. This is called by the CA
 // based on the `--scan-interval`. By default it's 10 seconds.
 func (m *datacrunchManager) Refresh() error {
 	return nil
 }
 
+// ResyncGroup lists the servers and reconciles the target size of the node
+// group with them, without waiting for the next refresh. The scale up backoff
+// of the node group and the capacity penalties of its instance types and
+// regions are cleared. It is meant for debug endpoints, e.g. after servers
+// were created or deleted outside of the autoscaler, and safe to call
+// concurrently with the autoscaler loop, running scaling operations are
+// waited for.
+func (m *datacrunchManager) ResyncGroup(id string) error {
+	m.clusterUpdateMutex.Lock()
+	defer m.clusterUpdateMutex.Unlock()
+
+	group, found := m.nodeGroups[id]
+	if !found {
+		return fmt.Errorf("node group %s not found", id)
+	}
+
+	inFlightCreates, generation := group.sizeSnapshot()
+	servers, err := m.cachedServers.servers()
+	if err != nil {
+		return fmt.Errorf("failed to list servers: %v", err)
+	}
+	m.deletingServers.prune(servers)
+	group.reconcileTargetSize(servers, inFlightCreates, generation)
+
+	group.resetBackoff()
+	for _, placement := range group.allPlacements() {
+		m.capacityPenalties.recordSuccess(group.capacityKey(placement))
+	}
+	size, _ := group.TargetSize()
+	klog.Infof("%v Resynced node group, target size %d", group.logFields(), size)
+	return nil
+}
+
 func (m *datacrunchManager) allServers(nodeGroup string) ([]*datacrunchclient.Instance, error) {
-	servers, err := m.cachedServers.getServersByNodeGroupName(nodeGroup)
+	servers, err := m.cachedServers.getServersByNodeGroupName(nodeGroup, m.tagKeys)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get servers for datacrunch: %v", err)
 	}
 	return servers, nil
 }
 
+// createdServer is a server created for a node group, which is not yet
+// waited for to register.
+type createdServer struct {
+	id        string
+	placement serverPlacement
+	// deadline is the end of the create timeout, counted from the start of
+	// the create.
+	deadline time.Time
+}
+
+// createServerWithRetry creates a server for the node group with the first of
+// the instance types and regions with capacity left, and waits for its node
+// to register in the background.
+func (m *datacrunchManager) createServerWithRetry(n *datacrunchNodeGroup, placements []serverPlacement) error {
+	server, err := m.createServer(n, placements)
+	if err != nil {
+		return err
+	}
+	m.serverCreated(n, server)
+	return nil
+}
+
+// createServer creates a server for the node group with the first of the
+// instance types and regions with capacity left. Transient API errors are
+// retried with exponential backoff and jitter, as long as the server create
+// timeout is not exceeded. The caller passes the created server to
+// serverCreated, or counts it as failed create.
+func (m *datacrunchManager) createServer(n *datacrunchNodeGroup, placements []serverPlacement) (createdServer, error) {
+	if len(placements) == 0 {
+		return createdServer{}, fmt.Errorf("no region to create server for node group %s in", n.id)
+	}
+
+	// Cleanup waits for the creates, their API calls are cancelled by it
+	if !m.startBackground() {
+		return createdServer{}, fmt.Errorf("not creating server for node group %s, manager is already cleaned up", n.id)
+	}
+	defer m.backgroundWG.Done()
+
+	deadline := time.Now().Add(n.createTimeout)
+
+	var err error
+	for i, placement := range placements {
+		region := placement.region
+		var id string
+		id, err = m.createServerInRequestedRegion(n, placement, deadline)
+		if err == nil {
+			return createdServer{id: id, placement: placement, deadline: deadline}, nil
+		}
+
+		if isOutOfCapacityError(err) {
+			m.capacityPenalties.recordFailure(n.capacityKey(placement))
+		}
+		if !isOutOfCapacityError(err) || i == len(placements)-1 {
+			serverCreateFailuresTotal.WithLabelValues(n.id, region).Inc()
+			return createdServer{}, classifyAPIError(err)
+		}
+
+		next := placements[i+1]
+		klog.Infof("%v Instance type %s is out of capacity, trying instance type %s in region %s: %v", logFields{nodeGroup: n.id, region: region}, placement.instanceType, next.instanceType, next.region, err)
+	}
+
+	return createdServer{}, classifyAPIError(err)
+}
+
+// serverCreated records the created server of the node group and waits for
+// its node to register in the background.
+func (m *datacrunchManager) serverCreated(n *datacrunchNodeGroup, server createdServer) {
+	id, placement, region := server.id, server.placement, server.placement.region
+	m.capacityPenalties.recordSuccess(n.capacityKey(placement))
+	klog.V(2).Infof("%v Created server of instance type %s", logFields{nodeGroup: n.id, region: region, server: id}, placement.instanceType)
+	serverCreatesTotal.WithLabelValues(n.id, region).Inc()
+	m.pendingRegistrations.add(id, n.id, region)
+	provisionTimeout, registerTimeout := n.createTimeout, n.registerTimeout
+	m.goBackground(func(ctx context.Context) {
+		if err := m.waitForServerRegistration(ctx, id, provisionTimeout, registerTimeout); err != nil && ctx.Err() == nil {
+			klog.Warningf("%v Server did not join the cluster: %v", logFields{nodeGroup: n.id, region: region, server: id}, err)
+		}
+	})
+}
+
+func (m *datacrunchManager) createServerInRegion(n *datacrunchNodeGroup, placement serverPlacement, deadline time.Time) (string, error) {
+	region := placement.region
+	backoff := m.createRetryBackoff
+	// the hostname is kept across attempts, so a server created by an attempt
+	// which failed client side, e.g. by a timeout of the response, is found
+	// instead of created twice
+	nodeName := newNodeName(n)
+
+	var err error
+	for attempt := 1; attempt <= m.createMaxAttempts; attempt++ {
+		if attempt > 1 {
+			if id, found := m.createdServer(region, nodeName); found {
+				klog.V(2).Infof("%v Server %s was created by a failed attempt, not creating it again", logFields{nodeGroup: n.id, region: region, server: id}, nodeName)
+				return id, nil
+			}
+		}
+
+		var id string
+		id, err = m.createServerLimited(n, placement, nodeName, false)
+		if err == nil {
+			m.cachedServers.invalidate(region)
+			if attempt > 1 {
+				klog.V(2).Infof("%v Created server after %d attempts", logFields{nodeGroup: n.id, region: region, server: id}, attempt)
+			}
+			return id, nil
+		}
+
+		if !isTransientError(err) {
+			return "", err
+		}
+
+		sleep := wait.Jitter(backoff, 0.5)
+		if attempt == m.createMaxAttempts || time.Now().Add(sleep).After(deadline) {
+			break
+		}
+
+		klog.V(2).Infof("%v Retrying creation of server in %s after transient error (attempt %d/%d): %v", logFields{nodeGroup: n.id, region: region}, sleep, attempt, m.createMaxAttempts, err)
+		time.Sleep(sleep)
+		backoff *= 2
+	}
+
+	return "", fmt.Errorf("giving up creating server for node group %s in region %s: %w", n.id, region, err)
+}
+
+// createServerInRequestedRegion creates a server like createServerInRegion
+// and verifies that it was created in the requested region. A server created
+// in another region is deleted and created again, so no capacity is acquired
+// in the wrong place.
+func (m *datacrunchManager) createServerInRequestedRegion(n *datacrunchNodeGroup, placement serverPlacement, deadline time.Time) (string, error) {
+	for attempt := 1; ; attempt++ {
+		id, err := m.createServerInRegion(n, placement, deadline)
+		if err != nil {
+			return "", err
+		}
+		if err := m.verifyServerRegion(n, id, placement.region); err != nil {
+			if !errors.Is(err, errWrongRegion) || attempt == wrongRegionMaxAttempts || !time.Now().Before(deadline) {
+				return "", fmt.Errorf("giving up creating server for node group %s in region %s: %w", n.id, placement.region, err)
+			}
+			klog.Warningf("%v Retrying creation of server (attempt %d/%d): %v", logFields{nodeGroup: n.id, region: placement.region}, attempt, wrongRegionMaxAttempts, err)
+			continue
+		}
+		return id, nil
+	}
+}
+
+// verifyServerRegion returns errWrongRegion if the server was created in
+// another region than requested, after deleting it. A server which cannot be
+// looked up is assumed to be in the requested region, like its create
+// reported.
+func (m *datacrunchManager) verifyServerRegion(n *datacrunchNodeGroup, serverID, region string) error {
+	fields := logFields{nodeGroup: n.id, region: region, server: serverID}
+	ctx, cancel := m.apiContext()
+	server, err := m.client.GetInstance(ctx, serverID)
+	cancel()
+	if err != nil {
+		klog.Warningf("%v Failed to verify the region of the created server: %v", fields, err)
+		return nil
+	}
+	if strings.EqualFold(server.Location, region) {
+		return nil
+	}
+
+	err = fmt.Errorf("%w: server %s was created in region %s instead of %s", errWrongRegion, serverID, server.Location, region)
+	klog.Warningf("%v Deleting server: %v", fields, err)
+	if deleteErr := m.deleteServer(server); deleteErr != nil {
+		return fmt.Errorf("%v, failed to delete it: %w", err, deleteErr)
+	}
+	return err
+}
+
+// createdServer returns the ID of the server with the hostname in the region,
+// listing the servers of the region again rather than using the cache.
+func (m *datacrunchManager) createdServer(region, nodeName string) (string, bool) {
+	m.cachedServers.invalidate(region)
+	server, err := m.cachedServers.getServerInRegion(region, nodeName)
+	if err != nil {
+		klog.Warningf("Failed to look up server %s in region %s before retrying its creation: %v", nodeName, region, err)
+		return "", false
+	}
+	if server == nil {
+		return "", false
+	}
+	return server.ID, true
+}
+
+// settleCancelledCreate looks up the server of a create which was cancelled
+// by Cleanup, with API calls of their own since those of the manager are
+// cancelled. A server created anyway carries the tags of its node group and
+// is adopted by the next run of the autoscaler. Otherwise the startup script
+// uploaded for it, if any, is deleted so the create leaves nothing behind.
+func (m *datacrunchManager) settleCancelledCreate(n *datacrunchNodeGroup, region, nodeName, scriptID string) {
+	fields := logFields{nodeGroup: n.id, region: region}
+	ctx, cancel := context.WithTimeout(context.Background(), m.apiCallTimeout)
+	servers, err := m.client.ListInstances(ctx, "")
+	cancel()
+	if err != nil {
+		klog.Warningf("%v Failed to look up server %s of a cancelled create, it is adopted by the next run if it exists: %v", fields, nodeName, err)
+		return
+	}
+	for _, server := range servers {
+		if server.Hostname == nodeName && strings.EqualFold(server.Location, region) {
+			fields.server = server.ID
+			klog.Infof("%v Server %s was created before its create was cancelled, it is adopted by the next run", fields, nodeName)
+			return
+		}
+	}
+
+	if scriptID == "" {
+		return
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), m.apiCallTimeout)
+	err = m.client.DeleteStartupScript(ctx, scriptID)
+	cancel()
+	if err != nil && !isNotFoundError(err) {
+		klog.Warningf("%v Failed to delete startup script %s of cancelled create of server %s: %v", fields, scriptID, nodeName, err)
+		return
+	}
+	klog.V(2).Infof("%v Server %s was not created before its create was cancelled, deleted its startup script", fields, nodeName)
+}
+
+// createServerLimited creates a server once fewer than the maximum number of
+// servers are being created in the region, waiting for a free slot otherwise.
+func (m *datacrunchManager) createServerLimited(n *datacrunchNodeGroup, placement serverPlacement, nodeName string, warm bool) (string, error) {
+	release := m.createSemaphores.acquire(placement.region)
+	defer release()
+
+	return createNamedServer(n, placement.region, placement.instanceType, nodeName, warm)
+}
+
+// regionSemaphores holds a semaphore of the same size for every region.
+type regionSemaphores struct {
+	sync.Mutex
+	size       int
+	semaphores map[string]chan struct{}
+}
+
+func newRegionSemaphores(size int) *regionSemaphores {
+	return &regionSemaphores{
+		size:       size,
+		semaphores: make(map[string]chan struct{}),
+	}
+}
+
+// acquire blocks until a slot of the region is free and returns the function
+// releasing it.
+func (s *regionSemaphores) acquire(region string) func() {
+	s.Lock()
+	semaphore, found := s.semaphores[region]
+	if !found {
+		semaphore = make(chan struct{}, s.size)
+		s.semaphores[region] = semaphore
+	}
+	s.Unlock()
+
+	semaphore <- struct{}{}
+	return func() {
+		<-semaphore
+	}
+}
+
+// isOutOfCapacityError returns whether the error is caused by the region
+// having no capacity left for the requested instance type. Quota errors are
+// not, even if their message reads alike: the quota of the project applies to
+// all regions and instance types, falling back to others does not help.
+func isOutOfCapacityError(err error) bool {
+	if errors.Is(err, errQuotaExceeded) || isQuotaExceededError(err) {
+		return false
+	}
+	return strings.Contains(err.Error(), "Not enough resources to deploy") || isReservationExhaustedError(err)
+}
+
+// isReservationExhaustedError returns whether the API rejected a server of
+// reserved capacity because the reservation is used up.
+func isReservationExhaustedError(err error) bool {
+	var apiErr *datacrunchclient.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	message := strings.ToLower(apiErr.Code + " " + apiErr.Message)
+	reservation := strings.Contains(message, "reserv") || strings.Contains(message, "long term") || strings.Contains(message, "long_term")
+	exhausted := strings.Contains(message, "exhausted") || strings.Contains(message, "insufficient") || strings.Contains(message, "not enough") || strings.Contains(message, "no capacity")
+	return reservation && exhausted
+}
+
+// isAuthError returns whether the API rejected the credentials or they lack
+// the permissions for a request.
+func isAuthError(err error) bool {
+	var apiErr *datacrunchclient.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden
+	}
+	return false
+}
+
+// isTransientError returns whether the error is caused by rate limiting,
+// a server side error of the DataCrunch API or a broken connection.
+func isTransientError(err error) bool {
+	var apiErr *datacrunchclient.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= http.StatusInternalServerError
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
 func (m *datacrunchManager) deleteByNode(node *apiv1.Node) error {
 	instance, err := m.serverForNode(node)
 	if err != nil {
 		return fmt.Errorf("failed to delete node %s error: %v", node.Name, err)
 	}
 	if instance == nil {
-		return fmt.Errorf("failed to delete node %s instance not found", node.Name)
+		return fmt.Errorf("failed to delete node %s: %w", node.Name, errServerNotFound)
 	}
 	return m.deleteServer(instance)
 }
 
 func (m *datacrunchManager) deleteServer(instance *datacrunchclient.Instance) error {
+	volumeIDs, err := m.dataVolumeIDs(instance)
+	if err != nil {
+		return fmt.Errorf("failed to delete server %s: %w", instance.ID, err)
+	}
 	req := datacrunchclient.InstanceActionRequest{
-		Action: "delete",
-		ID:     instance.ID,
+		Action:    "delete",
+		ID:        instance.ID,
+		VolumeIDs: volumeIDs,
 	}
 
-	klog.V(4).Infof("deleting server %s", instance.ID)
+	fields := logFields{nodeGroup: m.nodeGroupIDForServer(instance), region: instance.Location, server: instance.ID}
+	klog.V(2).Infof("%v Deleting server %s", fields, instance.Hostname)
 
-	err := m.client.PerformInstanceAction(req)
-	if err != nil {
-		return fmt.Errorf("failed to delete server %s error: %v", instance.ID, err)
+	ctx, cancel := m.apiContext()
+	defer cancel()
+	err = m.client.PerformInstanceAction(ctx, req)
+	switch {
+	case isNotFoundError(err):
+		// the delete is retried, e.g. after it timed out
+		klog.V(2).Infof("%v Server is already deleted", fields)
+	case err != nil:
+		return fmt.Errorf("failed to delete server %s: %w", instance.ID, err)
+	default:
+		// the region is taken from the server, servers of a node group can be
+		// spread over several regions
+		serverDeletesTotal.WithLabelValues(m.nodeGroupIDForServer(instance), instance.Location).Inc()
+	}
+	m.cachedServers.invalidate(instance.Location)
+	m.pendingRegistrations.remove(instance.ID)
+	m.deletingServers.add(instance.ID)
+	if warm, found := m.warmPool.remove(instance.ID); found {
+		m.deleteHoldScript(warm.scriptID)
 	}
 
 	// Wait for instance deletion, then cleanup detached volumes so we don't run into quota issues
 	// NOTE: Not sure if we even need to wait here, someone from datacrunch need to confirm this.
-	go func() {
-		klog.V(4).Infof("deleting volumes for server %s", instance.ID)
+	m.goBackground(func(ctx context.Context) {
+		klog.V(4).Infof("%v Deleting volumes of server", fields)
 		ticker := time.NewTicker(10 * time.Second)
 		defer ticker.Stop()
 
 		errorCount := 0
 		maxErrorCount := 3
 
-		for range ticker.C {
-			volumes, err := m.client.ListVolumesInTrash()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			listCtx, cancelList := m.apiContext()
+			volumes, err := m.client.ListVolumesInTrash(listCtx)
+			cancelList()
 			if err != nil {
-				klog.Errorf("failed to list volumes in trash. error: %v", err)
+				klog.Errorf("%v failed to list volumes in trash. error: %v", fields, err)
 				errorCount++
 				if errorCount > maxErrorCount {
 					ticker.Stop()
@@ -200,55 +1475,148 @@ func (m *datacrunchManager) deleteServer(instance *datacrunchclient.Instance) er
 				continue
 			}
 
+			deleted, failed := 0, false
 			for _, volume := range volumes {
-				if volume.Name == instance.Hostname {
-					klog.V(4).Infof("found detached volume for instance %s, deleting volume %s", instance.Hostname, volume.ID)
-					err := m.client.DeleteVolume(volume.ID, true)
-					if err != nil {
-						klog.Errorf("failed to delete volume %s. error: %v", volume.ID, err)
-						errorCount++
-						if errorCount > maxErrorCount {
-							ticker.Stop()
-							return
-						}
-						continue
-					}
-					ticker.Stop()
-					return
+				if !isServerVolume(instance, volume.Name) {
+					continue
+				}
+				klog.V(4).Infof("%v found detached volume for instance %s, deleting volume %s", fields, instance.Hostname, volume.ID)
+				deleteCtx, cancelDelete := m.apiContext()
+				err := m.client.DeleteVolume(deleteCtx, volume.ID, true)
+				cancelDelete()
+				if err != nil {
+					klog.Errorf("%v failed to delete volume %s. error: %v", fields, volume.ID, err)
+					failed = true
+					continue
 				}
+				deleted++
 			}
 
-			klog.Warningf("no volumes found for instance %s", instance.ID)
-			ticker.Stop()
+			// the volumes left are deleted on the next tick
+			if failed {
+				errorCount++
+				if errorCount > maxErrorCount {
+					return
+				}
+				continue
+			}
+			if deleted == 0 {
+				klog.Warningf("%v no volumes found for instance", fields)
+			}
 			return
 
 		}
-	}()
+	})
 
 	return nil
 }
 
-func (m *datacrunchManager) validProviderID(providerID string) bool {
-	return strings.HasPrefix(providerID, providerIDPrefix)
+// dataVolumeIDs returns the IDs of the data volumes the autoscaler created for
+// the server, they are deleted with the server. Volumes are only listed for
+// node groups with data volumes.
+func (m *datacrunchManager) dataVolumeIDs(instance *datacrunchclient.Instance) ([]string, error) {
+	nodeConfig := m.nodeConfig(m.nodeGroupIDForServer(instance))
+	if nodeConfig == nil || len(nodeConfig.DataVolumes) == 0 {
+		return nil, nil
+	}
+
+	ctx, cancel := m.apiContext()
+	defer cancel()
+	volumes, err := m.client.ListVolumes(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list data volumes: %w", err)
+	}
+
+	var ids []string
+	for _, volume := range volumes {
+		if !volume.IsOSVolume && volume.InstanceID == instance.ID && isServerVolume(instance, volume.Name) {
+			ids = append(ids, volume.ID)
+		}
+	}
+	return ids, nil
+}
+
+// hostnameForNodeName returns the server hostname of a node name, which is
+// mapped with DATACRUNCH_NODE_NAME_PATTERN if it matches.
+func (m *datacrunchManager) hostnameForNodeName(nodeName string) string {
+	if m.nodeNamePattern != nil && m.nodeNamePattern.MatchString(nodeName) {
+		return m.nodeNamePattern.ReplaceAllString(nodeName, m.nodeNameReplacement)
+	}
+	return nodeName
 }
 
+// serverForNode returns the server of the node, or nil if there is none. The
+// server is marked as registered, its node is in the cluster.
 func (m *datacrunchManager) serverForNode(node *apiv1.Node) (*datacrunchclient.Instance, error) {
+	instance, err := m.findServerForNode(node)
+	if err != nil {
+		return nil, err
+	}
+	if instance != nil {
+		m.pendingRegistrations.registered(instance.ID)
+		m.orphans.markRegistered(instance.ID)
+	}
+	return instance, nil
+}
+
+// findServerForNode returns the server of the node, or nil if there is none,
+// without marking it as registered.
+func (m *datacrunchManager) findServerForNode(node *apiv1.Node) (*datacrunchclient.Instance, error) {
 	var nodeIdOrName string
 	if node.Spec.ProviderID != "" {
-		if !m.validProviderID(node.Spec.ProviderID) {
+		if !isDatacrunchProviderID(node.Spec.ProviderID) {
 			// This cluster-autoscaler provider only handles DataCrunch instances.
 			// Any other provider ID prefix is invalid, and we return no instance. Returning an error here breaks hybrid
 			// clusters with nodes from multiple providers.
 			return nil, nil
 		}
-		nodeIdOrName = strings.TrimPrefix(node.Spec.ProviderID, providerIDPrefix)
+		serverID, err := toServerID(node.Spec.ProviderID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get instance for node %s: %w", node.Name, err)
+		}
+		nodeIdOrName = serverID
 	} else {
-		nodeIdOrName = node.Name
+		nodeIdOrName = m.hostnameForNodeName(node.Name)
 	}
 
-	instance, err := m.cachedServers.getServer(nodeIdOrName)
+	instance, err := m.lookupServer(node.Labels[apiv1.LabelTopologyRegion], nodeIdOrName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get instance for node %s error: %v", node.Name, err)
 	}
 	return instance, nil
 }
+
+// lookupServer returns the server with the ID or hostname, or nil if there is
+// none. Listing the servers is retried with backoff on transient errors, so a
+// hiccup of the DataCrunch API does not leave a node unmatched. A server which
+// is not found is not looked up again.
+func (m *datacrunchManager) lookupServer(region, nodeIdOrName string) (*datacrunchclient.Instance, error) {
+	backoff := m.nodeLookupBackoff
+	var err error
+	for attempt := 1; attempt <= m.nodeLookupMaxAttempts; attempt++ {
+		var instance *datacrunchclient.Instance
+		err = nil
+		// only the servers of the region of the node are needed, they stay
+		// cached while servers are created or deleted in other regions
+		if region != "" {
+			instance, err = m.cachedServers.getServerInRegion(region, nodeIdOrName)
+		}
+		// the region label may name a region the server is not in, e.g.
+		// after it was set by hand
+		if err == nil && instance == nil {
+			instance, err = m.cachedServers.getServer(nodeIdOrName)
+		}
+		if err == nil {
+			return instance, nil
+		}
+		if !isTransientError(err) || m.apiCallContext.Err() != nil || attempt == m.nodeLookupMaxAttempts {
+			break
+		}
+
+		sleep := wait.Jitter(backoff, 0.5)
+		klog.V(2).Infof("Retrying lookup of server %s in %s after transient error (attempt %d/%d): %v", nodeIdOrName, sleep, attempt, m.nodeLookupMaxAttempts, err)
+		time.Sleep(sleep)
+		backoff *= 2
+	}
+	return nil, err
+}
@@ -0,0 +1,1152 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+	apiv1 "k8s.io/api/core/v1"
+	autoscalerErrors "k8s.io/autoscaler/cluster-autoscaler/utils/errors"
+	"k8s.io/utils/clock"
+
+	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
+)
+
+// fakeClient is an in-memory implementation of datacrunchAPIClient.
+type fakeClient struct {
+	mu          sync.Mutex
+	servers     []datacrunchclient.Instance
+	serverTypes []datacrunchclient.InstanceType
+	deployed    []datacrunchclient.DeployInstanceRequest
+	deleted     []string
+	nextID      int
+
+	// volumes holds the data volumes created with deployed servers,
+	// deletedVolumes the IDs of the volumes deleted with their server.
+	volumes        []datacrunchclient.Volume
+	deletedVolumes []string
+
+	// listCalls counts the calls to ListInstances, which fail with listErr
+	// if set. listErrOnce clears listErr after it failed a call.
+	listCalls   int
+	listErr     error
+	listErrOnce bool
+	// keepDeleted keeps listing deleted servers as running, like the API
+	// does right after a delete.
+	keepDeleted bool
+
+	// listTypesCalls counts the calls to ListInstanceTypes, which fail with
+	// listTypesErr if set.
+	listTypesCalls int
+	listTypesErr   error
+
+	// deployErr is called for every deploy request and fails the request if
+	// it returns an error.
+	deployErr func(req datacrunchclient.DeployInstanceRequest) error
+	// deployedErr is called for every deploy request after the server was
+	// created and fails the response if it returns an error, like a timeout
+	// of a request which succeeded server side.
+	deployedErr func(req datacrunchclient.DeployInstanceRequest) error
+	// onDeploy is called for every deploy request before the client is
+	// locked, so it can block without serializing requests.
+	onDeploy func(req datacrunchclient.DeployInstanceRequest)
+	// onDelete is called for every delete request before the client is
+	// locked, like onDeploy.
+	onDelete func(id string)
+
+	// unavailableRegions holds the regions in which no instance type is
+	// available.
+	unavailableRegions map[string]bool
+	// regionAvailability is returned by ListInstanceAvailability.
+	regionAvailability datacrunchclient.InstanceAvailabilityList
+
+	sshKeys        []datacrunchclient.SSHKey
+	images         []datacrunchclient.ImageInfoResponseDto
+	startupScripts []datacrunchclient.StartupScript
+	// uploadedScripts holds the content of the uploaded scripts by name.
+	uploadedScripts map[string]string
+	// deletedScripts holds the IDs of the deleted scripts.
+	deletedScripts []string
+	// uploadErr fails UploadStartupScript if set.
+	uploadErr error
+
+	// calls counts the calls to all methods of the client.
+	calls atomic.Int32
+
+	// delay is the time ListInstances and PerformInstanceAction take to
+	// respond, unless their context is done first.
+	delay time.Duration
+}
+
+func newFakeClient(serverTypes []*datacrunchclient.InstanceType, servers []*datacrunchclient.Instance) *fakeClient {
+	// the image of the node configs of newTestNodeGroup
+	c := &fakeClient{images: []datacrunchclient.ImageInfoResponseDto{{ImageType: "ubuntu-24.04"}}}
+	for _, serverType := range serverTypes {
+		c.serverTypes = append(c.serverTypes, *serverType)
+	}
+	for _, server := range servers {
+		c.servers = append(c.servers, *server)
+	}
+	return c
+}
+
+// wait waits for the delay of the client or until the context is done.
+func (c *fakeClient) wait(ctx context.Context) error {
+	select {
+	case <-time.After(c.delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *fakeClient) ListInstances(ctx context.Context, status string) (datacrunchclient.InstanceList, error) {
+	c.calls.Add(1)
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.listCalls++
+	if c.listErr != nil {
+		err := c.listErr
+		if c.listErrOnce {
+			c.listErr = nil
+		}
+		return nil, err
+	}
+
+	list := make(datacrunchclient.InstanceList, 0, len(c.servers))
+	for _, server := range c.servers {
+		if status == "" || server.Status == status {
+			list = append(list, server)
+		}
+	}
+	return list, nil
+}
+
+func (c *fakeClient) GetInstance(ctx context.Context, id string) (*datacrunchclient.Instance, error) {
+	c.calls.Add(1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, server := range c.servers {
+		if server.ID == id {
+			return &server, nil
+		}
+	}
+	return nil, &datacrunchclient.APIError{StatusCode: http.StatusNotFound, Code: "not_found", Message: fmt.Sprintf("instance %s not found", id)}
+}
+
+func (c *fakeClient) DeployInstance(ctx context.Context, reqBody datacrunchclient.DeployInstanceRequest) (string, error) {
+	c.calls.Add(1)
+	if c.onDeploy != nil {
+		c.onDeploy(reqBody)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deployed = append(c.deployed, reqBody)
+	if c.deployErr != nil {
+		if err := c.deployErr(reqBody); err != nil {
+			return "", err
+		}
+	}
+
+	c.nextID++
+	id := fmt.Sprintf("deployed-%d", c.nextID)
+	c.servers = append(c.servers, datacrunchclient.Instance{
+		ID:           id,
+		Hostname:     reqBody.Hostname,
+		Description:  reqBody.Description,
+		Location:     reqBody.LocationCode,
+		InstanceType: reqBody.InstanceType,
+		IsSpot:       reqBody.IsSpot,
+		Status:       "provisioning",
+		CreatedAt:    time.Now().Format(time.RFC3339),
+	})
+	for i, volume := range reqBody.Volumes {
+		c.volumes = append(c.volumes, datacrunchclient.Volume{
+			ID:         fmt.Sprintf("%s-volume-%d", id, i),
+			InstanceID: id,
+			Name:       volume.Name,
+			Size:       volume.Size,
+			Type:       volume.Type,
+			Location:   reqBody.LocationCode,
+		})
+	}
+	if c.deployedErr != nil {
+		if err := c.deployedErr(reqBody); err != nil {
+			return "", err
+		}
+	}
+	return id, nil
+}
+
+func (c *fakeClient) PerformInstanceAction(ctx context.Context, reqBody datacrunchclient.InstanceActionRequest) error {
+	c.calls.Add(1)
+	if err := c.wait(ctx); err != nil {
+		return err
+	}
+	if c.onDelete != nil && reqBody.Action == "delete" {
+		c.onDelete(reqBody.ID)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if reqBody.Action != "delete" {
+		return nil
+	}
+	for i, server := range c.servers {
+		if server.ID == reqBody.ID {
+			if !c.keepDeleted {
+				c.servers = append(c.servers[:i], c.servers[i+1:]...)
+			}
+			c.deleted = append(c.deleted, reqBody.ID)
+			c.deletedVolumes = append(c.deletedVolumes, reqBody.VolumeIDs...)
+			return nil
+		}
+	}
+	return &datacrunchclient.APIError{StatusCode: http.StatusNotFound, Code: "not_found", Message: fmt.Sprintf("instance %s not found", reqBody.ID)}
+}
+
+func (c *fakeClient) ListInstanceTypes(ctx context.Context) (datacrunchclient.InstanceTypeList, error) {
+	c.calls.Add(1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.listTypesCalls++
+	if c.listTypesErr != nil {
+		return nil, c.listTypesErr
+	}
+
+	return append(datacrunchclient.InstanceTypeList{}, c.serverTypes...), nil
+}
+
+func (c *fakeClient) GetInstanceTypeAvailability(ctx context.Context, instanceType string, isSpot bool, locationCode string) (bool, error) {
+	c.calls.Add(1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return !c.unavailableRegions[locationCode], nil
+}
+
+func (c *fakeClient) ListInstanceAvailability(ctx context.Context, isSpot bool, locationCode string) (datacrunchclient.InstanceAvailabilityList, error) {
+	c.calls.Add(1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.regionAvailability, nil
+}
+
+func (c *fakeClient) UploadStartupScript(ctx context.Context, name string, script string) (string, error) {
+	c.calls.Add(1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.uploadErr != nil {
+		return "", c.uploadErr
+	}
+	if c.uploadedScripts == nil {
+		c.uploadedScripts = make(map[string]string)
+	}
+	c.uploadedScripts[name] = script
+	return "script-" + name, nil
+}
+
+func (c *fakeClient) ListVolumes(ctx context.Context, status string) ([]datacrunchclient.Volume, error) {
+	c.calls.Add(1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]datacrunchclient.Volume{}, c.volumes...), nil
+}
+
+func (c *fakeClient) ListVolumesInTrash(ctx context.Context) ([]datacrunchclient.VolumeInTrash, error) {
+	c.calls.Add(1)
+	return nil, nil
+}
+
+func (c *fakeClient) DeleteVolume(ctx context.Context, volumeID string, isPermanent bool) error {
+	c.calls.Add(1)
+	return nil
+}
+
+func (c *fakeClient) ListSSHKeys(ctx context.Context) ([]datacrunchclient.SSHKey, error) {
+	c.calls.Add(1)
+	return c.sshKeys, nil
+}
+
+func (c *fakeClient) ListImages(ctx context.Context) ([]datacrunchclient.ImageInfoResponseDto, error) {
+	c.calls.Add(1)
+	return c.images, nil
+}
+
+func (c *fakeClient) ListStartupScripts(ctx context.Context) ([]datacrunchclient.StartupScript, error) {
+	c.calls.Add(1)
+	return c.startupScripts, nil
+}
+
+func (c *fakeClient) DeleteStartupScript(ctx context.Context, scriptID string) error {
+	c.calls.Add(1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deletedScripts = append(c.deletedScripts, scriptID)
+	return nil
+}
+
+func (c *fakeClient) CloseIdleConnections() {}
+
+// setAPIClient makes newManager use the client for the duration of the test.
+func setAPIClient(t *testing.T, client datacrunchAPIClient) {
+	t.Helper()
+
+	original := newAPIClient
+	newAPIClient = func(clientID string, clientSecret func() (string, error)) datacrunchAPIClient {
+		return client
+	}
+	t.Cleanup(func() { newAPIClient = original })
+}
+
+// newTestManager returns a manager backed by a fakeClient whose caches are
+// pre-populated with the given server types and servers.
+func newTestManager(t *testing.T, serverTypes []*datacrunchclient.InstanceType, servers []*datacrunchclient.Instance) *datacrunchManager {
+	t.Helper()
+
+	client := newFakeClient(serverTypes, servers)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	cachedServerType := newServerTypeCache(ctx, client, serverTypeCacheTTLDefault, apiCallTimeoutDefault)
+	require.NoError(t, cachedServerType.Add(serverTypeCachedObject{
+		name:        serverTypeCacheKey,
+		serverTypes: serverTypes,
+	}))
+
+	cachedServers := newServersCache(ctx, client, apiCallTimeoutDefault)
+	require.NoError(t, cachedServers.store(servers))
+
+	registerMetrics()
+
+	return &datacrunchManager{
+		client:           client,
+		nodeGroups:       make(map[string]*datacrunchNodeGroup),
+		clusterConfig:    &ClusterConfig{NodeConfigs: make(map[string]*NodeConfig)},
+		cachedServerType: cachedServerType,
+		cachedPrices:     newPriceCache(cachedServerType, priceCacheRefreshIntervalDef),
+		cachedServers:    cachedServers,
+		apiCallContext:   ctx,
+		apiCallTimeout:   apiCallTimeoutDefault,
+
+		serverCreateTimeout:      serverCreateTimeoutDefault,
+		serverRegisterTimeout:    serverRegisterTimeoutDefault,
+		createMaxAttempts:        createMaxAttemptsDefault,
+		createRetryBackoff:       time.Millisecond,
+		tagKeys:                  defaultTagKeys,
+		nodeLookupMaxAttempts:    nodeLookupMaxAttemptsDefault,
+		nodeLookupBackoff:        time.Millisecond,
+		createSemaphores:         newRegionSemaphores(createMaxInFlightDefault),
+		createStaggerAbove:       createStaggerAboveDefault,
+		deleteMaxInFlight:        deleteMaxInFlightDefault,
+		scaleUpBackoff:           scaleUpBackoffDefault,
+		capacityPenalties:        newCapacityPenalties(clock.RealClock{}, capacityPenaltyDefault),
+		registrationPollInterval: serverRegistrationPollInterval,
+		clusterUpdateMutex:       &sync.Mutex{},
+		pendingRegistrations:     newPendingRegistrations(),
+		orphans:                  newOrphanCleanup(clock.RealClock{}),
+		failedServers:            newFailedServerCleanup(clock.RealClock{}),
+		deletingServers:          newDeletingServers(),
+		warmPool:                 newWarmPool(clock.RealClock{}),
+		backgroundCtx:            ctx,
+		cancelBackground:         cancel,
+	}
+}
+
+// fakeClientOf returns the fakeClient backing a manager built by newTestManager.
+func fakeClientOf(m *datacrunchManager) *fakeClient {
+	return m.client.(*fakeClient)
+}
+
+// newTestNodeGroup registers a node group with a default node config on the manager.
+func newTestNodeGroup(m *datacrunchManager, id string, minSize, maxSize int) *datacrunchNodeGroup {
+	n := &datacrunchNodeGroup{
+		id:                 id,
+		manager:            m,
+		minSize:            minSize,
+		maxSize:            maxSize,
+		region:             "FIN-01",
+		instanceType:       "1A100.22V",
+		createTimeout:      m.serverCreateTimeout,
+		registerTimeout:    m.serverRegisterTimeout,
+		clusterUpdateMutex: m.clusterUpdateMutex,
+	}
+	m.nodeGroups[id] = n
+	if _, found := m.clusterConfig.NodeConfigs[id]; !found {
+		m.clusterConfig.NodeConfigs[id] = &NodeConfig{
+			ImageType:      "ubuntu-24.04",
+			DiskSizeGB:     100,
+			InstanceOption: InstanceOptionOnDemandOnly,
+		}
+	}
+	return n
+}
+
+func TestCreateServerWithRetry(t *testing.T) {
+	serverTypes := []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}
+
+	t.Run("transient errors are retried", func(t *testing.T) {
+		manager := newTestManager(t, serverTypes, nil)
+		group := newTestNodeGroup(manager, "pool", 0, 3)
+		client := fakeClientOf(manager)
+		failures := 0
+		client.deployErr = func(req datacrunchclient.DeployInstanceRequest) error {
+			if failures < 2 {
+				failures++
+				return &datacrunchclient.APIError{StatusCode: http.StatusServiceUnavailable, Message: "try again later"}
+			}
+			return nil
+		}
+
+		require.NoError(t, manager.createServerWithRetry(group, group.allPlacements()))
+		assert.Len(t, client.deployed, 3)
+		assert.Len(t, client.servers, 1)
+	})
+
+	t.Run("validation errors fail fast", func(t *testing.T) {
+		manager := newTestManager(t, serverTypes, nil)
+		group := newTestNodeGroup(manager, "pool", 0, 3)
+		client := fakeClientOf(manager)
+		client.deployErr = func(req datacrunchclient.DeployInstanceRequest) error {
+			return &datacrunchclient.APIError{StatusCode: http.StatusBadRequest, Code: "invalid_request", Message: "invalid image"}
+		}
+
+		require.Error(t, manager.createServerWithRetry(group, group.allPlacements()))
+		assert.Len(t, client.deployed, 1)
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		manager := newTestManager(t, serverTypes, nil)
+		group := newTestNodeGroup(manager, "pool", 0, 3)
+		client := fakeClientOf(manager)
+		client.deployErr = func(req datacrunchclient.DeployInstanceRequest) error {
+			return &datacrunchclient.APIError{StatusCode: http.StatusTooManyRequests, Message: "rate limited"}
+		}
+
+		require.Error(t, manager.createServerWithRetry(group, group.allPlacements()))
+		assert.Len(t, client.deployed, createMaxAttemptsDefault)
+	})
+
+	t.Run("servers created by timed out attempts are not created again", func(t *testing.T) {
+		manager := newTestManager(t, serverTypes, nil)
+		group := newTestNodeGroup(manager, "pool", 0, 3)
+		client := fakeClientOf(manager)
+		timedOut := false
+		client.deployedErr = func(req datacrunchclient.DeployInstanceRequest) error {
+			if !timedOut {
+				timedOut = true
+				return &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+			}
+			return nil
+		}
+
+		require.NoError(t, manager.createServerWithRetry(group, group.allPlacements()))
+		assert.Len(t, client.deployed, 1)
+		assert.Len(t, client.servers, 1)
+	})
+
+	t.Run("servers created in the wrong region are deleted and created again", func(t *testing.T) {
+		manager := newTestManager(t, serverTypes, nil)
+		group := newTestNodeGroup(manager, "pool", 0, 3)
+		client := fakeClientOf(manager)
+		misplaced := false
+		client.deployedErr = func(req datacrunchclient.DeployInstanceRequest) error {
+			if !misplaced {
+				misplaced = true
+				client.servers[len(client.servers)-1].Location = "ICE-01"
+			}
+			return nil
+		}
+
+		require.NoError(t, manager.createServerWithRetry(group, group.allPlacements()))
+		require.Len(t, client.deployed, 2)
+		assert.Equal(t, "FIN-01", client.deployed[1].LocationCode)
+		assert.Equal(t, []string{"deployed-1"}, client.deleted)
+		require.Len(t, client.servers, 1)
+		assert.Equal(t, "FIN-01", client.servers[0].Location)
+	})
+
+	t.Run("gives up after max attempts in the wrong region", func(t *testing.T) {
+		manager := newTestManager(t, serverTypes, nil)
+		group := newTestNodeGroup(manager, "pool", 0, 3)
+		client := fakeClientOf(manager)
+		client.deployedErr = func(req datacrunchclient.DeployInstanceRequest) error {
+			client.servers[len(client.servers)-1].Location = "ICE-01"
+			return nil
+		}
+
+		err := manager.createServerWithRetry(group, group.allPlacements())
+		require.Error(t, err)
+		assert.ErrorIs(t, err, errWrongRegion)
+		assert.Len(t, client.deployed, wrongRegionMaxAttempts)
+		assert.Len(t, client.deleted, wrongRegionMaxAttempts)
+		assert.Empty(t, client.servers)
+	})
+}
+
+func TestManagerCleanup(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	t.Setenv("DATACRUNCH_CLIENT_ID", "client-id")
+	t.Setenv("DATACRUNCH_CLIENT_SECRET", "client-secret")
+	t.Setenv("DATACRUNCH_CLUSTER_CONFIG_JSON", `{"node_configs": {}}`)
+
+	server := &datacrunchclient.Instance{ID: "id1", Hostname: "pool-1a", Status: "running"}
+	setAPIClient(t, newFakeClient(nil, []*datacrunchclient.Instance{server}))
+
+	manager, err := newManager()
+	require.NoError(t, err)
+
+	// starts the cleanup of the server volume in the background
+	require.NoError(t, manager.deleteServer(server))
+
+	require.NoError(t, manager.Cleanup())
+}
+
+func TestCleanupWaitsForBackgroundGoroutines(t *testing.T) {
+	manager := newTestManager(t, nil, nil)
+
+	// goroutines started concurrently with Cleanup either returned when it
+	// returns or were not started
+	var running atomic.Int32
+	starting := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		starting.Add(1)
+		go func() {
+			defer starting.Done()
+			manager.goBackground(func(ctx context.Context) {
+				running.Add(1)
+				<-ctx.Done()
+				running.Add(-1)
+			})
+		}()
+	}
+	require.NoError(t, manager.Cleanup())
+	assert.Zero(t, running.Load())
+
+	starting.Wait()
+	assert.Zero(t, running.Load(), "no goroutine is started after Cleanup")
+}
+
+func TestCleanupSettlesCancelledCreates(t *testing.T) {
+	t.Setenv("DATACRUNCH_CLIENT_ID", "client-id")
+	t.Setenv("DATACRUNCH_CLIENT_SECRET", "client-secret")
+
+	// cancelCreate runs Cleanup while a server is deployed, the deploy fails
+	// with the cancellation before or after the server was created
+	cancelCreate := func(t *testing.T, created bool) *fakeClient {
+		manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+		manager.clusterName = "cluster"
+		group := newTestNodeGroup(manager, "pool", 0, 3)
+		manager.clusterConfig.NodeConfigs["pool"].StartupScriptBase64 = base64.StdEncoding.EncodeToString([]byte("kubeadm join"))
+		client := fakeClientOf(manager)
+
+		deploying := make(chan struct{})
+		client.onDeploy = func(req datacrunchclient.DeployInstanceRequest) {
+			close(deploying)
+			<-manager.apiCallContext.Done()
+		}
+		cancelled := func(req datacrunchclient.DeployInstanceRequest) error {
+			return manager.apiCallContext.Err()
+		}
+		if created {
+			client.deployedErr = cancelled
+		} else {
+			client.deployErr = cancelled
+		}
+
+		result := make(chan error, 1)
+		go func() {
+			result <- manager.createServerWithRetry(group, group.allPlacements())
+		}()
+		<-deploying
+		require.NoError(t, manager.Cleanup())
+
+		// Cleanup waited for the create
+		select {
+		case err := <-result:
+			assert.ErrorIs(t, err, context.Canceled)
+		default:
+			t.Fatal("Cleanup returned before the create")
+		}
+		assert.Error(t, manager.createServerWithRetry(group, group.allPlacements()), "no creates after Cleanup")
+		require.Len(t, client.deployed, 1)
+		return client
+	}
+
+	t.Run("created server is tagged for adoption", func(t *testing.T) {
+		client := cancelCreate(t, true)
+		require.Len(t, client.servers, 1)
+		tags := parseServerTags(client.servers[0].Description)
+		assert.Equal(t, "pool", tags[nodeGroupTagKey])
+		assert.Equal(t, "cluster", tags[clusterTagKey])
+		assert.Empty(t, client.deletedScripts, "the server needs its startup script")
+	})
+
+	t.Run("startup script of server not created is deleted", func(t *testing.T) {
+		client := cancelCreate(t, false)
+		assert.Empty(t, client.servers)
+		scriptName := "autoscaler-startup-script-" + client.deployed[0].Hostname
+		assert.Equal(t, []string{"script-" + scriptName}, client.deletedScripts)
+	})
+}
+
+func TestCreateServerConcurrencyLimit(t *testing.T) {
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+	manager.createSemaphores = newRegionSemaphores(3)
+	group := newTestNodeGroup(manager, "pool", 0, 20)
+	client := fakeClientOf(manager)
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	client.onDeploy = func(req datacrunchclient.DeployInstanceRequest) {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}
+	// every other request fails, the semaphore must be released on errors too
+	client.deployErr = func(req datacrunchclient.DeployInstanceRequest) error {
+		if len(client.deployed)%2 == 0 {
+			return &datacrunchclient.APIError{StatusCode: 400, Code: "invalid_request", Message: "invalid image"}
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = manager.createServerWithRetry(group, group.allPlacements())
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, client.deployed, 20)
+	assert.LessOrEqual(t, maxInFlight, 3)
+
+	// all slots are free again
+	for i := 0; i < 3; i++ {
+		manager.createSemaphores.acquire("FIN-01")
+	}
+}
+
+func TestAPICallTimeout(t *testing.T) {
+	server := &datacrunchclient.Instance{ID: "id1", Hostname: "pool-1a", Status: "running"}
+	manager := newTestManager(t, nil, []*datacrunchclient.Instance{server})
+	client := fakeClientOf(manager)
+	client.delay = time.Second
+
+	manager.apiCallTimeout = 10 * time.Millisecond
+	manager.cachedServers = newServersCache(manager.apiCallContext, client, manager.apiCallTimeout)
+
+	_, err := manager.cachedServers.servers()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	err = manager.deleteServer(server)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Empty(t, client.deleted)
+
+	// calls are cancelled by Cleanup
+	manager.apiCallTimeout = time.Minute
+	require.NoError(t, manager.Cleanup())
+	_, err = manager.cachedServers.servers()
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestValidateNodeConfigReferences(t *testing.T) {
+	manager := newTestManager(t, nil, nil)
+	client := fakeClientOf(manager)
+	newTestNodeGroup(manager, "pool", 0, 3)
+	manager.clusterConfig.NodeConfigs["pool"].ImageType = ""
+
+	// nothing referenced, the API is not called
+	require.NoError(t, manager.validateNodeConfigReferences())
+	assert.Zero(t, client.calls.Load())
+
+	client.sshKeys = []datacrunchclient.SSHKey{{ID: "key-1"}}
+	client.startupScripts = []datacrunchclient.StartupScript{{ID: "script-1"}}
+	manager.clusterConfig.NodeConfigs["pool"].SSHKeyIDs = []string{"key-1"}
+	manager.clusterConfig.NodeConfigs["pool"].StartupScriptID = "script-1"
+	require.NoError(t, manager.validateNodeConfigReferences())
+
+	manager.clusterConfig.AutoprovisioningNodeConfig = &NodeConfig{SSHKeyIDs: []string{"key-2"}}
+	err := manager.validateNodeConfigReferences()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "SSH key key-2")
+
+	manager.clusterConfig.AutoprovisioningNodeConfig = nil
+	manager.clusterConfig.NodeConfigs["pool"].StartupScriptID = "script-2"
+	err = manager.validateNodeConfigReferences()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "startup script script-2")
+
+	manager.clusterConfig.NodeConfigs["pool"].StartupScriptID = "script-1"
+	client.images = []datacrunchclient.ImageInfoResponseDto{{ID: "image-1", ImageType: "ubuntu-24.04-cuda-12.8-open-docker"}}
+	manager.clusterConfig.NodeConfigs["pool"].ImageType = "ubuntu-24.04-cuda-12.8-open-docker"
+	require.NoError(t, manager.validateNodeConfigReferences())
+
+	manager.clusterConfig.NodeConfigs["pool"].ImageType = "ubuntu-20.04-cuda-11.0"
+	err = manager.validateNodeConfigReferences()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "image ubuntu-20.04-cuda-11.0 of node config pool does not exist")
+}
+
+func TestCreateServerImage(t *testing.T) {
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}, {InstanceType: "CPU.4V.16G"}}, nil)
+	gpu := newTestNodeGroup(manager, "gpu", 0, 3)
+	cpu := newTestNodeGroup(manager, "cpu", 0, 3)
+	cpu.instanceType = "CPU.4V.16G"
+	client := fakeClientOf(manager)
+	manager.clusterConfig.NodeConfigs["gpu"].ImageType = "ubuntu-24.04-cuda-12.8-open-docker"
+	manager.clusterConfig.NodeConfigs["cpu"].ImageType = "ubuntu-24.04"
+
+	_, err := createServer(gpu, "FIN-01", gpu.instanceType)
+	require.NoError(t, err)
+	_, err = createServer(cpu, "FIN-01", cpu.instanceType)
+	require.NoError(t, err)
+	require.Len(t, client.deployed, 2)
+	assert.Equal(t, "ubuntu-24.04-cuda-12.8-open-docker", client.deployed[0].Image)
+	assert.Equal(t, "1A100.22V", client.deployed[0].InstanceType)
+	assert.Equal(t, "ubuntu-24.04", client.deployed[1].Image)
+	assert.Equal(t, "CPU.4V.16G", client.deployed[1].InstanceType)
+}
+
+func TestCreateServerStartupScriptAndSSHKeys(t *testing.T) {
+	t.Setenv("DATACRUNCH_CLIENT_ID", "client-id")
+	t.Setenv("DATACRUNCH_CLIENT_SECRET", "client-secret")
+
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+	group := newTestNodeGroup(manager, "pool", 0, 3)
+	client := fakeClientOf(manager)
+	nodeConfig := manager.clusterConfig.NodeConfigs["pool"]
+	nodeConfig.SSHKeyIDs = []string{"key-1", "key-2"}
+	nodeConfig.StartupScriptBase64 = base64.StdEncoding.EncodeToString([]byte("kubeadm join"))
+
+	_, err := createServer(group, "FIN-01", group.instanceType)
+	require.NoError(t, err)
+	require.Len(t, client.deployed, 1)
+	assert.Equal(t, []string{"key-1", "key-2"}, client.deployed[0].SSHKeyIDs)
+	assert.Equal(t, "script-autoscaler-startup-script-"+client.deployed[0].Hostname, client.deployed[0].StartupScriptID)
+
+	// an existing script is referenced without uploading it again
+	nodeConfig.StartupScriptBase64 = ""
+	nodeConfig.StartupScriptID = "script-1"
+	_, err = createServer(group, "FIN-01", group.instanceType)
+	require.NoError(t, err)
+	require.Len(t, client.deployed, 2)
+	assert.Equal(t, "script-1", client.deployed[1].StartupScriptID)
+	assert.Equal(t, []string{"key-1", "key-2"}, client.deployed[1].SSHKeyIDs)
+}
+
+func TestCreateServerRegionStartupScript(t *testing.T) {
+	t.Setenv("DATACRUNCH_CLIENT_ID", "client-id")
+	t.Setenv("DATACRUNCH_CLIENT_SECRET", "client-secret")
+
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+	group := newTestNodeGroup(manager, "pool", 0, 3)
+	client := fakeClientOf(manager)
+	nodeConfig := manager.clusterConfig.NodeConfigs["pool"]
+	nodeConfig.StartupScriptBase64 = base64.StdEncoding.EncodeToString([]byte("kubeadm join default.example.com"))
+	nodeConfig.RegionStartupScriptsBase64 = map[string]string{
+		"ICE-01": base64.StdEncoding.EncodeToString([]byte("kubeadm join ice.example.com")),
+	}
+
+	tests := []struct {
+		region string
+		join   string
+	}{
+		{region: "ICE-01", join: "kubeadm join ice.example.com"},
+		{region: "FIN-01", join: "kubeadm join default.example.com"},
+	}
+	for i, tc := range tests {
+		_, err := createServer(group, tc.region, group.instanceType)
+		require.NoError(t, err)
+		require.Len(t, client.deployed, i+1)
+		script := client.uploadedScripts["autoscaler-startup-script-"+client.deployed[i].Hostname]
+		assert.True(t, strings.HasSuffix(script, "\n"+tc.join), "region %s", tc.region)
+	}
+}
+
+func TestCreateAndDeleteServerDataVolumes(t *testing.T) {
+	t.Setenv("DATACRUNCH_CLIENT_ID", "client-id")
+	t.Setenv("DATACRUNCH_CLIENT_SECRET", "client-secret")
+
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+	group := newTestNodeGroup(manager, "pool", 0, 3)
+	client := fakeClientOf(manager)
+	manager.clusterConfig.NodeConfigs["pool"].DataVolumes = []DataVolumeConfig{
+		{Name: "scratch", SizeGB: 500, Type: "NVMe"},
+	}
+
+	_, err := createServer(group, "FIN-01", group.instanceType)
+	require.NoError(t, err)
+	_, err = createServer(group, "FIN-01", group.instanceType)
+	require.NoError(t, err)
+	require.Len(t, client.deployed, 2)
+	hostname := client.deployed[0].Hostname
+	assert.Equal(t, []datacrunchclient.DeployVolume{
+		{Name: hostname + "-data-scratch", Size: 500, Type: "NVMe"},
+	}, client.deployed[0].Volumes)
+
+	// only the volume of the deleted server is deleted with it
+	server := client.servers[0]
+	require.Equal(t, hostname, server.Hostname)
+	require.NoError(t, manager.deleteServer(&server))
+	assert.Equal(t, []string{server.ID + "-volume-0"}, client.deletedVolumes)
+}
+
+func TestValidateNodeConfigDataVolumes(t *testing.T) {
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+	newTestNodeGroup(manager, "pool", 0, 3)
+	nodeConfig := manager.clusterConfig.NodeConfigs["pool"]
+
+	nodeConfig.DataVolumes = []DataVolumeConfig{{Name: "scratch", SizeGB: 500, Type: "NVMe"}}
+	assert.NoError(t, manager.validateNodeConfigReferences())
+
+	nodeConfig.DataVolumes = []DataVolumeConfig{{Name: "scratch", Type: "NVMe"}}
+	assert.ErrorContains(t, manager.validateNodeConfigReferences(), "positive size_gb")
+
+	nodeConfig.DataVolumes = []DataVolumeConfig{
+		{Name: "scratch", SizeGB: 500, Type: "NVMe"},
+		{Name: "scratch", SizeGB: 100, Type: "HDD"},
+	}
+	assert.ErrorContains(t, manager.validateNodeConfigReferences(), "configured twice")
+}
+
+func TestCreateServerRegistrationLabelsAndTaints(t *testing.T) {
+	t.Setenv("DATACRUNCH_CLIENT_ID", "client-id")
+	t.Setenv("DATACRUNCH_CLIENT_SECRET", "client-secret")
+
+	serverTypes := []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V", GPU: datacrunchclient.GPU{NumberOfGPUs: 1}}}
+	manager := newTestManager(t, serverTypes, nil)
+	group := newTestNodeGroup(manager, "pool", 0, 3)
+	client := fakeClientOf(manager)
+	nodeConfig := manager.clusterConfig.NodeConfigs["pool"]
+	nodeConfig.StartupScriptBase64 = base64.StdEncoding.EncodeToString([]byte("kubeadm join"))
+	nodeConfig.Labels = map[string]string{"workload": "training"}
+	nodeConfig.Taints = []apiv1.Taint{
+		{Key: "gpu", Value: "true", Effect: apiv1.TaintEffectNoSchedule},
+		{Key: "dedicated", Effect: apiv1.TaintEffectPreferNoSchedule},
+	}
+
+	_, err := createServer(group, "FIN-01", group.instanceType)
+	require.NoError(t, err)
+	require.Len(t, client.deployed, 1)
+	script := client.uploadedScripts["autoscaler-startup-script-"+client.deployed[0].Hostname]
+	require.NotEmpty(t, script)
+
+	// the template node has the labels and taints injected into the script
+	nodeInfo, err := group.TemplateNodeInfo()
+	require.NoError(t, err)
+	node := nodeInfo.Node()
+	labels := make([]string, 0, len(node.Labels))
+	for key, value := range node.Labels {
+		if key != apiv1.LabelHostname {
+			labels = append(labels, key+"="+value)
+		}
+	}
+	sort.Strings(labels)
+	taints := make([]string, 0, len(node.Spec.Taints))
+	for _, taint := range node.Spec.Taints {
+		taints = append(taints, taint.ToString())
+	}
+
+	assert.Contains(t, script, fmt.Sprintf(`export NODE_LABELS="%s"`, strings.Join(labels, ",")))
+	assert.Contains(t, script, fmt.Sprintf(`export NODE_TAINTS="%s"`, strings.Join(taints, ",")))
+	assert.Contains(t, script, fmt.Sprintf(`export KUBELET_EXTRA_ARGS="--node-labels=%s --register-with-taints=%s"`, strings.Join(labels, ","), strings.Join(taints, ",")))
+	assert.Contains(t, script, "datacrunch.io/gpu-node=true")
+	assert.Contains(t, script, "gpu=true:NoSchedule,dedicated:PreferNoSchedule")
+}
+
+func TestCreateServerValidationCommand(t *testing.T) {
+	t.Setenv("DATACRUNCH_CLIENT_ID", "client-id")
+	t.Setenv("DATACRUNCH_CLIENT_SECRET", "client-secret")
+
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+	group := newTestNodeGroup(manager, "pool", 0, 3)
+	client := fakeClientOf(manager)
+	nodeConfig := manager.clusterConfig.NodeConfigs["pool"]
+	nodeConfig.StartupScriptBase64 = base64.StdEncoding.EncodeToString([]byte("kubeadm join"))
+
+	_, err := createServer(group, "FIN-01", group.instanceType)
+	require.NoError(t, err)
+	script := client.uploadedScripts["autoscaler-startup-script-"+client.deployed[0].Hostname]
+	assert.Contains(t, script, `if [ -n "" ]; then`, "no validation without command")
+
+	nodeConfig.ValidationCommand = `nvidia-smi --query-gpu=name --format=csv | grep -q "H100"`
+	_, err = createServer(group, "FIN-01", group.instanceType)
+	require.NoError(t, err)
+	script = client.uploadedScripts["autoscaler-startup-script-"+client.deployed[1].Hostname]
+	encoded := base64.StdEncoding.EncodeToString([]byte(nodeConfig.ValidationCommand))
+	assert.Contains(t, script, fmt.Sprintf(`bash -c "$(echo '%s' | base64 -d)"`, encoded))
+	assert.Contains(t, script, `"action": "shutdown"`)
+	// the node only joins after the validation
+	assert.Less(t, strings.Index(script, encoded), strings.Index(script, "kubeadm join"))
+}
+
+func TestCreateServerKubeletExtraArgs(t *testing.T) {
+	t.Setenv("DATACRUNCH_CLIENT_ID", "client-id")
+	t.Setenv("DATACRUNCH_CLIENT_SECRET", "client-secret")
+
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+	group := newTestNodeGroup(manager, "pool", 0, 3)
+	client := fakeClientOf(manager)
+	nodeConfig := manager.clusterConfig.NodeConfigs["pool"]
+	nodeConfig.StartupScriptBase64 = base64.StdEncoding.EncodeToString([]byte("kubeadm join"))
+	nodeConfig.KubeletExtraArgs = []string{"--max-pods=200", "--kube-reserved=cpu=500m,memory=1Gi"}
+
+	_, err := createServer(group, "FIN-01", group.instanceType)
+	require.NoError(t, err)
+	require.Len(t, client.deployed, 1)
+	script := client.uploadedScripts["autoscaler-startup-script-"+client.deployed[0].Hostname]
+	assert.Regexp(t, `export KUBELET_EXTRA_ARGS="--node-labels=\S+ --max-pods=200 --kube-reserved=cpu=500m,memory=1Gi"`, script)
+
+	// args which would break the pre-script or override the labels and
+	// taints are rejected on startup
+	for _, args := range [][]string{
+		{"max-pods=200"},
+		{"--max-pods=200 --v=4"},
+		{`--max-pods="200"`},
+		{"--node-labels=a=b"},
+		{"--system-reserved=memory"},
+	} {
+		nodeConfig.KubeletExtraArgs = args
+		assert.ErrorContains(t, manager.validateNodeConfigReferences(), "invalid kubelet_extra_args of node config pool", args)
+	}
+}
+
+func TestResyncGroup(t *testing.T) {
+	servers := []*datacrunchclient.Instance{
+		{ID: "id1", Hostname: "pool-1a", Location: "FIN-01", Status: "running"},
+		{ID: "id2", Hostname: "pool-2b", Location: "FIN-01", Status: "running"},
+	}
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, servers)
+	group := newTestNodeGroup(manager, "pool", 0, 5)
+	group.resetTargetSize(0)
+	size, _ := group.TargetSize()
+	require.Equal(t, 2, size)
+	group.recordScaleUp(false)
+	manager.capacityPenalties.recordFailure(group.capacityKey(group.allPlacements()[0]))
+
+	// a server is deleted and two are created outside of the autoscaler
+	client := fakeClientOf(manager)
+	client.mu.Lock()
+	client.servers = append(client.servers[1:],
+		datacrunchclient.Instance{ID: "id3", Hostname: "pool-3c", Location: "FIN-01", Status: "running"},
+		datacrunchclient.Instance{ID: "id4", Hostname: "pool-4d", Location: "FIN-01", Status: "running"},
+	)
+	client.mu.Unlock()
+
+	require.NoError(t, manager.ResyncGroup("pool"))
+	size, _ = group.TargetSize()
+	assert.Equal(t, 3, size)
+	assert.NoError(t, group.checkBackoff())
+	assert.NoError(t, group.checkCapacityPenalties())
+
+	assert.ErrorContains(t, manager.ResyncGroup("missing"), "node group missing not found")
+}
+
+func TestNewManagerChecksCredentials(t *testing.T) {
+	t.Setenv("DATACRUNCH_CLIENT_ID", "client-id")
+	t.Setenv("DATACRUNCH_CLIENT_SECRET", "client-secret")
+	t.Setenv("DATACRUNCH_CLUSTER_CONFIG_JSON", `{"node_configs": {}}`)
+
+	t.Run("invalid credentials", func(t *testing.T) {
+		client := newFakeClient(nil, nil)
+		client.listTypesErr = fmt.Errorf("login failed: %w", &datacrunchclient.APIError{StatusCode: http.StatusUnauthorized, Code: "unauthorized_request", Message: "invalid client credentials"})
+		setAPIClient(t, client)
+
+		manager, err := newManager()
+		require.Error(t, err)
+		assert.Nil(t, manager)
+		assert.Contains(t, err.Error(), "authentication failed")
+	})
+
+	t.Run("network error", func(t *testing.T) {
+		client := newFakeClient(nil, nil)
+		client.listTypesErr = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+		setAPIClient(t, client)
+
+		manager, err := newManager()
+		require.NoError(t, err)
+		require.NoError(t, manager.Cleanup())
+	})
+}
+
+func TestNewManagerChecksWriteAccess(t *testing.T) {
+	t.Setenv("DATACRUNCH_CLIENT_ID", "client-id")
+	t.Setenv("DATACRUNCH_CLIENT_SECRET", "client-secret")
+	t.Setenv("DATACRUNCH_CLUSTER_CONFIG_JSON", `{"node_configs": {}}`)
+	t.Setenv("DATACRUNCH_CHECK_WRITE_ACCESS", "true")
+
+	t.Run("read-only credentials", func(t *testing.T) {
+		client := newFakeClient(nil, nil)
+		client.uploadErr = &datacrunchclient.APIError{StatusCode: http.StatusForbidden, Code: "forbidden", Message: "insufficient permissions"}
+		setAPIClient(t, client)
+
+		manager, err := newManager()
+		require.Error(t, err)
+		assert.Nil(t, manager)
+		assert.ErrorIs(t, err, errAuth)
+		assert.Contains(t, err.Error(), "may not create startup scripts")
+		assert.NotContains(t, err.Error(), "list servers")
+	})
+
+	t.Run("writable credentials", func(t *testing.T) {
+		client := newFakeClient(nil, nil)
+		setAPIClient(t, client)
+
+		manager, err := newManager()
+		require.NoError(t, err)
+		require.NoError(t, manager.Cleanup())
+		assert.Contains(t, client.uploadedScripts, writeAccessCheckScriptName)
+		assert.Equal(t, []string{"script-" + writeAccessCheckScriptName}, client.deletedScripts)
+	})
+
+	t.Run("network error", func(t *testing.T) {
+		client := newFakeClient(nil, nil)
+		client.uploadErr = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+		setAPIClient(t, client)
+
+		manager, err := newManager()
+		require.NoError(t, err)
+		require.NoError(t, manager.Cleanup())
+	})
+}
+
+func TestNewManagerCatalogUnavailable(t *testing.T) {
+	t.Setenv("DATACRUNCH_CLIENT_ID", "client-id")
+	t.Setenv("DATACRUNCH_CLIENT_SECRET", "client-secret")
+	t.Setenv("DATACRUNCH_CLUSTER_CONFIG_JSON", `{"node_configs": {}}`)
+	original := serverTypeCacheRetryInterval
+	serverTypeCacheRetryInterval = 10 * time.Millisecond
+	t.Cleanup(func() { serverTypeCacheRetryInterval = original })
+
+	client := newFakeClient([]*datacrunchclient.InstanceType{{InstanceType: "1A100.22V", CPU: datacrunchclient.CPU{NumberOfCores: 22}, Memory: datacrunchclient.Memory{SizeInGigabytes: 120}}}, nil)
+	client.listTypesErr = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	setAPIClient(t, client)
+
+	manager, err := newManager()
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, manager.Cleanup()) })
+	group := newTestNodeGroup(manager, "pool", 0, 5)
+
+	// scale ups are retried by the autoscaler until the catalog is fetched
+	err = group.IncreaseSize(1)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, errServerTypesUnavailable)
+	var autoscalerErr autoscalerErrors.AutoscalerError
+	require.ErrorAs(t, err, &autoscalerErr)
+	assert.Equal(t, autoscalerErrors.TransientError, autoscalerErr.Type())
+	assert.Empty(t, client.deployed)
+
+	// the catalog is fetched in the background once the API is available
+	client.mu.Lock()
+	client.listTypesErr = nil
+	client.mu.Unlock()
+	require.Eventually(t, func() bool { return manager.cachedServerType.lastGoodServerTypes() != nil }, time.Second, time.Millisecond)
+
+	nodeInfo, err := group.TemplateNodeInfo()
+	require.NoError(t, err)
+	assert.Equal(t, int64(22), nodeInfo.Node().Status.Capacity.Cpu().Value())
+}
+
+func TestClientSecretFromEnv(t *testing.T) {
+	t.Run("env", func(t *testing.T) {
+		t.Setenv("DATACRUNCH_CLIENT_SECRET", "env-secret")
+		t.Setenv("DATACRUNCH_CLIENT_SECRET_FILE", "")
+
+		secret, err := clientSecretFromEnv()
+		require.NoError(t, err)
+		value, err := secret()
+		require.NoError(t, err)
+		assert.Equal(t, "env-secret", value)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		secretFile := filepath.Join(t.TempDir(), "client-secret")
+		require.NoError(t, os.WriteFile(secretFile, []byte("file-secret\n"), 0600))
+		t.Setenv("DATACRUNCH_CLIENT_SECRET", "env-secret")
+		t.Setenv("DATACRUNCH_CLIENT_SECRET_FILE", secretFile)
+
+		secret, err := clientSecretFromEnv()
+		require.NoError(t, err)
+		value, err := secret()
+		require.NoError(t, err)
+		assert.Equal(t, "file-secret", value, "the file takes precedence")
+
+		// the rotated secret is read on the next call
+		require.NoError(t, os.WriteFile(secretFile, []byte("  rotated-secret\n"), 0600))
+		value, err = secret()
+		require.NoError(t, err)
+		assert.Equal(t, "rotated-secret", value)
+
+		require.NoError(t, os.WriteFile(secretFile, []byte("\n"), 0600))
+		_, err = secret()
+		assert.Error(t, err)
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		t.Setenv("DATACRUNCH_CLIENT_SECRET", "")
+		t.Setenv("DATACRUNCH_CLIENT_SECRET_FILE", "")
+		_, err := clientSecretFromEnv()
+		assert.Error(t, err)
+
+		t.Setenv("DATACRUNCH_CLIENT_SECRET_FILE", filepath.Join(t.TempDir(), "missing"))
+		_, err = clientSecretFromEnv()
+		assert.Error(t, err)
+	})
+}
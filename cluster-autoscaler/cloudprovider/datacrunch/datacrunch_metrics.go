@@ -0,0 +1,122 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"sync"
+	"time"
+
+	k8smetrics "k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const metricsNamespace = "datacrunch"
+
+var (
+	serverCreatesTotal = k8smetrics.NewCounterVec(
+		&k8smetrics.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "server_creates_total",
+			Help:      "Number of servers created by the cluster autoscaler.",
+		},
+		[]string{"node_group", "region"},
+	)
+
+	serverCreateFailuresTotal = k8smetrics.NewCounterVec(
+		&k8smetrics.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "server_create_failures_total",
+			Help:      "Number of servers the cluster autoscaler failed to create.",
+		},
+		[]string{"node_group", "region"},
+	)
+
+	serverDeletesTotal = k8smetrics.NewCounterVec(
+		&k8smetrics.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "server_deletes_total",
+			Help:      "Number of servers deleted by the cluster autoscaler.",
+		},
+		[]string{"node_group", "region"},
+	)
+
+	serverRegisterDuration = k8smetrics.NewHistogramVec(
+		&k8smetrics.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "server_create_to_register_duration_seconds",
+			Help:      "Time from creating a server until its node is seen in the cluster.",
+			Buckets:   k8smetrics.ExponentialBuckets(30, 1.5, 12),
+		},
+		[]string{"node_group", "region"},
+	)
+
+	registerMetricsOnce sync.Once
+)
+
+// registerMetrics registers the DataCrunch metrics with the cluster autoscaler
+// metrics registry. It is safe to call multiple times, e.g. when several
+// providers are built in tests.
+func registerMetrics() {
+	registerMetricsOnce.Do(func() {
+		legacyregistry.MustRegister(serverCreatesTotal)
+		legacyregistry.MustRegister(serverCreateFailuresTotal)
+		legacyregistry.MustRegister(serverDeletesTotal)
+		legacyregistry.MustRegister(serverRegisterDuration)
+	})
+}
+
+// pendingRegistrations tracks servers created by the autoscaler until their
+// node shows up in the cluster, to observe the create-to-register latency.
+type pendingRegistrations struct {
+	sync.Mutex
+	servers map[string]pendingRegistration
+}
+
+type pendingRegistration struct {
+	nodeGroup string
+	region    string
+	createdAt time.Time
+}
+
+func newPendingRegistrations() *pendingRegistrations {
+	return &pendingRegistrations{servers: make(map[string]pendingRegistration)}
+}
+
+func (p *pendingRegistrations) add(serverID, nodeGroup, region string) {
+	p.Lock()
+	defer p.Unlock()
+	p.servers[serverID] = pendingRegistration{nodeGroup: nodeGroup, region: region, createdAt: time.Now()}
+}
+
+// registered observes the create-to-register latency of the server, if it
+// was created by the autoscaler and its node was not seen before.
+func (p *pendingRegistrations) registered(serverID string) {
+	p.Lock()
+	defer p.Unlock()
+	pending, found := p.servers[serverID]
+	if !found {
+		return
+	}
+	delete(p.servers, serverID)
+	serverRegisterDuration.WithLabelValues(pending.nodeGroup, pending.region).Observe(time.Since(pending.createdAt).Seconds())
+}
+
+func (p *pendingRegistrations) remove(serverID string) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.servers, serverID)
+}
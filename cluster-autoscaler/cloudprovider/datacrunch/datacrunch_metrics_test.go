@@ -0,0 +1,81 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
+	k8smetrics "k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func TestServerMetrics(t *testing.T) {
+	// registering more than once must not panic
+	registerMetrics()
+	registerMetrics()
+	serverCreatesTotal.Reset()
+	serverCreateFailuresTotal.Reset()
+	serverDeletesTotal.Reset()
+	serverRegisterDuration.Reset()
+
+	serverTypes := []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}
+	manager := newTestManager(t, serverTypes, nil)
+	group := newTestNodeGroup(manager, "metrics-pool", 0, 3)
+
+	require.NoError(t, manager.createServerWithRetry(group, group.allPlacements()))
+	assertCounterValue(t, 1, serverCreatesTotal.WithLabelValues("metrics-pool", "FIN-01"))
+
+	fakeClientOf(manager).deployErr = func(req datacrunchclient.DeployInstanceRequest) error {
+		return &datacrunchclient.APIError{StatusCode: 400, Code: "invalid_request", Message: "invalid image"}
+	}
+	require.Error(t, manager.createServerWithRetry(group, group.allPlacements()))
+	assertCounterValue(t, 1, serverCreateFailuresTotal.WithLabelValues("metrics-pool", "FIN-01"))
+
+	_, err := manager.cachedServers.servers()
+	require.NoError(t, err)
+	node := &apiv1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "metrics-pool-node"},
+		Spec:       apiv1.NodeSpec{ProviderID: "datacrunch://deployed-1"},
+	}
+
+	// the latency is only observed the first time the node is seen
+	for i := 0; i < 2; i++ {
+		instance, err := manager.serverForNode(node)
+		require.NoError(t, err)
+		require.NotNil(t, instance)
+	}
+	count, err := testutil.GetHistogramMetricCount(serverRegisterDuration.WithLabelValues("metrics-pool", "FIN-01"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+
+	group.targetSize = 1
+	require.NoError(t, group.DeleteNodes([]*apiv1.Node{node}))
+	assertCounterValue(t, 1, serverDeletesTotal.WithLabelValues("metrics-pool", "FIN-01"))
+}
+
+func assertCounterValue(t *testing.T, expected float64, counter k8smetrics.CounterMetric) {
+	t.Helper()
+
+	value, err := testutil.GetCounterMetricValue(counter)
+	require.NoError(t, err)
+	assert.Equal(t, expected, value)
+}
@@ -24,22 +24,30 @@ import (
 	"maps"
 	"math/rand"
 	"os"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	texttmpl "text/template"
+	"time"
 
 	apiv1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
 	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
 	"k8s.io/autoscaler/cluster-autoscaler/config"
 	"k8s.io/autoscaler/cluster-autoscaler/simulator/framework"
+	"k8s.io/client-go/util/flowcontrol"
 	"k8s.io/klog/v2"
 )
 
 const (
-	// ResourceGPU is the resource name for GPU
+	// ResourceGPU is the default resource name for GPU
 	ResourceGPU apiv1.ResourceName = "nvidia.com/gpu"
 )
 
@@ -89,6 +97,53 @@ if [ -n "$INSTANCE_ID" ]; then
 else
     echo "No instance found for hostname {{ .NODENAME }}"
 fi
+
+# 4. labels and taints of the node group, pass KUBELET_EXTRA_ARGS to the kubelet
+# so the node matches the template node of the autoscaler
+export NODE_LABELS="{{ .NODE_LABELS }}"
+export NODE_TAINTS="{{ .NODE_TAINTS }}"
+export KUBELET_EXTRA_ARGS="{{ .KUBELET_EXTRA_ARGS }}"
+
+# 5. servers which fail the validation command of the node group shut down
+# instead of joining the cluster, the autoscaler replaces them
+if [ -n "{{ .VALIDATION_COMMAND }}" ]; then
+    if ! bash -c "$(echo '{{ .VALIDATION_COMMAND }}' | base64 -d)"; then
+        echo "Validation command failed, shutting down"
+        if [ -n "$INSTANCE_ID" ]; then
+            curl -s -X PUT https://api.datacrunch.io/v1/instances \
+            --header "Authorization: Bearer $ACCESS_TOKEN" \
+            --header 'Content-Type: application/json' \
+            --data '{"action": "shutdown", "id": "'$INSTANCE_ID'"}'
+        fi
+        exit 1
+    fi
+fi
+
+# 6. warm servers wait until the autoscaler releases them by deleting their
+# hold script. The poll backs off from 10 seconds to a minute, the access
+# token is only fetched again once it is rejected.
+if [ -n "{{ .WARM_POOL_SCRIPT }}" ]; then
+    echo "Waiting for release from the warm pool"
+    POLL_INTERVAL=10
+    while true; do
+        if [ -z "$ACCESS_TOKEN" ] || [ "$ACCESS_TOKEN" = "null" ]; then
+            ACCESS_TOKEN=$(curl -s https://api.datacrunch.io/v1/oauth2/token \
+                --request POST \
+                --header 'Content-Type: application/json' \
+                --data '{"grant_type": "client_credentials", "client_id": "{{ .DATACRUNCH_CLIENT_ID }}", "client_secret": "{{ .DATACRUNCH_CLIENT_SECRET }}"}' | \
+                jq -r '.access_token')
+        fi
+        if ! SCRIPTS=$(curl -s -f https://api.datacrunch.io/v1/scripts \
+            --header "Authorization: Bearer $ACCESS_TOKEN"); then
+            ACCESS_TOKEN=""
+        elif ! echo "$SCRIPTS" | jq -e --arg NAME "{{ .WARM_POOL_SCRIPT }}" '.[] | select(.name == $NAME)' > /dev/null; then
+            break
+        fi
+        sleep $POLL_INTERVAL
+        POLL_INTERVAL=$(( POLL_INTERVAL * 2 > 60 ? 60 : POLL_INTERVAL * 2 ))
+    done
+    echo "Released from the warm pool"
+fi
 	`
 )
 
@@ -103,16 +158,113 @@ type datacrunchNodeGroup struct {
 	targetSize   int
 	region       string
 	instanceType string
-
+	// fallbackInstanceTypes are tried in order if instanceType is out of
+	// capacity. Templates use instanceType, created servers are accounted
+	// by their own instance type.
+	fallbackInstanceTypes []string
+	spot                  bool
+	// reserved node groups create their servers from the reserved capacity
+	// of the project instead of on-demand capacity.
+	reserved bool
+
+	// regions holds the regions servers are created in, in order of
+	// preference. The next region is tried if a region is out of capacity.
+	// The first region equals region, only region is used if empty.
+	regions []string
+
+	// createTimeout bounds the creation of a single server, including retries.
+	createTimeout time.Duration
+	// registerTimeout is the time a created server has to join the cluster.
+	registerTimeout time.Duration
+	// maxPods is the pod capacity of the nodes, defaultPodAmountsLimit is
+	// used if zero.
+	maxPods int
+	// tags are stored in the description of created servers, they are set
+	// for node groups found by auto discovery.
+	tags map[string]string
+	// options override the autoscaling options of the autoscaler.
+	options nodeGroupOptions
+	// createLimiter limits the servers added to the node group per minute,
+	// nil if the node group has no create rate.
+	createLimiter flowcontrol.RateLimiter
+
+	// clusterUpdateMutex is the clusterUpdateMutex of the manager, shared by
+	// all node groups.
 	clusterUpdateMutex *sync.Mutex
+
+	// autoprovisioned is set for node groups built by NewNodeGroup, they are
+	// deleted by the autoscaler once scaled to zero.
+	autoprovisioned bool
+	// autoprovisioningNodeConfig is the node config of an autoprovisioned
+	// node group, it is registered in the cluster config by Create.
+	autoprovisioningNodeConfig *NodeConfig
+
+	// sizeMutex guards targetSize and inFlightCreates, which are read by the
+	// autoscaler and other node groups while servers are created, the
+	// backoff state and the last error. It is only held briefly and never while calling the
+	// DataCrunch API, clusterUpdateMutex is acquired first if both are held.
+	sizeMutex sync.Mutex
+	// inFlightCreates is the number of servers being created, they are not
+	// necessarily returned by the DataCrunch API yet.
+	inFlightCreates int
+	// sizeGeneration is incremented whenever a scaling operation sets the
+	// target size, reconciliations of servers listed before are dropped.
+	sizeGeneration int
+	// scaleUpFailures is the number of consecutive scale ups which created
+	// no server, the node group is not scaled up until backoffUntil.
+	scaleUpFailures int
+	backoffUntil    time.Time
+	// lastError is the last error of a scale up or down, it occurred at
+	// lastErrorTime.
+	lastError     error
+	lastErrorTime time.Time
+	// lastScaleUpTime and lastScaleDownTime are the times the target size
+	// was last raised by a scale up or lowered by a scale down.
+	lastScaleUpTime   time.Time
+	lastScaleDownTime time.Time
+	// excessServers is the number of servers above the max size, they were
+	// created outside of the autoscaler.
+	excessServers int
+
+	// instanceTypeMissing is set by Refresh if the instance type is no
+	// longer in the catalog, the node group is not scaled up then.
+	instanceTypeMissing atomic.Bool
+	// mismatchedServers maps the IDs of the servers of the node group whose
+	// instance type is none of the node group to their instance type. It is
+	// only accessed by Refresh.
+	mismatchedServers map[string]string
 }
 
 type datacrunchNodeGroupSpec struct {
-	name         string
-	minSize      int
-	maxSize      int
-	region       string
-	instanceType string
+	name                  string
+	minSize               int
+	maxSize               int
+	regions               []string
+	instanceType          string
+	fallbackInstanceTypes []string
+	spot                  bool
+	reserved              bool
+	createTimeout         time.Duration
+	registerTimeout       time.Duration
+	maxPods               int
+	// createRate is the number of servers added to the node group per
+	// minute, zero does not limit it.
+	createRate float64
+	tags       map[string]string
+	options    nodeGroupOptions
+}
+
+// nodeGroupOptions holds the autoscaling options set in the spec of a node
+// group. Unset thresholds are nil, unset durations are zero.
+type nodeGroupOptions struct {
+	scaleDownUtilizationThreshold    *float64
+	scaleDownGpuUtilizationThreshold *float64
+	scaleDownUnneededTime            time.Duration
+	scaleDownUnreadyTime             time.Duration
+	maxNodeProvisionTime             time.Duration
+	// scaleDownDisabled keeps the autoscaler from scaling the node group
+	// down, e.g. for non-evictable or licensed workloads.
+	scaleDownDisabled bool
 }
 
 // MaxSize returns maximum size of the node group.
@@ -128,7 +280,41 @@ func (n *datacrunchNodeGroup) MinSize() int {
 // GetOptions returns NodeGroupAutoscalingOptions that should be used for this particular
 // NodeGroup. Returning a nil will result in using default options.
 func (n *datacrunchNodeGroup) GetOptions(defaults config.NodeGroupAutoscalingOptions) (*config.NodeGroupAutoscalingOptions, error) {
-	return nil, cloudprovider.ErrNotImplemented
+	options := defaults
+	if nodeConfig := n.nodeConfig(); nodeConfig != nil {
+		if nodeConfig.ScaleDownUtilizationThreshold != nil {
+			options.ScaleDownUtilizationThreshold = *nodeConfig.ScaleDownUtilizationThreshold
+		}
+		if nodeConfig.ScaleDownGpuUtilizationThreshold != nil {
+			options.ScaleDownGpuUtilizationThreshold = *nodeConfig.ScaleDownGpuUtilizationThreshold
+		}
+	}
+	if n.options.scaleDownUtilizationThreshold != nil {
+		options.ScaleDownUtilizationThreshold = *n.options.scaleDownUtilizationThreshold
+	}
+	if n.options.scaleDownGpuUtilizationThreshold != nil {
+		options.ScaleDownGpuUtilizationThreshold = *n.options.scaleDownGpuUtilizationThreshold
+	}
+	if n.options.scaleDownUnneededTime != 0 {
+		options.ScaleDownUnneededTime = n.options.scaleDownUnneededTime
+	}
+	if n.options.scaleDownUnreadyTime != 0 {
+		options.ScaleDownUnreadyTime = n.options.scaleDownUnreadyTime
+	}
+	if n.options.maxNodeProvisionTime != 0 {
+		options.MaxNodeProvisionTime = n.options.maxNodeProvisionTime
+	} else if n.reserved {
+		options.MaxNodeProvisionTime = reservedMaxNodeProvisionTime
+	}
+	// There is no option to disable scale down, no node is unneeded below a
+	// zero utilization threshold and for practically ever.
+	if n.options.scaleDownDisabled {
+		options.ScaleDownUtilizationThreshold = 0
+		options.ScaleDownGpuUtilizationThreshold = 0
+		options.ScaleDownUnneededTime = scaleDownDisabledTime
+		options.ScaleDownUnreadyTime = scaleDownDisabledTime
+	}
+	return &options, nil
 }
 
 // TargetSize returns the current target size of the node group. It is possible
@@ -137,113 +323,243 @@ func (n *datacrunchNodeGroup) GetOptions(defaults config.NodeGroupAutoscalingOpt
 // registration or removed nodes are deleted completely). Implementation
 // required.
 func (n *datacrunchNodeGroup) TargetSize() (int, error) {
+	n.sizeMutex.Lock()
+	defer n.sizeMutex.Unlock()
 	return n.targetSize, nil
 }
 
 // IncreaseSize increases the size of the node group. To delete a node you need
 // to explicitly name it and use DeleteNode. This function should wait until
 // node group size is updated. Implementation required.
-func (n *datacrunchNodeGroup) IncreaseSize(delta int) error {
+func (n *datacrunchNodeGroup) IncreaseSize(delta int) (err error) {
 	if delta <= 0 {
 		return fmt.Errorf("delta must be positive, have: %d", delta)
 	}
 
-	desiredTargetSize := n.targetSize + delta
-	if desiredTargetSize > n.MaxSize() {
-		return fmt.Errorf("size increase is too large. current: %d desired: %d max: %d", n.targetSize, desiredTargetSize, n.MaxSize())
+	if n.instanceTypeMissing.Load() {
+		return fmt.Errorf("node group %s is not scalable: instance type %s is no longer available in the DataCrunch catalog", n.id, n.instanceType)
 	}
 
-	actualDelta := delta
+	// the catalog is needed for the resource limits and the template node,
+	// it is retried in the background if it could not be fetched at startup
+	if _, err := n.manager.cachedServerType.getAllServerTypes(); err != nil {
+		return toAutoscalerError(fmt.Errorf("can not scale up node group %s: %w", n.id, err))
+	}
 
-	klog.V(4).Infof("Scaling Instance Pool %s to %d", n.id, desiredTargetSize)
+	if err := n.checkBackoff(); err != nil {
+		return err
+	}
+	defer func() { n.recordError(err) }()
 
+	// The increase is checked before waiting for the create rate limit, so
+	// rejected increases take no tokens, and the limit is waited for without
+	// holding clusterUpdateMutex, so other node groups are scaled up in the
+	// meantime. It is checked again under the lock, as they may have used up
+	// the limits of the cluster.
 	n.clusterUpdateMutex.Lock()
-	defer n.clusterUpdateMutex.Unlock()
-
-	instanceOption := n.manager.clusterConfig.NodeConfigs[n.id].InstanceOption
-
-	var available bool
-	var err error
-
-	switch instanceOption {
-	case InstanceOptionPreferSpot:
-		available, err = serverTypeAvailable(n.manager, n.instanceType, n.region, true)
-		if err != nil {
-			klog.V(4).Infof("Failed to check if server type %s is available in region %s with isSpot %t, trying to check with isSpot %t", n.instanceType, n.region, true, false)
-		}
-		if !available {
-			available, err = serverTypeAvailable(n.manager, n.instanceType, n.region, false)
-			if err != nil {
-				klog.V(4).Infof("Failed to check if server type %s is available in region %s with isSpot %t, trying to check with isSpot %t", n.instanceType, n.region, false, true)
-			}
-		}
-	case InstanceOptionPreferOnDemand:
-		available, err = serverTypeAvailable(n.manager, n.instanceType, n.region, false)
-		if err != nil {
-			klog.V(4).Infof("Failed to check if server type %s is available in region %s with isSpot %t, trying to check with isSpot %t", n.instanceType, n.region, false, true)
-		}
-		if !available {
-			available, err = serverTypeAvailable(n.manager, n.instanceType, n.region, true)
-			if err != nil {
-				klog.V(4).Infof("Failed to check if server type %s is available in region %s with isSpot %t, trying to check with isSpot %t", n.instanceType, n.region, true, false)
-			}
+	_, err = n.checkIncrease(delta)
+	n.clusterUpdateMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if !n.manager.dryRun {
+		if err := n.waitForCreateRate(delta); err != nil {
+			return toAutoscalerError(err)
 		}
-	case InstanceOptionSpotOnly:
-		available, err = serverTypeAvailable(n.manager, n.instanceType, n.region, true)
-	case InstanceOptionOnDemandOnly:
-		available, err = serverTypeAvailable(n.manager, n.instanceType, n.region, false)
 	}
 
+	// the target size is read under the lock, so concurrent scale ups can't
+	// exceed the max size together
+	n.clusterUpdateMutex.Lock()
+	unlock := sync.OnceFunc(n.clusterUpdateMutex.Unlock)
+	defer unlock()
+
+	targetSize, err := n.checkIncrease(delta)
 	if err != nil {
-		return fmt.Errorf("failed to check if server type %s is available in region %s with instance option %s: %v", n.instanceType, n.region, instanceOption, err)
+		return err
 	}
-
-	if !available {
-		return fmt.Errorf("server type %s not available in region %s with instance option %s", n.instanceType, n.region, instanceOption)
+	desiredTargetSize := targetSize + delta
+	klog.Infof("%v Increasing size by %d from %d to %d", n.logFields(), delta, targetSize, desiredTargetSize)
+
+	if n.manager.dryRun {
+		klog.Infof("%v Dry run: would create %d servers of type %s in regions %v", n.logFields(), delta, n.instanceType, n.allRegions())
+		n.sizeMutex.Lock()
+		n.targetSize = desiredTargetSize
+		n.sizeGeneration++
+		n.recordScaleActivity(delta)
+		n.sizeMutex.Unlock()
+		return nil
 	}
 
-	defer func() {
-		// create new servers cache
-		if _, err := n.manager.cachedServers.servers(); err != nil {
-			klog.Errorf("failed to update servers cache: %v", err)
+	// Warm servers are released first, their nodes join the cluster much
+	// faster than the nodes of new servers.
+	released := n.manager.releaseWarmServers(n, delta)
+	if released == delta {
+		n.resetTargetSize(released)
+		n.recordScaleUp(true)
+		return nil
+	}
+	toCreate := delta - released
+
+	// Placements which recently ran out of capacity are skipped, the scale
+	// up fails fast if there are no others, so the autoscaler moves on to
+	// other node groups. This is not counted as failed scale up.
+	if err := n.checkCapacityPenalties(); err != nil {
+		if released > 0 {
+			n.resetTargetSize(released)
 		}
+		return toAutoscalerError(err)
+	}
 
-		// Update target size
-		n.resetTargetSize(actualDelta)
-	}()
+	placements, err := n.availablePlacements(n.instanceOption())
+	if err != nil {
+		if released > 0 {
+			n.resetTargetSize(released)
+		}
+		n.recordScaleUp(released > 0)
+		return toAutoscalerError(err)
+	}
 
 	// There is no "Server Group" in Datacrunch Cloud, we need to create every
 	// server manually. This operation might fail for some of the servers
 	// because of quotas, rate limiting or server type availability. We need to
 	// collect the errors and inform cluster-autoscaler about this, so it can
-	// try other node groups if configured.
+	// try other node groups if configured. Servers created successfully are
+	// kept.
+	//
+	// The servers are created without holding clusterUpdateMutex, so other
+	// node groups are scaled up and down in the meantime. The target size
+	// includes them until the creates returned, so concurrent scale ups can't
+	// exceed the limits. The creates of large scale ups are staggered.
+	n.sizeMutex.Lock()
+	n.targetSize += delta
+	n.inFlightCreates += toCreate
+	n.sizeGeneration++
+	n.sizeMutex.Unlock()
+	unlock()
+
+	stagger := n.createStagger(toCreate)
 	waitGroup := sync.WaitGroup{}
-	errsCh := make(chan error, delta)
-	for i := 0; i < delta; i++ {
+	errsCh := make(chan error, toCreate)
+	startedCh := make(chan createdServer, toCreate)
+	for i := 0; i < toCreate; i++ {
 		waitGroup.Add(1)
 		go func() {
 			defer waitGroup.Done()
-			err := createServer(n)
+			err := n.createStaggered(time.Duration(i)*stagger, placements, startedCh)
+			n.addInFlightCreates(-1)
 			if err != nil {
-				actualDelta--
 				errsCh <- err
 			}
 		}()
 	}
 	waitGroup.Wait()
 	close(errsCh)
+	close(startedCh)
+
+	errs := make([]error, 0, toCreate)
+	for createErr := range errsCh {
+		errs = append(errs, createErr)
+	}
+	created := released + toCreate - len(errs)
 
-	errs := make([]error, 0, delta)
-	for err = range errsCh {
-		errs = append(errs, err)
+	// the target size only includes the servers which were created, the
+	// stale cache can't be counted if it fails to update
+	n.clusterUpdateMutex.Lock()
+	if _, err := n.manager.cachedServers.servers(); err != nil {
+		klog.Errorf("%v failed to update servers cache, using delta %d: %v", n.logFields(), created, err)
+		n.sizeMutex.Lock()
+		n.targetSize -= delta - created
+		n.sizeGeneration++
+		n.recordScaleActivity(created)
+		n.sizeMutex.Unlock()
+	} else {
+		n.resetTargetSize(created)
+	}
+	n.clusterUpdateMutex.Unlock()
+
+	// The servers are waited for to run without holding clusterUpdateMutex
+	// either. The servers which do not run are deleted and no longer
+	// counted.
+	if n.manager.waitForRunning {
+		started := make([]createdServer, 0, toCreate)
+		for server := range startedCh {
+			started = append(started, server)
+		}
+		if notRunning := n.manager.waitForServersRunning(n, started); len(notRunning) > 0 {
+			n.sizeMutex.Lock()
+			n.targetSize = max(n.targetSize-len(notRunning), 0)
+			n.sizeGeneration++
+			n.sizeMutex.Unlock()
+			errs = append(errs, notRunning...)
+			created -= len(notRunning)
+		}
 	}
+
+	n.recordScaleUp(created > 0)
+
 	if len(errs) > 0 {
-		return fmt.Errorf("failed to create all servers: %w", errors.Join(errs...))
+		klog.Warningf("%v Created %d of %d servers", n.logFields(), created, delta)
+		return toAutoscalerError(fmt.Errorf("failed to create %d of %d servers: %w", len(errs), delta, errors.Join(errs...)))
 	}
 
 	return nil
 }
 
+// checkIncrease checks that the node group can be increased by delta servers
+// within its max size and the limits of the cluster, and returns its target
+// size. clusterUpdateMutex must be held.
+func (n *datacrunchNodeGroup) checkIncrease(delta int) (int, error) {
+	targetSize, _ := n.TargetSize()
+	desiredTargetSize := targetSize + delta
+	if desiredTargetSize > n.MaxSize() {
+		return 0, fmt.Errorf("size increase is too large. current: %d desired: %d max: %d", targetSize, desiredTargetSize, n.MaxSize())
+	}
+	if err := n.checkMaxNodesTotal(delta, min(delta, n.manager.warmPool.size(n.id))); err != nil {
+		return 0, err
+	}
+	if err := n.checkResourceLimits(delta); err != nil {
+		return 0, err
+	}
+	return targetSize, nil
+}
+
+// createStagger returns the delay between the creates of a scale up by delta
+// servers. Scale ups by at most createStaggerAbove servers are not staggered,
+// larger scale ups spread the creates over at most half the create timeout,
+// so the last server still has time to be created.
+func (n *datacrunchNodeGroup) createStagger(delta int) time.Duration {
+	if delta <= n.manager.createStaggerAbove || n.manager.createStagger <= 0 {
+		return 0
+	}
+	maxStagger := time.Duration(float64(n.createTimeout/2) / (float64(delta-1) * (1 + createStaggerJitter)))
+	return min(n.manager.createStagger, maxStagger)
+}
+
+// createStaggered creates a server of a scale up once its delay passed, the
+// delay is jittered. The created server is sent to started if the scale up
+// waits for it to run.
+func (n *datacrunchNodeGroup) createStaggered(delay time.Duration, placements []serverPlacement, started chan<- createdServer) error {
+	if delay > 0 {
+		timer := time.NewTimer(wait.Jitter(delay, createStaggerJitter))
+		defer timer.Stop()
+		select {
+		case <-n.manager.backgroundCtx.Done():
+			return fmt.Errorf("not creating server for node group %s, manager is cleaned up: %w", n.id, n.manager.backgroundCtx.Err())
+		case <-timer.C:
+		}
+	}
+
+	if !n.manager.waitForRunning {
+		return n.manager.createServerWithRetry(n, placements)
+	}
+	server, err := n.manager.createServer(n, placements)
+	if err == nil {
+		started <- server
+	}
+	return err
+}
+
 // AtomicIncreaseSize is not implemented.
 func (n *datacrunchNodeGroup) AtomicIncreaseSize(delta int) error {
 	return cloudprovider.ErrNotImplemented
@@ -253,50 +569,93 @@ func (n *datacrunchNodeGroup) AtomicIncreaseSize(delta int) error {
 // of the node group with that). Error is returned either on failure or if the
 // given node doesn't belong to this node group. This function should wait
 // until node group size is updated. Implementation required.
-func (n *datacrunchNodeGroup) DeleteNodes(nodes []*apiv1.Node) error {
+func (n *datacrunchNodeGroup) DeleteNodes(nodes []*apiv1.Node) (err error) {
 	n.clusterUpdateMutex.Lock()
 	defer n.clusterUpdateMutex.Unlock()
+	defer func() { n.recordError(err) }()
+
+	if err := n.verifyOwnership(nodes); err != nil {
+		return err
+	}
 
 	delta := len(nodes)
 
-	targetSize := n.targetSize - delta
+	currentSize, _ := n.TargetSize()
+	targetSize := currentSize - delta
 	if targetSize < n.MinSize() {
-		return fmt.Errorf("size decrease is too large. current: %d desired: %d min: %d", n.targetSize, targetSize, n.MinSize())
+		return fmt.Errorf("size decrease is too large. current: %d desired: %d min: %d", currentSize, targetSize, n.MinSize())
+	}
+
+	// The target size may be miscounted, e.g. while servers are created, the
+	// servers left must not fall below the min size either.
+	servers, err := n.manager.allServers(n.id)
+	if err != nil {
+		return fmt.Errorf("refusing to delete nodes of node group %s, failed to count its servers: %v", n.id, err)
+	}
+	if left := n.manager.countActiveServers(serversLeftAfterDelete(servers, nodes)); left < n.MinSize() {
+		return fmt.Errorf("refusing to delete %d nodes of node group %s: %d servers would be left, below min size %d", delta, n.id, left, n.MinSize())
+	}
+
+	klog.Infof("%v Decreasing size by %d from %d to %d", n.logFields(), delta, currentSize, targetSize)
+
+	if n.manager.dryRun {
+		for _, node := range nodes {
+			klog.Infof("%v Dry run: would delete server %s of node %s", n.logFields(), node.Spec.ProviderID, node.Name)
+		}
+		n.sizeMutex.Lock()
+		n.targetSize = targetSize
+		n.sizeGeneration++
+		n.recordScaleActivity(-delta)
+		n.sizeMutex.Unlock()
+		return nil
 	}
 
-	actualDelta := delta
+	errs := make([]error, 0, len(nodes))
 
 	defer func() {
 		// create new servers cache
 		if _, err := n.manager.cachedServers.servers(); err != nil {
-			klog.Errorf("failed to update servers cache: %v", err)
+			klog.Errorf("%v failed to update servers cache: %v", n.logFields(), err)
 		}
 
-		n.resetTargetSize(-actualDelta)
+		n.resetTargetSize(-(delta - len(errs)))
 	}()
 
-	waitGroup := sync.WaitGroup{}
-	errsCh := make(chan error, len(nodes))
-	for _, node := range nodes {
-		waitGroup.Add(1)
-		go func(node *apiv1.Node) {
-			klog.Infof("Evicting server %s", node.Name)
-
-			err := n.manager.deleteByNode(node)
-			if err != nil {
-				actualDelta--
-				errsCh <- fmt.Errorf("failed to delete server for node %q: %w", node.Name, err)
-			}
-
-			waitGroup.Done()
-		}(node)
-	}
-	waitGroup.Wait()
-	close(errsCh)
+	// Servers are deleted in batches, so large scale downs don't exceed the
+	// rate limit of the DataCrunch API.
+	batchSize := n.manager.deleteMaxInFlight
+	for start := 0; start < len(nodes); start += batchSize {
+		if start > 0 && n.manager.deleteBatchDelay > 0 {
+			time.Sleep(n.manager.deleteBatchDelay)
+		}
+		batch := nodes[start:min(start+batchSize, len(nodes))]
+
+		waitGroup := sync.WaitGroup{}
+		errsCh := make(chan error, len(batch))
+		for _, node := range batch {
+			waitGroup.Add(1)
+			go func(node *apiv1.Node) {
+				defer waitGroup.Done()
+				klog.V(2).Infof("%v Deleting server of node %s", n.logFields(), node.Name)
+
+				err := n.manager.deleteByNode(node)
+				if errors.Is(err, errServerNotFound) && n.spot {
+					// Spot servers can be interrupted by DataCrunch at any time,
+					// there is nothing left to delete.
+					klog.Infof("%v Server of node %s in spot node group is already gone, it was probably interrupted", n.logFields(), node.Name)
+					err = nil
+				}
+				if err != nil {
+					errsCh <- fmt.Errorf("failed to delete server for node %q: %w", node.Name, err)
+				}
+			}(node)
+		}
+		waitGroup.Wait()
+		close(errsCh)
 
-	errs := make([]error, 0, len(nodes))
-	for err := range errsCh {
-		errs = append(errs, err)
+		for err := range errsCh {
+			errs = append(errs, err)
+		}
 	}
 	if len(errs) > 0 {
 		return fmt.Errorf("failed to delete all nodes: %w", errors.Join(errs...))
@@ -305,6 +664,34 @@ func (n *datacrunchNodeGroup) DeleteNodes(nodes []*apiv1.Node) error {
 	return nil
 }
 
+// verifyOwnership returns an error if one of the nodes belongs to another node
+// group, so no server is deleted by a scale down mixing up node groups. The
+// node group of a node is the one of its server, or its node group label if
+// the server is gone.
+func (n *datacrunchNodeGroup) verifyOwnership(nodes []*apiv1.Node) error {
+	var errs []error
+	for _, node := range nodes {
+		server, err := n.manager.serverForNode(node)
+		if err != nil {
+			return fmt.Errorf("refusing to delete nodes of node group %s, failed to get the server of node %s: %v", n.id, node.Name, err)
+		}
+		owner := node.Labels[nodeGroupLabel]
+		if server != nil {
+			owner = n.manager.nodeGroupIDForServer(server)
+		}
+		switch {
+		case server != nil && owner == "":
+			errs = append(errs, fmt.Errorf("server %s of node %s %w", server.ID, node.Name, errNotInNodeGroup))
+		case owner != "" && owner != n.id:
+			errs = append(errs, fmt.Errorf("node %s %w, it is in node group %s", node.Name, errNotInNodeGroup, owner))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("refusing to delete nodes of node group %s: %w", n.id, errors.Join(errs...))
+	}
+	return nil
+}
+
 // ForceDeleteNodes deletes nodes from the group regardless of constraints.
 func (n *datacrunchNodeGroup) ForceDeleteNodes(nodes []*apiv1.Node) error {
 	return cloudprovider.ErrNotImplemented
@@ -316,7 +703,13 @@ func (n *datacrunchNodeGroup) ForceDeleteNodes(nodes []*apiv1.Node) error {
 // It is assumed that cloud provider will not delete the existing nodes when there
 // is an option to just decrease the target. Implementation required.
 func (n *datacrunchNodeGroup) DecreaseTargetSize(delta int) error {
+	n.clusterUpdateMutex.Lock()
+	defer n.clusterUpdateMutex.Unlock()
+
+	n.sizeMutex.Lock()
+	defer n.sizeMutex.Unlock()
 	n.targetSize = n.targetSize + delta
+	n.sizeGeneration++
 	return nil
 }
 
@@ -334,14 +727,20 @@ func (n *datacrunchNodeGroup) Debug() string {
 // required that Instance objects returned by this method have Id field set.
 // Other fields are optional.
 func (n *datacrunchNodeGroup) Nodes() ([]cloudprovider.Instance, error) {
-	servers, err := n.manager.cachedServers.getServersByNodeGroupName(n.id)
+	servers, err := n.manager.cachedServers.getServersByNodeGroupName(n.id, n.manager.tagKeys)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get servers for datacrunch: %v", err)
 	}
 
 	instances := make([]cloudprovider.Instance, 0, len(servers))
 	for _, vm := range servers {
-		instances = append(instances, toInstance(vm))
+		// warm servers are no nodes until they are released
+		if n.manager.warmPool.isHeld(vm.ID) {
+			continue
+		}
+		instance := toInstance(vm)
+		instance.Status = n.manager.serverStatus(vm)
+		instances = append(instances, instance)
 	}
 
 	return instances, nil
@@ -359,6 +758,10 @@ func (n *datacrunchNodeGroup) TemplateNodeInfo() (*framework.NodeInfo, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create resource list for node group %s error: %v", n.id, err)
 	}
+	allocatable, err := n.allocatableResources(resourceList)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create allocatable resources for node group %s error: %v", n.id, err)
+	}
 
 	nodeName := newNodeName(n)
 
@@ -374,22 +777,11 @@ func (n *datacrunchNodeGroup) TemplateNodeInfo() (*framework.NodeInfo, error) {
 			Conditions: cloudprovider.BuildReadyConditions(),
 		},
 	}
-	node.Status.Allocatable = node.Status.Capacity
+	node.Status.Allocatable = allocatable
 	node.Status.Conditions = cloudprovider.BuildReadyConditions()
 
-	nodeGroupLabels, err := buildNodeGroupLabels(n)
-	if err != nil {
-		return nil, err
-	}
-	node.Labels = cloudprovider.JoinStringMaps(node.Labels, nodeGroupLabels)
-
-	for _, taint := range n.manager.clusterConfig.NodeConfigs[n.id].Taints {
-		node.Spec.Taints = append(node.Spec.Taints, apiv1.Taint{
-			Key:    taint.Key,
-			Value:  taint.Value,
-			Effect: taint.Effect,
-		})
-	}
+	node.Labels = cloudprovider.JoinStringMaps(node.Labels, n.nodeLabels(n.instanceType, resourceList))
+	node.Spec.Taints = n.nodeTaints()
 
 	nodeInfo := framework.NewNodeInfo(&node, nil, &framework.PodInfo{Pod: cloudprovider.BuildKubeProxy(n.id)})
 	return nodeInfo, nil
@@ -399,6 +791,8 @@ func (n *datacrunchNodeGroup) TemplateNodeInfo() (*framework.NodeInfo, error) {
 // Allows to tell the theoretical node group from the real one. Implementation
 // required.
 func (n *datacrunchNodeGroup) Exist() bool {
+	// There are no node groups on the DataCrunch side, the registry of the
+	// manager is the only source of truth.
 	_, exists := n.manager.nodeGroups[n.id]
 	return exists
 }
@@ -406,24 +800,106 @@ func (n *datacrunchNodeGroup) Exist() bool {
 // Create creates the node group on the cloud provider side. Implementation
 // optional.
 func (n *datacrunchNodeGroup) Create() (cloudprovider.NodeGroup, error) {
+	n.clusterUpdateMutex.Lock()
+	defer n.clusterUpdateMutex.Unlock()
+
+	if _, exists := n.manager.nodeGroups[n.id]; exists {
+		return nil, fmt.Errorf("node group %s already exists", n.id)
+	}
+
+	// There are no node groups on the DataCrunch side, servers are assigned to
+	// node groups by their hostname. Registering the node group is sufficient.
+	n.manager.nodeGroupsMutex.Lock()
 	n.manager.nodeGroups[n.id] = n
+	if n.autoprovisioningNodeConfig != nil {
+		n.manager.clusterConfig.NodeConfigs[n.id] = n.autoprovisioningNodeConfig
+	}
+	n.manager.nodeGroupsMutex.Unlock()
+	klog.V(2).Infof("Created node group %s", n.id)
 
-	return n, cloudprovider.ErrNotImplemented
+	return n, nil
 }
 
 // Delete deletes the node group on the cloud provider side.  This will be
 // executed only for autoprovisioned node groups, once their size drops to 0.
 // Implementation optional.
 func (n *datacrunchNodeGroup) Delete() error {
-	// We do not use actual node groups but all nodes within the Datacrunch project are labeled with a group
+	if !n.autoprovisioned {
+		return fmt.Errorf("node group %s is not autoprovisioned and can not be deleted", n.id)
+	}
+
+	n.clusterUpdateMutex.Lock()
+	defer n.clusterUpdateMutex.Unlock()
+
+	// The group is usually empty, servers left behind would not belong to any
+	// node group once it is deregistered.
+	servers, err := n.manager.allServers(n.id)
+	if err != nil {
+		return fmt.Errorf("failed to delete node group %s: %v", n.id, err)
+	}
+	errs := make([]error, 0)
+	for _, server := range servers {
+		if status := toInstanceStatus(server); status != nil && status.State == cloudprovider.InstanceDeleting {
+			continue
+		}
+		klog.Infof("Deleting server %s of node group %s", server.ID, n.id)
+		if err := n.manager.deleteServer(server); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if _, err := n.manager.cachedServers.servers(); err != nil {
+		klog.Errorf("failed to update servers cache: %v", err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to delete servers of node group %s: %w", n.id, errors.Join(errs...))
+	}
+
+	n.manager.nodeGroupsMutex.Lock()
+	delete(n.manager.nodeGroups, n.id)
+	delete(n.manager.clusterConfig.NodeConfigs, n.id)
+	n.manager.nodeGroupsMutex.Unlock()
+	klog.V(2).Infof("Deleted node group %s", n.id)
+
 	return nil
 }
 
+// nodeConfig returns the node config of the node group, or nil if there is
+// none.
+func (n *datacrunchNodeGroup) nodeConfig() *NodeConfig {
+	if n.autoprovisioningNodeConfig != nil {
+		return n.autoprovisioningNodeConfig
+	}
+	return n.manager.nodeConfig(n.id)
+}
+
 // Autoprovisioned returns true if the node group is autoprovisioned. An
 // autoprovisioned group was created by CA and can be deleted when scaled to 0.
 func (n *datacrunchNodeGroup) Autoprovisioned() bool {
-	// All groups are auto provisioned
-	return false
+	return n.autoprovisioned
+}
+
+// instanceOption returns the instance option used to create servers of the
+// node group. Node groups marked as spot in their spec only use spot instances.
+func (n *datacrunchNodeGroup) instanceOption() InstanceOption {
+	// reserved capacity is never spot, nor does it fall back to spot
+	if n.reserved {
+		return InstanceOptionOnDemandOnly
+	}
+	if n.spot {
+		return InstanceOptionSpotOnly
+	}
+	nodeConfig := n.nodeConfig()
+	if nodeConfig == nil {
+		return ""
+	}
+	return nodeConfig.InstanceOption
+}
+
+// prefersSpot returns whether servers of the node group are preferably
+// created on spot capacity.
+func (n *datacrunchNodeGroup) prefersSpot() bool {
+	instanceOption := n.instanceOption()
+	return instanceOption == InstanceOptionSpotOnly || instanceOption == InstanceOptionPreferSpot
 }
 
 func toInstance(vm *datacrunchclient.Instance) cloudprovider.Instance {
@@ -433,8 +909,36 @@ func toInstance(vm *datacrunchclient.Instance) cloudprovider.Instance {
 	}
 }
 
-func toProviderID(nodeID string) string {
-	return fmt.Sprintf("%s%s", providerIDPrefix, nodeID)
+// toProviderID returns the provider ID of the node of a server.
+func toProviderID(serverID string) string {
+	return fmt.Sprintf("%s%s", providerIDPrefix, serverID)
+}
+
+// isDatacrunchProviderID returns whether the provider ID belongs to
+// DataCrunch. The scheme is matched case-insensitively, the ID itself may
+// still be malformed.
+func isDatacrunchProviderID(providerID string) bool {
+	return len(providerID) >= len(providerIDPrefix) && strings.EqualFold(providerID[:len(providerIDPrefix)], providerIDPrefix)
+}
+
+// toServerID returns the ID of the server of a provider ID created by
+// toProviderID. Server IDs are case-sensitive and returned unmodified.
+func toServerID(providerID string) (string, error) {
+	if !isDatacrunchProviderID(providerID) {
+		return "", fmt.Errorf("provider ID %q does not start with %q", providerID, providerIDPrefix)
+	}
+
+	serverID := providerID[len(providerIDPrefix):]
+	if serverID == "" {
+		return "", fmt.Errorf("provider ID %q has no server ID", providerID)
+	}
+	if strings.Contains(serverID, "/") {
+		return "", fmt.Errorf("provider ID %q must not contain a path or region, expected %s<server-id>", providerID, providerIDPrefix)
+	}
+	if strings.ContainsAny(serverID, " \t\r\n") {
+		return "", fmt.Errorf("provider ID %q contains whitespace", providerID)
+	}
+	return serverID, nil
 }
 
 func toInstanceStatus(vm *datacrunchclient.Instance) *cloudprovider.InstanceStatus {
@@ -444,12 +948,24 @@ func toInstanceStatus(vm *datacrunchclient.Instance) *cloudprovider.InstanceStat
 
 	st := &cloudprovider.InstanceStatus{}
 	switch vm.Status {
-	case "provisioning":
+	case "new", "ordered", "validating", "provisioning":
 		st.State = cloudprovider.InstanceCreating
 	case "running":
 		st.State = cloudprovider.InstanceRunning
-	case "offline", "discontinued":
+	case "deleting", "offline", "discontinued":
 		st.State = cloudprovider.InstanceDeleting
+	// Failed servers are reported as creating with error info, so the
+	// autoscaler deletes them and backs off from the node group.
+	case "no_capacity":
+		st.State = cloudprovider.InstanceCreating
+		st.ErrorInfo = instanceErrorInfo(fmt.Errorf("%w: no capacity left for instance type %s in region %s", errOutOfCapacity, vm.InstanceType, vm.Location))
+	case "error", "installation_failed":
+		st.State = cloudprovider.InstanceCreating
+		st.ErrorInfo = &cloudprovider.InstanceErrorInfo{
+			ErrorClass:   cloudprovider.OtherErrorClass,
+			ErrorCode:    strings.ReplaceAll(vm.Status, "_", "-"),
+			ErrorMessage: fmt.Sprintf("server is in status %s", vm.Status),
+		}
 	default:
 		st.ErrorInfo = &cloudprovider.InstanceErrorInfo{
 			ErrorClass:   cloudprovider.OtherErrorClass,
@@ -465,50 +981,563 @@ func newNodeName(n *datacrunchNodeGroup) string {
 	return fmt.Sprintf("%s-%x", n.id, rand.Int63())
 }
 
+// nodeGroupIDForServer returns the id of the node group the server belongs to,
+// or an empty string if the server does not belong to any, see
+// serverTagKeys.nodeGroupIDForServer.
+func (m *datacrunchManager) nodeGroupIDForServer(server *datacrunchclient.Instance) string {
+	return m.tagKeys.nodeGroupIDForServer(server)
+}
+
+// nodeGroupIDForServer returns the id of the node group the server belongs to,
+// or an empty string if the server does not belong to any. Servers tagged
+// with the cluster belong to the node group they are tagged with, so servers
+// created outside of the autoscaler, e.g. replacements created by an
+// operator, are adopted even if their hostname looks generated, e.g. "db-01".
+// Other servers are assigned by their hostname, which newNodeName generates
+// as "<node-group>-<random hex>".
+func (k serverTagKeys) nodeGroupIDForServer(server *datacrunchclient.Instance) string {
+	tags := parseServerTags(server.Description)
+	if tags[k.cluster] != "" && tags[k.nodeGroup] != "" {
+		return tags[k.nodeGroup]
+	}
+	return nodeGroupIDForHostname(server.Hostname)
+}
+
+// nodeGroupIDForHostname returns the node group of a hostname generated by
+// newNodeName, or an empty string if the hostname was not generated.
+func nodeGroupIDForHostname(hostname string) string {
+	idx := strings.LastIndex(hostname, "-")
+	if idx <= 0 {
+		return ""
+	}
+
+	suffix := hostname[idx+1:]
+	if len(suffix) == 0 || len(suffix) > 16 {
+		return ""
+	}
+	for _, c := range suffix {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return ""
+		}
+	}
+
+	return hostname[:idx]
+}
+
 func buildNodeGroupLabels(n *datacrunchNodeGroup) (map[string]string, error) {
 	klog.V(4).Infof("Build node group label for %s", n.id)
 
-	labels := map[string]string{
+	labels := maps.Clone(n.nodeConfig().Labels)
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+
+	// The standard labels take precedence over the labels of the node
+	// config, scheduling constraints on them must match the servers created.
+	// DataCrunch has no zones within a location, every location is a zone
+	// of its own.
+	maps.Copy(labels, map[string]string{
 		apiv1.LabelInstanceType:   n.instanceType,
 		apiv1.LabelTopologyRegion: n.region,
+		apiv1.LabelTopologyZone:   n.region,
 		nodeGroupLabel:            n.id,
-	}
-
-	maps.Copy(labels, n.manager.clusterConfig.NodeConfigs[n.id].Labels)
+	})
 
 	klog.V(4).Infof("%s nodegroup labels: %s", n.id, labels)
 
 	return labels, nil
 }
 
+// nodeLabels returns the labels of the nodes of the node group with servers
+// of the instance type. They are set on the template node and passed to the
+// kubelet of created servers.
+func (n *datacrunchNodeGroup) nodeLabels(instanceType string, resourceList apiv1.ResourceList) map[string]string {
+	labels, _ := buildNodeGroupLabels(n)
+	// Pods selecting GPU nodes must be able to trigger a scale up from zero.
+	if gpus := resourceList[n.gpuResourceName()]; !gpus.IsZero() {
+		labels[GPULabel] = "true"
+		if serverType, err := n.manager.cachedServerType.getServerType(instanceType); err == nil {
+			if model := gpuModelLabelValue(serverType.Model); model != "" {
+				labels[gpuModelLabel] = model
+			}
+		}
+	}
+	return labels
+}
+
+// gpuModelLabelValue returns the GPU model of the catalog as label value, e.g.
+// "h100" for "H100" and "rtx-a6000" for "RTX A6000".
+func gpuModelLabelValue(model string) string {
+	var value strings.Builder
+	for _, r := range strings.ToLower(model) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '_':
+			value.WriteRune(r)
+		case value.Len() > 0 && !strings.HasSuffix(value.String(), "-"):
+			value.WriteByte('-')
+		}
+	}
+	label := value.String()
+	if len(label) > validation.LabelValueMaxLength {
+		label = label[:validation.LabelValueMaxLength]
+	}
+	return strings.Trim(label, "-._")
+}
+
+// nodeTaints returns the taints of the nodes of the node group. They are set
+// on the template node and passed to the kubelet of created servers.
+func (n *datacrunchNodeGroup) nodeTaints() []apiv1.Taint {
+	var taints []apiv1.Taint
+	for _, taint := range n.nodeConfig().Taints {
+		taints = append(taints, apiv1.Taint{
+			Key:    taint.Key,
+			Value:  taint.Value,
+			Effect: taint.Effect,
+		})
+	}
+	return taints
+}
+
+// kubeletRegistrationArgs returns the labels and taints of a node of the node
+// group created in the region in the format of the kubelet flags
+// --node-labels and --register-with-taints, and the flags.
+func (n *datacrunchNodeGroup) kubeletRegistrationArgs(region, instanceType string) (labels, taints, args string, err error) {
+	resourceList, err := machineTypeResourceList(n, instanceType)
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to create resource list for node group %s error: %v", n.id, err)
+	}
+
+	nodeLabels := n.nodeLabels(instanceType, resourceList)
+	// servers may be created in another region than the first one and with
+	// a fallback instance type
+	nodeLabels[apiv1.LabelTopologyRegion] = region
+	nodeLabels[apiv1.LabelTopologyZone] = region
+	nodeLabels[apiv1.LabelInstanceType] = instanceType
+	labelPairs := make([]string, 0, len(nodeLabels))
+	for key, value := range nodeLabels {
+		labelPairs = append(labelPairs, key+"="+value)
+	}
+	sort.Strings(labelPairs)
+	labels = strings.Join(labelPairs, ",")
+
+	nodeTaints := n.nodeTaints()
+	taintSpecs := make([]string, 0, len(nodeTaints))
+	for _, taint := range nodeTaints {
+		spec := taint.Key
+		if taint.Value != "" {
+			spec += "=" + taint.Value
+		}
+		taintSpecs = append(taintSpecs, spec+":"+string(taint.Effect))
+	}
+	taints = strings.Join(taintSpecs, ",")
+
+	args = "--node-labels=" + labels
+	if taints != "" {
+		args += " --register-with-taints=" + taints
+	}
+	if nodeConfig := n.nodeConfig(); nodeConfig != nil && len(nodeConfig.KubeletExtraArgs) > 0 {
+		args += " " + strings.Join(nodeConfig.KubeletExtraArgs, " ")
+	}
+	return labels, taints, args, nil
+}
+
+// serverDescription returns the description of servers created for the node
+// group, it holds the tags of the node group, the node group and the cluster
+// name if set.
+func (n *datacrunchNodeGroup) serverDescription() string {
+	tags := maps.Clone(n.tags)
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	tags[n.manager.tagKeys.nodeGroup] = n.id
+	if n.manager.clusterName != "" {
+		tags[n.manager.tagKeys.cluster] = n.manager.clusterName
+	}
+	return formatServerTags(tags)
+}
+
+// podsPerNode returns the pod capacity of the nodes of the node group.
+func (n *datacrunchNodeGroup) podsPerNode() int {
+	if n.maxPods > 0 {
+		return n.maxPods
+	}
+	return defaultPodAmountsLimit
+}
+
+// gpuResourceName returns the resource name the GPUs of the node group are
+// advertised with.
+func (n *datacrunchNodeGroup) gpuResourceName() apiv1.ResourceName {
+	if nodeConfig := n.nodeConfig(); nodeConfig != nil && nodeConfig.GPUResourceName != "" {
+		return nodeConfig.GPUResourceName
+	}
+	if n.manager.clusterConfig.GPUResourceName != "" {
+		return n.manager.clusterConfig.GPUResourceName
+	}
+	return ResourceGPU
+}
+
 func getMachineTypeResourceList(n *datacrunchNodeGroup) (apiv1.ResourceList, error) {
-	typeInfo, err := n.manager.cachedServerType.getServerType(n.instanceType)
+	return machineTypeResourceList(n, n.instanceType)
+}
+
+// machineTypeResourceList returns the resources of a server of the node group
+// with the instance type.
+func machineTypeResourceList(n *datacrunchNodeGroup, instanceType string) (apiv1.ResourceList, error) {
+	typeInfo, err := n.manager.cachedServerType.getServerType(instanceType)
 	if err != nil || typeInfo == nil {
-		return nil, fmt.Errorf("failed to get machine type %s info error: %v", n.instanceType, err)
+		return nil, fmt.Errorf("failed to get machine type %s info error: %v", instanceType, err)
 	}
 
-	diskSizeGB := n.manager.clusterConfig.NodeConfigs[n.id].DiskSizeGB
+	diskSizeGB := n.nodeConfig().DiskSizeGB
 
 	numGPUs := typeInfo.GPU.NumberOfGPUs
 
 	// Override the number of GPUs if specified. Useful for MIG mode.
-	if n.manager.clusterConfig.NodeConfigs[n.id].OverrideNumGPUs != nil {
-		numGPUs = *n.manager.clusterConfig.NodeConfigs[n.id].OverrideNumGPUs
+	if n.nodeConfig().OverrideNumGPUs != nil {
+		numGPUs = *n.nodeConfig().OverrideNumGPUs
 	}
 
-	return apiv1.ResourceList{
-		apiv1.ResourcePods:             *resource.NewQuantity(defaultPodAmountsLimit, resource.DecimalSI),
+	resourceList := apiv1.ResourceList{
+		apiv1.ResourcePods:             *resource.NewQuantity(int64(n.podsPerNode()), resource.DecimalSI),
 		apiv1.ResourceCPU:              *resource.NewQuantity(int64(typeInfo.CPU.NumberOfCores), resource.DecimalSI),
-		ResourceGPU:                    *resource.NewQuantity(int64(numGPUs), resource.DecimalSI),
 		apiv1.ResourceMemory:           *resource.NewQuantity(int64(typeInfo.Memory.SizeInGigabytes*1024*1024*1024), resource.DecimalSI),
 		apiv1.ResourceEphemeralStorage: *resource.NewQuantity(int64(diskSizeGB*1024*1024*1024), resource.DecimalSI),
-	}, nil
+	}
+	// the nodes carry no GPU resource, so they get no GPU labels either
+	if !n.manager.gpuDisabled {
+		resourceList[n.gpuResourceName()] = *resource.NewQuantity(int64(numGPUs), resource.DecimalSI)
+	}
+	return resourceList, nil
+}
+
+// allocatableResources returns the capacity of the servers of the node group
+// without the resources reserved by the kubelet, the reserved memory of the
+// node config and the reservations of its kubelet extra args.
+func (n *datacrunchNodeGroup) allocatableResources(capacity apiv1.ResourceList) (apiv1.ResourceList, error) {
+	allocatable := capacity.DeepCopy()
+	nodeConfig := n.nodeConfig()
+	if nodeConfig == nil {
+		return allocatable, nil
+	}
+
+	reservations, err := kubeletReservations(nodeConfig.KubeletExtraArgs)
+	if err != nil {
+		return nil, err
+	}
+	reservedMemory, err := memoryReservation(nodeConfig.ReservedMemory, allocatable[apiv1.ResourceMemory])
+	if err != nil {
+		return nil, err
+	}
+	if !reservedMemory.IsZero() {
+		memory := reservations[apiv1.ResourceMemory]
+		memory.Add(reservedMemory)
+		reservations[apiv1.ResourceMemory] = memory
+	}
+
+	for name, reserved := range reservations {
+		value, found := allocatable[name]
+		if !found {
+			continue
+		}
+		if reserved.Cmp(value) >= 0 {
+			return nil, fmt.Errorf("reserved %s %s exceeds the %s %s of machine type %s", name, reserved.String(), name, value.String(), n.instanceType)
+		}
+		value.Sub(reserved)
+		allocatable[name] = value
+	}
+	return allocatable, nil
+}
+
+// memoryReservation parses the reserved memory of a node config, which is a
+// quantity or a percentage of the memory capacity.
+func memoryReservation(reservation string, capacity resource.Quantity) (resource.Quantity, error) {
+	if reservation == "" {
+		return resource.Quantity{}, nil
+	}
+
+	if percentage, found := strings.CutSuffix(reservation, "%"); found {
+		value, err := strconv.ParseFloat(percentage, 64)
+		if err != nil || value < 0 || value >= 100 {
+			return resource.Quantity{}, fmt.Errorf("%q is not a percentage below 100%%", reservation)
+		}
+		return *resource.NewQuantity(int64(float64(capacity.Value())*value/100), resource.BinarySI), nil
+	}
+
+	quantity, err := resource.ParseQuantity(reservation)
+	if err != nil || quantity.Sign() < 0 {
+		return resource.Quantity{}, fmt.Errorf("%q is not a memory quantity", reservation)
+	}
+	return quantity, nil
+}
+
+// kubeletReservedFlags are the kubelet flags reserving resources for system
+// and kube daemons, their resources are not allocatable by pods.
+var kubeletReservedFlags = []string{"--kube-reserved", "--system-reserved"}
+
+// kubeletReservations sums up the resources reserved by the kubelet args, e.g.
+// "--kube-reserved=cpu=500m,memory=1Gi".
+func kubeletReservations(args []string) (apiv1.ResourceList, error) {
+	reservations := make(apiv1.ResourceList)
+	for _, arg := range args {
+		flag, value, _ := strings.Cut(arg, "=")
+		if !slices.Contains(kubeletReservedFlags, flag) || value == "" {
+			continue
+		}
+		for _, pair := range strings.Split(value, ",") {
+			name, amount, found := strings.Cut(pair, "=")
+			if !found {
+				return nil, fmt.Errorf("%q of %s is not a resource=quantity pair", pair, flag)
+			}
+			quantity, err := resource.ParseQuantity(amount)
+			if err != nil || quantity.Sign() < 0 {
+				return nil, fmt.Errorf("%q of %s is not a resource quantity", amount, flag)
+			}
+			total := reservations[apiv1.ResourceName(name)]
+			total.Add(quantity)
+			reservations[apiv1.ResourceName(name)] = total
+		}
+	}
+	return reservations, nil
+}
+
+// validateKubeletExtraArgs returns an error if a kubelet extra arg is not a
+// flag which can be passed in KUBELET_EXTRA_ARGS of the pre-script. The node
+// labels and taints are set by the autoscaler, they are not overridden.
+func validateKubeletExtraArgs(args []string) error {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "--") || strings.ContainsAny(arg, " \t\n\"'`$\\") {
+			return fmt.Errorf("%q is not a kubelet flag like --flag=value without whitespace and quotes", arg)
+		}
+		flag, _, _ := strings.Cut(arg, "=")
+		if flag == "--node-labels" || flag == "--register-with-taints" {
+			return fmt.Errorf("%s is set by the autoscaler from the labels and taints of the node config", flag)
+		}
+	}
+	_, err := kubeletReservations(args)
+	return err
+}
+
+// checkMaxNodesTotal returns an error if adding delta servers to the node
+// group would exceed the maximum number of servers of all node groups. The
+// target sizes and the warm servers are summed up, released is the number of
+// warm servers which are added to the node group instead of being created.
+// clusterUpdateMutex must be held.
+func (n *datacrunchNodeGroup) checkMaxNodesTotal(delta, released int) error {
+	maxNodesTotal := n.manager.maxNodesTotal
+	if maxNodesTotal == 0 {
+		return nil
+	}
+
+	current := n.manager.warmPool.count()
+	for _, group := range n.manager.nodeGroups {
+		size, _ := group.TargetSize()
+		current += size
+	}
+	if desired := current + delta - released; desired > maxNodesTotal {
+		return fmt.Errorf("increasing node group %s by %d would exceed the maximum number of nodes of all node groups: current %d, desired %d, max %d", n.id, delta, current, desired, maxNodesTotal)
+	}
+	return nil
+}
+
+// checkResourceLimits returns an error if creating delta servers in the node
+// group would exceed the maximum cores, memory or GPUs of the resource
+// limiter. Servers of all node groups are taken into account.
+func (n *datacrunchNodeGroup) checkResourceLimits(delta int) error {
+	limiter := n.manager.resourceLimiter
+	if limiter == nil {
+		return nil
+	}
+
+	increase, err := n.limitedResources(n.instanceType)
+	if err != nil {
+		return fmt.Errorf("failed to check resource limits of node group %s: %v", n.id, err)
+	}
+
+	usage := apiv1.ResourceList{}
+	for _, group := range n.manager.nodeGroups {
+		servers, err := n.manager.allServers(group.id)
+		if err != nil {
+			return fmt.Errorf("failed to check resource limits of node group %s: %v", n.id, err)
+		}
+		// servers are counted with their own instance type, which is a
+		// fallback instance type of the node group for some
+		for _, server := range servers {
+			instanceType := group.instanceType
+			if slices.Contains(group.fallbackInstanceTypes, server.InstanceType) {
+				instanceType = server.InstanceType
+			}
+			resources, err := group.limitedResources(instanceType)
+			if err != nil {
+				return fmt.Errorf("failed to check resource limits of node group %s: %v", n.id, err)
+			}
+			addResources(usage, resources, 1)
+		}
+	}
+
+	limits := map[string]apiv1.ResourceName{
+		cloudprovider.ResourceNameCores:  apiv1.ResourceCPU,
+		cloudprovider.ResourceNameMemory: apiv1.ResourceMemory,
+		string(ResourceGPU):              ResourceGPU,
+	}
+	for limit, resourceName := range limits {
+		if !limiter.HasMaxLimitSet(limit) {
+			continue
+		}
+		current := usage[resourceName]
+		perServer := increase[resourceName]
+		desired := current.Value() + perServer.Value()*int64(delta)
+		if desired > limiter.GetMax(limit) {
+			return fmt.Errorf("increasing node group %s by %d would exceed the %s limit: current %d, desired %d, max %d", n.id, delta, limit, current.Value(), desired, limiter.GetMax(limit))
+		}
+	}
+
+	return nil
+}
+
+// limitedResources returns the resources of a server of the node group with
+// the instance type, the GPUs are counted as nvidia.com/gpu whatever their
+// resource name, so the GPU limit covers the GPUs of all node groups.
+func (n *datacrunchNodeGroup) limitedResources(instanceType string) (apiv1.ResourceList, error) {
+	resources, err := machineTypeResourceList(n, instanceType)
+	if err != nil {
+		return nil, err
+	}
+	if name := n.gpuResourceName(); name != ResourceGPU {
+		resources[ResourceGPU] = resources[name]
+		delete(resources, name)
+	}
+	return resources, nil
+}
+
+// addResources adds count times the resources to total.
+func addResources(total apiv1.ResourceList, resources apiv1.ResourceList, count int) {
+	for name, quantity := range resources {
+		sum := total[name]
+		sum.Add(*resource.NewQuantity(quantity.Value()*int64(count), quantity.Format))
+		total[name] = sum
+	}
 }
 
 func serverTypeAvailable(manager *datacrunchManager, instanceType string, region string, isSpot bool) (bool, error) {
 	return manager.cachedServerType.GetInstanceTypeAvailabilityCached(instanceType, region, isSpot)
 }
 
+// serverTypeAvailableInRegion returns whether servers of the node group with
+// the instance type can be created in the region with the given instance
+// option.
+func serverTypeAvailableInRegion(n *datacrunchNodeGroup, instanceType, region string, instanceOption InstanceOption) (bool, error) {
+	var available bool
+	var err error
+
+	switch instanceOption {
+	case InstanceOptionPreferSpot:
+		available, err = serverTypeAvailable(n.manager, instanceType, region, true)
+		if err != nil {
+			klog.V(4).Infof("Failed to check if server type %s is available in region %s with isSpot %t, trying to check with isSpot %t", instanceType, region, true, false)
+		}
+		if !available {
+			available, err = serverTypeAvailable(n.manager, instanceType, region, false)
+			if err != nil {
+				klog.V(4).Infof("Failed to check if server type %s is available in region %s with isSpot %t, trying to check with isSpot %t", instanceType, region, false, true)
+			}
+		}
+	case InstanceOptionPreferOnDemand:
+		available, err = serverTypeAvailable(n.manager, instanceType, region, false)
+		if err != nil {
+			klog.V(4).Infof("Failed to check if server type %s is available in region %s with isSpot %t, trying to check with isSpot %t", instanceType, region, false, true)
+		}
+		if !available {
+			available, err = serverTypeAvailable(n.manager, instanceType, region, true)
+			if err != nil {
+				klog.V(4).Infof("Failed to check if server type %s is available in region %s with isSpot %t, trying to check with isSpot %t", instanceType, region, true, false)
+			}
+		}
+	case InstanceOptionSpotOnly:
+		available, err = serverTypeAvailable(n.manager, instanceType, region, true)
+	case InstanceOptionOnDemandOnly:
+		available, err = serverTypeAvailable(n.manager, instanceType, region, false)
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("failed to check if server type %s is available in region %s with instance option %s: %v", instanceType, region, instanceOption, err)
+	}
+
+	return available, nil
+}
+
+// serverPlacement is an instance type and region to create a server in.
+type serverPlacement struct {
+	instanceType string
+	region       string
+}
+
+// availablePlacements returns the instance types and regions of the node
+// group which are available with the given instance option, in order of
+// preference. All regions of an instance type are preferred over the next
+// instance type.
+func (n *datacrunchNodeGroup) availablePlacements(instanceOption InstanceOption) ([]serverPlacement, error) {
+	placements := n.allPlacements()
+	// The availability does not cover reserved capacity, creating a server
+	// fails with an out of capacity error if the reservation is exhausted.
+	if n.reserved {
+		return placements, nil
+	}
+	available := make([]serverPlacement, 0, len(placements))
+	var errs []error
+	for _, placement := range placements {
+		if remaining := n.manager.capacityPenalties.penalized(n.capacityKey(placement)); remaining > 0 {
+			klog.V(4).Infof("Server type %s in region %s recently ran out of capacity, skipping it for %s", placement.instanceType, placement.region, remaining.Round(time.Second))
+			continue
+		}
+		ok, err := serverTypeAvailableInRegion(n, placement.instanceType, placement.region, instanceOption)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !ok {
+			klog.V(4).Infof("Server type %s not available in region %s with instance option %s", placement.instanceType, placement.region, instanceOption)
+			continue
+		}
+		available = append(available, placement)
+	}
+
+	if len(available) == 0 {
+		if len(errs) > 0 {
+			return nil, errors.Join(errs...)
+		}
+		return nil, fmt.Errorf("%w: server type %s not available in region %s with instance option %s", errOutOfCapacity, strings.Join(n.instanceTypes(), ","), strings.Join(n.allRegions(), ","), instanceOption)
+	}
+
+	return available, nil
+}
+
+// allPlacements returns the instance types and regions servers of the node
+// group are created in, in order of preference.
+func (n *datacrunchNodeGroup) allPlacements() []serverPlacement {
+	var placements []serverPlacement
+	for _, instanceType := range n.instanceTypes() {
+		for _, region := range n.allRegions() {
+			placements = append(placements, serverPlacement{instanceType: instanceType, region: region})
+		}
+	}
+	return placements
+}
+
+// instanceTypes returns the instance types of the node group, in order of
+// preference.
+func (n *datacrunchNodeGroup) instanceTypes() []string {
+	return append([]string{n.instanceType}, n.fallbackInstanceTypes...)
+}
+
+// allRegions returns the regions servers of the node group are created in, in
+// order of preference.
+func (n *datacrunchNodeGroup) allRegions() []string {
+	if len(n.regions) == 0 {
+		return []string{n.region}
+	}
+	return n.regions
+}
+
 func processPreScriptTemplate(preScriptTemplate string, data interface{}) (string, error) {
 	if preScriptTemplate == "" {
 		return "", nil
@@ -527,17 +1556,35 @@ func processPreScriptTemplate(preScriptTemplate string, data interface{}) (strin
 	return script.String(), nil
 }
 
-func buildPreScript(scriptName string, nodeName string) (string, error) {
-	// Get credentials from environment
+func buildPreScript(n *datacrunchNodeGroup, region, instanceType, scriptName, nodeName, holdScriptName string) (string, error) {
+	// Get credentials from environment, the secret file is read again to
+	// pick up rotated secrets
 	clientID := os.Getenv("DATACRUNCH_CLIENT_ID")
-	clientSecret := os.Getenv("DATACRUNCH_CLIENT_SECRET")
+	if clientID == "" {
+		return "", fmt.Errorf("DATACRUNCH_CLIENT_ID must be set")
+	}
+	secret, err := clientSecretFromEnv()
+	if err != nil {
+		return "", err
+	}
+	clientSecret, err := secret()
+	if err != nil {
+		return "", err
+	}
 
-	if clientID == "" || clientSecret == "" {
-		return "", fmt.Errorf("DATACRUNCH_CLIENT_ID and DATACRUNCH_CLIENT_SECRET must be set")
+	nodeLabels, nodeTaints, kubeletArgs, err := n.kubeletRegistrationArgs(region, instanceType)
+	if err != nil {
+		return "", err
 	}
 
 	deleteScriptsAfterBoot := (strings.ToLower(os.Getenv("DATACRUNCH_DELETE_SCRIPTS_AFTER_BOOT")) == "true")
 
+	// the command is encoded, so it may contain quotes
+	validationCommand := ""
+	if command := n.nodeConfig().ValidationCommand; command != "" {
+		validationCommand = base64.StdEncoding.EncodeToString([]byte(command))
+	}
+
 	// Prepare template data
 	templateData := map[string]string{
 		"DATACRUNCH_CLIENT_ID":     clientID,
@@ -545,13 +1592,18 @@ func buildPreScript(scriptName string, nodeName string) (string, error) {
 		"SCRIPT_NAME":              scriptName,
 		"DELETE_SCRIPT":            fmt.Sprintf("%t", deleteScriptsAfterBoot),
 		"NODENAME":                 nodeName,
+		"NODE_LABELS":              nodeLabels,
+		"NODE_TAINTS":              nodeTaints,
+		"KUBELET_EXTRA_ARGS":       kubeletArgs,
+		"WARM_POOL_SCRIPT":         holdScriptName,
+		"VALIDATION_COMMAND":       validationCommand,
 	}
 
 	// Process the template
 	return processPreScriptTemplate(preStartupScriptTemplate, templateData)
 }
 
-func deployInstance(client *datacrunchclient.Client, deployReq datacrunchclient.DeployInstanceRequest, instanceOption InstanceOption, pricingOption *PricingOption) error {
+func deployInstance(m *datacrunchManager, deployReq datacrunchclient.DeployInstanceRequest, instanceOption InstanceOption, pricingOption *PricingOption) (string, error) {
 	// initial deploy request
 	switch instanceOption {
 	case InstanceOptionSpotOnly:
@@ -577,82 +1629,123 @@ func deployInstance(client *datacrunchclient.Client, deployReq datacrunchclient.
 	}
 
 	klog.V(4).Infof("Trying to deploy instance %+v", deployReq)
-	_, err := client.DeployInstance(deployReq)
+	ctx, cancel := m.apiContext()
+	id, err := m.client.DeployInstance(ctx, deployReq)
+	cancel()
 
 	if err != nil {
-		if strings.Contains(err.Error(), "Not enough resources to deploy") && (instanceOption == InstanceOptionPreferSpot || instanceOption == InstanceOptionPreferOnDemand) {
+		if isOutOfCapacityError(err) && (instanceOption == InstanceOptionPreferSpot || instanceOption == InstanceOptionPreferOnDemand) {
 			if instanceOption == InstanceOptionPreferSpot {
 				klog.V(4).Infof("Got error: %v, not enough resources to deploy instance %+v, trying to deploy instance with on_demand instead", err, deployReq)
-				return deployInstance(client, deployReq, InstanceOptionOnDemandOnly, pricingOption)
+				return deployInstance(m, deployReq, InstanceOptionOnDemandOnly, pricingOption)
 			}
 
 			klog.V(4).Infof("Got error: %v, not enough resources to deploy instance %+v, trying to deploy instance with spot instead", err, deployReq)
-			return deployInstance(client, deployReq, InstanceOptionSpotOnly, pricingOption)
+			return deployInstance(m, deployReq, InstanceOptionSpotOnly, pricingOption)
 		}
 
-		return fmt.Errorf("could not create instance type %s in region %s: %v", deployReq.InstanceType, deployReq.LocationCode, err)
+		return "", fmt.Errorf("could not create instance type %s in region %s: %w", deployReq.InstanceType, deployReq.LocationCode, err)
 	}
-	return nil
+	return id, nil
 }
 
-func createServer(n *datacrunchNodeGroup) error {
-	typeInfo, err := n.manager.cachedServerType.getServerType(n.instanceType)
-	if err != nil {
-		return err
+// startupScript returns the startup script of the servers of the node group
+// in the region. The script of the region takes precedence over the script of
+// the node config, which takes precedence over DATACRUNCH_STARTUP_SCRIPT.
+func (n *datacrunchNodeGroup) startupScript(region string) (string, error) {
+	nodeConfig := n.nodeConfig()
+
+	encoded := nodeConfig.StartupScriptBase64
+	for configRegion, script := range nodeConfig.RegionStartupScriptsBase64 {
+		if strings.EqualFold(configRegion, region) {
+			encoded = script
+			break
+		}
+	}
+	if encoded != "" {
+		startupScriptBytes, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return "", fmt.Errorf("failed to decode startup script: %v", err)
+		}
+		return string(startupScriptBytes), nil
 	}
 
-	diskSizeGB := n.manager.clusterConfig.NodeConfigs[n.id].DiskSizeGB
-	image := n.manager.clusterConfig.NodeConfigs[n.id].ImageType
-	sshKeyIDs := n.manager.clusterConfig.NodeConfigs[n.id].SSHKeyIDs
-	instanceOption := n.manager.clusterConfig.NodeConfigs[n.id].InstanceOption
-	// generate node name
-	nodeName := newNodeName(n)
-	startupScriptName := fmt.Sprintf("autoscaler-startup-script-%s", nodeName)
-
-	var startupScriptID string
 	startupScript := os.Getenv("DATACRUNCH_STARTUP_SCRIPT")
 	startupScriptFile := os.Getenv("DATACRUNCH_STARTUP_SCRIPT_FILE")
 	if startupScript == "" && startupScriptFile != "" {
 		startupScriptBytes, err := os.ReadFile(startupScriptFile)
 		if err != nil {
-			return fmt.Errorf("failed to read startup script file: %v", err)
+			return "", fmt.Errorf("failed to read startup script file: %v", err)
 		}
 		startupScript = string(startupScriptBytes)
 	}
+	return startupScript, nil
+}
 
-	if n.manager.clusterConfig.NodeConfigs[n.id].StartupScriptBase64 != "" {
-		startupScriptBytes, err := base64.StdEncoding.DecodeString(n.manager.clusterConfig.NodeConfigs[n.id].StartupScriptBase64)
-		if err != nil {
-			return fmt.Errorf("failed to decode startup script: %v", err)
-		}
-		startupScript = string(startupScriptBytes)
+// createServer creates a new server for the node group in the region with a
+// generated hostname and returns its ID.
+func createServer(n *datacrunchNodeGroup, region, instanceType string) (string, error) {
+	return createNamedServer(n, region, instanceType, newNodeName(n), false)
+}
+
+// createNamedServer creates a server of the node group with the hostname.
+// The node of a warm server does not register until its hold script is
+// deleted.
+func createNamedServer(n *datacrunchNodeGroup, region, instanceType, nodeName string, warm bool) (string, error) {
+	typeInfo, err := n.manager.cachedServerType.getServerType(instanceType)
+	if err != nil {
+		return "", err
+	}
+
+	diskSizeGB := n.nodeConfig().DiskSizeGB
+	image := n.nodeConfig().ImageType
+	sshKeyIDs := n.nodeConfig().SSHKeyIDs
+	instanceOption := n.instanceOption()
+	startupScriptName := fmt.Sprintf("autoscaler-startup-script-%s", nodeName)
 
+	var startupScriptID string
+	startupScript, err := n.startupScript(region)
+	if err != nil {
+		return "", err
+	}
+
+	if warm && startupScript == "" {
+		return "", errWarmPoolWithoutStartupScript
 	}
 
 	if startupScript != "" {
+		holdScriptName := ""
+		if warm {
+			holdScriptName = warmPoolScriptName(nodeName)
+		}
 		// Build pre-script from template
-		preScript, err := buildPreScript(startupScriptName, nodeName)
+		preScript, err := buildPreScript(n, region, instanceType, startupScriptName, nodeName, holdScriptName)
 		if err != nil {
-			return fmt.Errorf("failed to build pre-script: %v", err)
+			return "", fmt.Errorf("failed to build pre-script: %v", err)
 		}
 
 		// Combine pre-script with user script
 		finalScript := preScript + "\n\n# User startup script starts here\n" + startupScript
 		klog.V(4).Infof("Combined pre-script with user startup script")
 
-		startupScriptID, err = n.manager.client.UploadStartupScript(startupScriptName, finalScript)
+		ctx, cancel := n.manager.apiContext()
+		startupScriptID, err = n.manager.client.UploadStartupScript(ctx, startupScriptName, finalScript)
+		cancel()
 		if err != nil {
-			return fmt.Errorf("failed to upload startup script: %v", err)
+			return "", fmt.Errorf("failed to upload startup script: %w", err)
 		}
 		klog.V(4).Infof("Uploaded startup script defined in cluster config with ID: %s", startupScriptID)
+	} else if n.nodeConfig().StartupScriptID != "" {
+		startupScriptID = n.nodeConfig().StartupScriptID
+		klog.V(4).Infof("Using existing startup script with ID: %s", startupScriptID)
 	}
 
 	deployReq := datacrunchclient.DeployInstanceRequest{
 		InstanceType: typeInfo.InstanceType,
 		Image:        image,
 		Hostname:     nodeName,
-		Description:  n.id,
-		LocationCode: strings.ToUpper(n.region),
+		Description:  n.serverDescription(),
+		LocationCode: strings.ToUpper(region),
 		OSVolume: &datacrunchclient.OSVolume{
 			Name: nodeName, // also use node name as volume name so we can delete the volume later during scale down
 			Size: diskSizeGB,
@@ -660,26 +1753,367 @@ func createServer(n *datacrunchNodeGroup) error {
 		StartupScriptID: startupScriptID,
 		SSHKeyIDs:       sshKeyIDs,
 	}
+	for _, volume := range n.nodeConfig().DataVolumes {
+		deployReq.Volumes = append(deployReq.Volumes, datacrunchclient.DeployVolume{
+			Name: dataVolumeName(nodeName, volume.Name),
+			Size: volume.SizeGB,
+			Type: volume.Type,
+		})
+	}
 
 	// get pricing option
-	pricingOption := n.manager.clusterConfig.NodeConfigs[n.id].PricingOption
+	pricingOption := n.nodeConfig().PricingOption
+	// reserved capacity is paid for by the contract
+	if n.reserved {
+		deployReq.Contract = reservedContract
+		pricingOption = nil
+	}
 
 	// deploy instance
-	err = deployInstance(n.manager.client, deployReq, instanceOption, pricingOption)
+	id, err := deployInstance(n.manager, deployReq, instanceOption, pricingOption)
 	if err != nil {
-		return fmt.Errorf("could not create instance type %s in region %s: %v", n.instanceType, n.region, err)
+		err = fmt.Errorf("could not create instance type %s in region %s: %w", instanceType, region, err)
+		if n.manager.apiCallContext.Err() != nil {
+			uploadedScriptID := ""
+			if startupScript != "" {
+				uploadedScriptID = startupScriptID
+			}
+			n.manager.settleCancelledCreate(n, region, nodeName, uploadedScriptID)
+		}
+		return "", err
 	}
 
-	return nil
+	return id, nil
 }
 
 func (n *datacrunchNodeGroup) resetTargetSize(expectedDelta int) {
 	servers, err := n.manager.allServers(n.id)
+
+	n.sizeMutex.Lock()
+	defer n.sizeMutex.Unlock()
+	n.sizeGeneration++
+	n.recordScaleActivity(expectedDelta)
 	if err != nil {
-		klog.Warningf("failed to set node pool %s size, using delta %d error: %v", n.id, expectedDelta, err)
+		klog.Warningf("%v failed to set node pool size, using delta %d error: %v", n.logFields(), expectedDelta, err)
 		n.targetSize = n.targetSize + expectedDelta
 	} else {
-		klog.Infof("Set node group %s size from %d to %d, expected delta %d", n.id, n.targetSize, len(servers), expectedDelta)
-		n.targetSize = len(servers)
+		activeServers := n.manager.countActiveServers(servers)
+		size := n.clampToMaxSize(activeServers+n.inFlightCreates, activeServers)
+		klog.Infof("%v Set size from %d to %d, expected delta %d", n.logFields(), n.targetSize, size, expectedDelta)
+		n.targetSize = size
+	}
+}
+
+// reconcileTargetSize sets the target size to the number of servers of the
+// node group which are not being deleted. inFlightCreates is the number of
+// servers being created when the servers were listed, servers which were
+// created in the meantime may be counted twice until the next refresh. The
+// servers are not reconciled if a scaling operation set the target size
+// since generation, they were listed before.
+func (n *datacrunchNodeGroup) reconcileTargetSize(servers []*datacrunchclient.Instance, inFlightCreates, generation int) {
+	groupServers := make([]*datacrunchclient.Instance, 0)
+	for _, server := range servers {
+		if n.manager.nodeGroupIDForServer(server) == n.id {
+			groupServers = append(groupServers, server)
+		}
+	}
+	activeServers := n.manager.countActiveServers(groupServers)
+
+	n.sizeMutex.Lock()
+	defer n.sizeMutex.Unlock()
+	if generation != n.sizeGeneration {
+		klog.V(4).Infof("%v Not reconciling size, it was set while the servers were listed", n.logFields())
+		return
+	}
+	size := n.clampToMaxSize(activeServers+inFlightCreates, activeServers)
+	if size != n.targetSize {
+		klog.Infof("%v Reconciled size from %d to %d, %d servers being created", n.logFields(), n.targetSize, size, inFlightCreates)
+	}
+	n.targetSize = size
+}
+
+// resumeTargetSize sets the target size of a new node group from its servers
+// listed at startup. The servers still being created by a previous run of
+// the autoscaler, e.g. in status provisioning, are part of its scale up and
+// counted like running servers, so the scale up is not fired again. Servers
+// being deleted and warm servers are not counted.
+func (n *datacrunchNodeGroup) resumeTargetSize(servers []*datacrunchclient.Instance) {
+	running, creating := 0, 0
+	for _, server := range servers {
+		if n.manager.warmPool.isHeld(server.ID) {
+			continue
+		}
+		status := n.manager.serverStatus(server)
+		switch {
+		case status != nil && status.State == cloudprovider.InstanceDeleting:
+			// not counted
+		case status != nil && status.State == cloudprovider.InstanceCreating:
+			creating++
+		default:
+			running++
+		}
+	}
+
+	n.sizeMutex.Lock()
+	defer n.sizeMutex.Unlock()
+	n.targetSize = n.clampToMaxSize(running+creating, running+creating)
+	if creating > 0 {
+		klog.Infof("%v Resumed size %d from %d running servers and %d servers being created", n.logFields(), n.targetSize, running, creating)
+	}
+}
+
+// clampToMaxSize returns the size capped at the max size of the node group.
+// Servers above the max size were created outside of the autoscaler, they are
+// not deleted but reported whenever their number changes. sizeMutex must be
+// held.
+func (n *datacrunchNodeGroup) clampToMaxSize(size, activeServers int) int {
+	excess := max(activeServers-n.maxSize, 0)
+	if excess > 0 && excess != n.excessServers {
+		klog.Warningf("Node group %s has %d servers, %d more than its max size %d. They were probably created outside of the autoscaler and are not deleted, the target size is capped at the max size", n.id, activeServers, excess, n.maxSize)
+	}
+	n.excessServers = excess
+	return min(size, n.maxSize)
+}
+
+// recordScaleActivity records the time of a scale up by a positive delta or
+// a scale down by a negative delta. sizeMutex must be held.
+func (n *datacrunchNodeGroup) recordScaleActivity(delta int) {
+	switch {
+	case delta > 0:
+		n.lastScaleUpTime = time.Now()
+	case delta < 0:
+		n.lastScaleDownTime = time.Now()
+	}
+}
+
+func (n *datacrunchNodeGroup) addInFlightCreates(delta int) {
+	n.sizeMutex.Lock()
+	defer n.sizeMutex.Unlock()
+	n.inFlightCreates += delta
+}
+
+// sizeSnapshot returns the number of servers being created and the size
+// generation, they are taken before listing the servers to reconcile the
+// target size with.
+func (n *datacrunchNodeGroup) sizeSnapshot() (inFlightCreates, generation int) {
+	n.sizeMutex.Lock()
+	defer n.sizeMutex.Unlock()
+	return n.inFlightCreates, n.sizeGeneration
+}
+
+// checkBackoff returns an error if the node group is backing off after
+// failed scale ups.
+func (n *datacrunchNodeGroup) checkBackoff() error {
+	n.sizeMutex.Lock()
+	defer n.sizeMutex.Unlock()
+	if remaining := time.Until(n.backoffUntil); remaining > 0 {
+		return fmt.Errorf("node group %s is in backoff for %s after %d failed scale ups", n.id, remaining.Round(time.Second), n.scaleUpFailures)
+	}
+	return nil
+}
+
+// recordScaleUp updates the backoff state after a scale up. The node group
+// backs off if no server was created, the first created server resets the
+// backoff.
+func (n *datacrunchNodeGroup) recordScaleUp(success bool) {
+	if success {
+		n.resetBackoff()
+		return
+	}
+	n.sizeMutex.Lock()
+	defer n.sizeMutex.Unlock()
+	n.scaleUpFailures++
+	n.backoffUntil = time.Now().Add(n.manager.scaleUpBackoff)
+	klog.Warningf("Scale up of node group %s failed %d times in a row, backing off for %s", n.id, n.scaleUpFailures, n.manager.scaleUpBackoff)
+}
+
+// recordError records the error of a scale up or down, nil is ignored.
+func (n *datacrunchNodeGroup) recordError(err error) {
+	if err == nil {
+		return
+	}
+	n.sizeMutex.Lock()
+	defer n.sizeMutex.Unlock()
+	n.lastError = err
+	n.lastErrorTime = time.Now()
+}
+
+// resetBackoff clears the backoff state of the node group.
+func (n *datacrunchNodeGroup) resetBackoff() {
+	n.sizeMutex.Lock()
+	defer n.sizeMutex.Unlock()
+	n.scaleUpFailures = 0
+	n.backoffUntil = time.Time{}
+}
+
+// checkServerTypes warns about the servers of the node group whose instance
+// type is none of its instance types, e.g. because they were resized outside
+// of the autoscaler. Their capacity differs from the template node, they are
+// still counted and deleted as servers of the node group. A server is only
+// warned about again if its instance type changes.
+func (n *datacrunchNodeGroup) checkServerTypes(servers []*datacrunchclient.Instance) {
+	instanceTypes := n.instanceTypes()
+	mismatched := make(map[string]string)
+	for _, server := range servers {
+		if n.manager.nodeGroupIDForServer(server) != n.id || server.InstanceType == "" {
+			continue
+		}
+		if slices.ContainsFunc(instanceTypes, func(instanceType string) bool {
+			return strings.EqualFold(instanceType, server.InstanceType)
+		}) {
+			continue
+		}
+		mismatched[server.ID] = server.InstanceType
+		if n.mismatchedServers[server.ID] != server.InstanceType {
+			klog.Warningf("%v Server %s has instance type %s, which is none of the instance types %v of the node group. Its node does not match the template node of the node group", logFields{nodeGroup: n.id, region: server.Location, server: server.ID}, server.Hostname, server.InstanceType, instanceTypes)
+		}
+	}
+	n.mismatchedServers = mismatched
+}
+
+// checkInstanceType marks the node group as not scalable if its instance
+// type is no longer in the catalog, e.g. because DataCrunch deprecated it.
+func (n *datacrunchNodeGroup) checkInstanceType() {
+	_, err := n.manager.cachedServerType.getServerType(n.instanceType)
+	switch {
+	case errors.Is(err, errServerTypeNotFound):
+		if !n.instanceTypeMissing.Swap(true) {
+			klog.Warningf("instance type %s of node group %s is no longer available in the DataCrunch catalog, the node group will not be scaled up", n.instanceType, n.id)
+		}
+	case err != nil:
+		// keep the previous state if the catalog can not be fetched
+		klog.Warningf("failed to check instance type %s of node group %s: %v", n.instanceType, n.id, err)
+	default:
+		if n.instanceTypeMissing.Swap(false) {
+			klog.Infof("instance type %s of node group %s is available again", n.instanceType, n.id)
+		}
+	}
+}
+
+// serversLeftAfterDelete returns the servers which do not belong to any of
+// the nodes, matched by provider ID or by hostname.
+func serversLeftAfterDelete(servers []*datacrunchclient.Instance, nodes []*apiv1.Node) []*datacrunchclient.Instance {
+	deleted := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		if serverID, err := toServerID(node.Spec.ProviderID); err == nil {
+			deleted[serverID] = true
+		}
+		deleted[node.Name] = true
+	}
+
+	left := make([]*datacrunchclient.Instance, 0, len(servers))
+	for _, server := range servers {
+		if !deleted[server.ID] && !deleted[server.Hostname] {
+			left = append(left, server)
+		}
+	}
+	return left
+}
+
+// countActiveServers returns the number of servers which are not being
+// deleted.
+func (m *datacrunchManager) countActiveServers(servers []*datacrunchclient.Instance) int {
+	count := 0
+	for _, server := range servers {
+		if m.warmPool.isHeld(server.ID) {
+			continue
+		}
+		status := m.serverStatus(server)
+		if status != nil && status.State == cloudprovider.InstanceDeleting {
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+// serverStatus returns the status of the server. Servers deleted by the
+// autoscaler are reported as deleting, even if the API still lists them as
+// running. Running servers whose node did not register yet are reported as
+// creating while they boot, so the autoscaler waits for them. Servers which
+// failed their validation command are reported as failed.
+func (m *datacrunchManager) serverStatus(server *datacrunchclient.Instance) *cloudprovider.InstanceStatus {
+	if m.deletingServers.contains(server.ID) {
+		return &cloudprovider.InstanceStatus{State: cloudprovider.InstanceDeleting}
+	}
+	if m.failedValidation(server) {
+		return &cloudprovider.InstanceStatus{
+			State: cloudprovider.InstanceCreating,
+			ErrorInfo: &cloudprovider.InstanceErrorInfo{
+				ErrorClass:   cloudprovider.OtherErrorClass,
+				ErrorCode:    "validation-failed",
+				ErrorMessage: "server shut down before its node registered, its validation command failed",
+			},
+		}
+	}
+	status := toInstanceStatus(server)
+	if status != nil && status.State == cloudprovider.InstanceRunning && m.isBooting(server) {
+		status.State = cloudprovider.InstanceCreating
+	}
+	return status
+}
+
+// failedValidation returns whether the server shut down before its node
+// registered, which the pre-script does if the validation command of the node
+// group fails.
+func (m *datacrunchManager) failedValidation(server *datacrunchclient.Instance) bool {
+	if server.Status != "offline" || m.orphans.isRegistered(server.ID) {
+		return false
+	}
+	nodeConfig := m.nodeConfig(m.nodeGroupIDForServer(server))
+	return nodeConfig != nil && nodeConfig.ValidationCommand != ""
+}
+
+// isBooting returns whether the node of the running server did not register
+// yet and the server was created, or released from a warm pool, within the
+// register timeout of its node group. Servers which exceed the timeout are
+// reported as running, their nodes are missing.
+func (m *datacrunchManager) isBooting(server *datacrunchclient.Instance) bool {
+	if m.orphans.isRegistered(server.ID) {
+		return false
+	}
+	startedAt, err := m.registerStart(server)
+	if err != nil {
+		return false
+	}
+	return m.orphans.clock.Since(startedAt) < m.registerTimeout(m.nodeGroupIDForServer(server))
+}
+
+// deletingServers holds the servers deleted by the autoscaler until they
+// disappear from the server list.
+type deletingServers struct {
+	sync.Mutex
+	ids map[string]bool
+}
+
+func newDeletingServers() *deletingServers {
+	return &deletingServers{ids: make(map[string]bool)}
+}
+
+func (d *deletingServers) add(serverID string) {
+	d.Lock()
+	defer d.Unlock()
+	d.ids[serverID] = true
+}
+
+func (d *deletingServers) contains(serverID string) bool {
+	d.Lock()
+	defer d.Unlock()
+	return d.ids[serverID]
+}
+
+// prune forgets the servers which are no longer listed.
+func (d *deletingServers) prune(servers []*datacrunchclient.Instance) {
+	listed := make(map[string]bool, len(servers))
+	for _, server := range servers {
+		listed[server.ID] = true
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	for id := range d.ids {
+		if !listed[id] {
+			delete(d.ids, id)
+		}
 	}
 }
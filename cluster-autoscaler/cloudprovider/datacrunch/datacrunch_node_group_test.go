@@ -0,0 +1,1323 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+	autoscalerErrors "k8s.io/autoscaler/cluster-autoscaler/utils/errors"
+	"k8s.io/utils/clock"
+	"k8s.io/utils/ptr"
+)
+
+func TestDeleteNodesInterruptedSpotServer(t *testing.T) {
+	servers := []*datacrunchclient.Instance{
+		{ID: "id1", Hostname: "spot-pool-1a", Status: "running"},
+	}
+	manager := newTestManager(t, nil, servers)
+	group := newTestNodeGroup(manager, "spot-pool", 0, 3)
+	group.targetSize = 2
+
+	nodes := []*apiv1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "spot-pool-1a"}, Spec: apiv1.NodeSpec{ProviderID: "datacrunch://id1"}},
+		// the server of this node was interrupted and is already gone
+		{ObjectMeta: metav1.ObjectMeta{Name: "spot-pool-2b"}, Spec: apiv1.NodeSpec{ProviderID: "datacrunch://id2"}},
+	}
+
+	require.Error(t, group.DeleteNodes(nodes[1:]), "on-demand node groups must report missing servers")
+
+	group.spot = true
+	group.targetSize = 2
+	require.NoError(t, group.DeleteNodes(nodes))
+	assert.Equal(t, []string{"id1"}, fakeClientOf(manager).deleted)
+	assert.Equal(t, 0, group.targetSize)
+}
+
+func TestDeleteNodesBatches(t *testing.T) {
+	var servers []*datacrunchclient.Instance
+	var nodes []*apiv1.Node
+	for i := 0; i < 15; i++ {
+		id := fmt.Sprintf("id%d", i)
+		hostname := fmt.Sprintf("pool-%d", i)
+		// the servers of two nodes are missing
+		if i%7 != 3 {
+			servers = append(servers, &datacrunchclient.Instance{ID: id, Hostname: hostname, Status: "running"})
+		}
+		nodes = append(nodes, &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: hostname}, Spec: apiv1.NodeSpec{ProviderID: toProviderID(id)}})
+	}
+	manager := newTestManager(t, nil, servers)
+	manager.deleteMaxInFlight = 4
+	manager.deleteBatchDelay = time.Millisecond
+	group := newTestNodeGroup(manager, "pool", 0, 20)
+	group.targetSize = 15
+	client := fakeClientOf(manager)
+
+	var inFlight, maxInFlight atomic.Int32
+	client.onDelete = func(id string) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			previous := maxInFlight.Load()
+			if current <= previous || maxInFlight.CompareAndSwap(previous, current) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	err := group.DeleteNodes(nodes)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `node "pool-3"`)
+	assert.Contains(t, err.Error(), `node "pool-10"`)
+	assert.Len(t, client.deleted, 13, "all servers are deleted despite the errors")
+	assert.Equal(t, int32(4), maxInFlight.Load())
+	assert.Zero(t, group.targetSize)
+}
+
+func TestDeleteNodesOfOtherNodeGroup(t *testing.T) {
+	servers := []*datacrunchclient.Instance{
+		{ID: "id1", Hostname: "pool-1a", Status: "running"},
+		{ID: "id2", Hostname: "other-2b", Status: "running"},
+		{ID: "id3", Hostname: "unmanaged", Status: "running"},
+	}
+	manager := newTestManager(t, nil, servers)
+	group := newTestNodeGroup(manager, "pool", 0, 3)
+	newTestNodeGroup(manager, "other", 0, 3)
+	group.targetSize = 1
+	client := fakeClientOf(manager)
+
+	own := &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "pool-1a"}, Spec: apiv1.NodeSpec{ProviderID: toProviderID("id1")}}
+	tests := map[string]*apiv1.Node{
+		"server of other node group": {ObjectMeta: metav1.ObjectMeta{Name: "other-2b"}, Spec: apiv1.NodeSpec{ProviderID: toProviderID("id2")}},
+		// the label of the node is not trusted over its server
+		"mislabeled node":                 {ObjectMeta: metav1.ObjectMeta{Name: "other-2b", Labels: map[string]string{nodeGroupLabel: "pool"}}, Spec: apiv1.NodeSpec{ProviderID: toProviderID("id2")}},
+		"unmanaged server":                {ObjectMeta: metav1.ObjectMeta{Name: "unmanaged"}, Spec: apiv1.NodeSpec{ProviderID: toProviderID("id3")}},
+		"gone server of other node group": {ObjectMeta: metav1.ObjectMeta{Name: "other-3c", Labels: map[string]string{nodeGroupLabel: "other"}}, Spec: apiv1.NodeSpec{ProviderID: toProviderID("id4")}},
+	}
+	for name, node := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := group.DeleteNodes([]*apiv1.Node{own, node})
+			require.Error(t, err)
+			assert.ErrorIs(t, err, errNotInNodeGroup)
+			assert.Contains(t, err.Error(), node.Name)
+			assert.Empty(t, client.deleted, "no server is deleted if one node is of another node group")
+			assert.Equal(t, 1, group.targetSize)
+		})
+	}
+
+	require.NoError(t, group.DeleteNodes([]*apiv1.Node{own}))
+	assert.Equal(t, []string{"id1"}, client.deleted)
+}
+
+func TestIncreaseSizeStaggersCreates(t *testing.T) {
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+	manager.createStagger = 20 * time.Millisecond
+	manager.createSemaphores = newRegionSemaphores(10)
+	group := newTestNodeGroup(manager, "pool", 0, 10)
+	client := fakeClientOf(manager)
+
+	var mu sync.Mutex
+	var deployedAt []time.Time
+	client.onDeploy = func(req datacrunchclient.DeployInstanceRequest) {
+		mu.Lock()
+		defer mu.Unlock()
+		deployedAt = append(deployedAt, time.Now())
+	}
+
+	require.NoError(t, group.IncreaseSize(10))
+	require.Len(t, deployedAt, 10)
+	sort.Slice(deployedAt, func(i, j int) bool { return deployedAt[i].Before(deployedAt[j]) })
+	for i := 1; i < len(deployedAt); i++ {
+		// the goroutine of a create may start late, the create i waits at
+		// least i staggers
+		assert.GreaterOrEqual(t, deployedAt[i].Sub(deployedAt[0]), time.Duration(i)*manager.createStagger/2)
+	}
+
+	// the creates are staggered without holding clusterUpdateMutex, and the
+	// pending creates are stopped by Cleanup
+	manager.createStagger = time.Minute
+	other := newTestNodeGroup(manager, "other", 0, 10)
+	other.createTimeout = time.Hour
+	result := make(chan error, 1)
+	go func() { result <- other.IncreaseSize(10) }()
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		started := len(deployedAt) == 11
+		mu.Unlock()
+		if !started || !manager.clusterUpdateMutex.TryLock() {
+			return false
+		}
+		manager.clusterUpdateMutex.Unlock()
+		return true
+	}, time.Second, 5*time.Millisecond)
+	require.NoError(t, manager.Cleanup())
+	err := <-result
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorContains(t, err, "failed to create 9 of 10 servers")
+	assert.Len(t, deployedAt, 11)
+
+	// small scale ups are not staggered
+	assert.Zero(t, group.createStagger(createStaggerAboveDefault))
+	// the creates are spread over at most half the create timeout
+	group.createTimeout = 90 * time.Millisecond
+	stagger := group.createStagger(10)
+	assert.Positive(t, stagger)
+	assert.LessOrEqual(t, time.Duration(float64(9*stagger)*(1+createStaggerJitter)), group.createTimeout/2)
+}
+
+func TestDeleteNodesAlreadyDeleted(t *testing.T) {
+	servers := []*datacrunchclient.Instance{
+		{ID: "id1", Hostname: "pool-1a", Status: "running"},
+		{ID: "id2", Hostname: "pool-2b", Status: "running"},
+	}
+	manager := newTestManager(t, nil, servers)
+	group := newTestNodeGroup(manager, "pool", 0, 3)
+	group.targetSize = 2
+	client := fakeClientOf(manager)
+	// the first delete succeeded, but the autoscaler retries it with the
+	// stale cache, so the API reports the server as not found
+	client.mu.Lock()
+	client.servers = client.servers[1:]
+	client.mu.Unlock()
+
+	node := &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "pool-1a"}, Spec: apiv1.NodeSpec{ProviderID: toProviderID("id1")}}
+	require.NoError(t, group.DeleteNodes([]*apiv1.Node{node}))
+	assert.Empty(t, client.deleted)
+	size, err := group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 1, size)
+}
+
+func TestDeleteNodesBelowMinSize(t *testing.T) {
+	servers := []*datacrunchclient.Instance{
+		{ID: "id1", Hostname: "pool-1a", Status: "running"},
+		{ID: "id2", Hostname: "pool-2b", Status: "running"},
+	}
+	manager := newTestManager(t, nil, servers)
+	group := newTestNodeGroup(manager, "pool", 2, 3)
+	group.targetSize = 2
+	node := &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "pool-1a"}, Spec: apiv1.NodeSpec{ProviderID: "datacrunch://id1"}}
+
+	err := group.DeleteNodes([]*apiv1.Node{node})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "min: 2")
+
+	// a miscounted target size must not allow the delete either
+	group.targetSize = 3
+	err = group.DeleteNodes([]*apiv1.Node{node})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 servers would be left, below min size 2")
+	assert.Empty(t, fakeClientOf(manager).deleted)
+	assert.Equal(t, 3, group.targetSize)
+}
+
+func TestSpotNodeGroupInstanceOption(t *testing.T) {
+	manager := newTestManager(t, nil, nil)
+	group := newTestNodeGroup(manager, "pool", 0, 3)
+	manager.clusterConfig.NodeConfigs["pool"].InstanceOption = InstanceOptionPreferOnDemand
+
+	assert.Equal(t, InstanceOptionPreferOnDemand, group.instanceOption())
+	assert.False(t, group.prefersSpot())
+
+	group.spot = true
+	assert.Equal(t, InstanceOptionSpotOnly, group.instanceOption())
+	assert.True(t, group.prefersSpot())
+}
+
+func TestIncreaseSizeRegionFailover(t *testing.T) {
+	outOfCapacity := func(region string) func(req datacrunchclient.DeployInstanceRequest) error {
+		return func(req datacrunchclient.DeployInstanceRequest) error {
+			if req.LocationCode == region {
+				return &datacrunchclient.APIError{StatusCode: 400, Code: "invalid_request", Message: "Not enough resources to deploy instance"}
+			}
+			return nil
+		}
+	}
+	deployedRegions := func(c *fakeClient) []string {
+		regions := make([]string, 0, len(c.deployed))
+		for _, req := range c.deployed {
+			regions = append(regions, req.LocationCode)
+		}
+		return regions
+	}
+
+	t.Run("primary region exhausted", func(t *testing.T) {
+		manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+		group := newTestNodeGroup(manager, "pool", 0, 3)
+		group.regions = []string{"FIN-01", "ICE-01"}
+		client := fakeClientOf(manager)
+		client.deployErr = outOfCapacity("FIN-01")
+
+		require.NoError(t, group.IncreaseSize(2))
+		assert.Equal(t, 2, group.targetSize)
+		assert.ElementsMatch(t, []string{"FIN-01", "FIN-01", "ICE-01", "ICE-01"}, deployedRegions(client))
+
+		servers, err := manager.allServers("pool")
+		require.NoError(t, err)
+		require.Len(t, servers, 2)
+		for _, server := range servers {
+			assert.Equal(t, "ICE-01", server.Location)
+		}
+	})
+
+	t.Run("primary region unavailable", func(t *testing.T) {
+		manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+		group := newTestNodeGroup(manager, "pool", 0, 3)
+		group.regions = []string{"FIN-01", "ICE-01"}
+		client := fakeClientOf(manager)
+		client.unavailableRegions = map[string]bool{"FIN-01": true}
+
+		require.NoError(t, group.IncreaseSize(1))
+		assert.Equal(t, []string{"ICE-01"}, deployedRegions(client))
+	})
+
+	t.Run("all regions exhausted", func(t *testing.T) {
+		manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+		group := newTestNodeGroup(manager, "pool", 0, 3)
+		group.regions = []string{"FIN-01", "ICE-01"}
+		client := fakeClientOf(manager)
+		client.deployErr = func(req datacrunchclient.DeployInstanceRequest) error {
+			return outOfCapacity(req.LocationCode)(req)
+		}
+
+		require.Error(t, group.IncreaseSize(1))
+		assert.Equal(t, 0, group.targetSize)
+		assert.Equal(t, []string{"FIN-01", "ICE-01"}, deployedRegions(client))
+	})
+
+	t.Run("single region", func(t *testing.T) {
+		manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+		group := newTestNodeGroup(manager, "pool", 0, 3)
+		client := fakeClientOf(manager)
+		client.deployErr = outOfCapacity("FIN-01")
+
+		require.Error(t, group.IncreaseSize(1))
+		assert.Equal(t, []string{"FIN-01"}, deployedRegions(client))
+	})
+}
+
+func TestTemplateNodeInfo(t *testing.T) {
+	serverTypes := []*datacrunchclient.InstanceType{
+		{
+			InstanceType: "1A100.22V",
+			CPU:          datacrunchclient.CPU{NumberOfCores: 22},
+			GPU:          datacrunchclient.GPU{NumberOfGPUs: 1},
+			Memory:       datacrunchclient.Memory{SizeInGigabytes: 120},
+		},
+		{
+			InstanceType: "CPU.4V.16G",
+			CPU:          datacrunchclient.CPU{NumberOfCores: 4},
+			Memory:       datacrunchclient.Memory{SizeInGigabytes: 16},
+		},
+	}
+	manager := newTestManager(t, serverTypes, nil)
+	group := newTestNodeGroup(manager, "gpu-pool", 0, 3)
+	manager.clusterConfig.NodeConfigs["gpu-pool"].Labels = map[string]string{"nodepool": "gpu"}
+	manager.clusterConfig.NodeConfigs["gpu-pool"].Taints = []apiv1.Taint{{Key: "gpu-node", Effect: apiv1.TaintEffectNoSchedule}}
+
+	nodeInfo, err := group.TemplateNodeInfo()
+	require.NoError(t, err)
+	node := nodeInfo.Node()
+
+	allocatable := node.Status.Allocatable
+	assert.Equal(t, int64(22), allocatable.Cpu().Value())
+	assert.Equal(t, int64(120*1024*1024*1024), allocatable.Memory().Value())
+	assert.Equal(t, int64(100*1024*1024*1024), allocatable.StorageEphemeral().Value())
+	assert.Equal(t, int64(defaultPodAmountsLimit), allocatable.Pods().Value())
+	gpus := allocatable[ResourceGPU]
+	assert.Equal(t, int64(1), gpus.Value())
+	assert.Equal(t, node.Status.Capacity, allocatable)
+
+	assert.Equal(t, "true", node.Labels[GPULabel])
+	assert.Equal(t, "gpu-pool", node.Labels[nodeGroupLabel])
+	assert.Equal(t, "1A100.22V", node.Labels[apiv1.LabelInstanceType])
+	assert.Equal(t, "FIN-01", node.Labels[apiv1.LabelTopologyRegion])
+	assert.Equal(t, "FIN-01", node.Labels[apiv1.LabelTopologyZone])
+	assert.Equal(t, "gpu", node.Labels["nodepool"])
+	assert.Equal(t, []apiv1.Taint{{Key: "gpu-node", Effect: apiv1.TaintEffectNoSchedule}}, node.Spec.Taints)
+
+	// MiG setups override the number of GPUs
+	numGPUs := 7
+	manager.clusterConfig.NodeConfigs["gpu-pool"].OverrideNumGPUs = &numGPUs
+	nodeInfo, err = group.TemplateNodeInfo()
+	require.NoError(t, err)
+	gpus = nodeInfo.Node().Status.Allocatable[ResourceGPU]
+	assert.Equal(t, int64(7), gpus.Value())
+
+	cpuGroup := newTestNodeGroup(manager, "cpu-pool", 0, 3)
+	cpuGroup.instanceType = "CPU.4V.16G"
+	nodeInfo, err = cpuGroup.TemplateNodeInfo()
+	require.NoError(t, err)
+	assert.NotContains(t, nodeInfo.Node().Labels, GPULabel)
+
+	group.maxPods = 30
+	nodeInfo, err = group.TemplateNodeInfo()
+	require.NoError(t, err)
+	assert.Equal(t, int64(30), nodeInfo.Node().Status.Allocatable.Pods().Value())
+}
+
+func TestTemplateNodeInfoGPUResourceName(t *testing.T) {
+	serverTypes := []*datacrunchclient.InstanceType{{
+		InstanceType: "1A100.22V",
+		CPU:          datacrunchclient.CPU{NumberOfCores: 22},
+		GPU:          datacrunchclient.GPU{NumberOfGPUs: 2},
+		Memory:       datacrunchclient.Memory{SizeInGigabytes: 120},
+	}}
+	manager := newTestManager(t, serverTypes, nil)
+	manager.clusterConfig.GPUResourceName = "example.com/accelerator"
+	group := newTestNodeGroup(manager, "amd-pool", 0, 3)
+	manager.clusterConfig.NodeConfigs["amd-pool"].GPUResourceName = "amd.com/gpu"
+	defaultGroup := newTestNodeGroup(manager, "custom-pool", 0, 3)
+
+	nodeInfo, err := group.TemplateNodeInfo()
+	require.NoError(t, err)
+	node := nodeInfo.Node()
+	gpus := node.Status.Allocatable["amd.com/gpu"]
+	assert.Equal(t, int64(2), gpus.Value())
+	assert.NotContains(t, node.Status.Allocatable, ResourceGPU)
+	assert.Equal(t, "true", node.Labels[GPULabel])
+
+	// the cluster config sets the default of all node groups
+	nodeInfo, err = defaultGroup.TemplateNodeInfo()
+	require.NoError(t, err)
+	assert.Contains(t, nodeInfo.Node().Status.Allocatable, apiv1.ResourceName("example.com/accelerator"))
+
+	provider, err := newDatacrunchCloudProvider(manager, nil)
+	require.NoError(t, err)
+	gpuConfig := provider.GetNodeGpuConfig(node)
+	require.NotNil(t, gpuConfig)
+	assert.Equal(t, apiv1.ResourceName("amd.com/gpu"), gpuConfig.ResourceName)
+
+	// GPU limits count the GPUs of all resource names
+	manager.resourceLimiter = cloudprovider.NewResourceLimiter(nil, map[string]int64{string(ResourceGPU): 3})
+	err = group.IncreaseSize(2)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), string(ResourceGPU))
+}
+
+func TestTemplateNodeInfoReservedMemory(t *testing.T) {
+	serverTypes := []*datacrunchclient.InstanceType{{
+		InstanceType: "1A100.22V",
+		CPU:          datacrunchclient.CPU{NumberOfCores: 22},
+		Memory:       datacrunchclient.Memory{SizeInGigabytes: 120},
+	}}
+	manager := newTestManager(t, serverTypes, nil)
+	group := newTestNodeGroup(manager, "pool", 0, 3)
+	nodeConfig := manager.clusterConfig.NodeConfigs["pool"]
+	capacity := int64(120 * 1024 * 1024 * 1024)
+
+	tests := []struct {
+		reservedMemory string
+		allocatable    int64
+		err            string
+	}{
+		{reservedMemory: "", allocatable: capacity},
+		{reservedMemory: "8Gi", allocatable: capacity - 8*1024*1024*1024},
+		{reservedMemory: "10%", allocatable: capacity - capacity/10},
+		{reservedMemory: "120Gi", err: "exceeds the memory"},
+		{reservedMemory: "100%", err: "not a percentage below 100%"},
+		{reservedMemory: "lots", err: "not a memory quantity"},
+	}
+	for _, tc := range tests {
+		nodeConfig.ReservedMemory = tc.reservedMemory
+		nodeInfo, err := group.TemplateNodeInfo()
+		if tc.err != "" {
+			require.Error(t, err, tc.reservedMemory)
+			assert.Contains(t, err.Error(), tc.err)
+			continue
+		}
+		require.NoError(t, err, tc.reservedMemory)
+		node := nodeInfo.Node()
+		assert.Equal(t, capacity, node.Status.Capacity.Memory().Value(), tc.reservedMemory)
+		assert.Equal(t, tc.allocatable, node.Status.Allocatable.Memory().Value(), tc.reservedMemory)
+		assert.Equal(t, int64(22), node.Status.Allocatable.Cpu().Value())
+	}
+
+	// invalid reservations are rejected on startup
+	nodeConfig.ReservedMemory = "110%"
+	assert.ErrorContains(t, manager.validateNodeConfigReferences(), "invalid reserved_memory of node config pool")
+}
+
+func TestTemplateNodeInfoKubeletReservations(t *testing.T) {
+	serverTypes := []*datacrunchclient.InstanceType{{
+		InstanceType: "1A100.22V",
+		CPU:          datacrunchclient.CPU{NumberOfCores: 22},
+		Memory:       datacrunchclient.Memory{SizeInGigabytes: 120},
+	}}
+	manager := newTestManager(t, serverTypes, nil)
+	group := newTestNodeGroup(manager, "pool", 0, 3)
+	nodeConfig := manager.clusterConfig.NodeConfigs["pool"]
+	nodeConfig.ReservedMemory = "2Gi"
+	nodeConfig.KubeletExtraArgs = []string{"--max-pods=200", "--kube-reserved=cpu=500m,memory=1Gi", "--system-reserved=cpu=1,memory=512Mi"}
+
+	nodeInfo, err := group.TemplateNodeInfo()
+	require.NoError(t, err)
+	node := nodeInfo.Node()
+	assert.Equal(t, int64(22000), node.Status.Capacity.Cpu().MilliValue())
+	assert.Equal(t, int64(20500), node.Status.Allocatable.Cpu().MilliValue())
+	// the reservations add up with the reserved memory
+	assert.Equal(t, int64(120*1024-2048-1024-512)*1024*1024, node.Status.Allocatable.Memory().Value())
+
+	nodeConfig.KubeletExtraArgs = []string{"--system-reserved=cpu=22"}
+	_, err = group.TemplateNodeInfo()
+	assert.ErrorContains(t, err, "reserved cpu 22 exceeds the cpu 22")
+}
+
+func TestStandardNodeLabels(t *testing.T) {
+	t.Setenv("DATACRUNCH_CLIENT_ID", "client-id")
+	t.Setenv("DATACRUNCH_CLIENT_SECRET", "client-secret")
+
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}, {InstanceType: "2A100.44V"}}, nil)
+	group := newTestNodeGroup(manager, "pool", 0, 3)
+	group.regions = []string{"FIN-01", "ICE-01"}
+	nodeConfig := manager.clusterConfig.NodeConfigs["pool"]
+	// labels of the node config can not override the standard labels
+	nodeConfig.Labels = map[string]string{
+		apiv1.LabelInstanceType: "8H100.80S.176V",
+		nodeGroupLabel:          "other-pool",
+		"team":                  "ml",
+	}
+	nodeConfig.StartupScriptBase64 = base64.StdEncoding.EncodeToString([]byte("kubeadm join"))
+
+	nodeInfo, err := group.TemplateNodeInfo()
+	require.NoError(t, err)
+	labels := nodeInfo.Node().Labels
+	assert.Equal(t, "1A100.22V", labels[apiv1.LabelInstanceType])
+	assert.Equal(t, "FIN-01", labels[apiv1.LabelTopologyRegion])
+	assert.Equal(t, "pool", labels[nodeGroupLabel])
+	assert.Equal(t, "ml", labels["team"])
+
+	// created servers are labeled with their own region and instance type
+	client := fakeClientOf(manager)
+	_, err = createServer(group, "ICE-01", "2A100.44V")
+	require.NoError(t, err)
+	script := client.uploadedScripts["autoscaler-startup-script-"+client.deployed[0].Hostname]
+	assert.Contains(t, script, apiv1.LabelInstanceType+"=2A100.44V")
+	assert.Contains(t, script, apiv1.LabelTopologyRegion+"=ICE-01")
+	assert.Contains(t, script, nodeGroupLabel+"=pool")
+	assert.Contains(t, script, "team=ml")
+}
+
+func TestTemplateNodeInfoGPUModelLabel(t *testing.T) {
+	t.Setenv("DATACRUNCH_CLIENT_ID", "client-id")
+	t.Setenv("DATACRUNCH_CLIENT_SECRET", "client-secret")
+
+	serverTypes := []*datacrunchclient.InstanceType{
+		{InstanceType: "1H100.80S.30V", Model: "H100", GPU: datacrunchclient.GPU{NumberOfGPUs: 1}},
+		{InstanceType: "1A6000.10V", Model: "RTX A6000", GPU: datacrunchclient.GPU{NumberOfGPUs: 1}},
+		{InstanceType: "CPU.4V.16G", Model: "CPU Node"},
+	}
+	manager := newTestManager(t, serverTypes, nil)
+	group := newTestNodeGroup(manager, "pool", 0, 3)
+	group.instanceType = "1H100.80S.30V"
+	group.fallbackInstanceTypes = []string{"1A6000.10V"}
+
+	nodeInfo, err := group.TemplateNodeInfo()
+	require.NoError(t, err)
+	assert.Equal(t, "true", nodeInfo.Node().Labels[GPULabel])
+	assert.Equal(t, "h100", nodeInfo.Node().Labels[gpuModelLabel])
+
+	// created servers get the label of their instance type
+	manager.clusterConfig.NodeConfigs["pool"].StartupScriptBase64 = base64.StdEncoding.EncodeToString([]byte("kubeadm join"))
+	client := fakeClientOf(manager)
+	_, err = createServer(group, "FIN-01", "1A6000.10V")
+	require.NoError(t, err)
+	script := client.uploadedScripts["autoscaler-startup-script-"+client.deployed[0].Hostname]
+	assert.Contains(t, script, gpuModelLabel+"=rtx-a6000")
+
+	// nodes without GPUs have no GPU model
+	group.instanceType = "CPU.4V.16G"
+	nodeInfo, err = group.TemplateNodeInfo()
+	require.NoError(t, err)
+	assert.NotContains(t, nodeInfo.Node().Labels, GPULabel)
+	assert.NotContains(t, nodeInfo.Node().Labels, gpuModelLabel)
+}
+
+func TestTemplateNodeInfoGPUDisabled(t *testing.T) {
+	serverTypes := []*datacrunchclient.InstanceType{
+		{InstanceType: "1H100.80S.30V", Model: "H100", GPU: datacrunchclient.GPU{NumberOfGPUs: 1}},
+	}
+	manager := newTestManager(t, serverTypes, nil)
+	manager.gpuDisabled = true
+	group := newTestNodeGroup(manager, "pool", 0, 3)
+	group.instanceType = "1H100.80S.30V"
+
+	nodeInfo, err := group.TemplateNodeInfo()
+	require.NoError(t, err)
+	node := nodeInfo.Node()
+	assert.NotContains(t, node.Status.Capacity, ResourceGPU)
+	assert.NotContains(t, node.Status.Allocatable, ResourceGPU)
+	assert.NotContains(t, node.Labels, GPULabel)
+	assert.NotContains(t, node.Labels, gpuModelLabel)
+
+	provider, err := newDatacrunchCloudProvider(manager, nil)
+	require.NoError(t, err)
+	assert.Empty(t, provider.GetAvailableGPUTypes())
+	node.Labels[GPULabel] = "true"
+	assert.Nil(t, provider.GetNodeGpuConfig(node))
+}
+
+func TestIncreaseSizeMaxNodesTotal(t *testing.T) {
+	servers := []*datacrunchclient.Instance{
+		{ID: "id1", Hostname: "cpu-pool-1a", Status: "running"},
+		{ID: "id2", Hostname: "cpu-pool-2b", Status: "running"},
+		{ID: "id3", Hostname: "gpu-pool-3c", Status: "running"},
+		{ID: "id4", Hostname: "gpu-pool-4d", Status: "running"},
+	}
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, servers)
+	manager.maxNodesTotal = 5
+	cpuPool := newTestNodeGroup(manager, "cpu-pool", 0, 10)
+	gpuPool := newTestNodeGroup(manager, "gpu-pool", 0, 10)
+	cpuPool.targetSize = 2
+	gpuPool.targetSize = 2
+	client := fakeClientOf(manager)
+
+	require.NoError(t, gpuPool.IncreaseSize(1))
+	require.Len(t, client.deployed, 1)
+
+	// the max size of the node groups is not reached, but the total is
+	err := cpuPool.IncreaseSize(1)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "current 5, desired 6, max 5")
+	assert.Len(t, client.deployed, 1)
+	assert.Equal(t, 2, cpuPool.targetSize)
+
+	manager.maxNodesTotal = 0
+	require.NoError(t, cpuPool.IncreaseSize(1))
+}
+
+func TestIncreaseSizeResourceLimits(t *testing.T) {
+	serverTypes := []*datacrunchclient.InstanceType{
+		{
+			InstanceType: "1A100.22V",
+			CPU:          datacrunchclient.CPU{NumberOfCores: 22},
+			GPU:          datacrunchclient.GPU{NumberOfGPUs: 1},
+			Memory:       datacrunchclient.Memory{SizeInGigabytes: 120},
+		},
+	}
+	servers := []*datacrunchclient.Instance{
+		{ID: "id1", Hostname: "pool-1a", InstanceType: "1A100.22V", Status: "running"},
+		{ID: "id2", Hostname: "other-pool-2b", InstanceType: "1A100.22V", Status: "running"},
+	}
+
+	t.Run("cores", func(t *testing.T) {
+		manager := newTestManager(t, serverTypes, servers)
+		group := newTestNodeGroup(manager, "pool", 0, 5)
+		group.targetSize = 1
+		newTestNodeGroup(manager, "other-pool", 0, 5).targetSize = 1
+		// two servers exist, room for two more
+		manager.resourceLimiter = cloudprovider.NewResourceLimiter(nil, map[string]int64{cloudprovider.ResourceNameCores: 4 * 22})
+
+		err := group.IncreaseSize(3)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), cloudprovider.ResourceNameCores)
+		assert.Empty(t, fakeClientOf(manager).deployed)
+		assert.Equal(t, 1, group.targetSize)
+
+		require.NoError(t, group.IncreaseSize(2))
+		assert.Len(t, fakeClientOf(manager).deployed, 2)
+		assert.Equal(t, 3, group.targetSize)
+
+		require.Error(t, group.IncreaseSize(1))
+	})
+
+	t.Run("gpus", func(t *testing.T) {
+		manager := newTestManager(t, serverTypes, servers)
+		group := newTestNodeGroup(manager, "pool", 0, 5)
+		newTestNodeGroup(manager, "other-pool", 0, 5)
+		manager.resourceLimiter = cloudprovider.NewResourceLimiter(nil, map[string]int64{string(ResourceGPU): 2})
+
+		err := group.IncreaseSize(1)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), string(ResourceGPU))
+	})
+
+	t.Run("no limits", func(t *testing.T) {
+		manager := newTestManager(t, serverTypes, servers)
+		group := newTestNodeGroup(manager, "pool", 0, 5)
+		manager.resourceLimiter = cloudprovider.NewResourceLimiter(nil, nil)
+
+		require.NoError(t, group.IncreaseSize(4))
+	})
+}
+
+func TestToInstanceStatus(t *testing.T) {
+	tests := []struct {
+		status     string
+		state      cloudprovider.InstanceState
+		errorClass cloudprovider.InstanceErrorClass
+		errorCode  string
+	}{
+		{status: "new", state: cloudprovider.InstanceCreating},
+		{status: "ordered", state: cloudprovider.InstanceCreating},
+		{status: "validating", state: cloudprovider.InstanceCreating},
+		{status: "provisioning", state: cloudprovider.InstanceCreating},
+		{status: "running", state: cloudprovider.InstanceRunning},
+		{status: "deleting", state: cloudprovider.InstanceDeleting},
+		{status: "offline", state: cloudprovider.InstanceDeleting},
+		{status: "discontinued", state: cloudprovider.InstanceDeleting},
+		{status: "no_capacity", state: cloudprovider.InstanceCreating, errorClass: cloudprovider.OutOfResourcesErrorClass, errorCode: "no-capacity"},
+		{status: "error", state: cloudprovider.InstanceCreating, errorClass: cloudprovider.OtherErrorClass, errorCode: "error"},
+		{status: "installation_failed", state: cloudprovider.InstanceCreating, errorClass: cloudprovider.OtherErrorClass, errorCode: "installation-failed"},
+		{status: "notfound", errorClass: cloudprovider.OtherErrorClass, errorCode: "unknown-status"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.status, func(t *testing.T) {
+			st := toInstanceStatus(&datacrunchclient.Instance{ID: "id1", Status: tc.status})
+			require.NotNil(t, st)
+			assert.Equal(t, tc.state, st.State)
+			if tc.errorCode == "" {
+				assert.Nil(t, st.ErrorInfo)
+				return
+			}
+			require.NotNil(t, st.ErrorInfo)
+			assert.Equal(t, tc.errorClass, st.ErrorInfo.ErrorClass)
+			assert.Equal(t, tc.errorCode, st.ErrorInfo.ErrorCode)
+		})
+	}
+
+	assert.Nil(t, toInstanceStatus(&datacrunchclient.Instance{ID: "id1"}))
+}
+
+func TestNodesReportsBootingServers(t *testing.T) {
+	now := time.Now()
+	servers := []*datacrunchclient.Instance{
+		{ID: "id1", Hostname: "pool-1a", Status: "provisioning", CreatedAt: now.Format(time.RFC3339)},
+		{ID: "id2", Hostname: "pool-2b", Status: "running", CreatedAt: now.Format(time.RFC3339)},
+		{ID: "id3", Hostname: "pool-3c", Status: "running", CreatedAt: now.Format(time.RFC3339)},
+		{ID: "id4", Hostname: "pool-4d", Status: "running", CreatedAt: now.Add(-2 * time.Hour).Format(time.RFC3339)},
+	}
+	manager := newTestManager(t, nil, servers)
+	group := newTestNodeGroup(manager, "pool", 0, 5)
+	manager.orphans.markRegistered("id3")
+
+	instances, err := group.Nodes()
+	require.NoError(t, err)
+	states := make(map[string]cloudprovider.InstanceState, len(instances))
+	for _, instance := range instances {
+		require.NotNil(t, instance.Status)
+		assert.Nil(t, instance.Status.ErrorInfo)
+		states[instance.Id] = instance.Status.State
+	}
+	assert.Equal(t, map[string]cloudprovider.InstanceState{
+		toProviderID("id1"): cloudprovider.InstanceCreating,
+		// running, but its node did not register yet
+		toProviderID("id2"): cloudprovider.InstanceCreating,
+		toProviderID("id3"): cloudprovider.InstanceRunning,
+		// its node did not register within the register timeout
+		toProviderID("id4"): cloudprovider.InstanceRunning,
+	}, states)
+}
+
+func TestNodesReportsFailedServers(t *testing.T) {
+	servers := []*datacrunchclient.Instance{
+		{ID: "id1", Hostname: "pool-1a", Status: "running"},
+		{ID: "id2", Hostname: "pool-2b", Status: "error"},
+		{ID: "id3", Hostname: "other-3c", Status: "error"},
+	}
+	manager := newTestManager(t, nil, servers)
+	group := newTestNodeGroup(manager, "pool", 0, 3)
+
+	instances, err := group.Nodes()
+	require.NoError(t, err)
+	require.Len(t, instances, 2)
+	assert.Equal(t, "datacrunch://id1", instances[0].Id)
+	assert.Nil(t, instances[0].Status.ErrorInfo)
+	assert.Equal(t, "datacrunch://id2", instances[1].Id)
+	require.NotNil(t, instances[1].Status.ErrorInfo)
+	assert.Equal(t, cloudprovider.OtherErrorClass, instances[1].Status.ErrorInfo.ErrorClass)
+}
+
+func TestToServerID(t *testing.T) {
+	tests := []struct {
+		name       string
+		providerID string
+		serverID   string
+		wantErr    bool
+	}{
+		{name: "valid", providerID: "datacrunch://id1", serverID: "id1"},
+		{name: "uuid", providerID: "datacrunch://0b1c2d3e-aaaa-bbbb-cccc-0123456789ab", serverID: "0b1c2d3e-aaaa-bbbb-cccc-0123456789ab"},
+		{name: "scheme casing is ignored", providerID: "DataCrunch://id1", serverID: "id1"},
+		{name: "server id casing is kept", providerID: "datacrunch://ID1", serverID: "ID1"},
+		{name: "empty", providerID: "", wantErr: true},
+		{name: "missing prefix", providerID: "id1", wantErr: true},
+		{name: "other provider", providerID: "aws://id1", wantErr: true},
+		{name: "prefix only", providerID: "datacrunch://", wantErr: true},
+		{name: "trailing slash", providerID: "datacrunch://id1/", wantErr: true},
+		{name: "region qualified", providerID: "datacrunch://FIN-01/id1", wantErr: true},
+		{name: "extra slash", providerID: "datacrunch:///id1", wantErr: true},
+		{name: "whitespace", providerID: "datacrunch://id1 ", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			serverID, err := toServerID(tc.providerID)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.serverID, serverID)
+			assert.Equal(t, "datacrunch://"+tc.serverID, toProviderID(serverID))
+		})
+	}
+}
+
+func TestServerForNodeMalformedProviderID(t *testing.T) {
+	manager := newTestManager(t, nil, []*datacrunchclient.Instance{{ID: "id1", Hostname: "pool-1a"}})
+
+	instance, err := manager.serverForNode(&apiv1.Node{Spec: apiv1.NodeSpec{ProviderID: "DATACRUNCH://id1"}})
+	require.NoError(t, err)
+	require.NotNil(t, instance)
+	assert.Equal(t, "id1", instance.ID)
+
+	_, err = manager.serverForNode(&apiv1.Node{Spec: apiv1.NodeSpec{ProviderID: "datacrunch://FIN-01/id1"}})
+	assert.Error(t, err)
+
+	// nodes of other providers are ignored in hybrid clusters
+	instance, err = manager.serverForNode(&apiv1.Node{Spec: apiv1.NodeSpec{ProviderID: "aws://id1"}})
+	require.NoError(t, err)
+	assert.Nil(t, instance)
+}
+
+func TestServerForNodeHostnameMismatch(t *testing.T) {
+	servers := []*datacrunchclient.Instance{
+		{ID: "id1", Hostname: "pool-1a"},
+		{ID: "id2", Hostname: "Pool-2B"},
+		{ID: "id3", Hostname: "pool-3c.example.com"},
+		{ID: "id4", Hostname: "pool-4d"},
+	}
+	manager := newTestManager(t, nil, servers)
+	manager.nodeNamePattern = regexp.MustCompile(`^k8s-(.+)$`)
+	manager.nodeNameReplacement = "$1"
+
+	tests := []struct {
+		nodeName string
+		serverID string
+	}{
+		{nodeName: "pool-1a", serverID: "id1"},
+		{nodeName: "pool-1a.cluster.local", serverID: "id1"},
+		{nodeName: "pool-2b", serverID: "id2"},
+		{nodeName: "POOL-2B.cluster.local", serverID: "id2"},
+		{nodeName: "pool-3c", serverID: "id3"},
+		{nodeName: "POOL-3C.EXAMPLE.COM", serverID: "id3"},
+		{nodeName: "k8s-pool-4d", serverID: "id4"},
+		{nodeName: "pool-1", serverID: ""},
+		{nodeName: "pool-1a-extra", serverID: ""},
+	}
+	for _, tc := range tests {
+		instance, err := manager.serverForNode(&apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: tc.nodeName}})
+		require.NoError(t, err)
+		if tc.serverID == "" {
+			assert.Nil(t, instance, tc.nodeName)
+			continue
+		}
+		require.NotNil(t, instance, tc.nodeName)
+		assert.Equal(t, tc.serverID, instance.ID, tc.nodeName)
+	}
+}
+
+func TestServerForNodeRegionLabel(t *testing.T) {
+	servers := []*datacrunchclient.Instance{
+		{ID: "id1", Hostname: "pool-1a", Location: "FIN-01"},
+		{ID: "id2", Hostname: "pool-2b", Location: "ICE-01"},
+	}
+	manager := newTestManager(t, nil, servers)
+	node := func(name, region string) *apiv1.Node {
+		return &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{apiv1.LabelTopologyRegion: region}}}
+	}
+
+	// regions are case-insensitive
+	instance, err := manager.serverForNode(node("pool-1a", "fin-01"))
+	require.NoError(t, err)
+	require.NotNil(t, instance)
+	assert.Equal(t, "id1", instance.ID)
+	id, found := manager.createdServer("fin-01", "pool-1a")
+	assert.True(t, found)
+	assert.Equal(t, "id1", id)
+	_, cached := manager.cachedServers.cached(regionCacheKey("FIN-01"))
+	require.True(t, cached)
+	manager.cachedServers.invalidate("fin-01")
+	_, cached = manager.cachedServers.cached(regionCacheKey("FIN-01"))
+	assert.False(t, cached)
+
+	// a server outside of the region of the label is found in all regions
+	instance, err = manager.serverForNode(node("pool-2b", "FIN-01"))
+	require.NoError(t, err)
+	require.NotNil(t, instance)
+	assert.Equal(t, "id2", instance.ID)
+}
+
+func TestServerForNodeTransientError(t *testing.T) {
+	manager := newTestManager(t, nil, []*datacrunchclient.Instance{{ID: "id1", Hostname: "pool-1a"}})
+	manager.cachedServers.invalidate("")
+	client := fakeClientOf(manager)
+	node := &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "pool-1a"}}
+
+	// the lookup is retried once the listing failed transiently
+	client.listErr = &datacrunchclient.APIError{StatusCode: 503, Message: "Service Unavailable"}
+	client.listErrOnce = true
+	instance, err := manager.serverForNode(node)
+	require.NoError(t, err)
+	require.NotNil(t, instance)
+	assert.Equal(t, "id1", instance.ID)
+	assert.EqualValues(t, 2, client.listCalls)
+
+	// a server which does not exist is not looked up again
+	manager.cachedServers.invalidate("")
+	client.listCalls = 0
+	instance, err = manager.serverForNode(&apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "pool-2b"}})
+	require.NoError(t, err)
+	assert.Nil(t, instance)
+	assert.EqualValues(t, 1, client.listCalls)
+
+	// other errors are not retried
+	manager.cachedServers.invalidate("")
+	client.listCalls = 0
+	client.listErr = &datacrunchclient.APIError{StatusCode: 400, Message: "Bad Request"}
+	client.listErrOnce = false
+	_, err = manager.serverForNode(node)
+	assert.Error(t, err)
+	assert.EqualValues(t, 1, client.listCalls)
+
+	// the lookup gives up after the max attempts
+	client.listCalls = 0
+	client.listErr = &datacrunchclient.APIError{StatusCode: 503, Message: "Service Unavailable"}
+	_, err = manager.serverForNode(node)
+	assert.Error(t, err)
+	assert.EqualValues(t, manager.nodeLookupMaxAttempts, client.listCalls)
+}
+
+func TestDryRun(t *testing.T) {
+	serverTypes := []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}
+	servers := []*datacrunchclient.Instance{{ID: "id1", Hostname: "pool-1a", Status: "running"}}
+	manager := newTestManager(t, serverTypes, servers)
+	manager.dryRun = true
+	group := newTestNodeGroup(manager, "pool", 0, 5)
+	group.targetSize = 1
+	client := fakeClientOf(manager)
+
+	require.NoError(t, group.IncreaseSize(3))
+	size, err := group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 4, size)
+
+	node := &apiv1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool-1a"},
+		Spec:       apiv1.NodeSpec{ProviderID: "datacrunch://id1"},
+	}
+	require.NoError(t, group.DeleteNodes([]*apiv1.Node{node}))
+	size, err = group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 3, size)
+
+	// size limits are still enforced
+	require.Error(t, group.IncreaseSize(3))
+
+	assert.Zero(t, client.calls.Load())
+	assert.Len(t, client.servers, 1)
+}
+
+func TestGetOptions(t *testing.T) {
+	manager := newTestManager(t, nil, nil)
+	group := newTestNodeGroup(manager, "pool", 0, 3)
+	defaults := config.NodeGroupAutoscalingOptions{
+		ScaleDownUtilizationThreshold:    0.5,
+		ScaleDownGpuUtilizationThreshold: 0.5,
+		ScaleDownUnneededTime:            10 * time.Minute,
+		ScaleDownUnreadyTime:             20 * time.Minute,
+		MaxNodeProvisionTime:             15 * time.Minute,
+		IgnoreDaemonSetsUtilization:      true,
+	}
+
+	options, err := group.GetOptions(defaults)
+	require.NoError(t, err)
+	assert.Equal(t, defaults, *options)
+
+	spec, err := createNodePoolSpec("0:3:1A100.22V:FIN-01:pool:max_node_provision_time=45m,scale_down_gpu_utilization_threshold=0", "")
+	require.NoError(t, err)
+	group.options = spec.options
+
+	options, err = group.GetOptions(defaults)
+	require.NoError(t, err)
+	expected := defaults
+	expected.MaxNodeProvisionTime = 45 * time.Minute
+	expected.ScaleDownGpuUtilizationThreshold = 0
+	assert.Equal(t, expected, *options)
+}
+
+func TestGetOptionsNodeConfigThresholds(t *testing.T) {
+	manager := newTestManager(t, nil, nil)
+	group := newTestNodeGroup(manager, "h100-pool", 0, 3)
+	manager.clusterConfig.NodeConfigs["h100-pool"].ScaleDownGpuUtilizationThreshold = ptr.To(0.9)
+	defaults := config.NodeGroupAutoscalingOptions{
+		ScaleDownUtilizationThreshold:    0.5,
+		ScaleDownGpuUtilizationThreshold: 0.5,
+	}
+
+	options, err := group.GetOptions(defaults)
+	require.NoError(t, err)
+	assert.Equal(t, 0.5, options.ScaleDownUtilizationThreshold)
+	assert.Equal(t, 0.9, options.ScaleDownGpuUtilizationThreshold)
+
+	// the node group spec takes precedence over the node config
+	spec, err := createNodePoolSpec("0:3:1H100.80S:FIN-01:h100-pool:scale_down_gpu_utilization_threshold=0.7", "")
+	require.NoError(t, err)
+	group.options = spec.options
+	options, err = group.GetOptions(defaults)
+	require.NoError(t, err)
+	assert.Equal(t, 0.7, options.ScaleDownGpuUtilizationThreshold)
+
+	manager.clusterConfig.NodeConfigs["h100-pool"].ScaleDownUtilizationThreshold = ptr.To(1.5)
+	err = manager.validateNodeConfigReferences()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid scale_down_utilization_threshold of node config h100-pool")
+}
+
+func TestScaleDownDisabled(t *testing.T) {
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+	group := newTestNodeGroup(manager, "licensed-pool", 0, 3)
+	spec, err := createNodePoolSpec("0:3:1A100.22V:FIN-01:licensed-pool:scale_down_disabled=true,scale_down_unneeded_time=5m", "")
+	require.NoError(t, err)
+	group.options = spec.options
+
+	// no node is unneeded, whatever its utilization and the other options
+	defaults := config.NodeGroupAutoscalingOptions{
+		ScaleDownUtilizationThreshold:    0.5,
+		ScaleDownGpuUtilizationThreshold: 0.5,
+		ScaleDownUnneededTime:            10 * time.Minute,
+		ScaleDownUnreadyTime:             20 * time.Minute,
+		MaxNodeProvisionTime:             15 * time.Minute,
+	}
+	options, err := group.GetOptions(defaults)
+	require.NoError(t, err)
+	assert.Zero(t, options.ScaleDownUtilizationThreshold)
+	assert.Zero(t, options.ScaleDownGpuUtilizationThreshold)
+	assert.Equal(t, scaleDownDisabledTime, options.ScaleDownUnneededTime)
+	assert.Equal(t, scaleDownDisabledTime, options.ScaleDownUnreadyTime)
+	assert.Equal(t, defaults.MaxNodeProvisionTime, options.MaxNodeProvisionTime)
+
+	// scale ups are not affected
+	require.NoError(t, group.IncreaseSize(2))
+	size, err := group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 2, size)
+	assert.Len(t, fakeClientOf(manager).deployed, 2)
+}
+
+func TestIncreaseSizePartialFailure(t *testing.T) {
+	serverTypes := []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}
+
+	for _, listFails := range []bool{false, true} {
+		manager := newTestManager(t, serverTypes, nil)
+		group := newTestNodeGroup(manager, "pool", 0, 10)
+		client := fakeClientOf(manager)
+		client.deployErr = func(req datacrunchclient.DeployInstanceRequest) error {
+			// the first two requests fail
+			if len(client.deployed) <= 2 {
+				return &datacrunchclient.APIError{StatusCode: 400, Code: "invalid_request", Message: "invalid image"}
+			}
+			return nil
+		}
+		if listFails {
+			// the target size is updated by the number of created servers
+			client.listErr = errors.New("API unavailable")
+		}
+
+		err := group.IncreaseSize(5)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to create 2 of 5 servers")
+		assert.Contains(t, err.Error(), "invalid image")
+		group.sizeMutex.Lock()
+		assert.Equal(t, err, group.lastError, "the returned error is recorded")
+		group.sizeMutex.Unlock()
+
+		assert.Len(t, client.servers, 3, "created servers are kept")
+		size, err := group.TargetSize()
+		require.NoError(t, err)
+		assert.Equal(t, 3, size)
+	}
+}
+
+func TestIncreaseSizeReserved(t *testing.T) {
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+	group := newTestNodeGroup(manager, "pool", 0, 5)
+	group.reserved = true
+	group.regions = []string{"FIN-01", "ICE-01"}
+	client := fakeClientOf(manager)
+	// the public availability does not cover reserved capacity
+	client.unavailableRegions = map[string]bool{"FIN-01": true, "ICE-01": true}
+	manager.clusterConfig.NodeConfigs["pool"].InstanceOption = InstanceOptionPreferSpot
+
+	options, err := group.GetOptions(config.NodeGroupAutoscalingOptions{MaxNodeProvisionTime: 15 * time.Minute})
+	require.NoError(t, err)
+	assert.Equal(t, reservedMaxNodeProvisionTime, options.MaxNodeProvisionTime)
+
+	require.NoError(t, group.IncreaseSize(1))
+	require.Len(t, client.deployed, 1)
+	assert.Equal(t, reservedContract, client.deployed[0].Contract)
+	assert.False(t, client.deployed[0].IsSpot)
+	assert.Empty(t, client.deployed[0].Pricing)
+
+	// an exhausted reservation fails over to the next region, then fails the
+	// scale up with a capacity error so the autoscaler tries other node groups
+	client.deployErr = func(req datacrunchclient.DeployInstanceRequest) error {
+		return &datacrunchclient.APIError{StatusCode: 400, Code: "invalid_request", Message: "Reserved capacity for instance type 1A100.22V is exhausted"}
+	}
+	err = group.IncreaseSize(1)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, errOutOfCapacity)
+	var autoscalerErr autoscalerErrors.AutoscalerError
+	require.ErrorAs(t, err, &autoscalerErr)
+	assert.Equal(t, autoscalerErrors.TransientError, autoscalerErr.Type())
+	require.Len(t, client.deployed, 3)
+	assert.Equal(t, "FIN-01", client.deployed[1].LocationCode)
+	assert.Equal(t, "ICE-01", client.deployed[2].LocationCode)
+	for _, req := range client.deployed[1:] {
+		assert.False(t, req.IsSpot, "reserved capacity does not fall back to spot")
+	}
+	size, err := group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 1, size)
+}
+
+func TestIncreaseSizeInstanceTypeFallback(t *testing.T) {
+	serverTypes := []*datacrunchclient.InstanceType{
+		{InstanceType: "1A100.22V", CPU: datacrunchclient.CPU{NumberOfCores: 22}, GPU: datacrunchclient.GPU{NumberOfGPUs: 1}, Memory: datacrunchclient.Memory{SizeInGigabytes: 120}},
+		{InstanceType: "2A100.44V", CPU: datacrunchclient.CPU{NumberOfCores: 44}, GPU: datacrunchclient.GPU{NumberOfGPUs: 2}, Memory: datacrunchclient.Memory{SizeInGigabytes: 240}},
+	}
+	manager := newTestManager(t, serverTypes, nil)
+	group := newTestNodeGroup(manager, "pool", 0, 5)
+	group.fallbackInstanceTypes = []string{"2A100.44V"}
+	group.regions = []string{"FIN-01", "ICE-01"}
+	manager.clusterConfig.NodeConfigs["pool"].StartupScriptBase64 = base64.StdEncoding.EncodeToString([]byte("kubeadm join"))
+	t.Setenv("DATACRUNCH_CLIENT_ID", "client-id")
+	t.Setenv("DATACRUNCH_CLIENT_SECRET", "client-secret")
+	client := fakeClientOf(manager)
+	client.deployErr = func(req datacrunchclient.DeployInstanceRequest) error {
+		if req.InstanceType == "1A100.22V" {
+			return &datacrunchclient.APIError{StatusCode: 400, Code: "invalid_request", Message: "Not enough resources to deploy instance"}
+		}
+		return nil
+	}
+
+	// the preferred instance type is tried in all regions first
+	require.NoError(t, group.IncreaseSize(1))
+	require.Len(t, client.deployed, 3)
+	placements := make([]string, 0, len(client.deployed))
+	for _, req := range client.deployed {
+		placements = append(placements, req.InstanceType+"/"+req.LocationCode)
+	}
+	assert.Equal(t, []string{"1A100.22V/FIN-01", "1A100.22V/ICE-01", "2A100.44V/FIN-01"}, placements)
+	script := client.uploadedScripts["autoscaler-startup-script-"+client.deployed[2].Hostname]
+	assert.Contains(t, script, apiv1.LabelInstanceType+"=2A100.44V")
+
+	// the template uses the preferred instance type
+	nodeInfo, err := group.TemplateNodeInfo()
+	require.NoError(t, err)
+	assert.Equal(t, "1A100.22V", nodeInfo.Node().Labels[apiv1.LabelInstanceType])
+	assert.Equal(t, int64(22), nodeInfo.Node().Status.Allocatable.Cpu().Value())
+
+	// the created server is counted with its own instance type: 2 GPUs plus
+	// 2 GPUs of the preferred instance type exceed the limit
+	manager.resourceLimiter = cloudprovider.NewResourceLimiter(nil, map[string]int64{string(ResourceGPU): 3})
+	require.NoError(t, group.checkResourceLimits(1))
+	err = group.checkResourceLimits(2)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "current 2")
+}
+
+func TestIncreaseSizeBackoff(t *testing.T) {
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+	manager.scaleUpBackoff = time.Hour
+	// the instance type is not penalized, so the backoff is all that holds
+	// back scale ups
+	manager.capacityPenalties = newCapacityPenalties(clock.RealClock{}, 0)
+	group := newTestNodeGroup(manager, "pool", 0, 5)
+	client := fakeClientOf(manager)
+	outOfCapacity := true
+	client.deployErr = func(req datacrunchclient.DeployInstanceRequest) error {
+		if outOfCapacity {
+			return &datacrunchclient.APIError{StatusCode: 400, Code: "invalid_request", Message: "Not enough resources to deploy instance"}
+		}
+		return nil
+	}
+
+	require.Error(t, group.IncreaseSize(1))
+	require.Len(t, client.deployed, 1)
+
+	// the node group is skipped during the cool-down
+	err := group.IncreaseSize(1)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "backoff")
+	assert.Len(t, client.deployed, 1)
+
+	// scale ups are attempted again once the cool-down expired
+	group.backoffUntil = time.Now().Add(-time.Second)
+	require.Error(t, group.IncreaseSize(1))
+	assert.Len(t, client.deployed, 2)
+	assert.Equal(t, 2, group.scaleUpFailures)
+
+	// the first success resets the backoff
+	group.backoffUntil = time.Now().Add(-time.Second)
+	outOfCapacity = false
+	require.NoError(t, group.IncreaseSize(1))
+	assert.Equal(t, 0, group.scaleUpFailures)
+	require.NoError(t, group.IncreaseSize(1))
+	size, err := group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 2, size)
+}
+
+func TestConcurrentScalingKeepsTargetSizeConsistent(t *testing.T) {
+	var servers []*datacrunchclient.Instance
+	var nodes []*apiv1.Node
+	for i := 0; i < 4; i++ {
+		id := fmt.Sprintf("id%d", i)
+		hostname := fmt.Sprintf("pool-%d", i)
+		servers = append(servers, &datacrunchclient.Instance{ID: id, Hostname: hostname, Location: "FIN-01", Status: "running"})
+		nodes = append(nodes, &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: hostname}, Spec: apiv1.NodeSpec{ProviderID: toProviderID(id)}})
+	}
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, servers)
+	group := newTestNodeGroup(manager, "pool", 0, 6)
+	group.targetSize = len(servers)
+	provider, err := newDatacrunchCloudProvider(manager, nil)
+	require.NoError(t, err)
+	client := fakeClientOf(manager)
+
+	var exceeded atomic.Bool
+	checkSize := func() {
+		if size, _ := group.TargetSize(); size < 0 || size > group.MaxSize() {
+			exceeded.Store(true)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// scale ups beyond the max size are refused
+			_ = group.IncreaseSize(1)
+			checkSize()
+		}()
+	}
+	for _, node := range nodes[:2] {
+		wg.Add(1)
+		go func(node *apiv1.Node) {
+			defer wg.Done()
+			assert.NoError(t, group.DeleteNodes([]*apiv1.Node{node}))
+			checkSize()
+		}(node)
+	}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, provider.Refresh())
+			checkSize()
+		}()
+	}
+	wg.Wait()
+
+	assert.False(t, exceeded.Load(), "the target size must stay between 0 and the max size")
+	client.mu.Lock()
+	remaining := len(client.servers)
+	client.mu.Unlock()
+	assert.LessOrEqual(t, remaining, group.MaxSize())
+	size, err := group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, remaining, size, "the target size must match the servers left")
+
+	require.NoError(t, provider.Refresh())
+	size, err = group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, remaining, size)
+}
+
+func TestResumeTargetSize(t *testing.T) {
+	servers := []*datacrunchclient.Instance{
+		{ID: "id1", Hostname: "pool-1a", Location: "FIN-01", Status: "running"},
+		{ID: "id2", Hostname: "pool-2b", Location: "FIN-01", Status: "running"},
+		// still being created by the previous run
+		{ID: "id3", Hostname: "pool-3c", Location: "FIN-01", Status: "provisioning"},
+		{ID: "id4", Hostname: "pool-4d", Location: "FIN-01", Status: "new"},
+		{ID: "id5", Hostname: "pool-5e", Location: "FIN-01", Status: "deleting"},
+	}
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, servers)
+	group := newTestNodeGroup(manager, "pool", 0, 5)
+	provider, err := newDatacrunchCloudProvider(manager, nil)
+	require.NoError(t, err)
+
+	group.resumeTargetSize(servers)
+	size, err := group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 4, size, "the servers being created are part of the target size")
+
+	// the next loop finds no missing servers
+	require.NoError(t, provider.Refresh())
+	size, err = group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 4, size)
+	assert.Empty(t, fakeClientOf(manager).deployed)
+
+	// the resumed size is capped at the max size
+	group.maxSize = 3
+	group.resumeTargetSize(servers)
+	size, err = group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 3, size)
+}
+
+func TestReconcileTargetSizeDropsStaleServers(t *testing.T) {
+	servers := []*datacrunchclient.Instance{
+		{ID: "id1", Hostname: "pool-1a", Location: "FIN-01", Status: "running"},
+	}
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, servers)
+	group := newTestNodeGroup(manager, "pool", 0, 5)
+	inFlightCreates, generation := group.sizeSnapshot()
+
+	// a scale up finishes after the servers were listed
+	require.NoError(t, group.IncreaseSize(2))
+	group.reconcileTargetSize(servers, inFlightCreates, generation)
+	size, err := group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 3, size)
+
+	inFlightCreates, generation = group.sizeSnapshot()
+	group.reconcileTargetSize(servers, inFlightCreates, generation)
+	size, err = group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 1, size)
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+
+	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
+)
+
+const (
+	// clusterTagKey and nodeGroupTagKey are the tags of the servers created
+	// by the autoscaler with the default tag prefix.
+	clusterTagKey   = tagPrefixDefault + "cluster"
+	nodeGroupTagKey = tagPrefixDefault + "node-group"
+
+	orphanCleanupInterval = 5 * time.Minute
+)
+
+// serverTagKeys are the keys of the tags of the servers created by the
+// autoscaler, they identify the cluster and the node group of a server.
+type serverTagKeys struct {
+	cluster   string
+	nodeGroup string
+}
+
+// defaultTagKeys are the tag keys with the default tag prefix.
+var defaultTagKeys = newServerTagKeys(tagPrefixDefault)
+
+// newServerTagKeys returns the tag keys with the prefix, e.g.
+// "cluster-autoscaler/".
+func newServerTagKeys(prefix string) serverTagKeys {
+	return serverTagKeys{
+		cluster:   prefix + "cluster",
+		nodeGroup: prefix + "node-group",
+	}
+}
+
+// orphanCleanup tracks the servers whose nodes were seen in the cluster, so
+// servers which never registered can be deleted.
+type orphanCleanup struct {
+	sync.Mutex
+	clock      clock.Clock
+	startedAt  time.Time
+	lastRun    time.Time
+	registered map[string]bool
+}
+
+func newOrphanCleanup(c clock.Clock) *orphanCleanup {
+	return &orphanCleanup{
+		clock:      c,
+		startedAt:  c.Now(),
+		registered: make(map[string]bool),
+	}
+}
+
+// markRegistered records that the node of the server is in the cluster.
+func (o *orphanCleanup) markRegistered(serverID string) {
+	o.Lock()
+	defer o.Unlock()
+	o.registered[serverID] = true
+}
+
+// due returns whether the cleanup should run and records the run. It only
+// runs once the autoscaler ran for the register timeout, the nodes of all
+// servers are looked up by then.
+func (o *orphanCleanup) due(registerTimeout time.Duration) bool {
+	o.Lock()
+	defer o.Unlock()
+	now := o.clock.Now()
+	if now.Sub(o.startedAt) < registerTimeout || now.Sub(o.lastRun) < orphanCleanupInterval {
+		return false
+	}
+	o.lastRun = now
+	return true
+}
+
+// isRegistered returns whether the node of the server was seen.
+func (o *orphanCleanup) isRegistered(serverID string) bool {
+	o.Lock()
+	defer o.Unlock()
+	return o.registered[serverID]
+}
+
+// prune forgets the servers which no longer exist.
+func (o *orphanCleanup) prune(servers []*datacrunchclient.Instance) {
+	ids := make(map[string]bool, len(servers))
+	for _, server := range servers {
+		ids[server.ID] = true
+	}
+
+	o.Lock()
+	defer o.Unlock()
+	for id := range o.registered {
+		if !ids[id] {
+			delete(o.registered, id)
+		}
+	}
+}
+
+// registerStart returns when the node of the server started to register, the
+// creation of the server or its release from a warm pool.
+func (m *datacrunchManager) registerStart(server *datacrunchclient.Instance) (time.Time, error) {
+	createdAt, err := time.Parse(time.RFC3339, server.CreatedAt)
+	if err != nil {
+		return time.Time{}, err
+	}
+	// released warm servers register after their release
+	if releasedAt, found := m.warmPool.releasedAt(server.ID); found && releasedAt.After(createdAt) {
+		return releasedAt, nil
+	}
+	return createdAt, nil
+}
+
+// registerTimeout returns the register timeout of the node group.
+func (m *datacrunchManager) registerTimeout(nodeGroup string) time.Duration {
+	if group, found := m.nodeGroups[nodeGroup]; found {
+		return group.registerTimeout
+	}
+	return m.serverRegisterTimeout
+}
+
+// cleanupOrphans deletes the servers tagged with the cluster name whose
+// nodes did not register within the register timeout and the register grace
+// period, e.g. because the autoscaler restarted after creating them. Nothing
+// is deleted if no cluster name is configured, the servers of other clusters
+// can't be told apart.
+func (m *datacrunchManager) cleanupOrphans(servers []*datacrunchclient.Instance) {
+	if m.clusterName == "" || !m.orphans.due(m.serverRegisterTimeout+m.registerGracePeriod) {
+		return
+	}
+	m.orphans.prune(servers)
+
+	now := m.orphans.clock.Now()
+	for _, server := range servers {
+		tags := parseServerTags(server.Description)
+		if tags[m.tagKeys.cluster] != m.clusterName || m.orphans.isRegistered(server.ID) || m.warmPool.isHeld(server.ID) {
+			continue
+		}
+		if status := m.serverStatus(server); status != nil && status.State == cloudprovider.InstanceDeleting {
+			continue
+		}
+
+		startedAt, err := m.registerStart(server)
+		if err != nil {
+			klog.Warningf("Skipping orphan cleanup of server %s, failed to parse creation time %q: %v", server.ID, server.CreatedAt, err)
+			continue
+		}
+		registerTimeout := m.registerTimeout(tags[m.tagKeys.nodeGroup])
+		if now.Sub(startedAt) < registerTimeout+m.registerGracePeriod {
+			continue
+		}
+		// the nodes of orphans may register while other orphans are deleted
+		if m.orphans.isRegistered(server.ID) {
+			continue
+		}
+
+		if m.dryRun {
+			klog.Infof("Dry run: would delete orphaned server %s of node group %s", server.ID, tags[m.tagKeys.nodeGroup])
+			continue
+		}
+		klog.Warningf("Deleting orphaned server %s of node group %s, its node did not register within %s and the grace period of %s", server.ID, tags[m.tagKeys.nodeGroup], registerTimeout, m.registerGracePeriod)
+		if err := m.deleteServer(server); err != nil {
+			klog.Errorf("failed to delete orphaned server %s: %v", server.ID, err)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclock "k8s.io/utils/clock/testing"
+
+	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
+)
+
+func TestCleanupOrphans(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-time.Hour).Format(time.RFC3339)
+	tagged := func(id, cluster, createdAt string) *datacrunchclient.Instance {
+		return &datacrunchclient.Instance{
+			ID:          id,
+			Hostname:    "pool-" + id,
+			Status:      "running",
+			CreatedAt:   createdAt,
+			Description: formatServerTags(map[string]string{clusterTagKey: cluster, nodeGroupTagKey: "pool"}),
+		}
+	}
+	servers := []*datacrunchclient.Instance{
+		tagged("1a", "test", old),
+		tagged("2b", "test", old),
+		// created after the start of the autoscaler
+		tagged("3c", "test", now.Add(time.Minute).Format(time.RFC3339)),
+		tagged("4d", "other", old),
+		{ID: "5e", Hostname: "manual-5e", Status: "running", CreatedAt: old},
+	}
+	manager := newTestManager(t, nil, servers)
+	manager.clusterName = "test"
+	fakeClock := testclock.NewFakeClock(now)
+	manager.orphans = newOrphanCleanup(fakeClock)
+	newTestNodeGroup(manager, "pool", 0, 5)
+	provider, err := newDatacrunchCloudProvider(manager, nil)
+	require.NoError(t, err)
+	client := fakeClientOf(manager)
+
+	// the node of the second server is in the cluster
+	node := &apiv1.Node{Spec: apiv1.NodeSpec{ProviderID: toProviderID("2b")}}
+	instance, err := manager.serverForNode(node)
+	require.NoError(t, err)
+	require.NotNil(t, instance)
+
+	// the registered nodes are not known right after the start
+	require.NoError(t, provider.Refresh())
+	assert.Empty(t, client.deleted)
+
+	fakeClock.Step(manager.serverRegisterTimeout)
+	require.NoError(t, provider.Refresh())
+	assert.Equal(t, []string{"1a"}, client.deleted)
+
+	// the third server reached the register timeout, but the cleanup is
+	// throttled
+	fakeClock.Step(time.Minute)
+	require.NoError(t, provider.Refresh())
+	assert.Equal(t, []string{"1a"}, client.deleted)
+
+	fakeClock.Step(orphanCleanupInterval)
+	require.NoError(t, provider.Refresh())
+	assert.Equal(t, []string{"1a", "3c"}, client.deleted)
+}
+
+func TestCleanupOrphansGracePeriod(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tags := formatServerTags(map[string]string{clusterTagKey: "test", nodeGroupTagKey: "pool"})
+	createdAt := now.Add(8 * time.Minute).Format(time.RFC3339)
+	servers := []*datacrunchclient.Instance{
+		{ID: "1a", Hostname: "pool-1a", Status: "running", CreatedAt: createdAt, Description: tags},
+		{ID: "2b", Hostname: "pool-2b", Status: "running", CreatedAt: createdAt, Description: tags},
+	}
+	manager := newTestManager(t, nil, servers)
+	manager.clusterName = "test"
+	manager.registerGracePeriod = 10 * time.Minute
+	fakeClock := testclock.NewFakeClock(now)
+	manager.orphans = newOrphanCleanup(fakeClock)
+	newTestNodeGroup(manager, "pool", 0, 5)
+	provider, err := newDatacrunchCloudProvider(manager, nil)
+	require.NoError(t, err)
+	client := fakeClientOf(manager)
+
+	// the servers missed the register timeout, but are in the grace period
+	fakeClock.Step(manager.serverRegisterTimeout + manager.registerGracePeriod)
+	require.NoError(t, provider.Refresh())
+	assert.Empty(t, client.deleted)
+
+	// the node of the first server registers within the grace period
+	node := &apiv1.Node{Spec: apiv1.NodeSpec{ProviderID: toProviderID("1a")}}
+	_, err = manager.serverForNode(node)
+	require.NoError(t, err)
+
+	fakeClock.Step(manager.registerGracePeriod)
+	require.NoError(t, provider.Refresh())
+	assert.Equal(t, []string{"2b"}, client.deleted)
+}
+
+func TestAdoptTaggedServers(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tags := formatServerTags(map[string]string{clusterTagKey: "test", nodeGroupTagKey: "pool"})
+	servers := []*datacrunchclient.Instance{
+		{ID: "1a", Hostname: "pool-1a", Status: "running", CreatedAt: now.Add(-time.Hour).Format(time.RFC3339), Description: tags},
+		// created by an operator to replace a broken server
+		{ID: "2b", Hostname: "gpu-replacement", Status: "running", CreatedAt: now.Add(-time.Hour).Format(time.RFC3339), Description: tags},
+		// the hostname of a replacement may look generated
+		{ID: "4d", Hostname: "gpu-node-2", Status: "running", CreatedAt: now.Add(-time.Hour).Format(time.RFC3339), Description: tags},
+		// not tagged with a cluster, so not created for the autoscaler
+		{ID: "3c", Hostname: "gpu-manual", Status: "running", CreatedAt: now.Add(-time.Hour).Format(time.RFC3339), Description: "cluster-autoscaler/node-group=pool"},
+	}
+	manager := newTestManager(t, nil, servers)
+	manager.clusterName = "test"
+	fakeClock := testclock.NewFakeClock(now)
+	manager.orphans = newOrphanCleanup(fakeClock)
+	group := newTestNodeGroup(manager, "pool", 0, 5)
+	provider, err := newDatacrunchCloudProvider(manager, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, provider.Refresh())
+	size, err := group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 3, size, "the tagged servers are counted")
+	assert.Equal(t, "pool", manager.nodeGroupIDForServer(servers[2]), "the tags take precedence over the hostname")
+
+	// the node of the adopted server is in the cluster
+	node := &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "gpu-replacement"}, Spec: apiv1.NodeSpec{ProviderID: toProviderID("2b")}}
+	nodeGroup, err := provider.NodeGroupForNode(node)
+	require.NoError(t, err)
+	require.NotNil(t, nodeGroup)
+	assert.Equal(t, "pool", nodeGroup.Id())
+	node = &apiv1.Node{Spec: apiv1.NodeSpec{ProviderID: toProviderID("1a")}}
+	_, err = provider.NodeGroupForNode(node)
+	require.NoError(t, err)
+	node = &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "gpu-node-2"}}
+	nodeGroup, err = provider.NodeGroupForNode(node)
+	require.NoError(t, err)
+	require.NotNil(t, nodeGroup)
+	assert.Equal(t, "pool", nodeGroup.Id())
+
+	fakeClock.Step(manager.serverRegisterTimeout)
+	require.NoError(t, provider.Refresh())
+	assert.Empty(t, fakeClientOf(manager).deleted, "registered adopted servers are not orphans")
+
+	// the adopted servers are capped at the max size
+	group.maxSize = 1
+	require.NoError(t, provider.Refresh())
+	size, err = group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 1, size)
+}
+
+func TestTagPrefix(t *testing.T) {
+	t.Setenv("DATACRUNCH_CLIENT_ID", "client-id")
+	t.Setenv("DATACRUNCH_CLIENT_SECRET", "client-secret")
+	t.Setenv("DATACRUNCH_CLUSTER_CONFIG_JSON", `{"node_configs": {}}`)
+	setAPIClient(t, newFakeClient(nil, nil))
+
+	t.Setenv("DATACRUNCH_TAG_PREFIX", "example.com/autoscaler")
+	manager, err := newManager()
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, manager.Cleanup()) })
+	assert.Equal(t, serverTagKeys{cluster: "example.com/autoscaler/cluster", nodeGroup: "example.com/autoscaler/node-group"}, manager.tagKeys)
+
+	t.Setenv("DATACRUNCH_TAG_PREFIX", "with space/")
+	_, err = newManager()
+	assert.Error(t, err)
+
+	now := time.Now()
+	prefixed := formatServerTags(map[string]string{"example.com/autoscaler/cluster": "test", "example.com/autoscaler/node-group": "pool"})
+	servers := []*datacrunchclient.Instance{
+		{ID: "1a", Hostname: "gpu-replacement", Status: "running", CreatedAt: now.Format(time.RFC3339), Description: prefixed},
+		// tagged by another tool with the default prefix
+		{ID: "2b", Hostname: "gpu-other", Status: "running", CreatedAt: now.Format(time.RFC3339), Description: formatServerTags(map[string]string{clusterTagKey: "test", nodeGroupTagKey: "pool"})},
+	}
+	manager = newTestManager(t, nil, servers)
+	manager.clusterName = "test"
+	manager.tagKeys = newServerTagKeys("example.com/autoscaler/")
+	group := newTestNodeGroup(manager, "pool", 0, 5)
+
+	assert.Equal(t, prefixed, group.serverDescription(), "created servers are tagged with the prefix")
+	assert.Equal(t, "pool", manager.nodeGroupIDForServer(servers[0]), "servers tagged with the prefix are adopted")
+	assert.Empty(t, manager.nodeGroupIDForServer(servers[1]))
+	nodes, err := group.Nodes()
+	require.NoError(t, err)
+	require.Len(t, nodes, 1)
+	assert.Equal(t, toProviderID("1a"), nodes[0].Id)
+}
+
+func TestCleanupOrphansWithoutClusterName(t *testing.T) {
+	now := time.Now()
+	servers := []*datacrunchclient.Instance{
+		{ID: "1a", Hostname: "pool-1a", Status: "running", CreatedAt: now.Add(-time.Hour).Format(time.RFC3339), Description: "cluster-autoscaler/node-group=pool"},
+	}
+	manager := newTestManager(t, nil, servers)
+	fakeClock := testclock.NewFakeClock(now)
+	manager.orphans = newOrphanCleanup(fakeClock)
+	fakeClock.Step(manager.serverRegisterTimeout)
+
+	// servers of other clusters can't be told apart
+	manager.cleanupOrphans(servers)
+	assert.Empty(t, fakeClientOf(manager).deleted)
+}
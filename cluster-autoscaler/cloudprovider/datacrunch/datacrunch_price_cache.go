@@ -0,0 +1,138 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+const (
+	priceCacheKey                = "datacrunch-price-cache"
+	priceCacheRefreshIntervalDef = time.Minute * 10
+)
+
+// instancePrice holds the published hourly prices of an instance type.
+type instancePrice struct {
+	onDemand float64
+	spot     float64
+}
+
+type priceCachedObject struct {
+	name   string
+	prices map[string]instancePrice
+}
+
+// priceCache holds the hourly price table of all instance types, derived from
+// the server type catalog and refreshed after the configured interval.
+type priceCache struct {
+	cache.Store
+	serverTypes *serverTypeCache
+}
+
+func newPriceCache(serverTypes *serverTypeCache, refreshInterval time.Duration) *priceCache {
+	return &priceCache{
+		Store: cache.NewExpirationStore(func(obj interface{}) (s string, e error) {
+			return obj.(priceCachedObject).name, nil
+		}, &cache.TTLPolicy{
+			TTL:   refreshInterval,
+			Clock: clock.RealClock{},
+		}),
+		serverTypes: serverTypes,
+	}
+}
+
+func (m *priceCache) prices() (map[string]instancePrice, error) {
+	klog.V(4).Info("Building DataCrunch price table from server types")
+
+	serverTypes, err := m.serverTypes.getAllServerTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	prices := make(map[string]instancePrice, len(serverTypes))
+	for _, serverType := range serverTypes {
+		onDemand, err := strconv.ParseFloat(serverType.PricePerHour, 64)
+		if err != nil {
+			klog.Warningf("failed to parse price %q of server type %s: %v", serverType.PricePerHour, serverType.InstanceType, err)
+			continue
+		}
+
+		price := instancePrice{onDemand: onDemand}
+		if serverType.SpotPrice != "" {
+			spot, err := strconv.ParseFloat(serverType.SpotPrice, 64)
+			if err != nil {
+				klog.Warningf("failed to parse spot price %q of server type %s: %v", serverType.SpotPrice, serverType.InstanceType, err)
+			} else {
+				price.spot = spot
+			}
+		}
+		prices[serverType.InstanceType] = price
+	}
+
+	cacheObject := priceCachedObject{
+		name:   priceCacheKey,
+		prices: prices,
+	}
+
+	if err := m.Add(cacheObject); err != nil {
+		return nil, err
+	}
+
+	return prices, nil
+}
+
+func (m *priceCache) getAllPrices() (map[string]instancePrice, error) {
+	// List expires old entries
+	cacheList := m.List()
+	klog.V(5).Infof("Current priceCache len: %d\n", len(cacheList))
+
+	if obj, found, err := m.GetByKey(priceCacheKey); err == nil && found {
+		return obj.(priceCachedObject).prices, nil
+	}
+
+	return m.prices()
+}
+
+// getPrice returns the hourly price of the given instance type. Spot prices
+// fall back to the on-demand price when the catalog does not publish one, so
+// that a spot node is never considered free.
+func (m *priceCache) getPrice(instanceType string, isSpot bool) (float64, error) {
+	prices, err := m.getAllPrices()
+	if err != nil {
+		return 0, err
+	}
+
+	price, found := prices[instanceType]
+	if !found {
+		return 0, fmt.Errorf("no price found for instance type %s", instanceType)
+	}
+
+	if isSpot {
+		if price.spot > 0 {
+			return price.spot, nil
+		}
+		klog.V(4).Infof("no spot price found for instance type %s, using on-demand price", instanceType)
+	}
+
+	return price.onDemand, nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	podutils "k8s.io/autoscaler/cluster-autoscaler/utils/pod"
+	"k8s.io/klog/v2"
+)
+
+var (
+	// podBaselineCPU and podBaselineMemory are priced for pods which don't
+	// request CPU or memory, so that they are never free.
+	podBaselineCPU    = resource.MustParse("100m")
+	podBaselineMemory = resource.MustParse("128Mi")
+)
+
+var _ cloudprovider.PricingModel = (*datacrunchPriceModel)(nil)
+
+// datacrunchPriceModel implements the cloudprovider.PricingModel interface
+// based on the published hourly prices of the DataCrunch instance types.
+type datacrunchPriceModel struct {
+	manager *datacrunchManager
+}
+
+// NodePrice returns a price of running the given node for a given period of time.
+// All prices are in the currency of the DataCrunch project.
+func (model *datacrunchPriceModel) NodePrice(node *apiv1.Node, startTime time.Time, endTime time.Time) (float64, error) {
+	instanceType, isSpot, err := model.nodeInstanceType(node)
+	if err != nil {
+		return 0, err
+	}
+
+	pricePerHour, err := model.manager.cachedPrices.getPrice(instanceType, isSpot)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get price for node %s: %v", node.Name, err)
+	}
+
+	return pricePerHour * getHours(startTime, endTime), nil
+}
+
+// PodPrice returns a theoretical minimum price of running a pod for a given
+// period of time on a perfectly matching machine. The pod pays the share of
+// the on-demand price of an instance type given by the largest fraction of
+// its CPU, memory and GPU capacity the pod requests, on GPU instance types the
+// GPUs usually dominate. The cheapest instance type is used, pods requesting
+// GPUs are only priced on instance types with GPUs.
+func (model *datacrunchPriceModel) PodPrice(pod *apiv1.Pod, startTime time.Time, endTime time.Time) (float64, error) {
+	requests := podutils.PodRequests(pod)
+	cpu := requests.Cpu().MilliValue()
+	if cpu == 0 {
+		cpu = podBaselineCPU.MilliValue()
+	}
+	memory := requests.Memory().Value()
+	if memory == 0 {
+		memory = podBaselineMemory.Value()
+	}
+	gpus := int64(0)
+	for _, name := range model.manager.gpuResourceNames() {
+		if gpu, found := requests[name]; found {
+			gpus += gpu.Value()
+		}
+	}
+
+	serverTypes, err := model.manager.cachedServerType.getAllServerTypes()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get server types: %v", err)
+	}
+
+	pricePerHour := math.Inf(1)
+	for _, serverType := range serverTypes {
+		if serverType.CPU.NumberOfCores <= 0 || serverType.Memory.SizeInGigabytes <= 0 {
+			continue
+		}
+		if gpus > 0 && serverType.GPU.NumberOfGPUs <= 0 {
+			continue
+		}
+		price, err := model.manager.cachedPrices.getPrice(serverType.InstanceType, false)
+		if err != nil {
+			klog.V(4).Infof("Skipping server type %s for pod price: %v", serverType.InstanceType, err)
+			continue
+		}
+
+		share := math.Max(
+			float64(cpu)/float64(serverType.CPU.NumberOfCores*1000),
+			float64(memory)/float64(int64(serverType.Memory.SizeInGigabytes)*1024*1024*1024),
+		)
+		if gpus > 0 {
+			share = math.Max(share, float64(gpus)/float64(serverType.GPU.NumberOfGPUs))
+		}
+		pricePerHour = math.Min(pricePerHour, price*share)
+	}
+	if math.IsInf(pricePerHour, 1) {
+		return 0, fmt.Errorf("no priced server type to run pod %s/%s on", pod.Namespace, pod.Name)
+	}
+
+	return pricePerHour * getHours(startTime, endTime), nil
+}
+
+// nodeInstanceType returns the instance type of the node and whether it runs
+// on spot capacity. Existing servers are looked up directly, template nodes
+// are resolved via their labels and the configuration of their node group.
+func (model *datacrunchPriceModel) nodeInstanceType(node *apiv1.Node) (string, bool, error) {
+	instance, err := model.manager.serverForNode(node)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get instance for node %s: %v", node.Name, err)
+	}
+	if instance != nil {
+		return instance.InstanceType, instance.IsSpot, nil
+	}
+
+	instanceType, found := node.Labels[apiv1.LabelInstanceType]
+	if !found {
+		return "", false, fmt.Errorf("failed to determine instance type of node %s", node.Name)
+	}
+
+	isSpot := false
+	if group, found := model.manager.nodeGroups[node.Labels[nodeGroupLabel]]; found {
+		isSpot = group.prefersSpot()
+	}
+
+	return instanceType, isSpot, nil
+}
+
+func getHours(startTime time.Time, endTime time.Time) float64 {
+	minutes := endTime.Sub(startTime).Minutes()
+	hours := minutes / 60.0
+	return hours
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
+)
+
+func TestNodePrice(t *testing.T) {
+	serverTypes := []*datacrunchclient.InstanceType{
+		{InstanceType: "1A100.22V", PricePerHour: "1.50", SpotPrice: "0.50"},
+		{InstanceType: "CPU.4V.16G", PricePerHour: "0.20"},
+	}
+	servers := []*datacrunchclient.Instance{
+		{ID: "id1", Hostname: "gpu-pool-1", InstanceType: "1A100.22V", IsSpot: true},
+		{ID: "id2", Hostname: "gpu-pool-2", InstanceType: "1A100.22V"},
+	}
+	manager := newTestManager(t, serverTypes, servers)
+	manager.clusterConfig.NodeConfigs["spot-pool"] = &NodeConfig{InstanceOption: InstanceOptionSpotOnly}
+	manager.nodeGroups["spot-pool"] = &datacrunchNodeGroup{id: "spot-pool", manager: manager, instanceType: "1A100.22V"}
+	model := &datacrunchPriceModel{manager: manager}
+
+	start := time.Now()
+	end := start.Add(2 * time.Hour)
+
+	tests := []struct {
+		name     string
+		node     *apiv1.Node
+		expected float64
+	}{
+		{
+			name:     "spot server",
+			node:     &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "gpu-pool-1"}},
+			expected: 1.0,
+		},
+		{
+			name:     "on-demand server",
+			node:     &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "gpu-pool-2"}},
+			expected: 3.0,
+		},
+		{
+			name: "template node of spot node group",
+			node: &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "spot-pool-template", Labels: map[string]string{
+				apiv1.LabelInstanceType: "1A100.22V",
+				nodeGroupLabel:          "spot-pool",
+			}}},
+			expected: 1.0,
+		},
+		{
+			name: "spot price falls back to on-demand price",
+			node: &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "cpu-template", Labels: map[string]string{
+				apiv1.LabelInstanceType: "CPU.4V.16G",
+				nodeGroupLabel:          "spot-pool",
+			}}},
+			expected: 0.4,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			price, err := model.NodePrice(tc.node, start, end)
+			require.NoError(t, err)
+			assert.InDelta(t, tc.expected, price, 1e-9)
+		})
+	}
+}
+
+func TestNodePriceUnknownInstanceType(t *testing.T) {
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V", PricePerHour: "1.50"}}, nil)
+	model := &datacrunchPriceModel{manager: manager}
+
+	node := &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "unknown", Labels: map[string]string{
+		apiv1.LabelInstanceType: "8H100.80S",
+	}}}
+	_, err := model.NodePrice(node, time.Now(), time.Now().Add(time.Hour))
+	require.Error(t, err)
+
+	_, err = model.NodePrice(&apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "no-labels"}}, time.Now(), time.Now().Add(time.Hour))
+	require.Error(t, err)
+}
+
+func TestPodPrice(t *testing.T) {
+	serverTypes := []*datacrunchclient.InstanceType{
+		{
+			InstanceType: "8A100.176V", PricePerHour: "32.00",
+			CPU: datacrunchclient.CPU{NumberOfCores: 176}, Memory: datacrunchclient.Memory{SizeInGigabytes: 960}, GPU: datacrunchclient.GPU{NumberOfGPUs: 8},
+		},
+		{
+			InstanceType: "CPU.4V.16G", PricePerHour: "0.40",
+			CPU: datacrunchclient.CPU{NumberOfCores: 4}, Memory: datacrunchclient.Memory{SizeInGigabytes: 16},
+		},
+	}
+	manager := newTestManager(t, serverTypes, nil)
+	model := &datacrunchPriceModel{manager: manager}
+
+	podRequesting := func(requests apiv1.ResourceList) *apiv1.Pod {
+		return &apiv1.Pod{Spec: apiv1.PodSpec{Containers: []apiv1.Container{{Resources: apiv1.ResourceRequirements{Requests: requests}}}}}
+	}
+	start := time.Now()
+	end := start.Add(2 * time.Hour)
+
+	oneGPU, err := model.PodPrice(podRequesting(apiv1.ResourceList{
+		apiv1.ResourceCPU: resource.MustParse("4"),
+		ResourceGPU:       resource.MustParse("1"),
+	}), start, end)
+	require.NoError(t, err)
+	twoGPUs, err := model.PodPrice(podRequesting(apiv1.ResourceList{
+		apiv1.ResourceCPU: resource.MustParse("4"),
+		ResourceGPU:       resource.MustParse("2"),
+	}), start, end)
+	require.NoError(t, err)
+	// the GPUs dominate the price, one of eight GPUs for two hours
+	assert.InDelta(t, 8.0, oneGPU, 1e-9)
+	assert.InDelta(t, 2*oneGPU, twoGPUs, 1e-9)
+
+	// pods without GPUs are priced on the cheapest server type
+	cpuPod, err := model.PodPrice(podRequesting(apiv1.ResourceList{
+		apiv1.ResourceCPU:    resource.MustParse("2"),
+		apiv1.ResourceMemory: resource.MustParse("4Gi"),
+	}), start, end)
+	require.NoError(t, err)
+	assert.InDelta(t, 0.4, cpuPod, 1e-9)
+
+	// pods without requests are priced by the baseline
+	emptyPod, err := model.PodPrice(podRequesting(nil), start, end)
+	require.NoError(t, err)
+	assert.Positive(t, emptyPod)
+	assert.Less(t, emptyPod, cpuPod)
+}
+
+func TestPriceScalesWithGPUs(t *testing.T) {
+	serverTypes := []*datacrunchclient.InstanceType{
+		{
+			InstanceType: "1H100.80S.30V", PricePerHour: "2.50",
+			CPU: datacrunchclient.CPU{NumberOfCores: 30}, Memory: datacrunchclient.Memory{SizeInGigabytes: 120}, GPU: datacrunchclient.GPU{NumberOfGPUs: 1},
+		},
+		{
+			InstanceType: "8H100.80S.176V", PricePerHour: "20.00",
+			CPU: datacrunchclient.CPU{NumberOfCores: 176}, Memory: datacrunchclient.Memory{SizeInGigabytes: 1480}, GPU: datacrunchclient.GPU{NumberOfGPUs: 8},
+		},
+	}
+	manager := newTestManager(t, serverTypes, nil)
+	model := &datacrunchPriceModel{manager: manager}
+	start := time.Now()
+	end := start.Add(time.Hour)
+
+	templateNode := func(instanceType string) *apiv1.Node {
+		return &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: instanceType + "-template", Labels: map[string]string{apiv1.LabelInstanceType: instanceType}}}
+	}
+	oneGPUNode, err := model.NodePrice(templateNode("1H100.80S.30V"), start, end)
+	require.NoError(t, err)
+	eightGPUNode, err := model.NodePrice(templateNode("8H100.80S.176V"), start, end)
+	require.NoError(t, err)
+	// the full published price of the instance types, proportional to their GPUs
+	assert.InDelta(t, 2.5, oneGPUNode, 1e-9)
+	assert.InDelta(t, 8*oneGPUNode, eightGPUNode, 1e-9)
+
+	// a pod requesting one GPU pays for one GPU, whatever its CPU requests,
+	// so it fully uses the 1-GPU node but only an eighth of the 8-GPU node
+	pod := &apiv1.Pod{Spec: apiv1.PodSpec{Containers: []apiv1.Container{{Resources: apiv1.ResourceRequirements{Requests: apiv1.ResourceList{
+		apiv1.ResourceCPU: resource.MustParse("1"),
+		ResourceGPU:       resource.MustParse("1"),
+	}}}}}}
+	podPrice, err := model.PodPrice(pod, start, end)
+	require.NoError(t, err)
+	assert.InDelta(t, oneGPUNode, podPrice, 1e-9)
+	assert.InDelta(t, 1.0/8, podPrice/eightGPUNode, 1e-9)
+}
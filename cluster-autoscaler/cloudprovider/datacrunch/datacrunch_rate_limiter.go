@@ -0,0 +1,191 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/klog/v2"
+
+	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
+)
+
+// newCreateRateLimiter returns the limiter of a node group adding rate
+// servers per minute, one at a time. A zero rate returns nil, the node group
+// is only limited by the rate limit of the client.
+func newCreateRateLimiter(rate float64) flowcontrol.RateLimiter {
+	if rate == 0 {
+		return nil
+	}
+	return flowcontrol.NewTokenBucketRateLimiter(float32(rate/60), 1)
+}
+
+// waitForCreateRate blocks until the create rate limit of the node group
+// allows to add count servers, at most for the create timeout. It is waited
+// for once the increase was checked, before clusterUpdateMutex is acquired
+// for the creates, so the other node groups are scaled up in the meantime.
+func (n *datacrunchNodeGroup) waitForCreateRate(count int) error {
+	if n.createLimiter == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(n.manager.apiCallContext, n.createTimeout)
+	defer cancel()
+	start := time.Now()
+	for i := 0; i < count; i++ {
+		if err := n.createLimiter.Wait(ctx); err != nil {
+			return fmt.Errorf("create rate limit of node group %s does not allow %d servers within %s: %w", n.id, count, n.createTimeout, err)
+		}
+	}
+	if waited := time.Since(start); waited >= time.Second {
+		klog.V(2).Infof("%v Waited %s for the create rate limit", n.logFields(), waited.Round(time.Second))
+	}
+	return nil
+}
+
+// rateLimitedClient passes all API calls of the manager through a single
+// token bucket, so the node groups together stay below the rate limit of the
+// DataCrunch account. Waiting calls return early when their context is done.
+type rateLimitedClient struct {
+	client  datacrunchAPIClient
+	limiter flowcontrol.RateLimiter
+}
+
+var _ datacrunchAPIClient = (*rateLimitedClient)(nil)
+
+// newRateLimitedClient limits the client to qps requests per second, bursts
+// of up to one second of requests are allowed.
+func newRateLimitedClient(client datacrunchAPIClient, qps float64) *rateLimitedClient {
+	burst := int(math.Max(1, math.Floor(qps)))
+	return &rateLimitedClient{
+		client:  client,
+		limiter: flowcontrol.NewTokenBucketRateLimiter(float32(qps), burst),
+	}
+}
+
+func (c *rateLimitedClient) ListInstances(ctx context.Context, status string) (datacrunchclient.InstanceList, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.ListInstances(ctx, status)
+}
+
+func (c *rateLimitedClient) GetInstance(ctx context.Context, id string) (*datacrunchclient.Instance, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.GetInstance(ctx, id)
+}
+
+func (c *rateLimitedClient) DeployInstance(ctx context.Context, reqBody datacrunchclient.DeployInstanceRequest) (string, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return "", err
+	}
+	return c.client.DeployInstance(ctx, reqBody)
+}
+
+func (c *rateLimitedClient) PerformInstanceAction(ctx context.Context, reqBody datacrunchclient.InstanceActionRequest) error {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return c.client.PerformInstanceAction(ctx, reqBody)
+}
+
+func (c *rateLimitedClient) ListInstanceTypes(ctx context.Context) (datacrunchclient.InstanceTypeList, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.ListInstanceTypes(ctx)
+}
+
+func (c *rateLimitedClient) GetInstanceTypeAvailability(ctx context.Context, instanceType string, isSpot bool, locationCode string) (bool, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return false, err
+	}
+	return c.client.GetInstanceTypeAvailability(ctx, instanceType, isSpot, locationCode)
+}
+
+func (c *rateLimitedClient) ListInstanceAvailability(ctx context.Context, isSpot bool, locationCode string) (datacrunchclient.InstanceAvailabilityList, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.ListInstanceAvailability(ctx, isSpot, locationCode)
+}
+
+func (c *rateLimitedClient) UploadStartupScript(ctx context.Context, name string, script string) (string, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return "", err
+	}
+	return c.client.UploadStartupScript(ctx, name, script)
+}
+
+func (c *rateLimitedClient) ListVolumes(ctx context.Context, status string) ([]datacrunchclient.Volume, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.ListVolumes(ctx, status)
+}
+
+func (c *rateLimitedClient) ListVolumesInTrash(ctx context.Context) ([]datacrunchclient.VolumeInTrash, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.ListVolumesInTrash(ctx)
+}
+
+func (c *rateLimitedClient) DeleteVolume(ctx context.Context, volumeID string, isPermanent bool) error {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return c.client.DeleteVolume(ctx, volumeID, isPermanent)
+}
+
+func (c *rateLimitedClient) ListSSHKeys(ctx context.Context) ([]datacrunchclient.SSHKey, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.ListSSHKeys(ctx)
+}
+
+func (c *rateLimitedClient) ListImages(ctx context.Context) ([]datacrunchclient.ImageInfoResponseDto, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.ListImages(ctx)
+}
+
+func (c *rateLimitedClient) ListStartupScripts(ctx context.Context) ([]datacrunchclient.StartupScript, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.ListStartupScripts(ctx)
+}
+
+func (c *rateLimitedClient) DeleteStartupScript(ctx context.Context, scriptID string) error {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return c.client.DeleteStartupScript(ctx, scriptID)
+}
+
+func (c *rateLimitedClient) CloseIdleConnections() {
+	c.client.CloseIdleConnections()
+}
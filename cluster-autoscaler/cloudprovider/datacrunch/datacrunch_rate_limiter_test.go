@@ -0,0 +1,121 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/util/flowcontrol"
+
+	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
+)
+
+func TestRateLimitedClient(t *testing.T) {
+	fake := newFakeClient(nil, nil)
+	client := newRateLimitedClient(fake, 20)
+
+	// 20 calls after the burst of 20 take at least a second
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range 40 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.ListInstances(context.Background(), "")
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	assert.Equal(t, int32(40), fake.calls.Load())
+	assert.GreaterOrEqual(t, elapsed, 900*time.Millisecond)
+	assert.Less(t, elapsed, 3*time.Second)
+
+	// waiting calls return when their context is done, without calling the API
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := client.ListInstanceTypes(ctx)
+	require.Error(t, err)
+	assert.Equal(t, int32(40), fake.calls.Load())
+}
+
+// blockingRateLimiter lets waiting calls pass once it is released.
+type blockingRateLimiter struct {
+	flowcontrol.RateLimiter
+	waiting chan struct{}
+	release chan struct{}
+}
+
+func (l *blockingRateLimiter) Wait(ctx context.Context) error {
+	l.waiting <- struct{}{}
+	select {
+	case <-l.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestCreateRateLimitDoesNotBlockOtherNodeGroups(t *testing.T) {
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+	throttled := newTestNodeGroup(manager, "throttled", 0, 5)
+	other := newTestNodeGroup(manager, "other", 0, 5)
+	limiter := &blockingRateLimiter{waiting: make(chan struct{}), release: make(chan struct{})}
+	throttled.createLimiter = limiter
+	client := fakeClientOf(manager)
+
+	done := make(chan error)
+	go func() {
+		done <- throttled.IncreaseSize(1)
+	}()
+	<-limiter.waiting
+
+	// the throttled node group waits without holding up the other one
+	require.NoError(t, other.IncreaseSize(2))
+	size, _ := other.TargetSize()
+	assert.Equal(t, 2, size)
+	assert.Len(t, client.deployed, 2)
+
+	close(limiter.release)
+	require.NoError(t, <-done)
+	size, _ = throttled.TargetSize()
+	assert.Equal(t, 1, size)
+	assert.Len(t, client.deployed, 3)
+}
+
+func TestCreateRateLimit(t *testing.T) {
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+	group := newTestNodeGroup(manager, "pool", 0, 5)
+	group.createLimiter = newCreateRateLimiter(1)
+	assert.Nil(t, newCreateRateLimiter(0))
+
+	// rejected increases take no tokens
+	require.Error(t, group.IncreaseSize(6))
+	require.NoError(t, group.IncreaseSize(1))
+	// the next server is allowed in a minute, after the create timeout
+	group.createTimeout = time.Second
+	err := group.IncreaseSize(1)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "create rate limit of node group pool")
+	size, _ := group.TargetSize()
+	assert.Equal(t, 1, size)
+}
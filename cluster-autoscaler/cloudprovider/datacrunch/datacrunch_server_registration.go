@@ -0,0 +1,191 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
+	"k8s.io/klog/v2"
+)
+
+var (
+	// errProvisionTimeout is returned if a server did not run within the
+	// create timeout of its node group.
+	errProvisionTimeout = errors.New("server provision timeout")
+	// errRegisterTimeout is returned if a server runs, but its node did not
+	// join the cluster within the register timeout of its node group.
+	errRegisterTimeout = errors.New("server register timeout")
+)
+
+// serverRegistrationPollInterval is the interval at which the state of a
+// created server is polled.
+const serverRegistrationPollInterval = 15 * time.Second
+
+// Phases of a created server, until its node registered in the cluster.
+const (
+	serverPhaseProvisioning = "provisioning"
+	serverPhaseBooting      = "booting"
+	serverPhaseRegistered   = "registered"
+)
+
+// waitForServerRegistration polls the server until its node registered in the
+// cluster, which the manager notices when the node is looked up. The server
+// has to run within the provision timeout and its node has to register within
+// the register timeout, both counted from the call. Phase transitions are
+// logged at V(2), so slow joins can be told apart from stuck servers.
+func (m *datacrunchManager) waitForServerRegistration(ctx context.Context, serverID string, provisionTimeout, registerTimeout time.Duration) error {
+	start := time.Now()
+	phase := serverPhaseProvisioning
+	ticker := time.NewTicker(m.registrationPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("stopped waiting for server %s in phase %s: %w", serverID, phase, ctx.Err())
+		case <-ticker.C:
+		}
+		elapsed := time.Since(start)
+
+		if m.orphans.isRegistered(serverID) {
+			klog.V(2).Infof("Server %s is %s after %s", serverID, serverPhaseRegistered, elapsed.Round(time.Second))
+			return nil
+		}
+
+		apiCtx, cancel := m.apiContext()
+		server, err := m.client.GetInstance(apiCtx, serverID)
+		cancel()
+		switch {
+		case isNotFoundError(err):
+			return fmt.Errorf("server %s was deleted in phase %s: %w", serverID, phase, errServerNotFound)
+		case err != nil:
+			klog.V(4).Infof("Failed to get server %s in phase %s, retrying: %v", serverID, phase, err)
+		default:
+			status := toInstanceStatus(server)
+			if status != nil && status.ErrorInfo != nil {
+				return fmt.Errorf("server %s failed in status %s after %s", serverID, server.Status, elapsed.Round(time.Second))
+			}
+			if status != nil && status.State == cloudprovider.InstanceRunning && phase == serverPhaseProvisioning {
+				phase = serverPhaseBooting
+				klog.V(2).Infof("Server %s is %s after %s, waiting for its node to register", serverID, phase, elapsed.Round(time.Second))
+			}
+		}
+
+		if phase == serverPhaseProvisioning && elapsed >= provisionTimeout {
+			return fmt.Errorf("%w: server %s did not run within %s", errProvisionTimeout, serverID, provisionTimeout)
+		}
+		if elapsed >= registerTimeout {
+			return fmt.Errorf("%w: node of server %s did not register within %s", errRegisterTimeout, serverID, registerTimeout)
+		}
+	}
+}
+
+// waitForServersRunning waits concurrently for the created servers of the
+// node group to run. Servers which run are passed to serverCreated, the
+// errors of the servers which do not are returned, they are counted as
+// failed creates.
+func (m *datacrunchManager) waitForServersRunning(n *datacrunchNodeGroup, servers []createdServer) []error {
+	waitGroup := sync.WaitGroup{}
+	errsCh := make(chan error, len(servers))
+	for _, server := range servers {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := m.waitForServerRunning(n, server.id, server.deadline); err != nil {
+				serverCreateFailuresTotal.WithLabelValues(n.id, server.placement.region).Inc()
+				errsCh <- err
+				return
+			}
+			m.serverCreated(n, server)
+		}()
+	}
+	waitGroup.Wait()
+	close(errsCh)
+
+	errs := make([]error, 0, len(servers))
+	for err := range errsCh {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// waitForServerRunning polls the created server of the node group until it
+// runs. A server which fails or does not run by the deadline of its create is
+// deleted, so the scale up leaves no servers behind which never provision.
+// A server whose wait is stopped by Cleanup is kept, it is adopted by the
+// next run of the autoscaler.
+func (m *datacrunchManager) waitForServerRunning(n *datacrunchNodeGroup, serverID string, deadline time.Time) error {
+	fields := logFields{nodeGroup: n.id, server: serverID}
+	ticker := time.NewTicker(m.registrationPollInterval)
+	defer ticker.Stop()
+
+	var server *datacrunchclient.Instance
+	var err error
+	for err == nil {
+		apiCtx, cancel := m.apiContext()
+		polled, getErr := m.client.GetInstance(apiCtx, serverID)
+		cancel()
+		switch {
+		case isNotFoundError(getErr):
+			return fmt.Errorf("server %s was deleted before it ran: %w", serverID, errServerNotFound)
+		case getErr != nil:
+			klog.V(4).Infof("%v Failed to get server while waiting for it to run, retrying: %v", fields, getErr)
+		default:
+			server = polled
+			// serverStatus reports running servers whose nodes did not
+			// register yet as creating, so it only decides whether the
+			// server is deleted or failed, e.g. its validation
+			status := m.serverStatus(server)
+			if status != nil && status.State == cloudprovider.InstanceDeleting {
+				return fmt.Errorf("server %s is deleted before it ran: %w", serverID, errServerNotFound)
+			}
+			if status != nil && status.ErrorInfo != nil {
+				err = fmt.Errorf("server %s failed in status %s before it ran", serverID, server.Status)
+				continue
+			}
+			if status := toInstanceStatus(server); status != nil && status.State == cloudprovider.InstanceRunning {
+				return nil
+			}
+		}
+
+		if !time.Now().Before(deadline) {
+			err = fmt.Errorf("%w: server %s did not run within %s", errProvisionTimeout, serverID, n.createTimeout)
+			continue
+		}
+		select {
+		case <-m.backgroundCtx.Done():
+			return fmt.Errorf("stopped waiting for server %s to run: %w", serverID, m.backgroundCtx.Err())
+		case <-ticker.C:
+		}
+	}
+
+	if server == nil {
+		klog.Warningf("%v Could not delete server which did not run, it was never returned by the DataCrunch API: %v", fields, err)
+		return err
+	}
+	klog.Warningf("%v Deleting server which did not run: %v", fields, err)
+	if deleteErr := m.deleteServer(server); deleteErr != nil {
+		return fmt.Errorf("%w, failed to delete it: %v", err, deleteErr)
+	}
+	return err
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
+)
+
+func TestWaitForServerRegistration(t *testing.T) {
+	servers := []*datacrunchclient.Instance{
+		{ID: "provisioned", Hostname: "pool-1a", Status: "running"},
+		{ID: "stuck", Hostname: "pool-2b", Status: "provisioning"},
+		{ID: "registered", Hostname: "pool-3c", Status: "running"},
+		{ID: "failed", Hostname: "pool-4d", Status: "installation_failed"},
+	}
+	manager := newTestManager(t, nil, servers)
+	manager.registrationPollInterval = 10 * time.Millisecond
+	manager.orphans.markRegistered("registered")
+	ctx := context.Background()
+
+	// the server provisions quickly, but its node never registers
+	err := manager.waitForServerRegistration(ctx, "provisioned", 50*time.Millisecond, 200*time.Millisecond)
+	assert.ErrorIs(t, err, errRegisterTimeout)
+	assert.NotErrorIs(t, err, errProvisionTimeout)
+
+	err = manager.waitForServerRegistration(ctx, "stuck", 50*time.Millisecond, 200*time.Millisecond)
+	assert.ErrorIs(t, err, errProvisionTimeout)
+
+	assert.NoError(t, manager.waitForServerRegistration(ctx, "registered", 50*time.Millisecond, 200*time.Millisecond))
+
+	err = manager.waitForServerRegistration(ctx, "failed", 50*time.Millisecond, 200*time.Millisecond)
+	assert.ErrorContains(t, err, "failed in status installation_failed")
+
+	err = manager.waitForServerRegistration(ctx, "deleted", 50*time.Millisecond, 200*time.Millisecond)
+	assert.ErrorIs(t, err, errServerNotFound)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	err = manager.waitForServerRegistration(cancelled, "provisioned", time.Minute, time.Hour)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestIncreaseSizeWaitForRunning(t *testing.T) {
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+	manager.waitForRunning = true
+	manager.registrationPollInterval = 5 * time.Millisecond
+	group := newTestNodeGroup(manager, "pool", 0, 5)
+	group.createTimeout = 50 * time.Millisecond
+	client := fakeClientOf(manager)
+
+	// the servers never run
+	start := time.Now()
+	err := group.IncreaseSize(2)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, errProvisionTimeout)
+	assert.Less(t, time.Since(start), time.Second, "the scale up waits for the create timeout only")
+	size, err := group.TargetSize()
+	require.NoError(t, err)
+	assert.Zero(t, size, "the target size is reverted")
+	require.Len(t, client.deployed, 2)
+	assert.ElementsMatch(t, []string{"deployed-1", "deployed-2"}, client.deleted, "started servers are deleted")
+
+	// servers which run are kept, although their nodes did not register yet
+	group.resetBackoff()
+	client.deployedErr = func(req datacrunchclient.DeployInstanceRequest) error {
+		client.servers[len(client.servers)-1].Status = "running"
+		return nil
+	}
+	require.NoError(t, group.IncreaseSize(1))
+	size, err = group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 1, size)
+	assert.Len(t, client.deleted, 2)
+	assert.NotContains(t, client.deleted, "deployed-3", "the running server is kept")
+	server, err := manager.client.GetInstance(context.Background(), "deployed-3")
+	require.NoError(t, err)
+	assert.Equal(t, "running", server.Status)
+
+	// the servers are waited for without holding clusterUpdateMutex
+	group.createTimeout = time.Minute
+	created := make(chan struct{})
+	client.deployedErr = func(req datacrunchclient.DeployInstanceRequest) error {
+		close(created)
+		return nil
+	}
+	result := make(chan error, 1)
+	go func() { result <- group.IncreaseSize(1) }()
+	<-created
+	assert.Eventually(t, func() bool {
+		if !group.clusterUpdateMutex.TryLock() {
+			return false
+		}
+		group.clusterUpdateMutex.Unlock()
+		return true
+	}, time.Second, 5*time.Millisecond, "clusterUpdateMutex is released while the server is waited for")
+	client.mu.Lock()
+	client.servers[len(client.servers)-1].Status = "running"
+	client.mu.Unlock()
+	require.NoError(t, <-result)
+	size, err = group.TargetSize()
+	require.NoError(t, err)
+	assert.Equal(t, 2, size)
+}
@@ -19,6 +19,9 @@ package datacrunch
 import (
 	"context"
 	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -30,11 +33,36 @@ import (
 )
 
 const (
-	serverTypeCacheKey    = "datacrunch-server-type-cache"
-	serverTypeCachedTTL   = time.Minute * 10
-	serverTypeCacheMinTTL = 5
-	serverTypeCacheMaxTTL = 60
-	availabilityCacheTTL  = time.Minute * 1 // Refresh availability cache every minute
+	serverTypeCacheKey        = "datacrunch-server-type-cache"
+	serverTypeCacheTTLDefault = time.Minute * 5
+	serverTypeCacheMinTTL     = 5
+	serverTypeCacheMaxTTL     = 60
+	availabilityCacheTTL      = time.Minute * 1 // Refresh availability cache every minute
+)
+
+var (
+	// errServerTypeNotFound is returned if the catalog has no such server type.
+	errServerTypeNotFound = errors.New("server type not found")
+	// errServerTypesUnavailable is returned if the catalog was never fetched
+	// successfully, e.g. because the API was unavailable at startup.
+	errServerTypesUnavailable = errors.New("server type catalog unavailable")
+)
+
+// knownRegions are the DataCrunch regions. The regions listed by the
+// availability API are accepted too, so a new region can be used before it
+// is added here.
+var knownRegions = []string{"FIN-01", "FIN-02", "FIN-03", "ICE-01"}
+
+var (
+	// serverTypeCacheRetryInterval is the delay after which run retries a
+	// failed refresh of the catalog. It doubles with every refresh which
+	// failed in a row, up to the TTL.
+	serverTypeCacheRetryInterval = 30 * time.Second
+	// serverTypeCacheBreakerThreshold is the number of refreshes in a row
+	// which have to fail to open the circuit breaker of run, which does not
+	// refresh the catalog for serverTypeCacheBreakerCooldown then.
+	serverTypeCacheBreakerThreshold = 5
+	serverTypeCacheBreakerCooldown  = 10 * time.Minute
 )
 
 // Add availability cache to serverTypeCache
@@ -54,11 +82,31 @@ type availabilityCacheEntry struct {
 type serverTypeCache struct {
 	cache.Store
 	mngJitterClock          clock.Clock
-	datacrunchClient        *datacrunchclient.Client
+	datacrunchClient        datacrunchAPIClient
 	datacrunchClientContext context.Context
+	apiCallTimeout          time.Duration
 
 	availabilityCache map[availabilityKey]availabilityCacheEntry
 	availabilityMu    sync.RWMutex
+
+	// ttl is the interval in which the catalog is refreshed by run.
+	ttl          time.Duration
+	refreshClock clock.WithTicker
+
+	// lastGood is the last catalog fetched successfully. It is served while
+	// the catalog is expired and when refreshing it fails.
+	lastGood   []*datacrunchclient.InstanceType
+	lastGoodMu sync.RWMutex
+
+	// regionAvailability holds the server types available per region, as of
+	// the last catalog refresh. Regions missing from it are not restricted.
+	regionAvailability map[string]map[string]bool
+
+	// refreshFailures is the number of refreshes by run which failed in a
+	// row, the circuit breaker is open until breakerOpenUntil.
+	refreshFailures  int
+	breakerOpenUntil time.Time
+	breakerMu        sync.Mutex
 }
 
 type serverTypeClock struct {
@@ -83,7 +131,7 @@ type serverTypeCachedObject struct {
 	serverTypes []*datacrunchclient.InstanceType
 }
 
-func newServerTypeCache(ctx context.Context, datacrunchClient *datacrunchclient.Client) *serverTypeCache {
+func newServerTypeCache(ctx context.Context, datacrunchClient datacrunchAPIClient, ttl, apiCallTimeout time.Duration) *serverTypeCache {
 	jc := &serverTypeClock{
 		Clock: clock.RealClock{},
 	}
@@ -94,28 +142,112 @@ func newServerTypeCache(ctx context.Context, datacrunchClient *datacrunchclient.
 		cache.NewExpirationStore(func(obj interface{}) (s string, e error) {
 			return obj.(serverTypeCachedObject).name, nil
 		}, &cache.TTLPolicy{
-			TTL:   serverTypeCachedTTL,
+			TTL:   ttl,
 			Clock: jc,
 		}),
+		ttl,
+		apiCallTimeout,
 	)
 }
 
-func newServerTypeCacheWithClock(ctx context.Context, datacrunchClient *datacrunchclient.Client, jc clock.Clock, store cache.Store) *serverTypeCache {
+func newServerTypeCacheWithClock(ctx context.Context, datacrunchClient datacrunchAPIClient, jc clock.Clock, store cache.Store, ttl, apiCallTimeout time.Duration) *serverTypeCache {
 	return &serverTypeCache{
 		Store:                   store,
 		mngJitterClock:          jc,
 		datacrunchClient:        datacrunchClient,
 		datacrunchClientContext: ctx,
+		apiCallTimeout:          apiCallTimeout,
 		availabilityCache:       make(map[availabilityKey]availabilityCacheEntry),
+		ttl:                     ttl,
+		refreshClock:            clock.RealClock{},
 	}
 }
 
+// run refreshes the server type catalog every ttl until the context is
+// cancelled, so that readers never have to wait for the DataCrunch API. A
+// catalog which was never fetched is retried after
+// serverTypeCacheRetryInterval first. Failed refreshes are retried with
+// exponential backoff, after serverTypeCacheBreakerThreshold failures in a row
+// the circuit breaker opens and the last good catalog is served without
+// calling the API for serverTypeCacheBreakerCooldown.
+func (m *serverTypeCache) run(ctx context.Context) {
+	delay := m.ttl
+	if m.lastGoodServerTypes() == nil {
+		delay = serverTypeCacheRetryInterval
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.refreshClock.After(delay):
+			_, err := m.refresh()
+			delay = m.recordRefresh(err)
+		}
+	}
+}
+
+// recordRefresh records the result of a refresh by run and returns the delay
+// until the next refresh.
+func (m *serverTypeCache) recordRefresh(err error) time.Duration {
+	m.breakerMu.Lock()
+	defer m.breakerMu.Unlock()
+
+	if err == nil {
+		if m.refreshFailures > 0 {
+			klog.Infof("Refreshed server types after %d failed refreshes", m.refreshFailures)
+		}
+		m.refreshFailures = 0
+		m.breakerOpenUntil = time.Time{}
+		return m.ttl
+	}
+
+	m.refreshFailures++
+	if m.refreshFailures >= serverTypeCacheBreakerThreshold {
+		m.breakerOpenUntil = m.refreshClock.Now().Add(serverTypeCacheBreakerCooldown)
+		klog.Warningf("failed to refresh server types %d times in a row, not refreshing them for %s: %v", m.refreshFailures, serverTypeCacheBreakerCooldown, err)
+		return serverTypeCacheBreakerCooldown
+	}
+	delay := serverTypeCacheRetryInterval
+	for i := 1; i < m.refreshFailures && delay < m.ttl; i++ {
+		delay *= 2
+	}
+	delay = min(delay, m.ttl)
+	klog.Warningf("failed to refresh server types, retrying in %s: %v", delay, err)
+	return delay
+}
+
+// breakerOpen returns whether run does not refresh the catalog since too many
+// refreshes failed in a row.
+func (m *serverTypeCache) breakerOpen() bool {
+	m.breakerMu.Lock()
+	defer m.breakerMu.Unlock()
+	return m.refreshClock.Now().Before(m.breakerOpenUntil)
+}
+
+// serverTypes fetches the catalog, the last good catalog is returned if
+// fetching it fails.
 func (m *serverTypeCache) serverTypes() ([]*datacrunchclient.InstanceType, error) {
+	types, err := m.refresh()
+	if err != nil {
+		if lastGood := m.lastGoodServerTypes(); lastGood != nil {
+			klog.Warningf("failed to fetch server types, serving last known catalog: %v", err)
+			return lastGood, nil
+		}
+		return nil, err
+	}
+	return types, nil
+}
+
+// refresh fetches the catalog and stores it as the last good catalog.
+func (m *serverTypeCache) refresh() ([]*datacrunchclient.InstanceType, error) {
 	klog.Warning("Fetching server types from DataCrunch API")
 
-	instanceTypes, err := m.datacrunchClient.ListInstanceTypes()
+	ctx, cancel := context.WithTimeout(m.datacrunchClientContext, m.apiCallTimeout)
+	defer cancel()
+	instanceTypes, err := m.datacrunchClient.ListInstanceTypes(ctx)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", errServerTypesUnavailable, err)
 	}
 
 	// Convert InstanceTypeList (which is []InstanceType) to []*InstanceType
@@ -133,9 +265,75 @@ func (m *serverTypeCache) serverTypes() ([]*datacrunchclient.InstanceType, error
 		return nil, err
 	}
 
+	m.lastGoodMu.Lock()
+	m.lastGood = types
+	m.lastGoodMu.Unlock()
+
+	m.refreshRegionAvailability()
+
 	return types, nil
 }
 
+// refreshRegionAvailability fetches the server types available per region.
+// The previous availability is kept if fetching it fails.
+func (m *serverTypeCache) refreshRegionAvailability() {
+	ctx, cancel := context.WithTimeout(m.datacrunchClientContext, m.apiCallTimeout)
+	defer cancel()
+	availabilities, err := m.datacrunchClient.ListInstanceAvailability(ctx, false, "")
+	if err != nil {
+		klog.Warningf("failed to fetch server type availability per region: %v", err)
+		return
+	}
+
+	regionAvailability := parseRegionAvailability(availabilities)
+	m.lastGoodMu.Lock()
+	m.regionAvailability = regionAvailability
+	m.lastGoodMu.Unlock()
+}
+
+// parseRegionAvailability returns the server types available per region.
+func parseRegionAvailability(availabilities datacrunchclient.InstanceAvailabilityList) map[string]map[string]bool {
+	regionAvailability := make(map[string]map[string]bool, len(availabilities))
+	for _, availability := range availabilities {
+		if availability.LocationCode == "" {
+			continue
+		}
+		serverTypes := make(map[string]bool, len(availability.Availabilities))
+		for _, serverType := range availability.Availabilities {
+			serverTypes[serverType] = true
+		}
+		regionAvailability[availability.LocationCode] = serverTypes
+	}
+	return regionAvailability
+}
+
+// validateRegion returns an error listing the DataCrunch regions if the
+// region is none of them, e.g. because of a typo in a node group spec.
+// Regions are case-insensitive, servers are created in the upper-cased
+// region.
+func (m *serverTypeCache) validateRegion(region string) error {
+	regions := slices.Clone(knownRegions)
+	m.lastGoodMu.RLock()
+	for listed := range m.regionAvailability {
+		if !slices.Contains(regions, strings.ToUpper(listed)) {
+			regions = append(regions, strings.ToUpper(listed))
+		}
+	}
+	m.lastGoodMu.RUnlock()
+
+	if slices.Contains(regions, strings.ToUpper(region)) {
+		return nil
+	}
+	slices.Sort(regions)
+	return fmt.Errorf("unknown region %q, the DataCrunch regions are %s", region, strings.Join(regions, ", "))
+}
+
+func (m *serverTypeCache) lastGoodServerTypes() []*datacrunchclient.InstanceType {
+	m.lastGoodMu.RLock()
+	defer m.lastGoodMu.RUnlock()
+	return m.lastGood
+}
+
 func (m *serverTypeCache) getAllServerTypes() ([]*datacrunchclient.InstanceType, error) {
 	// List expires old entries
 	cacheList := m.List()
@@ -147,9 +345,38 @@ func (m *serverTypeCache) getAllServerTypes() ([]*datacrunchclient.InstanceType,
 		return foundServerTypes.serverTypes, nil
 	}
 
+	// An expired catalog is refreshed in the background by run.
+	if lastGood := m.lastGoodServerTypes(); lastGood != nil {
+		return lastGood, nil
+	}
+
 	return m.serverTypes()
 }
 
+// getServerTypesInRegion returns the server types available in the region.
+// All server types are returned if the availability of the region is unknown.
+func (m *serverTypeCache) getServerTypesInRegion(region string) ([]*datacrunchclient.InstanceType, error) {
+	serverTypes, err := m.getAllServerTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	m.lastGoodMu.RLock()
+	available, found := m.regionAvailability[region]
+	m.lastGoodMu.RUnlock()
+	if !found {
+		return serverTypes, nil
+	}
+
+	result := make([]*datacrunchclient.InstanceType, 0, len(serverTypes))
+	for _, serverType := range serverTypes {
+		if available[serverType.InstanceType] {
+			result = append(result, serverType)
+		}
+	}
+	return result, nil
+}
+
 func (m *serverTypeCache) getServerType(instanceType string) (*datacrunchclient.InstanceType, error) {
 	serverTypes, err := m.getAllServerTypes()
 	if err != nil {
@@ -162,7 +389,7 @@ func (m *serverTypeCache) getServerType(instanceType string) (*datacrunchclient.
 		}
 	}
 
-	return nil, errors.New("server type not found")
+	return nil, errServerTypeNotFound
 }
 
 // GetInstanceTypeAvailabilityCached checks the cache for (instanceType, region) availability, calls the API if not present, and caches the result.
@@ -178,7 +405,9 @@ func (m *serverTypeCache) GetInstanceTypeAvailabilityCached(instanceType, region
 	}
 
 	// Not cached or expired, call API
-	available, err := m.datacrunchClient.GetInstanceTypeAvailability(instanceType, isSpot, region)
+	ctx, cancel := context.WithTimeout(m.datacrunchClientContext, m.apiCallTimeout)
+	defer cancel()
+	available, err := m.datacrunchClient.GetInstanceTypeAvailability(ctx, instanceType, isSpot, region)
 	if err != nil {
 		return false, err
 	}
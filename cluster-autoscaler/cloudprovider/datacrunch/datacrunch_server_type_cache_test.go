@@ -18,22 +18,27 @@ package datacrunch
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
+	testclock "k8s.io/utils/clock/testing"
 )
 
 func TestServerTypeCache(t *testing.T) {
-	c := newServerTypeCache(context.Background(), nil)
+	c := newServerTypeCache(context.Background(), nil, serverTypeCacheTTLDefault, apiCallTimeoutDefault)
 
 	serverTypes := []*datacrunchclient.InstanceType{
 		{
-			Name: "test1",
+			Name:         "test1",
+			InstanceType: "test1",
 		},
 		{
-			Name: "test2",
+			Name:         "test2",
+			InstanceType: "test2",
 		},
 	}
 
@@ -60,3 +65,138 @@ func TestServerTypeCache(t *testing.T) {
 	_, err = c.getServerType("test3")
 	require.Error(t, err)
 }
+
+func TestServerTypeCacheBackgroundRefresh(t *testing.T) {
+	client := newFakeClient([]*datacrunchclient.InstanceType{{Name: "test1", InstanceType: "test1"}}, nil)
+	c := newServerTypeCache(context.Background(), client, serverTypeCacheTTLDefault, apiCallTimeoutDefault)
+	fakeClock := testclock.NewFakeClock(time.Now())
+	c.refreshClock = fakeClock
+
+	_, err := c.getAllServerTypes()
+	require.NoError(t, err)
+	listTypesCalls := func() int {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return client.listTypesCalls
+	}
+	require.Equal(t, 1, listTypesCalls())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.run(ctx)
+		close(done)
+	}()
+	require.Eventually(t, fakeClock.HasWaiters, time.Second, time.Millisecond)
+
+	// the catalog is refreshed once the TTL passed
+	client.mu.Lock()
+	client.listTypesErr = errors.New("API unavailable")
+	client.mu.Unlock()
+	fakeClock.Step(serverTypeCacheTTLDefault + time.Second)
+	require.Eventually(t, func() bool { return listTypesCalls() == 2 }, time.Second, time.Millisecond)
+
+	// the last good catalog is served without calling the API
+	serverTypes, err := c.getAllServerTypes()
+	require.NoError(t, err)
+	require.Len(t, serverTypes, 1)
+	assert.Equal(t, "test1", serverTypes[0].Name)
+
+	cancel()
+	<-done
+	assert.Equal(t, 2, listTypesCalls(), "expected exactly one background refresh")
+}
+
+func TestServerTypeCacheCircuitBreaker(t *testing.T) {
+	client := newFakeClient([]*datacrunchclient.InstanceType{{Name: "test1", InstanceType: "test1"}}, nil)
+	c := newServerTypeCache(context.Background(), client, serverTypeCacheTTLDefault, apiCallTimeoutDefault)
+	fakeClock := testclock.NewFakeClock(time.Now())
+	c.refreshClock = fakeClock
+
+	_, err := c.getAllServerTypes()
+	require.NoError(t, err)
+	listTypesCalls := func() int {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return client.listTypesCalls
+	}
+	setListTypesErr := func(err error) {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		client.listTypesErr = err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	// step waits for the next refresh of run and advances the clock by d
+	step := func(d time.Duration) {
+		t.Helper()
+		require.Eventually(t, fakeClock.HasWaiters, time.Second, time.Millisecond)
+		fakeClock.Step(d)
+	}
+
+	// failed refreshes are retried with exponential backoff
+	setListTypesErr(errors.New("API unavailable"))
+	step(serverTypeCacheTTLDefault)
+	require.Eventually(t, func() bool { return listTypesCalls() == 2 }, time.Second, time.Millisecond)
+	for i, delay := range []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute} {
+		step(delay - time.Millisecond)
+		assert.Equal(t, 2+i, listTypesCalls(), "refresh %d retried before the backoff passed", i+1)
+		fakeClock.Step(time.Millisecond)
+		require.Eventually(t, func() bool { return listTypesCalls() == 3+i }, time.Second, time.Millisecond)
+	}
+
+	// the breaker opens after 5 failures in a row, the last good catalog is
+	// served without calling the API during the cool-down
+	require.Eventually(t, c.breakerOpen, time.Second, time.Millisecond)
+	step(serverTypeCacheBreakerCooldown - time.Second)
+	assert.True(t, c.breakerOpen())
+	serverTypes, err := c.getAllServerTypes()
+	require.NoError(t, err)
+	require.Len(t, serverTypes, 1)
+	assert.Equal(t, 6, listTypesCalls())
+
+	// a successful refresh after the cool-down closes the breaker
+	setListTypesErr(nil)
+	fakeClock.Step(time.Second)
+	require.Eventually(t, func() bool { return listTypesCalls() == 7 }, time.Second, time.Millisecond)
+	require.Eventually(t, fakeClock.HasWaiters, time.Second, time.Millisecond)
+	assert.False(t, c.breakerOpen())
+	c.breakerMu.Lock()
+	assert.Zero(t, c.refreshFailures)
+	c.breakerMu.Unlock()
+
+	// and the catalog is refreshed every TTL again
+	step(serverTypeCacheTTLDefault)
+	require.Eventually(t, func() bool { return listTypesCalls() == 8 }, time.Second, time.Millisecond)
+}
+
+func TestServerTypeCacheRegionAvailability(t *testing.T) {
+	client := newFakeClient([]*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}, {InstanceType: "1H100.80S.30V"}}, nil)
+	client.regionAvailability = datacrunchclient.InstanceAvailabilityList{
+		{LocationCode: "FIN-01", Availabilities: []string{"1A100.22V"}},
+		{LocationCode: "ICE-01", Availabilities: []string{"1A100.22V", "1H100.80S.30V"}},
+	}
+	c := newServerTypeCache(context.Background(), client, serverTypeCacheTTLDefault, apiCallTimeoutDefault)
+
+	_, err := c.serverTypes()
+	require.NoError(t, err)
+
+	instanceTypes := func(region string) []string {
+		serverTypes, err := c.getServerTypesInRegion(region)
+		require.NoError(t, err)
+		return machineTypeNames(serverTypes)
+	}
+	assert.Equal(t, []string{"1A100.22V"}, instanceTypes("FIN-01"))
+	assert.Equal(t, []string{"1A100.22V", "1H100.80S.30V"}, instanceTypes("ICE-01"))
+	// unknown regions are not restricted
+	assert.Equal(t, []string{"1A100.22V", "1H100.80S.30V"}, instanceTypes("FIN-02"))
+}
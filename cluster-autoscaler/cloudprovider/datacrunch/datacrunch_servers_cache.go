@@ -18,6 +18,7 @@ package datacrunch
 
 import (
 	"context"
+	"strings"
 	"sync"
 	"time"
 
@@ -35,11 +36,19 @@ const (
 	serversCacheMaxTTL = 60
 )
 
+// serversCache holds the list of all servers and the lists of the servers of
+// every region, so the servers of a region can be looked up until it is
+// invalidated by a create or delete in that region.
 type serversCache struct {
 	cache.Store
 	mngJitterClock          clock.Clock
-	datacrunchClient        *datacrunchclient.Client
+	datacrunchClient        datacrunchAPIClient
 	datacrunchClientContext context.Context
+	apiCallTimeout          time.Duration
+
+	// listMutex serializes the listings, so an older listing never
+	// replaces a newer one.
+	listMutex sync.Mutex
 }
 
 type serversClock struct {
@@ -64,7 +73,7 @@ type serversCachedObject struct {
 	servers []*datacrunchclient.Instance
 }
 
-func newServersCache(ctx context.Context, datacrunchClient *datacrunchclient.Client) *serversCache {
+func newServersCache(ctx context.Context, datacrunchClient datacrunchAPIClient, apiCallTimeout time.Duration) *serversCache {
 	jc := &serversClock{
 		Clock: clock.RealClock{},
 	}
@@ -78,22 +87,39 @@ func newServersCache(ctx context.Context, datacrunchClient *datacrunchclient.Cli
 			TTL:   serversCachedTTL,
 			Clock: jc,
 		}),
+		apiCallTimeout,
 	)
 }
 
-func newServersCacheWithClock(ctx context.Context, datacrunchClient *datacrunchclient.Client, jc clock.Clock, store cache.Store) *serversCache {
+func newServersCacheWithClock(ctx context.Context, datacrunchClient datacrunchAPIClient, jc clock.Clock, store cache.Store, apiCallTimeout time.Duration) *serversCache {
 	return &serversCache{
-		store,
-		jc,
-		datacrunchClient,
-		ctx,
+		Store:                   store,
+		mngJitterClock:          jc,
+		datacrunchClient:        datacrunchClient,
+		datacrunchClientContext: ctx,
+		apiCallTimeout:          apiCallTimeout,
 	}
 }
 
+// regionCacheKey returns the key of the servers of the region. Regions are
+// case-insensitive, servers are created in the upper-cased region.
+func regionCacheKey(region string) string {
+	return serversCacheKey + "/" + strings.ToUpper(region)
+}
+
+// servers lists the servers and replaces the cached lists.
 func (m *serversCache) servers() ([]*datacrunchclient.Instance, error) {
+	m.listMutex.Lock()
+	defer m.listMutex.Unlock()
+	return m.listServers()
+}
+
+func (m *serversCache) listServers() ([]*datacrunchclient.Instance, error) {
 	klog.Warning("Fetching servers from DataCrunch API")
 
-	instances, err := m.datacrunchClient.ListInstances("")
+	ctx, cancel := context.WithTimeout(m.datacrunchClientContext, m.apiCallTimeout)
+	defer cancel()
+	instances, err := m.datacrunchClient.ListInstances(ctx, "")
 	if err != nil {
 		return nil, err
 	}
@@ -104,29 +130,88 @@ func (m *serversCache) servers() ([]*datacrunchclient.Instance, error) {
 		servers[i] = &instances[i]
 	}
 
-	cacheObject := serversCachedObject{
-		name:    serversCacheKey,
-		servers: servers,
-	}
-
-	if err := m.Add(cacheObject); err != nil {
+	if err := m.store(servers); err != nil {
 		return nil, err
 	}
 
 	return servers, nil
 }
 
+// store caches the servers and the servers of every region.
+func (m *serversCache) store(servers []*datacrunchclient.Instance) error {
+	regions := make(map[string][]*datacrunchclient.Instance)
+	for _, server := range servers {
+		region := strings.ToUpper(server.Location)
+		regions[region] = append(regions[region], server)
+	}
+
+	if err := m.Add(serversCachedObject{name: serversCacheKey, servers: servers}); err != nil {
+		return err
+	}
+	for region, regionServers := range regions {
+		if err := m.Add(serversCachedObject{name: regionCacheKey(region), servers: regionServers}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// invalidate drops the cached servers of the region, the next lookup in the
+// region lists the servers again. The cached servers of other regions are
+// kept.
+func (m *serversCache) invalidate(region string) {
+	for _, key := range []string{serversCacheKey, regionCacheKey(region)} {
+		if err := m.Delete(serversCachedObject{name: key}); err != nil {
+			klog.Warningf("failed to invalidate servers cache entry %s: %v", key, err)
+		}
+	}
+}
+
+// cached returns the servers cached under the key, if they did not expire.
+func (m *serversCache) cached(key string) ([]*datacrunchclient.Instance, bool) {
+	if obj, found, err := m.GetByKey(key); err == nil && found {
+		return obj.(serversCachedObject).servers, true
+	}
+	return nil, false
+}
+
 func (m *serversCache) getAllServers() ([]*datacrunchclient.Instance, error) {
 	// List expires old entries
 	cacheList := m.List()
 	klog.V(5).Infof("Current serversCache len: %d\n", len(cacheList))
 
-	if obj, found, err := m.GetByKey(serversCacheKey); err == nil && found {
-		foundServers := obj.(serversCachedObject)
-		return foundServers.servers, nil
+	if servers, found := m.cached(serversCacheKey); found {
+		return servers, nil
+	}
+
+	// concurrent lookups wait for a single listing
+	m.listMutex.Lock()
+	defer m.listMutex.Unlock()
+	if servers, found := m.cached(serversCacheKey); found {
+		return servers, nil
+	}
+	return m.listServers()
+}
+
+// getServersInRegion returns the servers of the region, the servers are only
+// listed if the region is not cached.
+func (m *serversCache) getServersInRegion(region string) ([]*datacrunchclient.Instance, error) {
+	if servers, found := m.cached(regionCacheKey(region)); found {
+		return servers, nil
 	}
 
-	return m.servers()
+	servers, err := m.getAllServers()
+	if err != nil {
+		return nil, err
+	}
+	// regions without servers are not cached
+	regionServers := make([]*datacrunchclient.Instance, 0)
+	for _, server := range servers {
+		if strings.EqualFold(server.Location, region) {
+			regionServers = append(regionServers, server)
+		}
+	}
+	return regionServers, nil
 }
 
 func (m *serversCache) getServer(nodeIdOrName string) (*datacrunchclient.Instance, error) {
@@ -134,28 +219,57 @@ func (m *serversCache) getServer(nodeIdOrName string) (*datacrunchclient.Instanc
 	if err != nil {
 		return nil, err
 	}
+	return findServer(servers, nodeIdOrName), nil
+}
+
+// getServerInRegion returns the server of the region with the ID or hostname,
+// or nil if there is none.
+func (m *serversCache) getServerInRegion(region, nodeIdOrName string) (*datacrunchclient.Instance, error) {
+	servers, err := m.getServersInRegion(region)
+	if err != nil {
+		return nil, err
+	}
+	return findServer(servers, nodeIdOrName), nil
+}
 
+// findServer returns the server with the ID or hostname. Server IDs are
+// case-sensitive, exact hostname matches take precedence over hostnames
+// which only match case-insensitively or without domain, e.g. a FQDN node
+// name and the short hostname of its server.
+func findServer(servers []*datacrunchclient.Instance, nodeIdOrName string) *datacrunchclient.Instance {
 	for _, server := range servers {
 		if server.Hostname == nodeIdOrName || server.ID == nodeIdOrName {
-			return server, nil
+			return server
+		}
+	}
+	for _, server := range servers {
+		if hostnamesMatch(server.Hostname, nodeIdOrName) {
+			return server
 		}
 	}
 
 	// return nil if server not found
-	return nil, nil
+	return nil
+}
+
+// hostnamesMatch returns whether the hostnames are equal ignoring case, or
+// one is the other with a domain.
+func hostnamesMatch(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	return a == b || strings.HasPrefix(a, b+".") || strings.HasPrefix(b, a+".")
 }
 
-func (m *serversCache) getServersByNodeGroupName(nodeGroup string) ([]*datacrunchclient.Instance, error) {
+func (m *serversCache) getServersByNodeGroupName(nodeGroup string, tagKeys serverTagKeys) ([]*datacrunchclient.Instance, error) {
 	servers, err := m.getAllServers()
 	if err != nil {
 		return nil, err
 	}
 
-	// DataCrunch does not have labels, so this is a stub. You may need to adapt this logic to your grouping method.
+	// DataCrunch does not have labels, servers are assigned to node groups
+	// by their hostname or their tags.
 	foundServers := make([]*datacrunchclient.Instance, 0)
 	for _, server := range servers {
-		// Example: match by Description or another field if you use it for grouping
-		if server.Description == nodeGroup {
+		if tagKeys.nodeGroupIDForServer(server) == nodeGroup {
 			foundServers = append(foundServers, server)
 		}
 	}
@@ -26,7 +26,7 @@ import (
 )
 
 func TestServersCache(t *testing.T) {
-	c := newServersCache(context.Background(), nil)
+	c := newServersCache(context.Background(), nil, apiCallTimeoutDefault)
 
 	// add initial cache entry, to test that it will be replaced
 	serversOld := []*datacrunchclient.Instance{
@@ -80,3 +80,31 @@ func TestServersCache(t *testing.T) {
 	require.Nil(t, server)
 	require.NoError(t, err)
 }
+
+func TestServersCacheGetServersByNodeGroupName(t *testing.T) {
+	c := newServersCache(context.Background(), nil, apiCallTimeoutDefault)
+
+	servers := []*datacrunchclient.Instance{
+		{ID: "id1", Hostname: "pool1-1a2b3c", Description: "pool1"},
+		// description was edited manually, the hostname still identifies the group
+		{ID: "id2", Hostname: "pool1-4d5e6f", Description: "my test server"},
+		{ID: "id3", Hostname: "pool1-large-7a8b9c", Description: "pool1"},
+		{ID: "id4", Hostname: "manually-created", Description: "pool1"},
+	}
+	err := c.Add(serversCachedObject{
+		name:    serversCacheKey,
+		servers: servers,
+	})
+	require.NoError(t, err)
+
+	found, err := c.getServersByNodeGroupName("pool1", defaultTagKeys)
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+	assert.Equal(t, "id1", found[0].ID)
+	assert.Equal(t, "id2", found[1].ID)
+
+	found, err = c.getServersByNodeGroupName("pool1-large", defaultTagKeys)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, "id3", found[0].ID)
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// NodeGroupStatus is a snapshot of the state of a node group.
+type NodeGroupStatus struct {
+	ID           string
+	Regions      []string
+	InstanceType string
+	MinSize      int
+	MaxSize      int
+	// CurrentSize is the number of servers of the node group which are not
+	// being deleted, warm servers are not counted.
+	CurrentSize int
+	TargetSize  int
+	// InFlightCreates is the number of servers being created.
+	InFlightCreates int
+	// ScaleUpFailures is the number of scale ups in a row which created no
+	// server, the node group is not scaled up until BackoffUntil.
+	ScaleUpFailures int
+	BackoffUntil    time.Time
+	// LastError is the last error of a scale up or down, it occurred at
+	// LastErrorTime. It is empty if no scale up or down failed.
+	LastError     string
+	LastErrorTime time.Time
+	// LastScaleUpTime and LastScaleDownTime are the times of the last scale
+	// up which created or released servers and of the last scale down which
+	// deleted servers, they are zero if there was none.
+	LastScaleUpTime   time.Time
+	LastScaleDownTime time.Time
+	// Priority is the priority of the node group in the cluster config.
+	Priority int
+}
+
+// Status returns a snapshot of the node groups sorted by ID, e.g. for a status
+// dashboard. It is read-only and safe to call concurrently with the
+// autoscaler loop, the current sizes are counted from the cached servers.
+func (d *DatacrunchCloudProvider) Status() ([]NodeGroupStatus, error) {
+	m := d.manager
+	m.nodeGroupsMutex.RLock()
+	groups := make([]*datacrunchNodeGroup, 0, len(m.nodeGroups))
+	for _, group := range m.nodeGroups {
+		groups = append(groups, group)
+	}
+	m.nodeGroupsMutex.RUnlock()
+	slices.SortFunc(groups, func(a, b *datacrunchNodeGroup) int {
+		return strings.Compare(a.id, b.id)
+	})
+
+	statuses := make([]NodeGroupStatus, 0, len(groups))
+	for _, group := range groups {
+		servers, err := m.cachedServers.getServersByNodeGroupName(group.id, m.tagKeys)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get servers of node group %s: %v", group.id, err)
+		}
+		statuses = append(statuses, group.status(m.countActiveServers(servers)))
+	}
+	return statuses, nil
+}
+
+// status returns the status of the node group with currentSize servers.
+func (n *datacrunchNodeGroup) status(currentSize int) NodeGroupStatus {
+	n.sizeMutex.Lock()
+	defer n.sizeMutex.Unlock()
+	status := NodeGroupStatus{
+		ID:                n.id,
+		Regions:           slices.Clone(n.allRegions()),
+		InstanceType:      n.instanceType,
+		MinSize:           n.minSize,
+		MaxSize:           n.maxSize,
+		CurrentSize:       currentSize,
+		TargetSize:        n.targetSize,
+		InFlightCreates:   n.inFlightCreates,
+		ScaleUpFailures:   n.scaleUpFailures,
+		BackoffUntil:      n.backoffUntil,
+		LastErrorTime:     n.lastErrorTime,
+		LastScaleUpTime:   n.lastScaleUpTime,
+		LastScaleDownTime: n.lastScaleDownTime,
+		Priority:          n.priority(),
+	}
+	if n.lastError != nil {
+		status.LastError = n.lastError.Error()
+	}
+	return status
+}
+
+// priority returns the priority of the node group for the priority expander.
+func (n *datacrunchNodeGroup) priority() int {
+	if nodeConfig := n.nodeConfig(); nodeConfig != nil {
+		return nodeConfig.Priority
+	}
+	return 0
+}
+
+// priorityExpanderConfig returns the priorities of the node groups in the
+// format of the ConfigMap of the priority expander, higher priorities first.
+// It is empty if no node group has a priority.
+func (m *datacrunchManager) priorityExpanderConfig() string {
+	m.nodeGroupsMutex.RLock()
+	groups := make([]*datacrunchNodeGroup, 0, len(m.nodeGroups))
+	for _, group := range m.nodeGroups {
+		groups = append(groups, group)
+	}
+	m.nodeGroupsMutex.RUnlock()
+
+	ids := make(map[int][]string)
+	prioritized := false
+	for _, group := range groups {
+		priority := group.priority()
+		ids[priority] = append(ids[priority], group.id)
+		prioritized = prioritized || priority != 0
+	}
+	if !prioritized {
+		return ""
+	}
+
+	priorities := make([]int, 0, len(ids))
+	for priority := range ids {
+		priorities = append(priorities, priority)
+	}
+	slices.Sort(priorities)
+	slices.Reverse(priorities)
+
+	var config strings.Builder
+	for _, priority := range priorities {
+		fmt.Fprintf(&config, "%d:\n", priority)
+		slices.Sort(ids[priority])
+		for _, id := range ids[priority] {
+			fmt.Fprintf(&config, "  - ^%s$\n", regexp.QuoteMeta(id))
+		}
+	}
+	return config.String()
+}
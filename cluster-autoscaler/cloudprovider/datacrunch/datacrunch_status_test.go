@@ -0,0 +1,133 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
+)
+
+func TestStatus(t *testing.T) {
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+	manager.capacityPenalties = newCapacityPenalties(manager.capacityPenalties.clock, 0)
+	pool := newTestNodeGroup(manager, "pool", 1, 5)
+	failing := newTestNodeGroup(manager, "failing", 0, 3)
+	provider, err := newDatacrunchCloudProvider(manager, nil)
+	require.NoError(t, err)
+	client := fakeClientOf(manager)
+
+	require.NoError(t, pool.IncreaseSize(2))
+	client.deployErr = func(req datacrunchclient.DeployInstanceRequest) error {
+		if nodeGroupIDForHostname(req.Hostname) == "failing" {
+			return &datacrunchclient.APIError{StatusCode: 400, Code: "invalid_request", Message: "Not enough resources to deploy instance"}
+		}
+		return nil
+	}
+	require.Error(t, failing.IncreaseSize(1))
+
+	statuses, err := provider.Status()
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+
+	failed := statuses[0]
+	assert.Equal(t, "failing", failed.ID)
+	assert.Zero(t, failed.CurrentSize)
+	assert.Zero(t, failed.TargetSize)
+	assert.Equal(t, 1, failed.ScaleUpFailures)
+	assert.False(t, failed.BackoffUntil.IsZero())
+	assert.Contains(t, failed.LastError, "failed to create 1 of 1 servers")
+	assert.False(t, failed.LastErrorTime.IsZero())
+
+	assert.False(t, statuses[1].LastScaleUpTime.IsZero())
+	statuses[1].LastScaleUpTime = time.Time{}
+	assert.Equal(t, NodeGroupStatus{
+		ID:           "pool",
+		Regions:      []string{"FIN-01"},
+		InstanceType: "1A100.22V",
+		MinSize:      1,
+		MaxSize:      5,
+		CurrentSize:  2,
+		TargetSize:   2,
+	}, statuses[1])
+}
+
+func TestStatusScaleActivityTimes(t *testing.T) {
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+	group := newTestNodeGroup(manager, "pool", 0, 5)
+	provider, err := newDatacrunchCloudProvider(manager, nil)
+	require.NoError(t, err)
+	client := fakeClientOf(manager)
+
+	status := func() NodeGroupStatus {
+		statuses, err := provider.Status()
+		require.NoError(t, err)
+		require.Len(t, statuses, 1)
+		return statuses[0]
+	}
+	assert.True(t, status().LastScaleUpTime.IsZero())
+	assert.True(t, status().LastScaleDownTime.IsZero())
+
+	start := time.Now()
+	require.NoError(t, group.IncreaseSize(2))
+	scaledUp := status().LastScaleUpTime
+	assert.False(t, scaledUp.Before(start))
+	assert.True(t, status().LastScaleDownTime.IsZero())
+
+	// a scale up which created no server is no scale activity
+	client.deployErr = func(req datacrunchclient.DeployInstanceRequest) error {
+		return &datacrunchclient.APIError{StatusCode: 400, Code: "invalid_request", Message: "invalid image"}
+	}
+	require.Error(t, group.IncreaseSize(1))
+	assert.Equal(t, scaledUp, status().LastScaleUpTime)
+
+	server := client.servers[0]
+	node := &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: server.Hostname}, Spec: apiv1.NodeSpec{ProviderID: toProviderID(server.ID)}}
+	require.NoError(t, group.DeleteNodes([]*apiv1.Node{node}))
+	assert.False(t, status().LastScaleDownTime.Before(scaledUp))
+	assert.Equal(t, scaledUp, status().LastScaleUpTime)
+}
+
+func TestStatusPriority(t *testing.T) {
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+	newTestNodeGroup(manager, "gpu-pool", 0, 3)
+	newTestNodeGroup(manager, "gpu.spot", 0, 3)
+	newTestNodeGroup(manager, "cpu-pool", 0, 3)
+	provider, err := newDatacrunchCloudProvider(manager, nil)
+	require.NoError(t, err)
+
+	assert.Empty(t, manager.priorityExpanderConfig(), "no priorities configured")
+
+	require.NoError(t, json.Unmarshal([]byte(`{"priority": 10}`), manager.clusterConfig.NodeConfigs["gpu-pool"]))
+	require.NoError(t, json.Unmarshal([]byte(`{"priority": 10}`), manager.clusterConfig.NodeConfigs["gpu.spot"]))
+	statuses, err := provider.Status()
+	require.NoError(t, err)
+	priorities := make(map[string]int)
+	for _, status := range statuses {
+		priorities[status.ID] = status.Priority
+	}
+	assert.Equal(t, map[string]int{"cpu-pool": 0, "gpu-pool": 10, "gpu.spot": 10}, priorities)
+
+	assert.Equal(t, "10:\n  - ^gpu-pool$\n  - ^gpu\\.spot$\n0:\n  - ^cpu-pool$\n", manager.priorityExpanderConfig())
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"slices"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+	podutils "k8s.io/autoscaler/cluster-autoscaler/utils/pod"
+)
+
+// NodeGroupUtilization is the resource utilization of the nodes of a node
+// group: the resources requested by the pods scheduled on them and their
+// allocatable resources.
+type NodeGroupUtilization struct {
+	ID          string
+	Nodes       int
+	Requested   apiv1.ResourceList
+	Allocatable apiv1.ResourceList
+}
+
+// Ratio returns the share of the allocatable resource which is requested. It
+// is 0 if the nodes have none of the resource.
+func (u NodeGroupUtilization) Ratio(name apiv1.ResourceName) float64 {
+	allocatable, found := u.Allocatable[name]
+	if !found || allocatable.IsZero() {
+		return 0
+	}
+	requested := u.Requested[name]
+	return requested.AsApproximateFloat64() / allocatable.AsApproximateFloat64()
+}
+
+// Utilization returns the resource utilization of the node groups sorted by
+// ID, e.g. for dashboards or external scaling policies. The provider has no
+// client of the cluster, the nodes and pods are passed by the caller. Pods
+// which are not scheduled on a node of a node group or terminated are not
+// counted. It is read-only and safe to call concurrently with the autoscaler
+// loop, the servers of the nodes are looked up in the cache.
+func (d *DatacrunchCloudProvider) Utilization(nodes []*apiv1.Node, pods []*apiv1.Pod) ([]NodeGroupUtilization, error) {
+	return d.manager.utilization(nodes, pods)
+}
+
+func (m *datacrunchManager) utilization(nodes []*apiv1.Node, pods []*apiv1.Pod) ([]NodeGroupUtilization, error) {
+	m.nodeGroupsMutex.RLock()
+	utilizations := make(map[string]*NodeGroupUtilization, len(m.nodeGroups))
+	for id := range m.nodeGroups {
+		utilizations[id] = &NodeGroupUtilization{ID: id, Requested: apiv1.ResourceList{}, Allocatable: apiv1.ResourceList{}}
+	}
+	m.nodeGroupsMutex.RUnlock()
+
+	// the node group of a node is looked up like NodeGroupForNode does,
+	// without marking its server as registered
+	nodeGroups := make(map[string]*NodeGroupUtilization, len(nodes))
+	for _, node := range nodes {
+		server, err := m.findServerForNode(node)
+		if err != nil {
+			return nil, err
+		}
+		id := node.Labels[nodeGroupLabel]
+		if server != nil {
+			id = m.nodeGroupIDForServer(server)
+		}
+		utilization, found := utilizations[id]
+		if !found {
+			continue
+		}
+		utilization.Nodes++
+		addResourceList(utilization.Allocatable, node.Status.Allocatable)
+		nodeGroups[node.Name] = utilization
+	}
+
+	for _, pod := range pods {
+		if pod.Status.Phase == apiv1.PodSucceeded || pod.Status.Phase == apiv1.PodFailed {
+			continue
+		}
+		if utilization, found := nodeGroups[pod.Spec.NodeName]; found {
+			addResourceList(utilization.Requested, podutils.PodRequests(pod))
+		}
+	}
+
+	result := make([]NodeGroupUtilization, 0, len(utilizations))
+	for _, utilization := range utilizations {
+		result = append(result, *utilization)
+	}
+	slices.SortFunc(result, func(a, b NodeGroupUtilization) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return result, nil
+}
+
+// addResourceList adds the quantities of the resources to the sum. Unlike
+// addResources it keeps milli quantities like CPU requests of 500m.
+func addResourceList(sum, resources apiv1.ResourceList) {
+	for name, quantity := range resources {
+		total := sum[name]
+		total.Add(quantity)
+		sum[name] = total
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
+)
+
+func TestUtilization(t *testing.T) {
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, nil)
+	pool := newTestNodeGroup(manager, "pool", 0, 5)
+	newTestNodeGroup(manager, "idle", 0, 5)
+	provider, err := newDatacrunchCloudProvider(manager, nil)
+	require.NoError(t, err)
+	client := fakeClientOf(manager)
+	require.NoError(t, pool.IncreaseSize(2))
+
+	allocatable := apiv1.ResourceList{
+		apiv1.ResourceCPU:    resource.MustParse("4"),
+		apiv1.ResourceMemory: resource.MustParse("16Gi"),
+	}
+	var nodes []*apiv1.Node
+	for _, server := range client.servers {
+		nodes = append(nodes, &apiv1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: server.Hostname},
+			Spec:       apiv1.NodeSpec{ProviderID: toProviderID(server.ID)},
+			Status:     apiv1.NodeStatus{Allocatable: allocatable},
+		})
+	}
+	// a node of no node group is not counted
+	nodes = append(nodes, &apiv1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "control-plane"},
+		Status:     apiv1.NodeStatus{Allocatable: allocatable},
+	})
+
+	pod := func(nodeName, cpu, memory string, phase apiv1.PodPhase) *apiv1.Pod {
+		return &apiv1.Pod{
+			Spec: apiv1.PodSpec{
+				NodeName: nodeName,
+				Containers: []apiv1.Container{{Resources: apiv1.ResourceRequirements{Requests: apiv1.ResourceList{
+					apiv1.ResourceCPU:    resource.MustParse(cpu),
+					apiv1.ResourceMemory: resource.MustParse(memory),
+				}}}},
+			},
+			Status: apiv1.PodStatus{Phase: phase},
+		}
+	}
+	pods := []*apiv1.Pod{
+		pod(nodes[0].Name, "2", "8Gi", apiv1.PodRunning),
+		pod(nodes[1].Name, "500m", "4Gi", apiv1.PodRunning),
+		pod(nodes[1].Name, "500m", "4Gi", apiv1.PodPending),
+		// terminated, unscheduled pods and pods of other nodes are not counted
+		pod(nodes[1].Name, "4", "16Gi", apiv1.PodSucceeded),
+		pod("", "4", "16Gi", apiv1.PodPending),
+		pod("control-plane", "4", "16Gi", apiv1.PodRunning),
+	}
+
+	utilizations, err := provider.Utilization(nodes, pods)
+	require.NoError(t, err)
+	require.Len(t, utilizations, 2)
+
+	idle := utilizations[0]
+	assert.Equal(t, "idle", idle.ID)
+	assert.Zero(t, idle.Nodes)
+	assert.Zero(t, idle.Ratio(apiv1.ResourceCPU))
+
+	used := utilizations[1]
+	assert.Equal(t, "pool", used.ID)
+	assert.Equal(t, 2, used.Nodes)
+	assert.InDelta(t, 3.0/8, used.Ratio(apiv1.ResourceCPU), 1e-9)
+	assert.InDelta(t, 0.5, used.Ratio(apiv1.ResourceMemory), 1e-9)
+	assert.Zero(t, used.Ratio(ResourceGPU))
+	assert.True(t, used.Allocatable.Cpu().Equal(resource.MustParse("8")))
+
+	// the utilization is read-only, no server is marked as registered
+	for _, server := range client.servers {
+		assert.Contains(t, manager.pendingRegistrations.servers, server.ID)
+	}
+}
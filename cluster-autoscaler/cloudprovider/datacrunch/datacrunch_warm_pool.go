@@ -0,0 +1,439 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+
+	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
+)
+
+const (
+	// warmPoolScriptPrefix prefixes the names of the startup scripts which
+	// hold back the registration of warm servers. The pre-script of a warm
+	// server waits as long as its script exists, deleting the script releases
+	// the server into the cluster.
+	warmPoolScriptPrefix = "autoscaler-warm-pool-"
+	// warmPoolHoldScript is the content of the scripts holding back warm
+	// servers, they are never run.
+	warmPoolHoldScript = "#!/bin/bash\n# holds back the registration of a warm server of the cluster autoscaler\n"
+)
+
+// errWarmPoolWithoutStartupScript is returned if a node config with a warm
+// pool or a warm server of a node group has no startup script, the
+// pre-script holding back the registration is only run along with a startup
+// script.
+var errWarmPoolWithoutStartupScript = errors.New("warm pools need a startup script in the cluster config")
+
+// hasStartupScript returns whether a startup script is configured for the
+// node config, inline in the cluster config or by the environment.
+func hasStartupScript(nodeConfig *NodeConfig) bool {
+	return nodeConfig.StartupScriptBase64 != "" || len(nodeConfig.RegionStartupScriptsBase64) > 0 ||
+		os.Getenv("DATACRUNCH_STARTUP_SCRIPT") != "" || os.Getenv("DATACRUNCH_STARTUP_SCRIPT_FILE") != ""
+}
+
+// warmPoolScriptName returns the name of the script holding back the server
+// with the hostname.
+func warmPoolScriptName(hostname string) string {
+	return warmPoolScriptPrefix + hostname
+}
+
+// warmServer is a booted server of a node group whose node does not register
+// until it is released.
+type warmServer struct {
+	id       string
+	hostname string
+	region   string
+	scriptID string
+}
+
+// warmPool tracks the warm servers of the node groups. Warm servers are not
+// nodes of their node group and not counted in its target size until they
+// are released by a scale up.
+type warmPool struct {
+	sync.Mutex
+	clock clock.Clock
+	// held holds the warm servers of every node group, oldest first.
+	held map[string][]warmServer
+	// released holds the release times of released servers, their nodes have
+	// the register timeout from the release to join the cluster.
+	released map[string]time.Time
+	// filling holds the node groups whose warm pool is being filled.
+	filling map[string]bool
+	// creating holds the number of warm servers being created for every
+	// node group.
+	creating map[string]int
+	// recovered is set once the warm servers of a previous run were looked
+	// up.
+	recovered bool
+}
+
+func newWarmPool(c clock.Clock) *warmPool {
+	return &warmPool{
+		clock:    c,
+		held:     make(map[string][]warmServer),
+		released: make(map[string]time.Time),
+		filling:  make(map[string]bool),
+		creating: make(map[string]int),
+	}
+}
+
+// add adds a warm server to the pool of the node group.
+func (p *warmPool) add(nodeGroup string, server warmServer) {
+	p.Lock()
+	defer p.Unlock()
+	p.held[nodeGroup] = append(p.held[nodeGroup], server)
+}
+
+// take removes the oldest warm server of the node group from the pool.
+func (p *warmPool) take(nodeGroup string) (warmServer, bool) {
+	p.Lock()
+	defer p.Unlock()
+	servers := p.held[nodeGroup]
+	if len(servers) == 0 {
+		return warmServer{}, false
+	}
+	p.held[nodeGroup] = servers[1:]
+	return servers[0], true
+}
+
+// size returns the number of warm servers of the node group.
+func (p *warmPool) size(nodeGroup string) int {
+	p.Lock()
+	defer p.Unlock()
+	return len(p.held[nodeGroup])
+}
+
+// count returns the number of warm servers of all node groups, including
+// those being created.
+func (p *warmPool) count() int {
+	p.Lock()
+	defer p.Unlock()
+	count := 0
+	for _, servers := range p.held {
+		count += len(servers)
+	}
+	for _, creating := range p.creating {
+		count += creating
+	}
+	return count
+}
+
+// addCreating adds delta to the number of warm servers being created for the
+// node group.
+func (p *warmPool) addCreating(nodeGroup string, delta int) {
+	p.Lock()
+	defer p.Unlock()
+	p.creating[nodeGroup] += delta
+}
+
+// isHeld returns whether the server is a warm server which was not released.
+func (p *warmPool) isHeld(serverID string) bool {
+	p.Lock()
+	defer p.Unlock()
+	for _, servers := range p.held {
+		for _, server := range servers {
+			if server.id == serverID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// markReleased records the release of the server.
+func (p *warmPool) markReleased(serverID string) {
+	p.Lock()
+	defer p.Unlock()
+	p.released[serverID] = p.clock.Now()
+}
+
+// releasedAt returns when the server was released, if it was.
+func (p *warmPool) releasedAt(serverID string) (time.Time, bool) {
+	p.Lock()
+	defer p.Unlock()
+	releasedAt, found := p.released[serverID]
+	return releasedAt, found
+}
+
+// remove drops the server from the pool, e.g. when it is deleted. It returns
+// the server if it was held.
+func (p *warmPool) remove(serverID string) (warmServer, bool) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.released, serverID)
+	for nodeGroup, servers := range p.held {
+		for i, server := range servers {
+			if server.id == serverID {
+				p.held[nodeGroup] = append(servers[:i:i], servers[i+1:]...)
+				return server, true
+			}
+		}
+	}
+	return warmServer{}, false
+}
+
+// prune forgets the servers which no longer exist.
+func (p *warmPool) prune(servers []*datacrunchclient.Instance) {
+	ids := make(map[string]bool, len(servers))
+	for _, server := range servers {
+		ids[server.ID] = true
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	for nodeGroup, held := range p.held {
+		left := held[:0]
+		for _, server := range held {
+			if ids[server.id] {
+				left = append(left, server)
+			}
+		}
+		p.held[nodeGroup] = left
+	}
+	for id := range p.released {
+		if !ids[id] {
+			delete(p.released, id)
+		}
+	}
+}
+
+// startFilling marks the warm pool of the node group as being filled, it
+// returns false if it already is.
+func (p *warmPool) startFilling(nodeGroup string) bool {
+	p.Lock()
+	defer p.Unlock()
+	if p.filling[nodeGroup] {
+		return false
+	}
+	p.filling[nodeGroup] = true
+	return true
+}
+
+func (p *warmPool) stopFilling(nodeGroup string) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.filling, nodeGroup)
+}
+
+// warmPoolSize returns the number of warm servers kept for the node group.
+func (n *datacrunchNodeGroup) warmPoolSize() int {
+	nodeConfig := n.nodeConfig()
+	if nodeConfig == nil {
+		return 0
+	}
+	return nodeConfig.WarmPoolSize
+}
+
+// refreshWarmPools forgets the warm servers which no longer exist and fills
+// the warm pools of the node groups in the background. The warm servers of a
+// previous run are recovered from their hold scripts on the first refresh.
+func (m *datacrunchManager) refreshWarmPools(servers []*datacrunchclient.Instance) {
+	groups := make([]*datacrunchNodeGroup, 0, len(m.nodeGroups))
+	for _, group := range m.nodeGroups {
+		if group.warmPoolSize() > 0 {
+			groups = append(groups, group)
+		}
+	}
+	if len(groups) == 0 {
+		return
+	}
+
+	if err := m.recoverWarmServers(servers); err != nil {
+		klog.Warningf("failed to recover warm servers, retrying on the next refresh: %v", err)
+		return
+	}
+	m.warmPool.prune(servers)
+
+	if m.dryRun {
+		return
+	}
+	for _, group := range groups {
+		if m.warmPool.size(group.id) >= group.warmPoolSize() || !m.warmPool.startFilling(group.id) {
+			continue
+		}
+		m.goBackground(func(ctx context.Context) {
+			defer m.warmPool.stopFilling(group.id)
+			group.fillWarmPool(ctx)
+		})
+	}
+}
+
+// recoverWarmServers adds the servers which are held back by a hold script to
+// the warm pools, once.
+func (m *datacrunchManager) recoverWarmServers(servers []*datacrunchclient.Instance) error {
+	m.warmPool.Lock()
+	recovered := m.warmPool.recovered
+	m.warmPool.Unlock()
+	if recovered {
+		return nil
+	}
+
+	ctx, cancel := m.apiContext()
+	scripts, err := m.client.ListStartupScripts(ctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	for _, script := range scripts {
+		hostname, found := strings.CutPrefix(script.Name, warmPoolScriptPrefix)
+		if !found {
+			continue
+		}
+		server := findServer(servers, hostname)
+		if server == nil {
+			continue
+		}
+		nodeGroup := m.nodeGroupIDForServer(server)
+		if _, found := m.nodeGroups[nodeGroup]; !found {
+			continue
+		}
+		klog.V(2).Infof("%v Recovered warm server %s", logFields{nodeGroup: nodeGroup, region: server.Location, server: server.ID}, server.Hostname)
+		m.warmPool.add(nodeGroup, warmServer{id: server.ID, hostname: server.Hostname, region: server.Location, scriptID: script.ID})
+	}
+
+	m.warmPool.Lock()
+	m.warmPool.recovered = true
+	m.warmPool.Unlock()
+	return nil
+}
+
+// fillWarmPool creates warm servers until the warm pool of the node group has
+// its size. Servers which fail to be created are retried on the next refresh.
+func (n *datacrunchNodeGroup) fillWarmPool(ctx context.Context) {
+	for n.manager.warmPool.size(n.id) < n.warmPoolSize() && ctx.Err() == nil {
+		if err := n.manager.createWarmServer(n); err != nil {
+			klog.Warningf("%v failed to create warm server: %v", n.logFields(), err)
+			return
+		}
+	}
+}
+
+// createWarmServer creates a server of the node group which is held back from
+// registration by a hold script, with the first of the instance types and
+// regions with capacity left. Warm servers are created within the same
+// limits as the servers of scale ups.
+func (m *datacrunchManager) createWarmServer(n *datacrunchNodeGroup) error {
+	if n.instanceTypeMissing.Load() {
+		return fmt.Errorf("instance type %s is no longer available in the DataCrunch catalog", n.instanceType)
+	}
+	if err := n.checkBackoff(); err != nil {
+		return err
+	}
+
+	n.clusterUpdateMutex.Lock()
+	err := n.checkMaxNodesTotal(1, 0)
+	if err == nil {
+		m.warmPool.addCreating(n.id, 1)
+	}
+	n.clusterUpdateMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	defer m.warmPool.addCreating(n.id, -1)
+
+	if err := n.waitForCreateRate(1); err != nil {
+		return err
+	}
+
+	placements, err := n.availablePlacements(n.instanceOption())
+	if err != nil {
+		return err
+	}
+
+	nodeName := newNodeName(n)
+	ctx, cancel := m.apiContext()
+	scriptID, err := m.client.UploadStartupScript(ctx, warmPoolScriptName(nodeName), warmPoolHoldScript)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to upload hold script: %w", err)
+	}
+
+	for _, placement := range placements {
+		var id string
+		id, err = m.createServerLimited(n, placement, nodeName, true)
+		if err == nil {
+			m.cachedServers.invalidate(placement.region)
+			m.capacityPenalties.recordSuccess(n.capacityKey(placement))
+			klog.V(2).Infof("%v Created warm server of instance type %s", logFields{nodeGroup: n.id, region: placement.region, server: id}, placement.instanceType)
+			m.warmPool.add(n.id, warmServer{id: id, hostname: nodeName, region: placement.region, scriptID: scriptID})
+			return nil
+		}
+		if !isOutOfCapacityError(err) {
+			break
+		}
+		m.capacityPenalties.recordFailure(n.capacityKey(placement))
+	}
+
+	m.deleteHoldScript(scriptID)
+	return err
+}
+
+// releaseWarmServers releases up to count warm servers of the node group into
+// the cluster and returns how many were released.
+func (m *datacrunchManager) releaseWarmServers(n *datacrunchNodeGroup, count int) int {
+	released := 0
+	for released < count {
+		server, found := m.warmPool.take(n.id)
+		if !found {
+			break
+		}
+
+		fields := logFields{nodeGroup: n.id, region: server.region, server: server.id}
+		ctx, cancel := m.apiContext()
+		err := m.client.DeleteStartupScript(ctx, server.scriptID)
+		cancel()
+		if err != nil && !isNotFoundError(err) {
+			// the server is still held back
+			klog.Warningf("%v failed to release warm server: %v", fields, err)
+			m.warmPool.add(n.id, server)
+			break
+		}
+
+		klog.V(2).Infof("%v Released warm server %s", fields, server.hostname)
+		m.warmPool.markReleased(server.id)
+		m.pendingRegistrations.add(server.id, n.id, server.region)
+		provisionTimeout, registerTimeout := n.createTimeout, n.registerTimeout
+		m.goBackground(func(ctx context.Context) {
+			if err := m.waitForServerRegistration(ctx, server.id, provisionTimeout, registerTimeout); err != nil && ctx.Err() == nil {
+				klog.Warningf("%v Server did not join the cluster: %v", fields, err)
+			}
+		})
+		released++
+	}
+	return released
+}
+
+// deleteHoldScript deletes the hold script of a warm server which is not
+// created or deleted.
+func (m *datacrunchManager) deleteHoldScript(scriptID string) {
+	ctx, cancel := m.apiContext()
+	defer cancel()
+	if err := m.client.DeleteStartupScript(ctx, scriptID); err != nil && !isNotFoundError(err) {
+		klog.Warningf("failed to delete hold script %s of warm server: %v", scriptID, err)
+	}
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datacrunch
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	datacrunchclient "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/datacrunch/datacrunch-go"
+)
+
+// newWarmPoolTestNodeGroup returns a node group with a startup script and a
+// warm pool of the size.
+func newWarmPoolTestNodeGroup(t *testing.T, servers []*datacrunchclient.Instance, warmPoolSize int) (*datacrunchNodeGroup, *fakeClient) {
+	t.Setenv("DATACRUNCH_CLIENT_ID", "client-id")
+	t.Setenv("DATACRUNCH_CLIENT_SECRET", "client-secret")
+
+	manager := newTestManager(t, []*datacrunchclient.InstanceType{{InstanceType: "1A100.22V"}}, servers)
+	group := newTestNodeGroup(manager, "pool", 0, 5)
+	nodeConfig := manager.clusterConfig.NodeConfigs["pool"]
+	nodeConfig.StartupScriptBase64 = base64.StdEncoding.EncodeToString([]byte("kubeadm join"))
+	nodeConfig.WarmPoolSize = warmPoolSize
+	return group, fakeClientOf(manager)
+}
+
+func TestCreateWarmServer(t *testing.T) {
+	group, client := newWarmPoolTestNodeGroup(t, nil, 1)
+
+	require.NoError(t, group.manager.createWarmServer(group))
+	require.Len(t, client.deployed, 1)
+	hostname := client.deployed[0].Hostname
+	assert.Equal(t, warmPoolHoldScript, client.uploadedScripts[warmPoolScriptName(hostname)])
+	assert.Contains(t, client.uploadedScripts["autoscaler-startup-script-"+hostname], warmPoolScriptName(hostname))
+	assert.Equal(t, 1, group.manager.warmPool.size(group.id))
+
+	// warm servers are neither nodes nor counted in the target size
+	nodes, err := group.Nodes()
+	require.NoError(t, err)
+	assert.Empty(t, nodes)
+	group.resetTargetSize(0)
+	size, _ := group.TargetSize()
+	assert.Zero(t, size)
+
+	// servers created by scale ups are not held back
+	_, err = createServer(group, "FIN-01", group.instanceType)
+	require.NoError(t, err)
+	assert.NotContains(t, client.uploadedScripts["autoscaler-startup-script-"+client.deployed[1].Hostname], warmPoolScriptPrefix)
+}
+
+func TestCreateWarmServerWithoutStartupScript(t *testing.T) {
+	group, client := newWarmPoolTestNodeGroup(t, nil, 1)
+	require.NoError(t, group.manager.validateNodeConfigReferences())
+	nodeConfig := group.manager.clusterConfig.NodeConfigs["pool"]
+	nodeConfig.StartupScriptBase64 = ""
+
+	// the config is rejected on startup, also with a startup script ID
+	assert.ErrorIs(t, group.manager.validateNodeConfigReferences(), errWarmPoolWithoutStartupScript)
+	nodeConfig.StartupScriptID = "script-id"
+	client.startupScripts = append(client.startupScripts, datacrunchclient.StartupScript{ID: "script-id"})
+	assert.ErrorIs(t, group.manager.validateNodeConfigReferences(), errWarmPoolWithoutStartupScript)
+	nodeConfig.StartupScriptID = ""
+
+	require.ErrorIs(t, group.manager.createWarmServer(group), errWarmPoolWithoutStartupScript)
+	assert.Empty(t, client.deployed)
+	require.Len(t, client.deletedScripts, 1)
+	assert.Zero(t, group.manager.warmPool.size(group.id))
+}
+
+func TestCreateWarmServerLimits(t *testing.T) {
+	group, client := newWarmPoolTestNodeGroup(t, nil, 3)
+	manager := group.manager
+
+	// warm servers count against the maximum number of nodes, those released
+	// by a scale up are not counted twice
+	manager.maxNodesTotal = 2
+	require.NoError(t, manager.createWarmServer(group))
+	require.NoError(t, manager.createWarmServer(group))
+	assert.ErrorContains(t, manager.createWarmServer(group), "maximum number of nodes")
+	require.Len(t, client.deployed, 2)
+	require.NoError(t, group.IncreaseSize(2))
+	assert.Len(t, client.deployed, 2)
+	assert.ErrorContains(t, manager.createWarmServer(group), "maximum number of nodes")
+	manager.maxNodesTotal = 0
+
+	// placements without capacity are penalized
+	client.deployErr = func(req datacrunchclient.DeployInstanceRequest) error {
+		return &datacrunchclient.APIError{StatusCode: 400, Code: "invalid_request", Message: "Not enough resources to deploy instance"}
+	}
+	require.Error(t, manager.createWarmServer(group))
+	assert.Positive(t, manager.capacityPenalties.penalized(group.capacityKey(group.allPlacements()[0])))
+	client.deployErr = nil
+
+	// node groups backing off after failed scale ups create no warm servers
+	deployed := len(client.deployed)
+	group.recordScaleUp(false)
+	assert.ErrorContains(t, manager.createWarmServer(group), "backoff")
+	assert.Len(t, client.deployed, deployed)
+	assert.Zero(t, manager.warmPool.count())
+}
+
+func TestIncreaseSizeConsumesWarmPool(t *testing.T) {
+	group, client := newWarmPoolTestNodeGroup(t, nil, 2)
+	require.NoError(t, group.manager.createWarmServer(group))
+	require.NoError(t, group.manager.createWarmServer(group))
+	warmServers := []string{client.servers[0].ID, client.servers[1].ID}
+
+	require.NoError(t, group.IncreaseSize(3))
+
+	// the warm servers are released before a new server is created
+	assert.Len(t, client.deployed, 3)
+	assert.ElementsMatch(t, []string{
+		"script-" + warmPoolScriptName(client.servers[0].Hostname),
+		"script-" + warmPoolScriptName(client.servers[1].Hostname),
+	}, client.deletedScripts)
+	assert.Zero(t, group.manager.warmPool.size(group.id))
+	for _, id := range warmServers {
+		_, released := group.manager.warmPool.releasedAt(id)
+		assert.True(t, released)
+	}
+	size, _ := group.TargetSize()
+	assert.Equal(t, 3, size)
+
+	nodes, err := group.Nodes()
+	require.NoError(t, err)
+	assert.Len(t, nodes, 3)
+}
+
+func TestIncreaseSizeOnlyFromWarmPool(t *testing.T) {
+	group, client := newWarmPoolTestNodeGroup(t, nil, 2)
+	require.NoError(t, group.manager.createWarmServer(group))
+	require.NoError(t, group.manager.createWarmServer(group))
+
+	require.NoError(t, group.IncreaseSize(1))
+
+	assert.Len(t, client.deployed, 2)
+	assert.Len(t, client.deletedScripts, 1)
+	assert.Equal(t, 1, group.manager.warmPool.size(group.id))
+	size, _ := group.TargetSize()
+	assert.Equal(t, 1, size)
+}
+
+func TestRefreshWarmPools(t *testing.T) {
+	servers := []*datacrunchclient.Instance{
+		{ID: "warm-1", Hostname: "pool-1a", Location: "FIN-01", Status: "running"},
+		{ID: "node-1", Hostname: "pool-1b", Location: "FIN-01", Status: "running"},
+	}
+	group, client := newWarmPoolTestNodeGroup(t, servers, 2)
+	// the warm server of a previous run is recovered from its hold script
+	client.startupScripts = []datacrunchclient.StartupScript{
+		{ID: "hold-1", Name: warmPoolScriptName("pool-1a")},
+		{ID: "script-1", Name: "autoscaler-startup-script-pool-1b"},
+	}
+
+	listed, err := group.manager.cachedServers.servers()
+	require.NoError(t, err)
+	group.manager.refreshWarmPools(listed)
+
+	assert.True(t, group.manager.warmPool.isHeld("warm-1"))
+	assert.False(t, group.manager.warmPool.isHeld("node-1"))
+	require.Eventually(t, func() bool {
+		return group.manager.warmPool.size(group.id) == 2
+	}, time.Second, time.Millisecond)
+	client.mu.Lock()
+	assert.Len(t, client.deployed, 1)
+	client.mu.Unlock()
+
+	// servers which are gone are dropped from the pool
+	group.manager.warmPool.prune(servers[1:])
+	assert.False(t, group.manager.warmPool.isHeld("warm-1"))
+}
+
+func TestDeleteWarmServer(t *testing.T) {
+	group, client := newWarmPoolTestNodeGroup(t, nil, 1)
+	require.NoError(t, group.manager.createWarmServer(group))
+	server := client.servers[0]
+
+	require.NoError(t, group.manager.deleteServer(&server))
+	assert.Zero(t, group.manager.warmPool.size(group.id))
+	assert.Equal(t, []string{"script-" + warmPoolScriptName(server.Hostname)}, client.deletedScripts)
+}